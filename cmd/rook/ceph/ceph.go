@@ -53,6 +53,9 @@ type config struct {
 	monEndpoints       string
 	nodeName           string
 	pvcBacked          bool
+	strictDeviceCheck  bool
+	wipeDevices        bool
+	maxNewOSDs         int
 }
 
 func init() {
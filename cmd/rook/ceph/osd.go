@@ -59,6 +59,7 @@ var osdRemoveCmd = &cobra.Command{
 var (
 	osdDataDeviceFilter     string
 	osdDataDevicePathFilter string
+	osdDataDeviceSelector   string
 	ownerRefID              string
 	clusterName             string
 	osdID                   int
@@ -70,6 +71,7 @@ var (
 	blockPath               string
 	lvBackedPV              bool
 	osdIDsToRemove          string
+	osdConfigOverrides      string
 )
 
 func addOSDFlags(command *cobra.Command) {
@@ -80,10 +82,16 @@ func addOSDFlags(command *cobra.Command) {
 	provisionCmd.Flags().StringVar(&cfg.devices, "data-devices", "", "comma separated list of devices to use for storage")
 	provisionCmd.Flags().StringVar(&osdDataDeviceFilter, "data-device-filter", "", "a regex filter for the device names to use, or \"all\"")
 	provisionCmd.Flags().StringVar(&osdDataDevicePathFilter, "data-device-path-filter", "", "a regex filter for the device path names to use")
+	provisionCmd.Flags().StringVar(&osdDataDeviceSelector, "data-device-selector", "", "JSON object of the size, rotational, vendor and model criteria restricting the devices matched by the filter")
 	provisionCmd.Flags().StringVar(&cfg.metadataDevice, "metadata-device", "", "device to use for metadata (e.g. a high performance SSD/NVMe device)")
 	provisionCmd.Flags().BoolVar(&cfg.forceFormat, "force-format", false,
 		"true to force the format of any specified devices, even if they already have a filesystem.  BE CAREFUL!")
 	provisionCmd.Flags().BoolVar(&cfg.pvcBacked, "pvc-backed-osd", false, "true to specify a block mode pvc is backing the OSD")
+	provisionCmd.Flags().BoolVar(&cfg.strictDeviceCheck, "strict-device-check", false, "true to refuse the devices that appear to be in use")
+	provisionCmd.Flags().BoolVar(&cfg.wipeDevices, "wipe-device-on-provision", false,
+		"true to wipe the devices listed by name that do not hold an osd of this cluster before provisioning them.  BE CAREFUL!")
+	provisionCmd.Flags().IntVar(&cfg.maxNewOSDs, "max-new-osds", -1, "the maximum number of osds created on the node, -1 for no limit")
+	provisionCmd.Flags().StringVar(&osdConfigOverrides, "osd-config-overrides", "", "JSON object of the ceph config settings to set on the provisioned OSDs")
 	// flags for generating the osd config
 	osdConfigCmd.Flags().IntVar(&osdID, "osd-id", -1, "osd id for which to generate config")
 	osdConfigCmd.Flags().BoolVar(&osdIsDevice, "is-device", false, "whether the osd is a device")
@@ -118,7 +126,12 @@ func addOSDConfigFlags(command *cobra.Command) {
 	command.Flags().IntVar(&cfg.storeConfig.OSDsPerDevice, "osds-per-device", 1, "the number of OSDs per device")
 	command.Flags().BoolVar(&cfg.storeConfig.EncryptedDevice, "encrypted-device", false, "whether to encrypt the OSD with dmcrypt")
 	command.Flags().StringVar(&cfg.storeConfig.DeviceClass, "osd-crush-device-class", "", "The device class for all OSDs configured on this node")
+	command.Flags().StringVar(&cfg.storeConfig.DeviceClassHint, "osd-crush-device-class-hint", "", "An additional class recorded on the OSDs configured on this node, the crush device class is not changed")
 	command.Flags().StringVar(&cfg.storeConfig.InitialWeight, "osd-crush-initial-weight", "", "The initial weight of OSD in TiB units")
+	command.Flags().StringVar(&cfg.storeConfig.BlueStoreRocksDBOptions, "osd-bluestore-rocksdb-options", "", "The bluestore_rocksdb_options of the OSDs")
+	command.Flags().StringVar(&cfg.storeConfig.PGAutoscaleMode, "osd-pg-autoscale-mode", "", "The osd_pool_default_pg_autoscale_mode of the pools created after the OSDs are provisioned")
+	command.Flags().BoolVar(&cfg.storeConfig.Zoned, "osd-zoned", false, "whether the devices are zoned devices provisioned with the zoned bluestore backend")
+	command.Flags().BoolVar(&cfg.storeConfig.RawMode, "osd-raw-mode", false, "whether the devices must be provisioned with ceph-volume raw mode instead of lvm")
 }
 
 func init() {
@@ -186,6 +199,11 @@ func prepareOSD(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	deviceSelector, err := osdcfg.ParseDeviceSelector(osdDataDeviceSelector)
+	if err != nil {
+		rook.TerminateFatal(errors.Wrap(err, "failed to parse the device selector"))
+	}
+
 	var dataDevices []osddaemon.DesiredDevice
 	if osdDataDeviceFilter != "" {
 		if cfg.devices != "" || osdDataDevicePathFilter != "" {
@@ -193,7 +211,7 @@ func prepareOSD(cmd *cobra.Command, args []string) error {
 		}
 
 		dataDevices = []osddaemon.DesiredDevice{
-			{Name: osdDataDeviceFilter, IsFilter: true, OSDsPerDevice: cfg.storeConfig.OSDsPerDevice},
+			{Name: osdDataDeviceFilter, IsFilter: true, OSDsPerDevice: cfg.storeConfig.OSDsPerDevice, Selector: deviceSelector},
 		}
 	} else if osdDataDevicePathFilter != "" {
 		if cfg.devices != "" {
@@ -201,7 +219,7 @@ func prepareOSD(cmd *cobra.Command, args []string) error {
 		}
 
 		dataDevices = []osddaemon.DesiredDevice{
-			{Name: osdDataDevicePathFilter, IsDevicePathFilter: true, OSDsPerDevice: cfg.storeConfig.OSDsPerDevice},
+			{Name: osdDataDevicePathFilter, IsDevicePathFilter: true, OSDsPerDevice: cfg.storeConfig.OSDsPerDevice, Selector: deviceSelector},
 		}
 	} else {
 		var err error
@@ -211,6 +229,22 @@ func prepareOSD(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	configOverrides, err := osdcfg.ParseConfigOverrides(osdConfigOverrides)
+	if err != nil {
+		rook.TerminateFatal(errors.Wrap(err, "failed to parse the osd config overrides"))
+	}
+	cfg.storeConfig.ConfigOverrides = configOverrides
+	if cfg.storeConfig.BlueStoreRocksDBOptions != "" {
+		if err := osdcfg.ValidateRocksDBOptions(cfg.storeConfig.BlueStoreRocksDBOptions); err != nil {
+			rook.TerminateFatal(err)
+		}
+	}
+	if cfg.storeConfig.PGAutoscaleMode != "" {
+		if err := osdcfg.ValidatePGAutoscaleMode(cfg.storeConfig.PGAutoscaleMode); err != nil {
+			rook.TerminateFatal(err)
+		}
+	}
+
 	context := createContext()
 	commonOSDInit(provisionCmd)
 	crushLocation, topologyAffinity, err := getLocation(context.Clientset)
@@ -226,7 +260,7 @@ func prepareOSD(cmd *cobra.Command, args []string) error {
 	clusterInfo.OwnerInfo = ownerInfo
 	kv := k8sutil.NewConfigMapKVStore(clusterInfo.Namespace, context.Clientset, ownerInfo)
 	agent := osddaemon.NewAgent(context, dataDevices, cfg.metadataDevice, forceFormat,
-		cfg.storeConfig, &clusterInfo, cfg.nodeName, kv, cfg.pvcBacked)
+		cfg.storeConfig, &clusterInfo, cfg.nodeName, kv, cfg.pvcBacked, cfg.strictDeviceCheck, cfg.wipeDevices, cfg.maxNewOSDs)
 
 	err = osddaemon.Provision(context, agent, crushLocation, topologyAffinity)
 	if err != nil {
@@ -310,6 +344,8 @@ func parseDevices(devices string) ([]osddaemon.DesiredDevice, error) {
 		d.DeviceClass = cd.StoreConfig.DeviceClass
 		d.InitialWeight = cd.StoreConfig.InitialWeight
 		d.MetadataDevice = cd.StoreConfig.MetadataDevice
+		d.Encrypted = cd.StoreConfig.EncryptedDevice
+		d.Zoned = cd.StoreConfig.Zoned
 
 		if d.OSDsPerDevice < 1 {
 			return nil, errors.Errorf("osds per device should be greater than 0 (%q)", d.OSDsPerDevice)
@@ -173,3 +173,47 @@ func TestParseDesiredDevices(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []osddaemon.DesiredDevice{}, result)
 }
+
+func TestParseDevicesWithSeparators(t *testing.T) {
+	// the devices are passed as JSON so names with the characters used as separators by older versions
+	// of Rook, like the by-path names, are not misparsed
+	configuredDevices := []osdcfg.ConfiguredDevice{
+		{
+			ID: "/dev/disk/by-path/pci-0000:00:1f.2-ata-1",
+			StoreConfig: osdcfg.StoreConfig{
+				OSDsPerDevice:  2,
+				MetadataDevice: "/dev/disk/by-path/pci-0000:3b:00.0-nvme-1",
+			},
+		},
+		{
+			ID:          "/dev/disk/by-id/wwn-0x5000c500a0b1c2d3,part1",
+			StoreConfig: osdcfg.StoreConfig{OSDsPerDevice: 1},
+		},
+	}
+	marshalledDevices, err := json.Marshal(configuredDevices)
+	assert.NoError(t, err)
+
+	result, err := parseDevices(string(marshalledDevices))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(result))
+	assert.Equal(t, "/dev/disk/by-path/pci-0000:00:1f.2-ata-1", result[0].Name)
+	assert.Equal(t, 2, result[0].OSDsPerDevice)
+	assert.Equal(t, "/dev/disk/by-path/pci-0000:3b:00.0-nvme-1", result[0].MetadataDevice)
+	assert.Equal(t, "/dev/disk/by-id/wwn-0x5000c500a0b1c2d3,part1", result[1].Name)
+	assert.Equal(t, 1, result[1].OSDsPerDevice)
+}
+
+func TestParseDevicesEncryption(t *testing.T) {
+	configuredDevices := []osdcfg.ConfiguredDevice{
+		{ID: "sda", StoreConfig: osdcfg.StoreConfig{OSDsPerDevice: 1, EncryptedDevice: true}},
+		{ID: "sdb", StoreConfig: osdcfg.StoreConfig{OSDsPerDevice: 1}},
+	}
+	marshalledDevices, err := json.Marshal(configuredDevices)
+	assert.NoError(t, err)
+
+	result, err := parseDevices(string(marshalledDevices))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(result))
+	assert.True(t, result[0].Encrypted)
+	assert.False(t, result[1].Encrypted)
+}
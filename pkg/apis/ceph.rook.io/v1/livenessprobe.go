@@ -39,3 +39,8 @@ func GetOSDLivenessProbe(l CephClusterHealthCheckSpec) *corev1.Probe {
 func GetMdsLivenessProbe(l CephClusterHealthCheckSpec) *corev1.Probe {
 	return l.LivenessProbe[ResourcesKeyMDS].Probe
 }
+
+// GetOSDReadinessProbe returns the readiness probe for the OSD service
+func GetOSDReadinessProbe(l CephClusterHealthCheckSpec) *corev1.Probe {
+	return l.ReadinessProbe[ResourcesKeyOSD].Probe
+}
@@ -15,6 +15,11 @@ limitations under the License.
 */
 package v1
 
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
 // AnyUseAllDevices gets whether to use all devices
 func (s *StorageScopeSpec) AnyUseAllDevices() bool {
 	if s.Selection.GetUseAllDevices() {
@@ -168,3 +173,20 @@ func (s *StorageScopeSpec) IsOnPVCEncrypted() bool {
 
 	return false
 }
+
+// RequestedStorage returns the total storage requested by the PVCs of the device set, i.e. the sum of
+// the storage requests of the volume claim templates times the count of the device set. The templates
+// without a storage request do not count.
+func (s *StorageClassDeviceSet) RequestedStorage() resource.Quantity {
+	perDevice := resource.Quantity{}
+	for _, template := range s.VolumeClaimTemplates {
+		if request, ok := template.Spec.Resources.Requests[v1.ResourceStorage]; ok {
+			perDevice.Add(request)
+		}
+	}
+	total := resource.Quantity{}
+	for i := 0; i < s.Count; i++ {
+		total.Add(perDevice)
+	}
+	return total
+}
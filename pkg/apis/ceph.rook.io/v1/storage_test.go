@@ -19,6 +19,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func TestNodeExists(t *testing.T) {
@@ -287,3 +289,38 @@ func TestIsOnPVCEncrypted(t *testing.T) {
 	}
 	assert.True(t, s.IsOnPVCEncrypted())
 }
+
+func TestStorageClassDeviceSetRequestedStorage(t *testing.T) {
+	template := func(storage string) v1.PersistentVolumeClaim {
+		pvc := v1.PersistentVolumeClaim{}
+		if storage != "" {
+			pvc.Spec.Resources.Requests = v1.ResourceList{v1.ResourceStorage: resource.MustParse(storage)}
+		}
+		return pvc
+	}
+
+	// data, metadata and wal templates
+	deviceSet := &StorageClassDeviceSet{
+		Count:                3,
+		VolumeClaimTemplates: []v1.PersistentVolumeClaim{template("10Gi"), template("5Gi"), template("1Gi")},
+	}
+	expected := resource.MustParse("48Gi")
+	requested := deviceSet.RequestedStorage()
+	assert.Equal(t, 0, expected.Cmp(requested), requested.String())
+
+	// the templates without a storage request do not count
+	deviceSet.VolumeClaimTemplates = []v1.PersistentVolumeClaim{template("10Gi"), template("")}
+	expected = resource.MustParse("30Gi")
+	requested = deviceSet.RequestedStorage()
+	assert.Equal(t, 0, expected.Cmp(requested), requested.String())
+
+	deviceSet.VolumeClaimTemplates = []v1.PersistentVolumeClaim{template("")}
+	requested = deviceSet.RequestedStorage()
+	assert.True(t, requested.IsZero())
+
+	// no device
+	deviceSet.VolumeClaimTemplates = []v1.PersistentVolumeClaim{template("10Gi")}
+	deviceSet.Count = 0
+	requested = deviceSet.RequestedStorage()
+	assert.True(t, requested.IsZero())
+}
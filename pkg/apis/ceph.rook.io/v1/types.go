@@ -21,6 +21,7 @@ import (
 
 	rook "github.com/rook/rook/pkg/apis/rook.io"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -62,6 +63,9 @@ type CephClusterHealthCheckSpec struct {
 	// LivenessProbe allows to change the livenessprobe configuration for a given daemon
 	// +optional
 	LivenessProbe map[rook.KeyType]*ProbeSpec `json:"livenessProbe,omitempty"`
+	// ReadinessProbe enables and configures the readinessprobe of a given daemon, only the osd is supported. No readiness probe is set by default
+	// +optional
+	ReadinessProbe map[rook.KeyType]*ProbeSpec `json:"readinessProbe,omitempty"`
 }
 
 // DaemonHealthSpec is a daemon health check
@@ -1440,6 +1444,14 @@ type DisruptionManagementSpec struct {
 	// +optional
 	PGHealthCheckTimeout time.Duration `json:"pgHealthCheckTimeout,omitempty"`
 
+	// OSDMaxUnavailable is the number of OSDs allowed to be unavailable at the same time by the default
+	// OSD poddisruptionbudget when no failure domain is draining. It only works if managePodBudgets is true.
+	// Values above 1 only apply if all the OSDs are in a single failure domain, otherwise 1 is used.
+	// The default is 1.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	OSDMaxUnavailable int `json:"osdMaxUnavailable,omitempty"`
+
 	// This enables management of machinedisruptionbudgets
 	// +optional
 	ManageMachineDisruptionBudgets bool `json:"manageMachineDisruptionBudgets,omitempty"`
@@ -1676,6 +1688,333 @@ type StorageScopeSpec struct {
 	// +nullable
 	// +optional
 	StorageClassDeviceSets []StorageClassDeviceSet `json:"storageClassDeviceSets,omitempty"`
+	// BlockDevMapperImage is the image used by the init containers copying the OSD block devices
+	// on PVC to the OSD data directory. It must provide bash and the GNU coreutils. Defaults to the
+	// Ceph image.
+	// +optional
+	BlockDevMapperImage string `json:"blockDevMapperImage,omitempty"`
+	// BridgeVolumeMedium is the medium of the emptyDir volumes used by the OSD prepare jobs to copy
+	// the block devices on PVC, either "Memory" or "Disk". Defaults to "Memory".
+	// +kubebuilder:validation:Enum=Memory;Disk
+	// +optional
+	BridgeVolumeMedium string `json:"bridgeVolumeMedium,omitempty"`
+	// BridgeVolumeSizeLimit is the size limit of the emptyDir volumes used by the OSD prepare jobs to copy
+	// the block devices on PVC. Defaults to 100Mi.
+	// +optional
+	BridgeVolumeSizeLimit *resource.Quantity `json:"bridgeVolumeSizeLimit,omitempty"`
+	// BinariesVolumeSizeLimit is the size limit of the emptyDir volume receiving the rook binaries
+	// copied into the OSD pods. Defaults to 512Mi.
+	// +optional
+	BinariesVolumeSizeLimit *resource.Quantity `json:"binariesVolumeSizeLimit,omitempty"`
+	// RunAsCephUser runs the OSD daemon containers as the ceph user instead of root when the OSD
+	// supports it. OSD provisioning always runs as root.
+	// +optional
+	RunAsCephUser bool `json:"runAsCephUser,omitempty"`
+	// SeccompProfile is set on the security context of all the containers of the OSD daemon and
+	// OSD prepare pods. No profile is set by default.
+	// +optional
+	// +nullable
+	SeccompProfile *v1.SeccompProfile `json:"seccompProfile,omitempty"`
+	// UseCapabilities runs the OSD daemon containers unprivileged with only the capabilities they
+	// need when the OSD supports it. Containers mapping devices always run privileged.
+	// +optional
+	UseCapabilities bool `json:"useCapabilities,omitempty"`
+	// MaxOSDsPerNode is the maximum number of OSDs provisioned on a node. The prepare jobs do not
+	// create OSDs on the devices above this limit. Zero means no limit.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxOSDsPerNode int `json:"maxOSDsPerNode,omitempty"`
+	// ExtraVolumes are added to the OSD daemon pods, e.g. to provide secrets or config maps to
+	// custom tooling. The names must not collide with the volumes managed by Rook.
+	// +optional
+	// +nullable
+	ExtraVolumes []v1.Volume `json:"extraVolumes,omitempty"`
+	// ExtraVolumeMounts are added to the OSD daemon container
+	// +optional
+	// +nullable
+	ExtraVolumeMounts []v1.VolumeMount `json:"extraVolumeMounts,omitempty"`
+	// ExtraEnv are environment variables added to the OSD daemon container. They cannot override
+	// the environment variables set by Rook.
+	// +optional
+	// +nullable
+	ExtraEnv []v1.EnvVar `json:"extraEnv,omitempty"`
+	// OSDCrushLocations overrides the CRUSH location passed to already provisioned OSDs. The keys are
+	// the OSD IDs and the values map CRUSH bucket types to bucket names, e.g. "3": {"rack": "rack1"}.
+	// The bucket types not overridden keep the location found when the OSD was provisioned.
+	// +optional
+	// +nullable
+	OSDCrushLocations map[string]map[string]string `json:"osdCrushLocations,omitempty"`
+	// OSDHostnames overrides the hostname label of the node where already provisioned OSDs run, e.g.
+	// after the node holding their devices was renamed. The keys are the OSD IDs. The CRUSH location
+	// of the OSDs does not change. OSDs on portable PVCs are not pinned to a node and ignore it.
+	// +optional
+	// +nullable
+	OSDHostnames map[string]string `json:"osdHostnames,omitempty"`
+	// MaxConcurrentPrepareJobs is the maximum number of OSD prepare jobs running at the same time. The
+	// jobs above the limit are launched by a later reconcile. Zero means no limit.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxConcurrentPrepareJobs int `json:"maxConcurrentPrepareJobs,omitempty"`
+	// OSDKeyring mounts the keyring of the OSD daemons from a secret instead of using the keyring
+	// created in the OSD data directory
+	// +optional
+	// +nullable
+	OSDKeyring *OSDKeyringSpec `json:"osdKeyring,omitempty"`
+	// RuntimeClassName is the runtime class of the OSD daemon and OSD prepare pods, e.g. to run
+	// them with a container runtime giving access to specific hardware
+	// +optional
+	// +nullable
+	RuntimeClassName *string `json:"runtimeClassName,omitempty"`
+	// AutomountServiceAccountToken sets whether the service account token is mounted in the OSD
+	// daemon pods. The OSD prepare pods and the daemon pods of lvm OSDs on PVC always mount it
+	// since they call the Kubernetes API.
+	// +optional
+	// +nullable
+	AutomountServiceAccountToken *bool `json:"automountServiceAccountToken,omitempty"`
+	// TerminationMessagePolicy is set on all the containers of the OSD daemon and OSD prepare pods.
+	// FallbackToLogsOnError reports the end of the container logs as the termination message when
+	// a container fails. The default policy of Kubernetes applies if not set.
+	// +kubebuilder:validation:Enum=File;FallbackToLogsOnError;""
+	// +optional
+	TerminationMessagePolicy v1.TerminationMessagePolicy `json:"terminationMessagePolicy,omitempty"`
+	// StrictDeviceCheck makes the OSD prepare jobs refuse the devices that appear to be in use, i.e.
+	// read-only devices and devices with partitions or holders, instead of letting ceph-volume decide
+	// +optional
+	StrictDeviceCheck bool `json:"strictDeviceCheck,omitempty"`
+	// PrepareJobTTLSecondsAfterFinished is the time to live of the finished OSD prepare jobs, they are
+	// then deleted by the TTL controller of Kubernetes. The finished jobs are kept if not set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	// +nullable
+	PrepareJobTTLSecondsAfterFinished *int32 `json:"prepareJobTTLSecondsAfterFinished,omitempty"`
+	// PVCLabelPrefix is the prefix of the keys of the labels set by Rook on the PVCs of the storage
+	// class device sets, e.g. "<prefix>/DeviceSet". Defaults to "ceph.rook.io".
+	// +optional
+	PVCLabelPrefix string `json:"pvcLabelPrefix,omitempty"`
+	// SchedulerName is the name of the scheduler of the OSD and OSD prepare pods. The scheduler name of
+	// a storage class device set takes precedence. Defaults to the default scheduler.
+	// +optional
+	SchedulerName string `json:"schedulerName,omitempty"`
+	// DevicesHostPathType is the type of the hostPath volume of /dev in the OSD and OSD prepare pods,
+	// e.g. "Directory" so the pods fail to start if the path is missing on the host.
+	// +kubebuilder:validation:Enum="";DirectoryOrCreate;Directory;FileOrCreate;File;Socket;CharDevice;BlockDevice
+	// +optional
+	// +nullable
+	DevicesHostPathType *v1.HostPathType `json:"devicesHostPathType,omitempty"`
+	// DataDirHostPathType is the type of the hostPath volume of the dataDirHostPath in the OSD and OSD
+	// prepare pods, e.g. "Directory" so the pods fail to start if the path is missing on the host.
+	// +kubebuilder:validation:Enum="";DirectoryOrCreate;Directory;FileOrCreate;File;Socket;CharDevice;BlockDevice
+	// +optional
+	// +nullable
+	DataDirHostPathType *v1.HostPathType `json:"dataDirHostPathType,omitempty"`
+	// CrushUpdateOnStart is whether the OSDs update their crush location when they start. Set it to
+	// false if the crush map is managed outside of Rook. Defaults to true.
+	// +optional
+	// +nullable
+	CrushUpdateOnStart *bool `json:"crushUpdateOnStart,omitempty"`
+	// CABundle mounts a CA trust bundle from a configmap or a secret into the OSD and OSD prepare
+	// pods, e.g. to reach a KMS served with a certificate signed by an internal CA
+	// +optional
+	// +nullable
+	CABundle *OSDCABundleSpec `json:"caBundle,omitempty"`
+	// MemoryTargets are the osd_memory_target of the OSDs of each device class, e.g. to give more
+	// memory to the OSDs on SSDs. The OSDs of the other device classes compute their memory target
+	// from the memory limit of their pod.
+	// +optional
+	// +nullable
+	MemoryTargets map[string]resource.Quantity `json:"memoryTargets,omitempty"`
+	// WipeDevicesOnProvision makes the OSD prepare jobs wipe the devices listed by name before creating
+	// new OSDs on them, so the leftovers of a previous OSD do not prevent the provisioning. The devices
+	// holding an OSD of this cluster and the PVCs are never wiped, a PVC reused after its OSD was purged
+	// must be zapped manually. DANGER: the data of the wiped devices is lost.
+	// +optional
+	WipeDevicesOnProvision bool `json:"wipeDevicesOnProvision,omitempty"`
+	// Debug enables the debugging options of the OSD daemon containers
+	// +optional
+	// +nullable
+	Debug *OSDDebugSpec `json:"debug,omitempty"`
+	// LogHostPath is the absolute directory on the hosts the OSDs write their log files to, instead of
+	// the log directory below the dataDirHostPath. It is only used when the log collector is enabled.
+	// +optional
+	LogHostPath string `json:"logHostPath,omitempty"`
+	// PVCFinalizer is a finalizer added to the PVCs created for the storage class device sets, e.g.
+	// "example.com/osd-protection", to prevent deleting them by accident. Unlike the
+	// kubernetes.io/pvc-protection finalizer, it also keeps the PVC while the OSD pod is not running.
+	// The finalizer is removed when the OSD is removed.
+	// +optional
+	PVCFinalizer string `json:"pvcFinalizer,omitempty"`
+	// ProvisionCommand replaces the command and the args of the container of the OSD prepare jobs,
+	// e.g. to run a custom wrapper of "/rook/rook ceph osd provision". The env vars and the volume
+	// mounts of the container are kept.
+	// +optional
+	// +nullable
+	ProvisionCommand []string `json:"provisionCommand,omitempty"`
+	// NodeMemoryTarget derives the osd_memory_target of the OSDs on nodes without a memory limit from
+	// the allocatable memory of their node. It does not apply to the OSDs on PVC.
+	// +optional
+	// +nullable
+	NodeMemoryTarget *OSDNodeMemoryTargetSpec `json:"nodeMemoryTarget,omitempty"`
+	// PrepareHostnames overrides the hostname label of the node where the OSD prepare job of a node
+	// runs, e.g. to prepare the devices on a staging node before they are moved to their node. The keys
+	// are the names of the nodes of the storage spec. The OSDs still run on the nodes of the storage
+	// spec. The prepare jobs of the OSDs on PVC ignore it.
+	// +optional
+	// +nullable
+	PrepareHostnames map[string]string `json:"prepareHostnames,omitempty"`
+	// ProgressDeadlineSeconds is the progress deadline of the OSD deployments, the time an OSD pod may
+	// take to start before its deployment is reported as failed, e.g. a longer deadline for the OSDs
+	// that are slow to start. The default of Kubernetes applies if it is not set.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	// +nullable
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	// Sysfs mounts the /sys directory of the host read-only in the OSD prepare and OSD daemon
+	// containers, for the devices whose provisioning requires reading sysfs. It does not apply to the
+	// OSDs on PVC.
+	// +optional
+	// +nullable
+	Sysfs *OSDSysfsSpec `json:"sysfs,omitempty"`
+	// AdminSocketDir is the absolute directory of the admin sockets of the OSD daemons in their
+	// containers, checked by the liveness and readiness probes of the OSDs. Defaults to /run/ceph.
+	// +optional
+	AdminSocketDir string `json:"adminSocketDir,omitempty"`
+	// NodeSelector is merged with the hostname label in the node selector of the OSD pods on nodes,
+	// e.g. to also require a storage tier label on the nodes. The hostname label cannot be overridden.
+	// It does not apply to the prepare jobs and to the portable OSDs on PVC.
+	// +optional
+	// +nullable
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// ValidateDataPath adds an init container to the OSD pods checking that the data path of the OSD
+	// exists and is writable before the OSD daemon starts
+	// +optional
+	ValidateDataPath bool `json:"validateDataPath,omitempty"`
+	// Restricted runs the OSD daemon pods without host paths, host namespaces or privileged
+	// containers, for the clusters forbidding them. Only the raw mode OSDs on PVC of the device sets
+	// without encryption are supported. The OSD prepare jobs are not restricted.
+	// +optional
+	Restricted bool `json:"restricted,omitempty"`
+	// AdminSocketEmptyDir mounts an emptyDir at the admin socket directory of the OSDs in all the
+	// containers of the OSD pods, for the tools sharing the admin sockets with the OSD daemon
+	// +optional
+	AdminSocketEmptyDir bool `json:"adminSocketEmptyDir,omitempty"`
+	// PVCOwnerReference is whether the CephCluster is set as the owner of the PVCs created for the
+	// storage class device sets, so they are garbage collected when the CephCluster is deleted.
+	// Defaults to true.
+	// +optional
+	// +nullable
+	PVCOwnerReference *bool `json:"pvcOwnerReference,omitempty"`
+	// BinariesMountPath is the absolute directory the rook and tini binaries are copied to in the OSD
+	// and OSD prepare containers, for the images where the default /rook directory is used already
+	// +optional
+	BinariesMountPath string `json:"binariesMountPath,omitempty"`
+	// CephConfigDir adds the keys of a configmap as files of the /etc/ceph directory of the OSD and
+	// OSD prepare containers, next to the ceph.conf managed by Rook
+	// +optional
+	// +nullable
+	CephConfigDir *OSDCephConfigDirSpec `json:"cephConfigDir,omitempty"`
+	// RestartThrottle delays the start of the OSD pods restarting repeatedly, e.g. on a failing disk
+	// +optional
+	// +nullable
+	RestartThrottle *OSDRestartThrottleSpec `json:"restartThrottle,omitempty"`
+	// OSDImages overrides the ceph image of already provisioned OSDs, e.g. to keep some OSDs on the
+	// previous image during a phased upgrade. The keys are the OSD IDs. The prepare jobs always run the
+	// cluster image.
+	// +optional
+	// +nullable
+	OSDImages map[string]string `json:"osdImages,omitempty"`
+	// ReadOnlyRootFilesystem runs the OSD daemon containers with a read-only root filesystem. The
+	// directories written by the daemons, /tmp and /var/run, are mounted from emptyDirs. The OSDs on
+	// PVC in lvm mode keep a writable root filesystem since they are activated in the daemon container.
+	// +optional
+	ReadOnlyRootFilesystem bool `json:"readOnlyRootFilesystem,omitempty"`
+	// PodHostname is the hostname of the OSD pods, suffixed with the OSD ID so that each OSD pod has
+	// its own hostname, e.g. "osd" for the hostname "osd-3" of OSD 3
+	// +optional
+	PodHostname string `json:"podHostname,omitempty"`
+	// PodSubdomain is the subdomain of the OSD pods, the name of a headless service selecting them
+	// +optional
+	PodSubdomain string `json:"podSubdomain,omitempty"`
+	// PVCBindTimeoutSeconds is how long after their creation the OSDs of the storage class device sets
+	// wait for their PVCs to be bound before being provisioned, so that the placement of the OSDs is
+	// derived from the bound PVs. The reconcile is retried until the PVCs are bound or the timeout
+	// elapsed, after which the OSDs are provisioned with the pending PVCs. Zero means no wait.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	PVCBindTimeoutSeconds int `json:"pvcBindTimeoutSeconds,omitempty"`
+}
+
+// OSDRestartThrottleSpec is when and how long the start of a repeatedly restarting OSD pod is delayed
+type OSDRestartThrottleSpec struct {
+	// FailureThreshold is the number of failed starts in a row allowed before the starts are delayed.
+	// Defaults to 3.
+	// +optional
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+	// DelaySeconds is the number of seconds the starts are delayed
+	DelaySeconds int `json:"delaySeconds"`
+	// WindowSeconds is the number of seconds after a start during which the next start of the pod
+	// counts as a failed start. Defaults to 600.
+	// +optional
+	WindowSeconds int `json:"windowSeconds,omitempty"`
+}
+
+// OSDCABundleSpec is the configmap or secret holding a CA trust bundle and the directory it is mounted at
+type OSDCABundleSpec struct {
+	// ConfigMapName is the name of the configmap holding the CA bundle in the namespace of the cluster
+	// +optional
+	ConfigMapName string `json:"configMapName,omitempty"`
+	// SecretName is the name of the secret holding the CA bundle in the namespace of the cluster, it
+	// cannot be set with ConfigMapName
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+	// MountPath is the directory the keys of the configmap or secret are mounted in, in all the
+	// containers of the pods. Defaults to "/etc/rook/ca-bundle".
+	// +optional
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// OSDCephConfigDirSpec is the configmap holding the additional files of the ceph config directory
+type OSDCephConfigDirSpec struct {
+	// ConfigMapName is the name of the configmap in the namespace of the cluster
+	ConfigMapName string `json:"configMapName"`
+	// Items are the keys of the configmap and the names of the files they are written to. The files
+	// managed by Rook, e.g. ceph.conf, cannot be overridden.
+	Items []v1.KeyToPath `json:"items"`
+}
+
+// OSDDebugSpec are the options easing the interactive troubleshooting of the OSD daemon containers
+type OSDDebugSpec struct {
+	// Stdin allocates a buffer for stdin in the OSD daemon containers, e.g. to attach to them
+	// +optional
+	Stdin bool `json:"stdin,omitempty"`
+	// TTY allocates a TTY in the OSD daemon containers, usually set together with Stdin
+	// +optional
+	TTY bool `json:"tty,omitempty"`
+}
+
+// OSDNodeMemoryTargetSpec divides the allocatable memory of a node between the OSDs running on it
+type OSDNodeMemoryTargetSpec struct {
+	// ExpectedOSDsPerNode is the number of OSDs the allocatable memory of the node is divided by
+	// +kubebuilder:validation:Minimum=1
+	ExpectedOSDsPerNode int `json:"expectedOSDsPerNode"`
+}
+
+// OSDSysfsSpec selects the containers the /sys directory of the host is mounted in
+type OSDSysfsSpec struct {
+	// Prepare mounts /sys in the provision container of the OSD prepare pods
+	// +optional
+	Prepare bool `json:"prepare,omitempty"`
+	// Daemon mounts /sys in the OSD daemon containers
+	// +optional
+	Daemon bool `json:"daemon,omitempty"`
+}
+
+// OSDKeyringSpec is the secret holding the keyring of the OSD daemons and the path it is mounted at
+type OSDKeyringSpec struct {
+	// SecretName is the name of the secret holding the keyring in the namespace of the cluster
+	SecretName string `json:"secretName"`
+	// Path is the path of the keyring passed to the OSD daemons with --keyring. The secret is mounted
+	// in the parent directory of the path, and the file name must be a key of the secret.
+	Path string `json:"path"`
 }
 
 // Node is a storage nodes
@@ -1715,6 +2054,11 @@ type Selection struct {
 	// A regular expression to allow more fine-grained selection of devices with path names
 	// +optional
 	DevicePathFilter string `json:"devicePathFilter,omitempty"`
+	// DeviceSelector restricts the devices selected with useAllDevices, deviceFilter or
+	// devicePathFilter to the devices matching all its criteria
+	// +optional
+	// +nullable
+	DeviceSelector *DeviceSelector `json:"deviceSelector,omitempty"`
 	// List of devices to use as storage devices
 	// +kubebuilder:pruning:PreserveUnknownFields
 	// +nullable
@@ -1725,6 +2069,27 @@ type Selection struct {
 	VolumeClaimTemplates []v1.PersistentVolumeClaim `json:"volumeClaimTemplates,omitempty"`
 }
 
+// DeviceSelector selects devices by their properties. A device must match all the criteria set.
+type DeviceSelector struct {
+	// MinSize is the minimum size of the devices, e.g. 100Gi
+	// +optional
+	MinSize string `json:"minSize,omitempty"`
+	// MaxSize is the maximum size of the devices, e.g. 2Ti
+	// +optional
+	MaxSize string `json:"maxSize,omitempty"`
+	// Rotational selects the rotational devices (HDDs) if true, the non-rotational devices (SSDs and
+	// NVMe devices) if false
+	// +optional
+	// +nullable
+	Rotational *bool `json:"rotational,omitempty"`
+	// Vendor is the vendor of the devices reported by udev, compared case-insensitively with underscores matching spaces
+	// +optional
+	Vendor string `json:"vendor,omitempty"`
+	// Model is the model of the devices reported by udev, compared case-insensitively with underscores matching spaces
+	// +optional
+	Model string `json:"model,omitempty"`
+}
+
 // PlacementSpec is the placement for core ceph daemons part of the CephCluster CRD
 type PlacementSpec map[rook.KeyType]Placement
 
@@ -23,6 +23,7 @@ package v1
 import (
 	rookio "github.com/rook/rook/pkg/apis/rook.io"
 	corev1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
@@ -351,6 +352,21 @@ func (in *CephClusterHealthCheckSpec) DeepCopyInto(out *CephClusterHealthCheckSp
 			(*out)[key] = outVal
 		}
 	}
+	if in.ReadinessProbe != nil {
+		in, out := &in.ReadinessProbe, &out.ReadinessProbe
+		*out = make(map[rookio.KeyType]*ProbeSpec, len(*in))
+		for key, val := range *in {
+			var outVal *ProbeSpec
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = new(ProbeSpec)
+				(*in).DeepCopyInto(*out)
+			}
+			(*out)[key] = outVal
+		}
+	}
 	return
 }
 
@@ -1423,6 +1439,27 @@ func (in *DeviceClasses) DeepCopy() *DeviceClasses {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DeviceSelector) DeepCopyInto(out *DeviceSelector) {
+	*out = *in
+	if in.Rotational != nil {
+		in, out := &in.Rotational, &out.Rotational
+		*out = new(bool)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DeviceSelector.
+func (in *DeviceSelector) DeepCopy() *DeviceSelector {
+	if in == nil {
+		return nil
+	}
+	out := new(DeviceSelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DisruptionManagementSpec) DeepCopyInto(out *DisruptionManagementSpec) {
 	*out = *in
@@ -2011,6 +2048,125 @@ func (in NodesByName) DeepCopy() NodesByName {
 	return *out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSDCABundleSpec) DeepCopyInto(out *OSDCABundleSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSDCABundleSpec.
+func (in *OSDCABundleSpec) DeepCopy() *OSDCABundleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OSDCABundleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSDCephConfigDirSpec) DeepCopyInto(out *OSDCephConfigDirSpec) {
+	*out = *in
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]corev1.KeyToPath, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSDCephConfigDirSpec.
+func (in *OSDCephConfigDirSpec) DeepCopy() *OSDCephConfigDirSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OSDCephConfigDirSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSDDebugSpec) DeepCopyInto(out *OSDDebugSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSDDebugSpec.
+func (in *OSDDebugSpec) DeepCopy() *OSDDebugSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OSDDebugSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSDKeyringSpec) DeepCopyInto(out *OSDKeyringSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSDKeyringSpec.
+func (in *OSDKeyringSpec) DeepCopy() *OSDKeyringSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OSDKeyringSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSDNodeMemoryTargetSpec) DeepCopyInto(out *OSDNodeMemoryTargetSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSDNodeMemoryTargetSpec.
+func (in *OSDNodeMemoryTargetSpec) DeepCopy() *OSDNodeMemoryTargetSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OSDNodeMemoryTargetSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSDRestartThrottleSpec) DeepCopyInto(out *OSDRestartThrottleSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSDRestartThrottleSpec.
+func (in *OSDRestartThrottleSpec) DeepCopy() *OSDRestartThrottleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OSDRestartThrottleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OSDSysfsSpec) DeepCopyInto(out *OSDSysfsSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OSDSysfsSpec.
+func (in *OSDSysfsSpec) DeepCopy() *OSDSysfsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(OSDSysfsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ObjectRealmSpec) DeepCopyInto(out *ObjectRealmSpec) {
 	*out = *in
@@ -2571,6 +2727,11 @@ func (in *Selection) DeepCopyInto(out *Selection) {
 		*out = new(bool)
 		**out = **in
 	}
+	if in.DeviceSelector != nil {
+		in, out := &in.DeviceSelector, &out.DeviceSelector
+		*out = new(DeviceSelector)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Devices != nil {
 		in, out := &in.Devices, &out.Devices
 		*out = make([]Device, len(*in))
@@ -2768,6 +2929,174 @@ func (in *StorageScopeSpec) DeepCopyInto(out *StorageScopeSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.BridgeVolumeSizeLimit != nil {
+		in, out := &in.BridgeVolumeSizeLimit, &out.BridgeVolumeSizeLimit
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.BinariesVolumeSizeLimit != nil {
+		in, out := &in.BinariesVolumeSizeLimit, &out.BinariesVolumeSizeLimit
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.SeccompProfile != nil {
+		in, out := &in.SeccompProfile, &out.SeccompProfile
+		*out = new(corev1.SeccompProfile)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExtraVolumes != nil {
+		in, out := &in.ExtraVolumes, &out.ExtraVolumes
+		*out = make([]corev1.Volume, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExtraVolumeMounts != nil {
+		in, out := &in.ExtraVolumeMounts, &out.ExtraVolumeMounts
+		*out = make([]corev1.VolumeMount, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExtraEnv != nil {
+		in, out := &in.ExtraEnv, &out.ExtraEnv
+		*out = make([]corev1.EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.OSDCrushLocations != nil {
+		in, out := &in.OSDCrushLocations, &out.OSDCrushLocations
+		*out = make(map[string]map[string]string, len(*in))
+		for key, val := range *in {
+			var outVal map[string]string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make(map[string]string, len(*in))
+				for key, val := range *in {
+					(*out)[key] = val
+				}
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.OSDHostnames != nil {
+		in, out := &in.OSDHostnames, &out.OSDHostnames
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.OSDKeyring != nil {
+		in, out := &in.OSDKeyring, &out.OSDKeyring
+		*out = new(OSDKeyringSpec)
+		**out = **in
+	}
+	if in.RuntimeClassName != nil {
+		in, out := &in.RuntimeClassName, &out.RuntimeClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.AutomountServiceAccountToken != nil {
+		in, out := &in.AutomountServiceAccountToken, &out.AutomountServiceAccountToken
+		*out = new(bool)
+		**out = **in
+	}
+	if in.PrepareJobTTLSecondsAfterFinished != nil {
+		in, out := &in.PrepareJobTTLSecondsAfterFinished, &out.PrepareJobTTLSecondsAfterFinished
+		*out = new(int32)
+		**out = **in
+	}
+	if in.DevicesHostPathType != nil {
+		in, out := &in.DevicesHostPathType, &out.DevicesHostPathType
+		*out = new(corev1.HostPathType)
+		**out = **in
+	}
+	if in.DataDirHostPathType != nil {
+		in, out := &in.DataDirHostPathType, &out.DataDirHostPathType
+		*out = new(corev1.HostPathType)
+		**out = **in
+	}
+	if in.CrushUpdateOnStart != nil {
+		in, out := &in.CrushUpdateOnStart, &out.CrushUpdateOnStart
+		*out = new(bool)
+		**out = **in
+	}
+	if in.CABundle != nil {
+		in, out := &in.CABundle, &out.CABundle
+		*out = new(OSDCABundleSpec)
+		**out = **in
+	}
+	if in.MemoryTargets != nil {
+		in, out := &in.MemoryTargets, &out.MemoryTargets
+		*out = make(map[string]resource.Quantity, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val.DeepCopy()
+		}
+	}
+	if in.Debug != nil {
+		in, out := &in.Debug, &out.Debug
+		*out = new(OSDDebugSpec)
+		**out = **in
+	}
+	if in.ProvisionCommand != nil {
+		in, out := &in.ProvisionCommand, &out.ProvisionCommand
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NodeMemoryTarget != nil {
+		in, out := &in.NodeMemoryTarget, &out.NodeMemoryTarget
+		*out = new(OSDNodeMemoryTargetSpec)
+		**out = **in
+	}
+	if in.PrepareHostnames != nil {
+		in, out := &in.PrepareHostnames, &out.PrepareHostnames
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ProgressDeadlineSeconds != nil {
+		in, out := &in.ProgressDeadlineSeconds, &out.ProgressDeadlineSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Sysfs != nil {
+		in, out := &in.Sysfs, &out.Sysfs
+		*out = new(OSDSysfsSpec)
+		**out = **in
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.PVCOwnerReference != nil {
+		in, out := &in.PVCOwnerReference, &out.PVCOwnerReference
+		*out = new(bool)
+		**out = **in
+	}
+	if in.CephConfigDir != nil {
+		in, out := &in.CephConfigDir, &out.CephConfigDir
+		*out = new(OSDCephConfigDirSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RestartThrottle != nil {
+		in, out := &in.RestartThrottle, &out.RestartThrottle
+		*out = new(OSDRestartThrottleSpec)
+		**out = **in
+	}
+	if in.OSDImages != nil {
+		in, out := &in.OSDImages, &out.OSDImages
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	return
 }
 
@@ -29,29 +29,36 @@ const (
 
 // OsdAgent represents the OSD struct of an agent
 type OsdAgent struct {
-	clusterInfo    *cephclient.ClusterInfo
-	nodeName       string
-	forceFormat    bool
-	devices        []DesiredDevice
-	metadataDevice string
-	storeConfig    config.StoreConfig
-	kv             *k8sutil.ConfigMapKVStore
-	pvcBacked      bool
+	clusterInfo       *cephclient.ClusterInfo
+	nodeName          string
+	forceFormat       bool
+	devices           []DesiredDevice
+	metadataDevice    string
+	storeConfig       config.StoreConfig
+	kv                *k8sutil.ConfigMapKVStore
+	pvcBacked         bool
+	strictDeviceCheck bool
+	wipeDevices       bool
+	// maxNewOSDs is the maximum number of OSDs created on the node, -1 for no limit
+	maxNewOSDs int
 }
 
 // NewAgent is the instantiation of the OSD agent
 func NewAgent(context *clusterd.Context, devices []DesiredDevice, metadataDevice string, forceFormat bool,
-	storeConfig config.StoreConfig, clusterInfo *cephclient.ClusterInfo, nodeName string, kv *k8sutil.ConfigMapKVStore, pvcBacked bool) *OsdAgent {
+	storeConfig config.StoreConfig, clusterInfo *cephclient.ClusterInfo, nodeName string, kv *k8sutil.ConfigMapKVStore, pvcBacked, strictDeviceCheck, wipeDevices bool, maxNewOSDs int) *OsdAgent {
 
 	return &OsdAgent{
-		devices:        devices,
-		metadataDevice: metadataDevice,
-		forceFormat:    forceFormat,
-		storeConfig:    storeConfig,
-		clusterInfo:    clusterInfo,
-		nodeName:       nodeName,
-		kv:             kv,
-		pvcBacked:      pvcBacked,
+		devices:           devices,
+		metadataDevice:    metadataDevice,
+		forceFormat:       forceFormat,
+		storeConfig:       storeConfig,
+		clusterInfo:       clusterInfo,
+		nodeName:          nodeName,
+		kv:                kv,
+		pvcBacked:         pvcBacked,
+		strictDeviceCheck: strictDeviceCheck,
+		wipeDevices:       wipeDevices,
+		maxNewOSDs:        maxNewOSDs,
 	}
 }
 
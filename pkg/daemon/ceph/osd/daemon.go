@@ -17,11 +17,13 @@ limitations under the License.
 package osd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"syscall"
 
@@ -30,6 +32,8 @@ import (
 	"github.com/rook/rook/pkg/clusterd"
 	"github.com/rook/rook/pkg/daemon/ceph/client"
 	oposd "github.com/rook/rook/pkg/operator/ceph/cluster/osd"
+	opconfig "github.com/rook/rook/pkg/operator/ceph/config"
+	"github.com/rook/rook/pkg/util/exec"
 	"github.com/rook/rook/pkg/util/sys"
 )
 
@@ -211,6 +215,20 @@ func Provision(context *clusterd.Context, agent *OsdAgent, crushLocation, topolo
 
 	context.Devices = rawDevices
 
+	if agent.wipeDevices && !agent.pvcBacked {
+		wiped, err := wipeDevices(context, agent)
+		if err != nil {
+			return errors.Wrap(err, "failed to wipe devices")
+		}
+		if wiped {
+			// discover the devices again since wiping them removed their partitions and holders
+			context.Devices, err = clusterd.DiscoverDevices(context.Executor)
+			if err != nil {
+				return errors.Wrap(err, "failed hardware discovery after wiping devices")
+			}
+		}
+	}
+
 	logger.Info("creating and starting the osds")
 
 	// determine the set of devices that can/should be used for OSDs.
@@ -218,6 +236,7 @@ func Provision(context *clusterd.Context, agent *OsdAgent, crushLocation, topolo
 	if err != nil {
 		return errors.Wrap(err, "failed to get available devices")
 	}
+	limitNewOSDs(agent, devices)
 
 	// orchestration is about to start, update the status
 	status = oposd.OrchestrationStatus{Status: oposd.OrchestrationStatusOrchestrating, PvcBackedOSD: agent.pvcBacked}
@@ -242,14 +261,22 @@ func Provision(context *clusterd.Context, agent *OsdAgent, crushLocation, topolo
 		return nil
 	}
 
-	// Populate CRUSH location for each OSD on the host
+	// Populate CRUSH location and the device class hint for each OSD on the host
 	for i := range deviceOSDs {
 		deviceOSDs[i].Location = crushLocation
 		deviceOSDs[i].TopologyAffinity = topologyAffinity
+		deviceOSDs[i].DeviceClassHint = agent.storeConfig.DeviceClassHint
 	}
 
 	logger.Infof("devices = %+v", deviceOSDs)
 
+	if err := setConfigOverrides(context, agent, deviceOSDs); err != nil {
+		return errors.Wrap(err, "failed to set the osd config overrides")
+	}
+	if err := setPGAutoscaleMode(context, agent); err != nil {
+		return errors.Wrap(err, "failed to set the default pg autoscale mode")
+	}
+
 	// Since we are done configuring the PVC we need to release it from LVM
 	// If we don't do this, the device will remain hold by LVM and we won't be able to detach it
 	// When running on PVC, the device is:
@@ -283,6 +310,211 @@ func Provision(context *clusterd.Context, agent *OsdAgent, crushLocation, topolo
 	return nil
 }
 
+// setConfigOverrides writes the config overrides and the rocksdb options in the config section of
+// each OSD in the mon config database
+func setConfigOverrides(context *clusterd.Context, agent *OsdAgent, osds []oposd.OSDInfo) error {
+	overrides := map[string]string{}
+	for k, v := range agent.storeConfig.ConfigOverrides {
+		overrides[k] = v
+	}
+	if agent.storeConfig.BlueStoreRocksDBOptions != "" {
+		overrides["bluestore_rocksdb_options"] = agent.storeConfig.BlueStoreRocksDBOptions
+	}
+	if len(overrides) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(overrides))
+	for k := range overrides {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	options := []opconfig.Option{}
+	for _, osd := range osds {
+		who := fmt.Sprintf("osd.%d", osd.ID)
+		for _, k := range keys {
+			options = append(options, opconfig.Option{Who: who, Option: k, Value: overrides[k]})
+		}
+	}
+	return opconfig.GetMonStore(context, agent.clusterInfo).SetAll(options...)
+}
+
+// setPGAutoscaleMode sets the default pg autoscale mode of the cluster. The mode is only the default
+// of the pools created afterwards, the mode of the existing pools is not changed.
+func setPGAutoscaleMode(context *clusterd.Context, agent *OsdAgent) error {
+	if agent.storeConfig.PGAutoscaleMode == "" {
+		return nil
+	}
+
+	logger.Infof("setting the default pg autoscale mode of the new pools to %q", agent.storeConfig.PGAutoscaleMode)
+	return opconfig.GetMonStore(context, agent.clusterInfo).Set("global", "osd_pool_default_pg_autoscale_mode", agent.storeConfig.PGAutoscaleMode)
+}
+
+// deviceInUse returns whether the device appears to be used by something else than a new OSD and why.
+// The partitions and the holders of the device are listed with lsblk since the device discovery does
+// not report them. The devices of the PVCs are not checked since they are dedicated to the OSD by the
+// claim.
+func deviceInUse(executor exec.Executor, device *sys.LocalDisk) (bool, string, error) {
+	if device.Readonly {
+		return true, "the device is read-only", nil
+	}
+
+	// lsblk lists the device itself first, then its partitions and holders
+	lines, err := sys.ListDevicesChild(executor, device.Name)
+	if err != nil {
+		return false, "", err
+	}
+	partitions, holders := 0, 0
+	for i, line := range lines {
+		if i == 0 || strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.Contains(line, fmt.Sprintf(`TYPE="%s"`, sys.PartType)) {
+			partitions++
+		} else {
+			holders++
+		}
+	}
+	if partitions > 0 {
+		return true, fmt.Sprintf("the device has %d partition(s)", partitions), nil
+	}
+	if holders > 0 {
+		return true, "the device has holders such as lvm or device mapper devices", nil
+	}
+	return false, "", nil
+}
+
+// wipeDevices zaps the devices listed by name in the desired devices so that the leftovers of a
+// previous OSD do not prevent provisioning a new one. The devices matched by a filter are never wiped,
+// nor are the devices holding an OSD of this cluster. It returns whether any device was wiped.
+func wipeDevices(context *clusterd.Context, agent *OsdAgent) (bool, error) {
+	wiped := false
+	for _, device := range context.Devices {
+		if !isDeviceListedByName(device, agent.devices) {
+			continue
+		}
+		if device.Readonly {
+			logger.Infof("not wiping read-only device %q", device.Name)
+			continue
+		}
+
+		devicePath := filepath.Join("/dev", device.Name)
+		hasOSD, err := deviceHasClusterOSD(context, agent.clusterInfo.FSID, devicePath)
+		if err != nil {
+			return wiped, errors.Wrapf(err, "failed to check if device %q holds an osd of this cluster", devicePath)
+		}
+		if hasOSD {
+			logger.Infof("not wiping device %q since it holds an osd of this cluster", devicePath)
+			continue
+		}
+
+		logger.Warningf("wiping device %q before provisioning it", devicePath)
+		if _, err := callCephVolume(context, true, "lvm", "zap", devicePath, "--destroy"); err != nil {
+			return wiped, errors.Wrapf(err, "failed to wipe device %q", devicePath)
+		}
+		wiped = true
+	}
+	return wiped, nil
+}
+
+// isDeviceListedByName returns whether the device is one of the desired devices given by name or by
+// one of its /dev links, as opposed to a device matched by a filter
+func isDeviceListedByName(device *sys.LocalDisk, desiredDevices []DesiredDevice) bool {
+	for _, desiredDevice := range desiredDevices {
+		if desiredDevice.IsFilter || desiredDevice.IsDevicePathFilter || desiredDevice.Name == "all" {
+			continue
+		}
+		if desiredDevice.Name == device.Name || desiredDevice.Name == filepath.Join("/dev", device.Name) {
+			return true
+		}
+		for _, link := range strings.Fields(device.DevLinks) {
+			if link == desiredDevice.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// limitNewOSDs removes the data devices on which creating OSDs would exceed the maximum number of new
+// OSDs of the node. The devices are considered in the order of their names and the metadata and wal
+// devices are always kept.
+func limitNewOSDs(agent *OsdAgent, devices *DeviceOsdMapping) {
+	if agent.maxNewOSDs < 0 {
+		return
+	}
+
+	names := []string{}
+	for name, entry := range devices.Entries {
+		if entry.Metadata == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	count := 0
+	for _, name := range names {
+		osdsPerDevice := agent.storeConfig.OSDsPerDevice
+		if devices.Entries[name].Config.OSDsPerDevice > 1 {
+			osdsPerDevice = devices.Entries[name].Config.OSDsPerDevice
+		}
+		if osdsPerDevice < 1 {
+			osdsPerDevice = 1
+		}
+		if count+osdsPerDevice > agent.maxNewOSDs {
+			logger.Warningf("skipping device %q, creating its osds would exceed the maximum of %d new osds on node %q", name, agent.maxNewOSDs, agent.nodeName)
+			delete(devices.Entries, name)
+			continue
+		}
+		count += osdsPerDevice
+	}
+}
+
+// isMetadataDevice returns whether the device is the metadata device of the node. The operator passes
+// the metadata device as a path below /dev, either the device name or one of its /dev links.
+func isMetadataDevice(device *sys.LocalDisk, metadataDevice string) bool {
+	if metadataDevice == "" {
+		return false
+	}
+	return isDeviceListedByName(device, []DesiredDevice{{Name: metadataDevice}})
+}
+
+// deviceHasClusterOSD returns whether ceph-volume finds an OSD of the cluster with the given fsid on
+// the device, either in raw or in lvm mode
+func deviceHasClusterOSD(context *clusterd.Context, cephfsid, devicePath string) (bool, error) {
+	result, err := callCephVolume(context, false, "raw", "list", devicePath, "--format", "json")
+	if err != nil {
+		return false, errors.Wrap(err, "failed to retrieve ceph-volume raw list results")
+	}
+	var rawOSDs map[string]osdInfoBlock
+	if err := json.Unmarshal([]byte(result), &rawOSDs); err != nil {
+		return false, errors.Wrap(err, "failed to unmarshal ceph-volume raw list results")
+	}
+	for _, osd := range rawOSDs {
+		if osd.CephFsid == cephfsid {
+			return true, nil
+		}
+	}
+
+	result, err = callCephVolume(context, false, "lvm", "list", devicePath, "--format", "json")
+	if err != nil {
+		return false, errors.Wrap(err, "failed to retrieve ceph-volume lvm list results")
+	}
+	var lvmOSDs map[string][]osdInfo
+	if err := json.Unmarshal([]byte(result), &lvmOSDs); err != nil {
+		return false, errors.Wrap(err, "failed to unmarshal ceph-volume lvm list results")
+	}
+	for _, lvs := range lvmOSDs {
+		for _, lv := range lvs {
+			if lv.Tags.ClusterFSID == cephfsid {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
 func getAvailableDevices(context *clusterd.Context, agent *OsdAgent) (*DeviceOsdMapping, error) {
 	desiredDevices := agent.devices
 	logger.Debugf("desiredDevices are %+v", desiredDevices)
@@ -316,6 +548,18 @@ func getAvailableDevices(context *clusterd.Context, agent *OsdAgent) (*DeviceOsd
 			}
 		}
 
+		if agent.strictDeviceCheck && !agent.pvcBacked {
+			inUse, reason, err := deviceInUse(context.Executor, device)
+			if err != nil {
+				logger.Infof("skipping device %q since its usage could not be checked. %v", device.Name, err)
+				continue
+			}
+			if inUse {
+				logger.Infof("skipping device %q that appears to be in use: %s", device.Name, reason)
+				continue
+			}
+		}
+
 		// If we detect a partition we have to make sure that ceph-volume will be able to consume it
 		// ceph-volume version 14.2.8 has the right code to support partitions
 		if device.Type == sys.PartType {
@@ -372,12 +616,16 @@ func getAvailableDevices(context *clusterd.Context, agent *OsdAgent) (*DeviceOsd
 		}
 
 		var deviceInfo *DeviceOsdIDEntry
-		if agent.metadataDevice != "" && agent.metadataDevice == device.Name {
+		if isMetadataDevice(device, agent.metadataDevice) {
 			// current device is desired as the metadata device
 			deviceInfo = &DeviceOsdIDEntry{Data: unassignedOSDID, Metadata: []int{}}
 		} else if len(desiredDevices) == 1 && desiredDevices[0].Name == "all" {
-			// user has specified all devices, use the current one for data
-			deviceInfo = &DeviceOsdIDEntry{Data: unassignedOSDID}
+			// user has specified all devices, use the current one for data unless it is not selected
+			if selected, reason := desiredDevices[0].Selector.Matches(device.Size, device.Rotational, device.Vendor, device.Model); !selected {
+				logger.Infof("skipping device %q that does not match the device selector: %s", device.Name, reason)
+			} else {
+				deviceInfo = &DeviceOsdIDEntry{Data: unassignedOSDID}
+			}
 		} else if len(desiredDevices) > 0 {
 			var matched bool
 			var matchedDevice DesiredDevice
@@ -436,6 +684,13 @@ func getAvailableDevices(context *clusterd.Context, agent *OsdAgent) (*DeviceOsd
 					}
 				}
 
+				if matched && (desiredDevice.IsFilter || desiredDevice.IsDevicePathFilter) {
+					if selected, reason := desiredDevice.Selector.Matches(device.Size, device.Rotational, device.Vendor, device.Model); !selected {
+						logger.Infof("device %q does not match the device selector: %s", device.Name, reason)
+						matched = false
+					}
+				}
+
 				if matched {
 					break
 				}
@@ -465,6 +720,7 @@ func getAvailableDevices(context *clusterd.Context, agent *OsdAgent) (*DeviceOsd
 		}
 
 		if deviceInfo != nil {
+			deviceInfo.Partition = device.Type == sys.PartType
 			// When running on PVC, we typically have a single device only
 			// So it's fine to name the first entry of the map "data" instead of the PVC name
 			// It is particularly useful when a metadata PVC is used because we need to identify it in the map
@@ -22,6 +22,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rook/rook/pkg/clusterd"
 	cephclient "github.com/rook/rook/pkg/daemon/ceph/client"
+	oposd "github.com/rook/rook/pkg/operator/ceph/cluster/osd"
+	"github.com/rook/rook/pkg/operator/ceph/cluster/osd/config"
 	cephver "github.com/rook/rook/pkg/operator/ceph/version"
 	exectest "github.com/rook/rook/pkg/util/exec/test"
 	"github.com/rook/rook/pkg/util/sys"
@@ -249,7 +251,7 @@ NAME="sdb1" SIZE="30" TYPE="part" PKNAME="sdb"`, nil
 
 	context := &clusterd.Context{Executor: executor}
 	context.Devices = []*sys.LocalDisk{
-		{Name: "sda", DevLinks: "/dev/disk/by-id/scsi-0123 /dev/disk/by-path/pci-0:1:2:3-scsi-1", RealPath: "/dev/sda"},
+		{Name: "sda", DevLinks: "/dev/disk/by-id/scsi-0123 /dev/disk/by-path/pci-0:1:2:3-scsi-1", RealPath: "/dev/sda", Vendor: "ATA", Model: "Samsung_SSD_860_EVO_500GB"},
 		{Name: "sdb", DevLinks: "/dev/disk/by-id/scsi-4567 /dev/disk/by-path/pci-4:5:6:7-scsi-1", RealPath: "/dev/sdb"},
 		{Name: "sdc", DevLinks: "/dev/disk/by-id/scsi-89ab /dev/disk/by-path/pci-8:9:a:b-scsi-1", RealPath: "/dev/sdc"},
 		{Name: "sdd", DevLinks: "/dev/disk/by-id/scsi-cdef /dev/disk/by-path/pci-c:d:e:f-scsi-1", RealPath: "/dev/sdd"},
@@ -281,6 +283,21 @@ NAME="sdb1" SIZE="30" TYPE="part" PKNAME="sdb"`, nil
 	assert.Equal(t, -1, mapping.Entries["nvme01"].Data)
 	assert.NotNil(t, mapping.Entries["nvme01"].Metadata)
 	assert.Equal(t, 0, len(mapping.Entries["nvme01"].Metadata))
+	assert.True(t, mapping.Entries["sdt1"].Partition)
+	assert.False(t, mapping.Entries["sda"].Partition)
+
+	// the metadata device is given with the /dev prefix by the operator
+	for _, metadataDevice := range []string{"/dev/nvme01", "/dev/disk/by-id/nvme-0246"} {
+		agent.metadataDevice = metadataDevice
+		mapping, err = getAvailableDevices(context, agent)
+		assert.Nil(t, err)
+		assert.Equal(t, 7, len(mapping.Entries), metadataDevice)
+		assert.Equal(t, -1, mapping.Entries["nvme01"].Data, metadataDevice)
+		assert.NotNil(t, mapping.Entries["nvme01"].Metadata, metadataDevice)
+		assert.Equal(t, 0, len(mapping.Entries["nvme01"].Metadata), metadataDevice)
+		assert.Nil(t, mapping.Entries["sda"].Metadata, metadataDevice)
+	}
+	agent.metadataDevice = "nvme01"
 
 	// Partition is skipped
 	agent.clusterInfo.CephVersion = cephver.Nautilus
@@ -310,6 +327,20 @@ NAME="sdb1" SIZE="30" TYPE="part" PKNAME="sdb"`, nil
 	assert.Equal(t, -1, mapping.Entries["sda"].Data)
 	assert.Equal(t, -1, mapping.Entries["sdd"].Data)
 
+	// select the sd* devices by the model reported by udev, where the spaces are underscores
+	for _, model := range []string{"Samsung SSD 860 EVO 500GB", "samsung_ssd_860_evo_500gb", " Samsung  SSD 860 EVO 500GB "} {
+		selector := &config.DeviceSelector{Vendor: "ata", Model: model}
+		agent.devices = []DesiredDevice{{Name: "^sd.$", IsFilter: true, Selector: selector}}
+		mapping, err = getAvailableDevices(context, agent)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(mapping.Entries), model)
+		assert.Equal(t, -1, mapping.Entries["sda"].Data, model)
+	}
+	agent.devices = []DesiredDevice{{Name: "all", Selector: &config.DeviceSelector{Model: "Samsung SSD 860"}}}
+	mapping, err = getAvailableDevices(context, agent)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(mapping.Entries))
+
 	// select an exact device
 	agent.devices = []DesiredDevice{{Name: "sdd"}}
 	mapping, err = getAvailableDevices(context, agent)
@@ -378,6 +409,166 @@ NAME="sdb1" SIZE="30" TYPE="part" PKNAME="sdb"`, nil
 	assert.Equal(t, 1, len(mapping.Entries), mapping)
 }
 
+func TestLimitNewOSDs(t *testing.T) {
+	newMapping := func() *DeviceOsdMapping {
+		return &DeviceOsdMapping{Entries: map[string]*DeviceOsdIDEntry{
+			"sdc":     {Data: unassignedOSDID},
+			"sda":     {Data: unassignedOSDID},
+			"sdb":     {Data: unassignedOSDID, Config: DesiredDevice{OSDsPerDevice: 2}},
+			"nvme0n1": {Data: unassignedOSDID, Metadata: []int{}},
+		}}
+	}
+	deviceNames := func(mapping *DeviceOsdMapping) []string {
+		names := []string{}
+		for name := range mapping.Entries {
+			names = append(names, name)
+		}
+		return names
+	}
+
+	// no limit
+	agent := &OsdAgent{maxNewOSDs: -1}
+	mapping := newMapping()
+	limitNewOSDs(agent, mapping)
+	assert.ElementsMatch(t, []string{"nvme0n1", "sda", "sdb", "sdc"}, deviceNames(mapping))
+
+	// the devices are taken in order and count their osds per device
+	agent.maxNewOSDs = 3
+	mapping = newMapping()
+	limitNewOSDs(agent, mapping)
+	assert.ElementsMatch(t, []string{"nvme0n1", "sda", "sdb"}, deviceNames(mapping))
+
+	// a device whose osds do not all fit is skipped
+	agent.maxNewOSDs = 2
+	mapping = newMapping()
+	limitNewOSDs(agent, mapping)
+	assert.ElementsMatch(t, []string{"nvme0n1", "sda", "sdc"}, deviceNames(mapping))
+
+	// the osds per device of the node apply to the devices without their own setting
+	agent.storeConfig.OSDsPerDevice = 2
+	mapping = newMapping()
+	limitNewOSDs(agent, mapping)
+	assert.ElementsMatch(t, []string{"nvme0n1", "sda"}, deviceNames(mapping))
+
+	// no new osd on a node at the limit, the metadata device is kept
+	agent.maxNewOSDs = 0
+	mapping = newMapping()
+	limitNewOSDs(agent, mapping)
+	assert.ElementsMatch(t, []string{"nvme0n1"}, deviceNames(mapping))
+}
+
+func TestDeviceInUse(t *testing.T) {
+	// the output of lsblk --noheadings --pairs for each device
+	lsblkOutput := map[string]string{
+		"/dev/sdb": `NAME="sdb" MAJ:MIN="8:16" RM="0" SIZE="10G" RO="0" TYPE="disk" MOUNTPOINT=""
+NAME="sdb1" MAJ:MIN="8:17" RM="0" SIZE="5G" RO="0" TYPE="part" MOUNTPOINT=""
+NAME="sdb2" MAJ:MIN="8:18" RM="0" SIZE="5G" RO="0" TYPE="part" MOUNTPOINT=""`,
+		"/dev/sdc": `NAME="sdc" MAJ:MIN="8:32" RM="0" SIZE="10G" RO="0" TYPE="disk" MOUNTPOINT=""
+NAME="vg1-lv1" MAJ:MIN="253:0" RM="0" SIZE="10G" RO="0" TYPE="lvm" MOUNTPOINT=""`,
+		"/dev/sdd": `NAME="sdd" MAJ:MIN="8:48" RM="0" SIZE="10G" RO="0" TYPE="disk" MOUNTPOINT=""`,
+		"/dev/sde1": `NAME="sde1" MAJ:MIN="8:65" RM="0" SIZE="10G" RO="0" TYPE="part" MOUNTPOINT=""
+NAME="crypt1" MAJ:MIN="253:1" RM="0" SIZE="10G" RO="0" TYPE="crypt" MOUNTPOINT=""`,
+	}
+	executor := &exectest.MockExecutor{
+		MockExecuteCommandWithOutput: func(command string, args ...string) (string, error) {
+			if command == "lsblk" {
+				if output, ok := lsblkOutput[args[len(args)-1]]; ok {
+					return output, nil
+				}
+			}
+			return "", errors.Errorf("unknown command %s %s", command, args)
+		},
+	}
+
+	for name, expectedReason := range map[string]string{
+		"sda":  "the device is read-only",
+		"sdb":  "the device has 2 partition(s)",
+		"sdc":  "the device has holders such as lvm or device mapper devices",
+		"sde1": "the device has holders such as lvm or device mapper devices",
+	} {
+		inUse, reason, err := deviceInUse(executor, &sys.LocalDisk{Name: name, Readonly: name == "sda"})
+		assert.NoError(t, err, name)
+		assert.True(t, inUse, name)
+		assert.Equal(t, expectedReason, reason, name)
+	}
+
+	inUse, _, err := deviceInUse(executor, &sys.LocalDisk{Name: "sdd", Size: 1024})
+	assert.NoError(t, err)
+	assert.False(t, inUse)
+
+	// the devices that cannot be checked are reported as errors
+	_, _, err = deviceInUse(executor, &sys.LocalDisk{Name: "sdf"})
+	assert.Error(t, err)
+}
+
+func TestWipeDevices(t *testing.T) {
+	// sdb holds an osd of this cluster in raw mode, sdc in lvm mode, sdd an osd of another cluster
+	rawList := map[string]string{
+		"/dev/sdb": `{"0": {"ceph_fsid": "my-fsid", "device": "/dev/sdb", "osd_id": 0, "osd_uuid": "uuid-0", "type": "bluestore"}}`,
+		"/dev/sdd": `{"3": {"ceph_fsid": "other-fsid", "device": "/dev/sdd", "osd_id": 3, "osd_uuid": "uuid-3", "type": "bluestore"}}`,
+	}
+	lvmList := map[string]string{
+		"/dev/sdc": `{"1": [{"name": "osd-block-uuid-1", "path": "/dev/ceph-vg/osd-block-uuid-1", "tags": {"ceph.cluster_fsid": "my-fsid"}, "type": "block"}]}`,
+	}
+	var zapped []string
+	executor := &exectest.MockExecutor{
+		MockExecuteCommandWithOutput: func(command string, args ...string) (string, error) {
+			// the args are "-oL ceph-volume --log-path /tmp/ceph-log <mode> list <device> --format json"
+			if args[4] == "raw" {
+				if out, ok := rawList[args[6]]; ok {
+					return out, nil
+				}
+				return "{}", nil
+			}
+			if out, ok := lvmList[args[6]]; ok {
+				return out, nil
+			}
+			return "{}", nil
+		},
+		MockExecuteCommandWithCombinedOutput: func(command string, args ...string) (string, error) {
+			assert.Equal(t, []string{"lvm", "zap"}, args[4:6])
+			zapped = append(zapped, args[6])
+			return "", nil
+		},
+	}
+	context := &clusterd.Context{Executor: executor, Devices: []*sys.LocalDisk{
+		{Name: "sda", DevLinks: "/dev/disk/by-id/disk-a"},
+		{Name: "sdb"},
+		{Name: "sdc"},
+		{Name: "sdd"},
+		{Name: "sde", Readonly: true},
+		{Name: "sdf"},
+	}}
+	agent := &OsdAgent{clusterInfo: &cephclient.ClusterInfo{FSID: "my-fsid"}}
+
+	// only the devices listed by name and free of an osd of this cluster are wiped
+	agent.devices = []DesiredDevice{
+		{Name: "/dev/disk/by-id/disk-a"}, {Name: "sdb"}, {Name: "sdc"}, {Name: "/dev/sdd"}, {Name: "sde"}, {Name: "sd.", IsFilter: true},
+	}
+	wiped, err := wipeDevices(context, agent)
+	assert.NoError(t, err)
+	assert.True(t, wiped)
+	assert.Equal(t, []string{"/dev/sda", "/dev/sdd"}, zapped)
+
+	// the devices matched by a filter or by all are never wiped
+	zapped = nil
+	agent.devices = []DesiredDevice{{Name: "all"}, {Name: "/dev/sd.", IsDevicePathFilter: true}}
+	wiped, err = wipeDevices(context, agent)
+	assert.NoError(t, err)
+	assert.False(t, wiped)
+	assert.Empty(t, zapped)
+
+	// a device is not wiped if it cannot be checked
+	executor.MockExecuteCommandWithOutput = func(command string, args ...string) (string, error) {
+		return "", errors.New("induced failure")
+	}
+	agent.devices = []DesiredDevice{{Name: "sdf"}}
+	wiped, err = wipeDevices(context, agent)
+	assert.Error(t, err)
+	assert.False(t, wiped)
+	assert.Empty(t, zapped)
+}
+
 func TestGetVolumeGroupName(t *testing.T) {
 	validLVPath := "/dev/vgName1/lvName2"
 	invalidLVPath1 := "/dev//vgName2"
@@ -392,3 +583,58 @@ func TestGetVolumeGroupName(t *testing.T) {
 	vgName = getVolumeGroupName(invalidLVPath2)
 	assert.Equal(t, vgName, "")
 }
+
+func TestSetConfigOverrides(t *testing.T) {
+	execedCmds := []string{}
+	executor := &exectest.MockExecutor{
+		MockExecuteCommandWithOutputFile: func(command string, outfile string, args ...string) (string, error) {
+			execedCmds = append(execedCmds, strings.Join(args, " "))
+			return "", nil
+		},
+	}
+	context := &clusterd.Context{Executor: executor}
+	agent := &OsdAgent{clusterInfo: &cephclient.ClusterInfo{Namespace: "ns"}}
+	osds := []oposd.OSDInfo{{ID: 0}, {ID: 3}}
+
+	// nothing to set
+	assert.NoError(t, setConfigOverrides(context, agent, osds))
+	assert.Empty(t, execedCmds)
+
+	agent.storeConfig.ConfigOverrides = map[string]string{"osd_op_num_shards": "4", "bluestore_cache_size": "3221225472"}
+	assert.NoError(t, setConfigOverrides(context, agent, osds))
+	assert.Equal(t, 4, len(execedCmds))
+	assert.Contains(t, execedCmds[0], "config set osd.0 bluestore_cache_size 3221225472 ")
+	assert.Contains(t, execedCmds[1], "config set osd.0 osd_op_num_shards 4 ")
+	assert.Contains(t, execedCmds[2], "config set osd.3 bluestore_cache_size 3221225472 ")
+	assert.Contains(t, execedCmds[3], "config set osd.3 osd_op_num_shards 4 ")
+
+	// the rocksdb options are set with the overrides
+	execedCmds = []string{}
+	agent.storeConfig.ConfigOverrides = nil
+	agent.storeConfig.BlueStoreRocksDBOptions = "compression=kLZ4Compression;max_write_buffer_number=4"
+	assert.NoError(t, setConfigOverrides(context, agent, osds[:1]))
+	assert.Equal(t, 1, len(execedCmds))
+	assert.Contains(t, execedCmds[0], "config set osd.0 bluestore_rocksdb_options compression=kLZ4Compression;max_write_buffer_number=4 ")
+}
+
+func TestSetPGAutoscaleMode(t *testing.T) {
+	execedCmds := []string{}
+	executor := &exectest.MockExecutor{
+		MockExecuteCommandWithOutputFile: func(command string, outfile string, args ...string) (string, error) {
+			execedCmds = append(execedCmds, strings.Join(args, " "))
+			return "", nil
+		},
+	}
+	context := &clusterd.Context{Executor: executor}
+	agent := &OsdAgent{clusterInfo: &cephclient.ClusterInfo{Namespace: "ns"}}
+
+	// nothing to set
+	assert.NoError(t, setPGAutoscaleMode(context, agent))
+	assert.Empty(t, execedCmds)
+
+	// only the default of the new pools is set
+	agent.storeConfig.PGAutoscaleMode = "warn"
+	assert.NoError(t, setPGAutoscaleMode(context, agent))
+	assert.Equal(t, 1, len(execedCmds))
+	assert.Contains(t, execedCmds[0], "config set global osd_pool_default_pg_autoscale_mode warn ")
+}
@@ -18,6 +18,8 @@ package osd
 
 import (
 	"encoding/json"
+
+	"github.com/rook/rook/pkg/operator/ceph/cluster/osd/config"
 )
 
 const (
@@ -43,8 +45,12 @@ type DesiredDevice struct {
 	DatabaseSizeMB     int
 	DeviceClass        string
 	InitialWeight      string
+	Encrypted          bool
+	Zoned              bool
 	IsFilter           bool
 	IsDevicePathFilter bool
+	// Selector restricts the devices matched by a filter, nil if all the matched devices can be used
+	Selector *config.DeviceSelector
 }
 
 // DeviceOsdMapping represents the mapping of an OSD on disk
@@ -58,6 +64,7 @@ type DeviceOsdIDEntry struct {
 	Metadata              []int         // OSD IDs (multiple) that have metadata stored here
 	Config                DesiredDevice // Device specific config options
 	PersistentDevicePaths []string
+	Partition             bool // Whether the device is a partition, which can only be prepared in raw mode
 }
 
 func (m *DeviceOsdMapping) String() string {
@@ -111,6 +111,14 @@ func removeOSD(clusterdContext *clusterd.Context, clusterInfo *client.ClusterInf
 					}
 				}
 			}
+			// Remove the finalizer added by the operator so that the PVC can be deleted
+			pvc, err := clusterdContext.Clientset.CoreV1().PersistentVolumeClaims(clusterInfo.Namespace).Get(ctx, pvcName, metav1.GetOptions{})
+			if err != nil {
+				logger.Errorf("failed to fetch pvc %q for OSD %d. %v", pvcName, osdID, err)
+			} else if err := osd.RemovePVCFinalizer(clusterdContext.Clientset, pvc); err != nil {
+				// Continue deleting the OSD PVC, it will only be deleted once the finalizer is removed
+				logger.Errorf("failed to remove the finalizer of pvc %q for OSD %d. %v", pvcName, osdID, err)
+			}
 			// Remove the OSD PVC
 			logger.Infof("removing the OSD PVC %q", pvcName)
 			if err := clusterdContext.Clientset.CoreV1().PersistentVolumeClaims(clusterInfo.Namespace).Delete(ctx, pvcName, metav1.DeleteOptions{}); err != nil {
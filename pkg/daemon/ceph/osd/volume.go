@@ -220,6 +220,12 @@ func (a *OsdAgent) configureCVDevices(context *clusterd.Context, devices *Device
 		return nil, errors.Wrap(err, "failed to determine which ceph-volume mode to use")
 	}
 
+	// ceph-volume lvm batch cannot prepare partitions, they are prepared in raw mode instead
+	partitions := &DeviceOsdMapping{Entries: map[string]*DeviceOsdIDEntry{}}
+	if !useRawMode && !a.pvcBacked {
+		partitions = a.takeNewPartitions(devices)
+	}
+
 	// If not raw mode we must execute a few LVM prerequisites
 	if !useRawMode {
 		err = lvmPreReq(context, a.pvcBacked, lvBackedPV)
@@ -248,6 +254,13 @@ func (a *OsdAgent) configureCVDevices(context *clusterd.Context, devices *Device
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to initialize lvm based osd")
 			}
+			if len(partitions.Entries) > 0 {
+				logger.Info("initializing osd partitions with raw mode")
+				err := a.initializeDevicesRawMode(context, partitions)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to initialize raw based osd on partitions")
+				}
+			}
 		}
 	}
 
@@ -500,29 +513,19 @@ func (a *OsdAgent) useRawMode(context *clusterd.Context, pvcBacked bool) (bool,
 		return a.clusterInfo.CephVersion.IsAtLeast(cephVolumeRawModeMinCephVersion), nil
 	}
 
-	var useRawMode bool
-	// Can we safely use ceph-volume raw mode in the non-PVC case?
-	// On non-PVC we see a race between systemd-udev and the osd process to acquire the lock on the device
-	if a.clusterInfo.CephVersion.IsNautilus() && a.clusterInfo.CephVersion.IsAtLeast(cephFlockFixNautilusMinCephVersion) {
-		logger.Debugf("will use raw mode since cluster version is at least %v", cephFlockFixNautilusMinCephVersion)
-		useRawMode = true
-	}
-
-	if a.clusterInfo.CephVersion.IsOctopus() && a.clusterInfo.CephVersion.IsAtLeast(cephFlockFixOctopusMinCephVersion) {
-		logger.Debugf("will use raw mode since cluster version is at least %v", cephFlockFixOctopusMinCephVersion)
-		useRawMode = true
-	}
-
-	if a.clusterInfo.CephVersion.IsAtLeastPacific() {
-		logger.Debug("will use raw mode since cluster version is at least pacific")
-		useRawMode = true
-	}
+	useRawMode := a.rawModeSupported()
 
 	// ceph-volume raw mode does not support encryption yet
 	if a.storeConfig.EncryptedDevice {
 		logger.Debug("won't use raw mode since encryption is enabled")
 		useRawMode = false
 	}
+	for _, device := range a.devices {
+		if device.Encrypted {
+			logger.Debugf("won't use raw mode since encryption is enabled on device %q", device.Name)
+			useRawMode = false
+		}
+	}
 
 	// ceph-volume raw mode does not support more than one OSD per disk
 	osdsPerDeviceCountString := sanitizeOSDsPerDevice(a.storeConfig.OSDsPerDevice)
@@ -541,9 +544,87 @@ func (a *OsdAgent) useRawMode(context *clusterd.Context, pvcBacked bool) (bool,
 		useRawMode = false
 	}
 
+	// zoned devices cannot hold lvm volumes, bluestore must use the whole device
+	if a.hasZonedDevices() && !useRawMode {
+		return false, errors.New("zoned devices can only be provisioned in raw mode, i.e. on ceph pacific or newer without encryption, several osds per device or a metadata device")
+	}
+
+	// the raw mode is required, do not fall back to lvm
+	if a.storeConfig.RawMode && !useRawMode {
+		return false, errors.Errorf("raw mode is required but cannot be used, i.e. the ceph version must be at least %q, %q or pacific without encryption, several osds per device or a metadata device", cephFlockFixNautilusMinCephVersion.String(), cephFlockFixOctopusMinCephVersion.String())
+	}
+
 	return useRawMode, nil
 }
 
+// rawModeSupported returns whether the ceph version allows to use ceph-volume raw mode in the non-PVC
+// case. On non-PVC we see a race between systemd-udev and the osd process to acquire the lock on the device.
+func (a *OsdAgent) rawModeSupported() bool {
+	if a.clusterInfo.CephVersion.IsNautilus() && a.clusterInfo.CephVersion.IsAtLeast(cephFlockFixNautilusMinCephVersion) {
+		logger.Debugf("will use raw mode since cluster version is at least %v", cephFlockFixNautilusMinCephVersion)
+		return true
+	}
+
+	if a.clusterInfo.CephVersion.IsOctopus() && a.clusterInfo.CephVersion.IsAtLeast(cephFlockFixOctopusMinCephVersion) {
+		logger.Debugf("will use raw mode since cluster version is at least %v", cephFlockFixOctopusMinCephVersion)
+		return true
+	}
+
+	if a.clusterInfo.CephVersion.IsAtLeastPacific() {
+		logger.Debug("will use raw mode since cluster version is at least pacific")
+		return true
+	}
+	return false
+}
+
+// takeNewPartitions removes the partitions without an OSD from the devices to prepare in lvm mode since
+// ceph-volume lvm batch only accepts whole devices. It returns the partitions that can be prepared in
+// raw mode instead, the other partitions are skipped.
+func (a *OsdAgent) takeNewPartitions(devices *DeviceOsdMapping) *DeviceOsdMapping {
+	partitions := &DeviceOsdMapping{Entries: map[string]*DeviceOsdIDEntry{}}
+	for name, device := range devices.Entries {
+		if !device.Partition || device.Data != unassignedOSDID {
+			continue
+		}
+		delete(devices.Entries, name)
+		if reason := a.partitionRawModeBlocker(device); reason != "" {
+			logger.Warningf("skipping partition %q since partitions can only be prepared in raw mode. %s", name, reason)
+			continue
+		}
+		logger.Infof("partition %q will be prepared in raw mode", name)
+		partitions.Entries[name] = device
+	}
+	return partitions
+}
+
+// partitionRawModeBlocker returns why the partition cannot be prepared in raw mode, or an empty string
+// if it can be
+func (a *OsdAgent) partitionRawModeBlocker(partition *DeviceOsdIDEntry) string {
+	switch {
+	case !a.rawModeSupported():
+		return fmt.Sprintf("the ceph version must be at least %q, %q or pacific", cephFlockFixNautilusMinCephVersion.String(), cephFlockFixOctopusMinCephVersion.String())
+	case a.storeConfig.EncryptedDevice || partition.Config.Encrypted:
+		return "raw mode does not support encryption"
+	case a.metadataDevice != "" || partition.Config.MetadataDevice != "":
+		return "raw mode does not support a metadata device"
+	case a.storeConfig.OSDsPerDevice > 1 || partition.Config.OSDsPerDevice > 1:
+		return "raw mode does not support several osds per device"
+	}
+	return ""
+}
+
+func (a *OsdAgent) hasZonedDevices() bool {
+	if a.storeConfig.Zoned {
+		return true
+	}
+	for _, device := range a.devices {
+		if device.Zoned {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *OsdAgent) initializeDevicesRawMode(context *clusterd.Context, devices *DeviceOsdMapping) error {
 	baseCommand := "stdbuf"
 	cephVolumeMode := "raw"
@@ -640,9 +721,15 @@ func (a *OsdAgent) initializeDevicesLVMMode(context *clusterd.Context, devices *
 					if deviceOSDCount != metadataDevices[md]["osdsperdevice"] {
 						return errors.Errorf("metadataDevice (%s) has more than 1 osdsPerDevice value set: %s != %s", md, deviceOSDCount, metadataDevices[md]["osdsperdevice"])
 					}
+					if device.Config.Encrypted != (metadataDevices[md]["encrypted"] == "true") {
+						return errors.Errorf("metadataDevice (%s) is shared by encrypted and non-encrypted devices", md)
+					}
 				} else {
 					metadataDevices[md] = make(map[string]string)
 					metadataDevices[md]["osdsperdevice"] = deviceOSDCount
+					if device.Config.Encrypted {
+						metadataDevices[md]["encrypted"] = "true"
+					}
 					if device.Config.DeviceClass != "" {
 						metadataDevices[md]["deviceclass"] = device.Config.DeviceClass
 					}
@@ -665,7 +752,8 @@ func (a *OsdAgent) initializeDevicesLVMMode(context *clusterd.Context, devices *
 					}
 				}
 			} else {
-				immediateExecuteArgs := append(baseArgs, []string{
+				immediateExecuteArgs := a.appendEncryptedArg(device, baseArgs)
+				immediateExecuteArgs = append(immediateExecuteArgs, []string{
 					osdsPerDeviceFlag,
 					deviceOSDCount,
 					deviceArg,
@@ -705,6 +793,9 @@ func (a *OsdAgent) initializeDevicesLVMMode(context *clusterd.Context, devices *
 	for md, conf := range metadataDevices {
 
 		mdArgs := batchArgs
+		if _, ok := conf["encrypted"]; ok && !a.storeConfig.EncryptedDevice {
+			mdArgs = append(mdArgs, encryptedFlag)
+		}
 		if _, ok := conf["osdsperdevice"]; ok {
 			mdArgs = append(mdArgs, []string{
 				osdsPerDeviceFlag,
@@ -794,6 +885,16 @@ func (a *OsdAgent) initializeDevicesLVMMode(context *clusterd.Context, devices *
 	return nil
 }
 
+// appendEncryptedArg returns a copy of the args requesting encryption if only this device must be
+// encrypted, the flag is already part of the args if all the devices are encrypted
+func (a *OsdAgent) appendEncryptedArg(device *DeviceOsdIDEntry, args []string) []string {
+	newArgs := append([]string{}, args...)
+	if device.Config.Encrypted && !a.storeConfig.EncryptedDevice {
+		newArgs = append(newArgs, encryptedFlag)
+	}
+	return newArgs
+}
+
 func (a *OsdAgent) appendDeviceClassArg(device *DeviceOsdIDEntry, args []string) []string {
 	deviceClass := device.Config.DeviceClass
 	if deviceClass == "" {
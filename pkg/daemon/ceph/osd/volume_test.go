@@ -1423,3 +1423,150 @@ func TestAppendOSDInfo(t *testing.T) {
 		assert.Equal(t, 3, len(trimmedOSDs))
 	}
 }
+
+func TestInitializeBlockPerDeviceEncryption(t *testing.T) {
+	devices := &DeviceOsdMapping{
+		Entries: map[string]*DeviceOsdIDEntry{
+			"sda": {Data: -1, Metadata: nil, Config: DesiredDevice{Name: "/dev/sda", Encrypted: true}},
+			"sdb": {Data: -1, Metadata: nil, Config: DesiredDevice{Name: "/dev/sdb"}},
+		},
+	}
+
+	executedDevices := map[string]bool{}
+	executor := &exectest.MockExecutor{}
+	executor.MockExecuteCommand = func(command string, args ...string) error {
+		logger.Infof("%s %v", command, args)
+
+		// Validate base common args
+		err := testBaseArgs(args)
+		if err != nil {
+			return err
+		}
+
+		// only the selected device is encrypted
+		if args[9] == "--dmcrypt" && args[10] == "--osds-per-device" && args[11] == "1" && args[12] == "/dev/sda" {
+			executedDevices["sda"] = true
+			return nil
+		}
+		if args[9] == "--osds-per-device" && args[10] == "1" && args[11] == "/dev/sdb" {
+			executedDevices["sdb"] = true
+			return nil
+		}
+
+		return errors.Errorf("unknown command %s %s", command, args)
+	}
+	a := &OsdAgent{clusterInfo: &cephclient.ClusterInfo{CephVersion: cephver.CephVersion{Major: 14, Minor: 2, Extra: 8}}, nodeName: "node1"}
+	context := &clusterd.Context{Executor: executor}
+
+	err := a.initializeDevicesLVMMode(context, devices)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"sda": true, "sdb": true}, executedDevices)
+
+	// devices sharing a metadata device must all be encrypted or not
+	devices.Entries["sda"].Config.MetadataDevice = "/dev/sdd"
+	devices.Entries["sdb"].Config.MetadataDevice = "/dev/sdd"
+	err = a.initializeDevicesLVMMode(context, devices)
+	assert.Error(t, err)
+
+	// raw mode does not support encryption
+	a = &OsdAgent{
+		clusterInfo: &cephclient.ClusterInfo{CephVersion: cephver.CephVersion{Major: 16, Minor: 2, Extra: 1}},
+		devices:     []DesiredDevice{{Name: "sda"}, {Name: "sdb"}},
+	}
+	useRawMode, err := a.useRawMode(context, false)
+	assert.NoError(t, err)
+	assert.True(t, useRawMode)
+	a.devices[0].Encrypted = true
+	useRawMode, err = a.useRawMode(context, false)
+	assert.NoError(t, err)
+	assert.False(t, useRawMode)
+}
+
+func TestUseRawModeZonedDevices(t *testing.T) {
+	context := &clusterd.Context{Executor: &exectest.MockExecutor{}}
+	a := &OsdAgent{
+		clusterInfo: &cephclient.ClusterInfo{CephVersion: cephver.CephVersion{Major: 16, Minor: 2, Extra: 1}},
+		devices:     []DesiredDevice{{Name: "sda", Zoned: true}, {Name: "sdb"}},
+	}
+	useRawMode, err := a.useRawMode(context, false)
+	assert.NoError(t, err)
+	assert.True(t, useRawMode)
+
+	// the zoned devices cannot be provisioned in lvm mode
+	a.devices[1].Encrypted = true
+	_, err = a.useRawMode(context, false)
+	assert.Error(t, err)
+
+	a.devices = []DesiredDevice{{Name: "sda"}}
+	a.storeConfig.Zoned = true
+	a.metadataDevice = "nvme0n1"
+	_, err = a.useRawMode(context, false)
+	assert.Error(t, err)
+}
+
+func TestTakeNewPartitions(t *testing.T) {
+	newDevices := func() *DeviceOsdMapping {
+		return &DeviceOsdMapping{Entries: map[string]*DeviceOsdIDEntry{
+			"sda":  {Data: -1},
+			"sdb1": {Data: -1, Partition: true},
+			"sdc1": {Data: 2, Partition: true},
+			"sdd1": {Data: -1, Partition: true, Config: DesiredDevice{Encrypted: true}},
+		}}
+	}
+	a := &OsdAgent{
+		clusterInfo: &cephclient.ClusterInfo{CephVersion: cephver.CephVersion{Major: 16, Minor: 2, Extra: 1}},
+	}
+
+	// the new partitions are removed from the lvm devices, the encrypted one cannot use raw mode
+	devices := newDevices()
+	partitions := a.takeNewPartitions(devices)
+	assert.Equal(t, 2, len(devices.Entries))
+	assert.NotNil(t, devices.Entries["sda"])
+	// the partitions holding an osd are kept so that the osd is still reported
+	assert.NotNil(t, devices.Entries["sdc1"])
+	assert.Equal(t, 1, len(partitions.Entries))
+	assert.NotNil(t, partitions.Entries["sdb1"])
+
+	// the node settings requiring lvm mode exclude all the partitions
+	a.metadataDevice = "nvme0n1"
+	devices = newDevices()
+	partitions = a.takeNewPartitions(devices)
+	assert.Equal(t, 2, len(devices.Entries))
+	assert.Empty(t, partitions.Entries)
+	a.metadataDevice = ""
+
+	// the ceph version must support raw mode
+	a.clusterInfo.CephVersion = cephver.CephVersion{Major: 15, Minor: 2, Extra: 4}
+	devices = newDevices()
+	partitions = a.takeNewPartitions(devices)
+	assert.Equal(t, 2, len(devices.Entries))
+	assert.Empty(t, partitions.Entries)
+}
+
+func TestUseRawModeRequired(t *testing.T) {
+	context := &clusterd.Context{Executor: &exectest.MockExecutor{}}
+	a := &OsdAgent{
+		clusterInfo: &cephclient.ClusterInfo{CephVersion: cephver.CephVersion{Major: 15, Minor: 2, Extra: 9}},
+		devices:     []DesiredDevice{{Name: "sda"}},
+		storeConfig: config.StoreConfig{RawMode: true, OSDsPerDevice: 1},
+	}
+	useRawMode, err := a.useRawMode(context, false)
+	assert.NoError(t, err)
+	assert.True(t, useRawMode)
+
+	// the required raw mode does not fall back to lvm
+	a.devices[0].Encrypted = true
+	_, err = a.useRawMode(context, false)
+	assert.Error(t, err)
+
+	a.devices[0].Encrypted = false
+	a.clusterInfo.CephVersion = cephver.CephVersion{Major: 15, Minor: 2, Extra: 8}
+	_, err = a.useRawMode(context, false)
+	assert.Error(t, err)
+
+	// lvm is used when the raw mode is not required
+	a.storeConfig.RawMode = false
+	useRawMode, err = a.useRawMode(context, false)
+	assert.NoError(t, err)
+	assert.False(t, useRawMode)
+}
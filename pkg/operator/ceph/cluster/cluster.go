@@ -45,6 +45,7 @@ import (
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
@@ -64,6 +65,9 @@ type cluster struct {
 	isUpgrade          bool
 	watchersActivated  bool
 	monitoringChannels map[string]*clusterHealth
+	// osdRecorder reports the OSD provisioning events on the cephClusterRef if set
+	osdRecorder    record.EventRecorder
+	cephClusterRef *v1.ObjectReference
 }
 
 type clusterHealth struct {
@@ -143,6 +147,9 @@ func (c *cluster) doOrchestration(rookImage string, cephVersion cephver.CephVers
 	// Start the OSDs
 	controller.UpdateCondition(c.context, c.namespacedName, cephv1.ConditionProgressing, v1.ConditionTrue, cephv1.ClusterProgressingReason, "Configuring Ceph OSDs")
 	osds := osd.New(c.context, c.ClusterInfo, *spec, rookImage)
+	if c.osdRecorder != nil && c.cephClusterRef != nil {
+		osds.SetEventRecorder(c.osdRecorder, c.cephClusterRef)
+	}
 	err = osds.Start()
 	if err != nil {
 		return errors.Wrap(err, "failed to start ceph osds")
@@ -42,6 +42,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -94,6 +95,8 @@ type ClusterController struct {
 	client                  client.Client
 	namespacedName          types.NamespacedName
 	recorder                *k8sutil.EventReporter
+	// osdRecorder reports the OSD provisioning events, they are not deduplicated like the cluster events
+	osdRecorder record.EventRecorder
 }
 
 // ReconcileCephCluster reconciles a CephFilesystem object
@@ -122,6 +125,7 @@ func newReconciler(mgr manager.Manager, ctx *clusterd.Context, clusterController
 	// that they are coming from Rook. The controller name already has context that it is for Ceph
 	// and from the cluster controller.
 	clusterController.recorder = k8sutil.NewEventReporter(mgr.GetEventRecorderFor("rook-" + controllerName))
+	clusterController.osdRecorder = mgr.GetEventRecorderFor("rook-" + controllerName)
 
 	return &ReconcileCephCluster{
 		client:            mgr.GetClient(),
@@ -326,6 +330,11 @@ func (r *ReconcileCephCluster) reconcileDelete(cephCluster *cephv1.CephCluster)
 	// Log deletion to the operator log
 	logger.Info(deletingMsg)
 
+	// Release the OSD PVCs so that they are not stuck in the terminating state once garbage collected
+	if err := osd.RemoveAllPVCFinalizers(r.context.Clientset, cephCluster.Namespace, cephCluster.Spec.Storage.PVCLabelPrefix); err != nil {
+		return reconcile.Result{}, cephCluster, errors.Wrapf(err, "failed to release the osd pvcs of CephCluster %q", nsName.String())
+	}
+
 	doCleanup := true
 
 	// Start cluster clean up only if cleanupPolicy is applied to the ceph cluster
@@ -397,6 +406,16 @@ func (c *ClusterController) onAdd(clusterObj *cephv1.CephCluster, ownerInfo *k8s
 		// It's a new cluster so let's populate the struct
 		cluster = newCluster(clusterObj, c.context, c.csiConfigMutex, ownerInfo)
 	}
+	// the OSD provisioning events are reported on a reference to the CephCluster rather than on the
+	// object of the cache, which is shared with the other controllers and changes between reconciles
+	cluster.osdRecorder = c.osdRecorder
+	cluster.cephClusterRef = &corev1.ObjectReference{
+		APIVersion: cephv1.SchemeGroupVersion.String(),
+		Kind:       "CephCluster",
+		Namespace:  clusterObj.Namespace,
+		Name:       clusterObj.Name,
+		UID:        clusterObj.UID,
+	}
 
 	// Note that this lock is held through the callback process, as this creates CSI resources, but we must lock in
 	// this scope as the clusterMap is authoritative on cluster count and thus involved in the check for CSI resource
@@ -582,7 +601,7 @@ func (c *ClusterController) deleteOSDEncryptionKeyFromKMS(currentCluster *cephv1
 	}
 
 	// Fetch PVCs
-	osdPVCs, _, err := osd.GetExistingPVCs(c.context, currentCluster.Namespace)
+	osdPVCs, _, err := osd.GetExistingPVCs(c.context, currentCluster.Namespace, currentCluster.Spec.Storage.PVCLabelPrefix)
 	if err != nil {
 		return errors.Wrap(err, "failed to list osd pvc")
 	}
@@ -26,6 +26,7 @@ import (
 	rookalpha "github.com/rook/rook/pkg/apis/rook.io/v1alpha2"
 	"github.com/rook/rook/pkg/clusterd"
 	"github.com/rook/rook/pkg/daemon/ceph/agent/flexvolume/attachment"
+	"github.com/rook/rook/pkg/operator/ceph/cluster/osd"
 	"github.com/rook/rook/pkg/operator/k8sutil"
 	testop "github.com/rook/rook/pkg/operator/test"
 	"github.com/stretchr/testify/assert"
@@ -154,6 +155,45 @@ func TestReconcile_DeleteCephCluster(t *testing.T) {
 		assert.Error(t, err)
 		assert.True(t, kerrors.IsNotFound(err))
 	})
+
+	t.Run("osd pvcs are released", func(t *testing.T) {
+		finalizer := "storage.example.com/osd-protection"
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name:        "set1-data-0-abcde",
+			Namespace:   cephNs,
+			Labels:      map[string]string{osd.CephDeviceSetLabelKey: "set1"},
+			Annotations: map[string]string{"ceph.rook.io/pvc-finalizer": finalizer},
+			Finalizers:  []string{finalizer},
+		}}
+		clusterdCtx := &clusterd.Context{
+			Clientset:                  k8sfake.NewSimpleClientset(pvc),
+			DynamicClientset:           dynamicfake.NewSimpleDynamicClient(scheme),
+			RequestCancelOrchestration: abool.New(),
+		}
+		volumeAttachmentController := &attachment.MockAttachment{
+			MockList: func(namespace string) (*rookalpha.VolumeList, error) {
+				return &rookalpha.VolumeList{Items: []rookalpha.Volume{}}, nil
+			},
+		}
+		controller := NewClusterController(clusterdCtx, "", volumeAttachmentController, nil, nil)
+		controller.recorder = k8sutil.NewEventReporter(record.NewFakeRecorder(5))
+		client := clientfake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(fakeCluster.DeepCopy()).Build()
+		reconcileCephCluster := &ReconcileCephCluster{
+			client:            client,
+			scheme:            scheme,
+			context:           clusterdCtx,
+			clusterController: controller,
+		}
+
+		resp, err := reconcileCephCluster.Reconcile(ctx, reconcile.Request{NamespacedName: nsName})
+		assert.NoError(t, err)
+		assert.True(t, resp.IsZero())
+
+		// the pvc is not stuck in the terminating state by the finalizer added by rook
+		released, err := clusterdCtx.Clientset.CoreV1().PersistentVolumeClaims(cephNs).Get(ctx, pvc.Name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, released.Finalizers)
+	})
 }
 
 func Test_checkIfVolumesExist(t *testing.T) {
@@ -18,6 +18,7 @@ package osd
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"path"
 
@@ -25,11 +26,16 @@ import (
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	"github.com/rook/rook/pkg/operator/ceph/cluster/mgr"
 	opconfig "github.com/rook/rook/pkg/operator/ceph/config"
+	"github.com/rook/rook/pkg/operator/ceph/controller"
+	"github.com/rook/rook/pkg/operator/k8sutil"
 	v1 "k8s.io/api/core/v1"
 )
 
 const (
 	dmCryptKeySize = 128
+	// uid and gid of the ceph user in the ceph images
+	cephUserID  = int64(167)
+	cephGroupID = int64(167)
 )
 
 // PrivilegedContext returns a privileged Pod security context
@@ -41,6 +47,73 @@ func PrivilegedContext() *v1.SecurityContext {
 	}
 }
 
+// osdCapabilities are the capabilities granted to the OSD containers instead of running privileged
+var osdCapabilities = []v1.Capability{
+	"CHOWN",
+	"DAC_OVERRIDE",
+	"FOWNER",
+	"FSETID",
+	"MKNOD",
+	"SETGID",
+	"SETUID",
+	"SYS_ADMIN",
+	"SYS_RESOURCE",
+}
+
+// supportsUnprivilegedDaemon returns whether the OSD daemon can run without full privileges. This
+// is only the case of non-encrypted raw mode OSDs on PVC: the init containers copy the block
+// devices into the OSD data dir and chown it, so the daemon does not need root to access its data.
+// Other OSDs either need to activate LVM volumes or to open dm-crypt devices from the daemon pod.
+func supportsUnprivilegedDaemon(osdProps osdProperties, osd OSDInfo) bool {
+	return osdProps.onPVC() && osd.CVMode == "raw" && !osdProps.encrypted
+}
+
+// capabilitiesSecurityContext returns a copy of the given security context that is not privileged
+// but is granted the capabilities needed by the OSD
+func capabilitiesSecurityContext(securityContext *v1.SecurityContext) *v1.SecurityContext {
+	privileged := false
+	s := securityContext.DeepCopy()
+	s.Privileged = &privileged
+	s.Capabilities = &v1.Capabilities{
+		Add:  append([]v1.Capability{}, osdCapabilities...),
+		Drop: []v1.Capability{"ALL"},
+	}
+	return s
+}
+
+// cephUserSecurityContext returns a copy of the given security context running as the ceph user
+func cephUserSecurityContext(securityContext *v1.SecurityContext) *v1.SecurityContext {
+	runAsUser := cephUserID
+	runAsGroup := cephGroupID
+	s := securityContext.DeepCopy()
+	s.RunAsUser = &runAsUser
+	s.RunAsGroup = &runAsGroup
+	return s
+}
+
+// applySeccompProfileToAllContainers sets the seccomp profile from the storage spec on all containers
+// and all init containers in the pod. The pod is left untouched if no profile is configured.
+func (c *Cluster) applySeccompProfileToAllContainers(spec *v1.PodSpec) {
+	if c.spec.Storage.SeccompProfile == nil {
+		return
+	}
+	setProfile := func(container *v1.Container) {
+		// security contexts may be shared between containers, so never modify them in place
+		securityContext := container.SecurityContext.DeepCopy()
+		if securityContext == nil {
+			securityContext = &v1.SecurityContext{}
+		}
+		securityContext.SeccompProfile = c.spec.Storage.SeccompProfile.DeepCopy()
+		container.SecurityContext = securityContext
+	}
+	for i := range spec.InitContainers {
+		setProfile(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		setProfile(&spec.Containers[i])
+	}
+}
+
 func osdOnSDNFlag(network cephv1.NetworkSpec) []string {
 	var args []string
 	// OSD fails to find the right IP to bind to when running on SDN
@@ -48,6 +121,41 @@ func osdOnSDNFlag(network cephv1.NetworkSpec) []string {
 	if !network.IsHost() {
 		args = append(args, "--ms-learn-addr-from-peer=false")
 	}
+	// With multus the OSDs have an interface for each network attachment besides the SDN interface,
+	// bind to the interfaces named in the selectors so the OSD traffic does not go through the SDN
+	if network.IsMultus() {
+		args = append(args, multusNetworkInterfaceFlags(network)...)
+	}
+
+	return args
+}
+
+// osdNetworkFlags returns the flags selecting the addresses the OSD binds to. The IPv4 and IPv6
+// binding follows the IP family of the cluster network, with host networking too since the OSD then
+// binds to the addresses of the node in the same families.
+func (c *Cluster) osdNetworkFlags() []string {
+	args := osdOnSDNFlag(c.spec.Network)
+	return append(args, controller.NetworkBindingFlags(c.clusterInfo, &c.spec)...)
+}
+
+// multusNetworkInterfaceFlags returns the flags binding the public and cluster messengers to the
+// interfaces set in the JSON syntax of the network selectors. The short syntax does not name the
+// interface, the OSDs then select the addresses in the public and cluster networks.
+func multusNetworkInterfaceFlags(network cephv1.NetworkSpec) []string {
+	var args []string
+	for _, selectorKey := range opconfig.NetworkSelectors {
+		selector, ok := network.Selectors[selectorKey]
+		if !ok {
+			continue
+		}
+		var attachment struct {
+			Interface string `json:"interface"`
+		}
+		if err := json.Unmarshal([]byte(selector), &attachment); err != nil || attachment.Interface == "" {
+			continue
+		}
+		args = append(args, opconfig.NewFlag(fmt.Sprintf("%s_network_interface", selectorKey), attachment.Interface))
+	}
 
 	return args
 }
@@ -87,3 +195,61 @@ func (c *Cluster) isCephVolumeRawModeSupported() bool {
 
 	return false
 }
+
+// applyTerminationMessagePolicyToAllContainers sets the termination message policy from the storage
+// spec on all containers and all init containers in the pod
+func (c *Cluster) applyTerminationMessagePolicyToAllContainers(spec *v1.PodSpec) {
+	policy := c.spec.Storage.TerminationMessagePolicy
+	if policy == "" {
+		return
+	}
+	for i := range spec.InitContainers {
+		spec.InitContainers[i].TerminationMessagePolicy = policy
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].TerminationMessagePolicy = policy
+	}
+}
+
+// applyAutomountServiceAccountToken sets whether the service account token is mounted in the OSD
+// daemon pod. The lvm OSDs on PVC keep the token since the rook binary starting them calls the
+// Kubernetes API.
+func (c *Cluster) applyAutomountServiceAccountToken(spec *v1.PodSpec, osd OSDInfo, osdProps osdProperties) {
+	automount := c.spec.Storage.AutomountServiceAccountToken
+	if automount == nil {
+		return
+	}
+	if !*automount && osdProps.onPVC() && osd.CVMode == "lvm" {
+		logger.Infof("mounting the service account token in osd %d anyway since lvm osds on pvc need to access the kubernetes api", osd.ID)
+		return
+	}
+	value := *automount
+	spec.AutomountServiceAccountToken = &value
+}
+
+// applyHostPathTypes sets the hostPath types from the storage spec on the /dev and dataDirHostPath
+// volumes of the pod so that Kubernetes checks the paths on the host before starting the pod
+func (c *Cluster) applyHostPathTypes(spec *v1.PodSpec) {
+	hostPathTypes := map[string]*v1.HostPathType{
+		"devices":             c.spec.Storage.DevicesHostPathType,
+		k8sutil.DataDirVolume: c.spec.Storage.DataDirHostPathType,
+	}
+	for i := range spec.Volumes {
+		hostPathType := hostPathTypes[spec.Volumes[i].Name]
+		if hostPathType == nil || spec.Volumes[i].HostPath == nil {
+			continue
+		}
+		value := *hostPathType
+		spec.Volumes[i].HostPath.Type = &value
+	}
+}
+
+// applyDebugOptions sets the stdin and tty of the OSD daemon container from the debug options of the
+// storage spec so that users can attach to the container to troubleshoot it
+func (c *Cluster) applyDebugOptions(container *v1.Container) {
+	if c.spec.Storage.Debug == nil {
+		return
+	}
+	container.Stdin = c.spec.Storage.Debug.Stdin
+	container.TTY = c.spec.Storage.Debug.TTY
+}
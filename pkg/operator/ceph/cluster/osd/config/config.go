@@ -18,7 +18,12 @@ limitations under the License.
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -31,6 +36,45 @@ const (
 	DeviceClassKey     = "deviceClass"
 	InitialWeightKey   = "initialWeight"
 	PrimaryAffinityKey = "primaryAffinity"
+	// BlueStoreRocksDBOptionsKey is the key of the bluestore_rocksdb_options of the OSDs
+	BlueStoreRocksDBOptionsKey = "bluestoreRocksDBOptions"
+	// ScrubBeginHourKey, ScrubEndHourKey and ScrubLoadThresholdKey restrict when the OSDs are allowed to scrub
+	ScrubBeginHourKey     = "scrubBeginHour"
+	ScrubEndHourKey       = "scrubEndHour"
+	ScrubLoadThresholdKey = "scrubLoadThreshold"
+	// StoreTypeKey is the object store backing the OSDs, only bluestore is supported
+	StoreTypeKey = "storeType"
+	// PGAutoscaleModeKey is the default pg autoscale mode of the pools created after the OSDs are provisioned
+	PGAutoscaleModeKey = "pgAutoscaleMode"
+	// ZonedKey marks zoned devices (SMR HDDs or ZNS SSDs) that are provisioned with the zoned bluestore backend
+	ZonedKey = "zoned"
+	// DeviceClassHintKey is an additional class of the devices, exposed on the OSDs without changing
+	// their crush device class
+	DeviceClassHintKey = "deviceClassHint"
+	// RawModeKey requires the devices of the nodes to be provisioned with ceph-volume raw mode, without LVM
+	RawModeKey = "rawMode"
+	// MaxObjectSizeKey and MaxWriteSizeMBKey raise the limits of the size of the objects and of the
+	// writes accepted by the OSDs
+	MaxObjectSizeKey  = "maxObjectSize"
+	MaxWriteSizeMBKey = "maxWriteSizeMB"
+	// OpNumShardsKey and OpNumThreadsPerShardKey configure the sharded op queue of the OSDs
+	OpNumShardsKey          = "opNumShards"
+	OpNumThreadsPerShardKey = "opNumThreadsPerShard"
+	// RecoveryMaxActiveKey and MaxBackfillsKey limit the concurrency of the recovery and backfill of the OSDs
+	RecoveryMaxActiveKey = "recoveryMaxActive"
+	MaxBackfillsKey      = "maxBackfills"
+	// NUMANodeKey pins the OSDs to a NUMA node of the host
+	NUMANodeKey = "numaNode"
+	// ConfigOverrideKeyPrefix prefixes the ceph config settings to set on the OSDs, e.g. "osdConfig.bluestore_cache_size"
+	ConfigOverrideKeyPrefix = "osdConfig."
+)
+
+const (
+	mib = 1024 * 1024
+	// maxObjectSizeLimit is the size of the largest object bluestore can store, 4GiB
+	maxObjectSizeLimit = 4 * 1024 * mib
+	// defaultMaxObjectSize is the default value of osd_max_object_size in Ceph, 128MiB
+	defaultMaxObjectSize = 128 * mib
 )
 
 // StoreConfig represents the configuration of an OSD on a device.
@@ -43,6 +87,35 @@ type StoreConfig struct {
 	DeviceClass     string `json:"deviceClass,omitempty"`
 	InitialWeight   string `json:"initialWeight,omitempty"`
 	PrimaryAffinity string `json:"primaryAffinity,omitempty"`
+	// BlueStoreRocksDBOptions are the bluestore_rocksdb_options set on the OSDs
+	BlueStoreRocksDBOptions string `json:"bluestoreRocksDBOptions,omitempty"`
+	// ScrubBeginHour, ScrubEndHour and ScrubLoadThreshold are passed to the OSD daemons at startup
+	ScrubBeginHour     string `json:"scrubBeginHour,omitempty"`
+	ScrubEndHour       string `json:"scrubEndHour,omitempty"`
+	ScrubLoadThreshold string `json:"scrubLoadThreshold,omitempty"`
+	// StoreType is the configured store type, empty if not set
+	StoreType string `json:"storeType,omitempty"`
+	// PGAutoscaleMode is set as osd_pool_default_pg_autoscale_mode when the OSDs are provisioned
+	PGAutoscaleMode string `json:"pgAutoscaleMode,omitempty"`
+	// ConfigOverrides are arbitrary ceph config settings written in the config section of the OSDs
+	ConfigOverrides map[string]string `json:"configOverrides,omitempty"`
+	// Zoned is true if the devices are zoned devices provisioned with the zoned bluestore backend
+	Zoned bool `json:"zoned,omitempty"`
+	// DeviceClassHint is the additional class of the devices, empty if not set
+	DeviceClassHint string `json:"deviceClassHint,omitempty"`
+	// RawMode is true if the devices must be provisioned with ceph-volume raw mode instead of falling back to lvm
+	RawMode bool `json:"rawMode,omitempty"`
+	// MaxObjectSize in bytes and MaxWriteSizeMB are passed to the OSD daemons at startup
+	MaxObjectSize  string `json:"maxObjectSize,omitempty"`
+	MaxWriteSizeMB string `json:"maxWriteSizeMB,omitempty"`
+	// OpNumShards and OpNumThreadsPerShard are passed to the OSD daemons at startup
+	OpNumShards          string `json:"opNumShards,omitempty"`
+	OpNumThreadsPerShard string `json:"opNumThreadsPerShard,omitempty"`
+	// RecoveryMaxActive and MaxBackfills are passed to the OSD daemons at startup
+	RecoveryMaxActive string `json:"recoveryMaxActive,omitempty"`
+	MaxBackfills      string `json:"maxBackfills,omitempty"`
+	// NUMANode is the NUMA node the OSD daemons are pinned to at startup
+	NUMANode string `json:"numaNode,omitempty"`
 }
 
 // NewStoreConfig returns a StoreConfig with proper defaults set.
@@ -76,6 +149,45 @@ func ToStoreConfig(config map[string]string) StoreConfig {
 			storeConfig.InitialWeight = v
 		case PrimaryAffinityKey:
 			storeConfig.PrimaryAffinity = v
+		case BlueStoreRocksDBOptionsKey:
+			storeConfig.BlueStoreRocksDBOptions = v
+		case ScrubBeginHourKey:
+			storeConfig.ScrubBeginHour = v
+		case ScrubEndHourKey:
+			storeConfig.ScrubEndHour = v
+		case ScrubLoadThresholdKey:
+			storeConfig.ScrubLoadThreshold = v
+		case StoreTypeKey:
+			storeConfig.StoreType = v
+		case PGAutoscaleModeKey:
+			storeConfig.PGAutoscaleMode = v
+		case ZonedKey:
+			storeConfig.Zoned = (v == "true")
+		case DeviceClassHintKey:
+			storeConfig.DeviceClassHint = v
+		case RawModeKey:
+			storeConfig.RawMode = (v == "true")
+		case MaxObjectSizeKey:
+			storeConfig.MaxObjectSize = v
+		case MaxWriteSizeMBKey:
+			storeConfig.MaxWriteSizeMB = v
+		case OpNumShardsKey:
+			storeConfig.OpNumShards = v
+		case OpNumThreadsPerShardKey:
+			storeConfig.OpNumThreadsPerShard = v
+		case RecoveryMaxActiveKey:
+			storeConfig.RecoveryMaxActive = v
+		case MaxBackfillsKey:
+			storeConfig.MaxBackfills = v
+		case NUMANodeKey:
+			storeConfig.NUMANode = v
+		default:
+			if strings.HasPrefix(k, ConfigOverrideKeyPrefix) {
+				if storeConfig.ConfigOverrides == nil {
+					storeConfig.ConfigOverrides = map[string]string{}
+				}
+				storeConfig.ConfigOverrides[strings.TrimPrefix(k, ConfigOverrideKeyPrefix)] = v
+			}
 		}
 	}
 
@@ -93,6 +205,141 @@ func MetadataDevice(config map[string]string) string {
 	return ""
 }
 
+// FormatConfigOverrides encodes the config overrides as a JSON object so that keys and values may
+// contain any character
+func FormatConfigOverrides(overrides map[string]string) string {
+	// marshalling a map of strings cannot fail and the keys are sorted so the result is stable
+	b, _ := json.Marshal(overrides)
+	return string(b)
+}
+
+// ParseConfigOverrides decodes the config overrides encoded by FormatConfigOverrides. The comma
+// separated list of key=value pairs used by older prepare jobs is still accepted.
+func ParseConfigOverrides(raw string) (map[string]string, error) {
+	overrides := map[string]string{}
+	if raw == "" {
+		return overrides, nil
+	}
+
+	if strings.HasPrefix(raw, "{") {
+		if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal config overrides %q", raw)
+		}
+		return overrides, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.Errorf("invalid config override %q, expected key=value", pair)
+		}
+		overrides[kv[0]] = kv[1]
+	}
+	return overrides, nil
+}
+
+// ValidateRocksDBOptions checks that the RocksDB options are a list of key=value pairs separated by
+// semicolons. Commas are accepted as separators too like in the default bluestore_rocksdb_options.
+func ValidateRocksDBOptions(options string) error {
+	if strings.TrimSpace(options) == "" {
+		return errors.New("empty rocksdb options")
+	}
+
+	pairs := strings.FieldsFunc(options, func(r rune) bool { return r == ';' || r == ',' })
+	if len(pairs) == 0 {
+		return errors.Errorf("invalid rocksdb options %q, no key=value pair found", options)
+	}
+	for _, pair := range pairs {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" || strings.ContainsAny(kv[0], " \t") {
+			return errors.Errorf("invalid rocksdb option %q in %q, expected key=value", pair, options)
+		}
+	}
+	return nil
+}
+
+// ValidateScrubHour checks that the scrub hour is a valid hour of the day, i.e. between 0 and 23
+func ValidateScrubHour(hour string) error {
+	h, err := strconv.Atoi(hour)
+	if err != nil {
+		return errors.Wrapf(err, "invalid scrub hour %q", hour)
+	}
+	if h < 0 || h > 23 {
+		return errors.Errorf("invalid scrub hour %d, must be between 0 and 23", h)
+	}
+	return nil
+}
+
+// ValidateScrubLoadThreshold checks that the scrub load threshold is a positive number
+func ValidateScrubLoadThreshold(threshold string) error {
+	t, err := strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return errors.Wrapf(err, "invalid scrub load threshold %q", threshold)
+	}
+	if t < 0 {
+		return errors.Errorf("invalid scrub load threshold %q, must not be negative", threshold)
+	}
+	return nil
+}
+
+// ValidateObjectSizeLimits checks that the max object size is between 1MiB and 4GiB, the largest
+// object bluestore can store, and that the max write size is at least 1MB and not larger than the
+// max object size. The write size is checked against the Ceph default of 128MiB when the max object
+// size is not set. The empty limits are not checked.
+func ValidateObjectSizeLimits(maxObjectSize, maxWriteSizeMB string) error {
+	objectSize := uint64(defaultMaxObjectSize)
+	if maxObjectSize != "" {
+		var err error
+		objectSize, err = strconv.ParseUint(maxObjectSize, 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "invalid max object size %q", maxObjectSize)
+		}
+		if objectSize < mib || objectSize > maxObjectSizeLimit {
+			return errors.Errorf("invalid max object size %d, must be between %d and %d bytes", objectSize, mib, uint64(maxObjectSizeLimit))
+		}
+	}
+	if maxWriteSizeMB != "" {
+		writeSize, err := strconv.ParseUint(maxWriteSizeMB, 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "invalid max write size %q", maxWriteSizeMB)
+		}
+		if writeSize < 1 || writeSize*mib > objectSize {
+			return errors.Errorf("invalid max write size %dMB, must be between 1MB and the max object size of %d bytes", writeSize, objectSize)
+		}
+	}
+	return nil
+}
+
+// ValidatePositiveInteger checks that the value of the given setting is a positive integer
+func ValidatePositiveInteger(name, value string) error {
+	i, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return errors.Wrapf(err, "invalid %s %q", name, value)
+	}
+	if i == 0 {
+		return errors.Errorf("invalid %s %q, must be a positive integer", name, value)
+	}
+	return nil
+}
+
+// ValidateNonNegativeInteger checks that the value of the given setting is zero or a positive integer
+func ValidateNonNegativeInteger(name, value string) error {
+	if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+		return errors.Wrapf(err, "invalid %s %q, must be a non-negative integer", name, value)
+	}
+	return nil
+}
+
+// ValidatePGAutoscaleMode checks that the pg autoscale mode is one of the modes of the pg autoscaler
+func ValidatePGAutoscaleMode(mode string) error {
+	switch mode {
+	case "on", "off", "warn":
+		return nil
+	default:
+		return errors.Errorf("invalid pg autoscale mode %q, must be one of \"on\", \"off\" or \"warn\"", mode)
+	}
+}
+
 func convertToIntIgnoreErr(raw string) int {
 	val, err := strconv.Atoi(raw)
 	if err != nil {
@@ -107,3 +354,78 @@ type ConfiguredDevice struct {
 	ID          string      `json:"id"`
 	StoreConfig StoreConfig `json:"storeConfig"`
 }
+
+// ValidateStoreType checks that the store type is empty or a store type the OSDs can be
+// provisioned with
+func ValidateStoreType(storeType string) error {
+	switch storeType {
+	case "", Bluestore:
+		return nil
+	case "filestore":
+		return errors.Errorf("store type %q is not supported anymore, only %q osds can be provisioned", storeType, Bluestore)
+	default:
+		return errors.Errorf("unknown store type %q, must be %q", storeType, Bluestore)
+	}
+}
+
+// DeviceSelector restricts the devices selected by a device filter to the devices matching all the
+// criteria set. It is passed to the prepare job as a JSON object.
+type DeviceSelector struct {
+	MinSizeBytes uint64 `json:"minSizeBytes,omitempty"`
+	MaxSizeBytes uint64 `json:"maxSizeBytes,omitempty"`
+	Rotational   *bool  `json:"rotational,omitempty"`
+	Vendor       string `json:"vendor,omitempty"`
+	Model        string `json:"model,omitempty"`
+}
+
+// FormatDeviceSelector encodes the device selector as a JSON object
+func FormatDeviceSelector(selector DeviceSelector) string {
+	// marshalling the selector cannot fail
+	b, _ := json.Marshal(selector)
+	return string(b)
+}
+
+// ParseDeviceSelector decodes the device selector encoded by FormatDeviceSelector. A nil selector
+// is returned if raw is empty.
+func ParseDeviceSelector(raw string) (*DeviceSelector, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	selector := &DeviceSelector{}
+	if err := json.Unmarshal([]byte(raw), selector); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal device selector %q", raw)
+	}
+	return selector, nil
+}
+
+// Matches returns whether a device with the given properties matches all the criteria of the
+// selector and the reason why it does not. A nil selector matches all the devices.
+func (s *DeviceSelector) Matches(size uint64, rotational bool, vendor, model string) (bool, string) {
+	if s == nil {
+		return true, ""
+	}
+	if s.MinSizeBytes > 0 && size < s.MinSizeBytes {
+		return false, fmt.Sprintf("size %d is lower than the minimum size %d", size, s.MinSizeBytes)
+	}
+	if s.MaxSizeBytes > 0 && size > s.MaxSizeBytes {
+		return false, fmt.Sprintf("size %d is greater than the maximum size %d", size, s.MaxSizeBytes)
+	}
+	if s.Rotational != nil && *s.Rotational != rotational {
+		return false, fmt.Sprintf("rotational is %t instead of %t", rotational, *s.Rotational)
+	}
+	if s.Vendor != "" && normalizeDeviceID(vendor) != normalizeDeviceID(s.Vendor) {
+		return false, fmt.Sprintf("vendor %q is not %q", vendor, s.Vendor)
+	}
+	if s.Model != "" && normalizeDeviceID(model) != normalizeDeviceID(s.Model) {
+		return false, fmt.Sprintf("model %q is not %q", model, s.Model)
+	}
+	return true, ""
+}
+
+// normalizeDeviceID returns the vendor or model of a device in lower case with its words separated
+// by single spaces. udev replaces the spaces with underscores in ID_VENDOR and ID_MODEL, e.g. the
+// model "Samsung SSD 860" is reported as "Samsung_SSD_860", so underscores separate words too.
+func normalizeDeviceID(id string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(id, "_", " ")), " "))
+}
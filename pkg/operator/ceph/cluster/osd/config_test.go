@@ -36,6 +36,71 @@ func TestOsdOnSDNFlag(t *testing.T) {
 	network.Provider = "host"
 	args = osdOnSDNFlag(network)
 	assert.Empty(t, args)
+
+	// the short syntax does not name the interfaces
+	network = cephv1.NetworkSpec{Provider: "multus", Selectors: map[string]string{"public": "macvlan-public", "cluster": "macvlan-cluster"}}
+	args = osdOnSDNFlag(network)
+	assert.Equal(t, []string{"--ms-learn-addr-from-peer=false"}, args)
+
+	// the interfaces of the JSON syntax are bound
+	network.Selectors = map[string]string{
+		"public":  `{"name": "macvlan-public", "interface": "public0"}`,
+		"cluster": `{"name": "macvlan-cluster", "interface": "cluster0"}`,
+	}
+	args = osdOnSDNFlag(network)
+	assert.Equal(t, []string{"--ms-learn-addr-from-peer=false", "--public-network-interface=public0", "--cluster-network-interface=cluster0"}, args)
+
+	// a single network without interface
+	network.Selectors = map[string]string{"cluster": `{"name": "macvlan-cluster"}`}
+	args = osdOnSDNFlag(network)
+	assert.Equal(t, []string{"--ms-learn-addr-from-peer=false"}, args)
+}
+
+func TestOSDNetworkFlags(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		network cephv1.NetworkSpec
+		version cephver.CephVersion
+		want    []string
+	}{
+		{"default", cephv1.NetworkSpec{}, cephver.Pacific, []string{"--ms-learn-addr-from-peer=false"}},
+		{"ipv4", cephv1.NetworkSpec{IPFamily: cephv1.IPv4}, cephver.Pacific,
+			[]string{"--ms-learn-addr-from-peer=false", "--ms-bind-ipv4=true", "--ms-bind-ipv6=false"}},
+		{"ipv6", cephv1.NetworkSpec{IPFamily: cephv1.IPv6}, cephver.Pacific,
+			[]string{"--ms-learn-addr-from-peer=false", "--ms-bind-ipv4=false", "--ms-bind-ipv6=true"}},
+		{"dual-stack", cephv1.NetworkSpec{IPFamily: cephv1.IPv6, DualStack: true}, cephver.Pacific,
+			[]string{"--ms-learn-addr-from-peer=false", "--ms-bind-ipv4=true", "--ms-bind-ipv6=true"}},
+		{"dual-stack before pacific", cephv1.NetworkSpec{IPFamily: cephv1.IPv6, DualStack: true}, cephver.Octopus,
+			[]string{"--ms-learn-addr-from-peer=false", "--ms-bind-ipv6=true"}},
+		{"ipv4 on host", cephv1.NetworkSpec{Provider: "host", IPFamily: cephv1.IPv4}, cephver.Pacific,
+			[]string{"--ms-bind-ipv4=true", "--ms-bind-ipv6=false"}},
+		{"ipv6 on host", cephv1.NetworkSpec{Provider: "host", IPFamily: cephv1.IPv6}, cephver.Pacific,
+			[]string{"--ms-bind-ipv4=false", "--ms-bind-ipv6=true"}},
+		{"dual-stack on host", cephv1.NetworkSpec{Provider: "host", IPFamily: cephv1.IPv4, DualStack: true}, cephver.Pacific,
+			[]string{"--ms-bind-ipv4=true", "--ms-bind-ipv6=true"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCluster(t, cephv1.ClusterSpec{Network: test.network})
+			c.clusterInfo.CephVersion = test.version
+			assert.Equal(t, test.want, c.osdNetworkFlags())
+
+			// the flags are passed to the osd daemon once
+			osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+			osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+			deployment, err := c.makeDeployment(osdProps, osd, testProvisionConfig(c))
+			assert.NoError(t, err)
+			args := deployment.Spec.Template.Spec.Containers[0].Args
+			for _, flag := range test.want {
+				count := 0
+				for _, arg := range args {
+					if arg == flag {
+						count++
+					}
+				}
+				assert.Equal(t, 1, count, flag)
+			}
+		})
+	}
 }
 
 func TestEncryptionKeyPath(t *testing.T) {
@@ -17,7 +17,10 @@ limitations under the License.
 package osd
 
 import (
+	"context"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/pkg/errors"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
@@ -26,9 +29,13 @@ import (
 	opcontroller "github.com/rook/rook/pkg/operator/ceph/controller"
 	"github.com/rook/rook/pkg/operator/k8sutil"
 	"github.com/rook/rook/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
 )
 
 type createConfig struct {
@@ -151,6 +158,18 @@ func (c *Cluster) startProvisioningOverPVCs(config *provisionConfig, errs *provi
 		return util.NewSet(), nil
 	}
 
+	if err := c.initPrepareJobSlots(config); err != nil {
+		errs.addError("failed to provision OSDs on PVCs. %v", err)
+		return util.NewSet(), nil
+	}
+	if config.prepareJobSlots != nil {
+		sort.SliceStable(c.deviceSets, func(i, j int) bool {
+			claim := c.deviceSets[i].PVCSources[bluestorePVCData].ClaimName
+			otherClaim := c.deviceSets[j].PVCSources[bluestorePVCData].ClaimName
+			return config.prepareJobSlots.launchedBefore(claim, otherClaim)
+		})
+	}
+
 	awaitingStatusConfigMaps := util.NewSet()
 	for _, volume := range c.deviceSets {
 		// Check whether we need to cancel the orchestration
@@ -238,6 +257,17 @@ func (c *Cluster) startProvisioningOverPVCs(config *provisionConfig, errs *provi
 			continue
 		}
 
+		// Requeue until the PVCs are bound, the placement of the prepare job depends on the bound PVs
+		if len(volume.PendingPVCs) > 0 {
+			errs.addError("deferred OSD prepare job for PVC %q to the next reconcile until PVC(s) %v are bound", osdProps.crushHostname, volume.PendingPVCs)
+			continue
+		}
+
+		if err := c.requireLocalPVNodeAffinity(&osdProps, volume.BoundVolumes); err != nil {
+			errs.addError("failed to provision OSD on PVC %q. %v", osdProps.crushHostname, err)
+			continue
+		}
+
 		// Update the orchestration status of this pvc to the starting state
 		status := OrchestrationStatus{Status: OrchestrationStatusStarting, PvcBackedOSD: true}
 		cmName := c.updateOSDStatus(osdProps.crushHostname, status)
@@ -308,6 +338,16 @@ func (c *Cluster) startProvisioningOverNodes(config *provisionConfig, errs *prov
 		return util.NewSet(), nil
 	}
 
+	if err := c.initPrepareJobSlots(config); err != nil {
+		errs.addError("failed to provision OSDs on nodes. %v", err)
+		return util.NewSet(), nil
+	}
+	if config.prepareJobSlots != nil {
+		sort.SliceStable(c.ValidStorage.Nodes, func(i, j int) bool {
+			return config.prepareJobSlots.launchedBefore(c.ValidStorage.Nodes[i].Name, c.ValidStorage.Nodes[j].Name)
+		})
+	}
+
 	awaitingStatusConfigMaps := util.NewSet()
 	for _, node := range c.ValidStorage.Nodes {
 		// Check whether we need to cancel the orchestration
@@ -339,6 +379,13 @@ func (c *Cluster) startProvisioningOverNodes(config *provisionConfig, errs *prov
 			storeConfig:    storeConfig,
 			metadataDevice: metadataDevice,
 		}
+		if c.spec.Storage.MaxOSDsPerNode > 0 {
+			osdProps.maxNewOSDs = c.spec.Storage.MaxOSDsPerNode - config.nodeOSDCounts[n.Name]
+			if osdProps.maxNewOSDs < 0 {
+				osdProps.maxNewOSDs = 0
+			}
+			logger.Infof("up to %d new OSDs may be provisioned on node %q which has %d OSDs", osdProps.maxNewOSDs, n.Name, config.nodeOSDCounts[n.Name])
+		}
 
 		// update the orchestration status of this node to the starting state
 		status := OrchestrationStatus{Status: OrchestrationStatusStarting}
@@ -359,6 +406,21 @@ func (c *Cluster) startProvisioningOverNodes(config *provisionConfig, errs *prov
 	return awaitingStatusConfigMaps, nil
 }
 
+// countOSDDeploymentsPerNode returns the number of the OSD deployments running on each node, keyed by
+// the hostname of the node selector of the deployments. The OSDs on PVC are not counted.
+func countOSDDeploymentsPerNode(deployments []appsv1.Deployment) map[string]int {
+	counts := map[string]int{}
+	for _, d := range deployments {
+		if _, ok := d.Labels[OSDOverPVCLabelKey]; ok {
+			continue
+		}
+		if hostname := d.Spec.Template.Spec.NodeSelector[v1.LabelHostname]; hostname != "" {
+			counts[hostname]++
+		}
+	}
+	return counts
+}
+
 func (c *Cluster) runPrepareJob(osdProps *osdProperties, config *provisionConfig) error {
 	nodeOrPVC := "node"
 	if osdProps.onPVC() {
@@ -371,18 +433,122 @@ func (c *Cluster) runPrepareJob(osdProps *osdProperties, config *provisionConfig
 		return errors.Wrapf(err, "failed to generate osd provisioning job template for %s %q", nodeOrPVC, nodeOrPVCName)
 	}
 
-	if err := k8sutil.RunReplaceableJob(c.context.Clientset, job, false); err != nil {
-		if !kerrors.IsAlreadyExists(err) {
-			return errors.Wrapf(err, "failed to run provisioning job for %s %q", nodeOrPVC, nodeOrPVCName)
-		}
+	if config.prepareJobSlots != nil && !config.prepareJobSlots.take(job.Name, c.spec.Storage.MaxConcurrentPrepareJobs) {
+		return errors.Errorf("deferred provisioning job for %s %q to the next reconcile since %d prepare jobs are running, the maximum allowed by maxConcurrentPrepareJobs",
+			nodeOrPVC, nodeOrPVCName, config.prepareJobSlots.running.Count())
+	}
+
+	created, err := CreateOrUpdatePrepareJob(c.context.Clientset, job)
+	if err != nil {
+		return errors.Wrapf(err, "failed to run provisioning job for %s %q", nodeOrPVC, nodeOrPVCName)
+	}
+	if !created {
 		logger.Infof("letting preexisting OSD provisioning job run to completion for %s %q", nodeOrPVC, nodeOrPVCName)
 		return nil
 	}
 
 	logger.Infof("started OSD provisioning job for %s %q", nodeOrPVC, nodeOrPVCName)
+	c.recordPrepareJobStart(nodeOrPVCName)
 	return nil
 }
 
+// CreateOrUpdatePrepareJob creates the OSD prepare job idempotently. A running job is always left to
+// run to completion, even if its containers differ, since interrupting ceph-volume in the middle of
+// the provisioning could leave partially created OSDs behind. A finished job is replaced since the
+// pod template of a job cannot be updated. If the job is created by another caller in the meantime,
+// e.g. a racing orchestration, the job is kept as well. It returns whether the job was created.
+func CreateOrUpdatePrepareJob(clientset kubernetes.Interface, job *batch.Job) (bool, error) {
+	ctx := context.TODO()
+	existing, err := clientset.BatchV1().Jobs(job.Namespace).Get(ctx, job.Name, metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return false, errors.Wrapf(err, "failed to get existing job %q", job.Name)
+	}
+	if err == nil {
+		if existing.Status.Active > 0 {
+			if containersDiffer(existing.Spec.Template.Spec.InitContainers, job.Spec.Template.Spec.InitContainers) ||
+				containersDiffer(existing.Spec.Template.Spec.Containers, job.Spec.Template.Spec.Containers) {
+				logger.Infof("job %q is still running, the changes of its spec apply to the next provisioning", job.Name)
+			}
+			return false, nil
+		}
+
+		logger.Infof("replacing finished job %q", job.Name)
+		if err := k8sutil.DeleteBatchJob(clientset, job.Namespace, job.Name, true); err != nil {
+			return false, errors.Wrapf(err, "failed to delete existing job %q", job.Name)
+		}
+	}
+
+	if _, err := clientset.BatchV1().Jobs(job.Namespace).Create(ctx, job, metav1.CreateOptions{}); err != nil {
+		if kerrors.IsAlreadyExists(err) {
+			logger.Infof("job %q was created in the meantime", job.Name)
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "failed to create job %q", job.Name)
+	}
+	return true, nil
+}
+
+// initPrepareJobSlots lists the OSD prepare jobs once per reconcile when
+// storage.maxConcurrentPrepareJobs is set. The jobs launched afterwards are added to the running
+// jobs as they take a slot.
+func (c *Cluster) initPrepareJobSlots(config *provisionConfig) error {
+	if c.spec.Storage.MaxConcurrentPrepareJobs <= 0 || config.prepareJobSlots != nil {
+		return nil
+	}
+	selector := metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", k8sutil.AppAttr, prepareAppName)}
+	jobs, err := c.context.Clientset.BatchV1().Jobs(c.clusterInfo.Namespace).List(context.TODO(), selector)
+	if err != nil {
+		return errors.Wrap(err, "failed to list osd prepare jobs")
+	}
+	slots := &prepareJobSlots{running: util.NewSet(), launched: map[string]time.Time{}}
+	for i := range jobs.Items {
+		slots.launched[jobs.Items[i].Name] = jobs.Items[i].CreationTimestamp.Time
+		if !prepareJobFinished(&jobs.Items[i]) {
+			slots.running.Add(jobs.Items[i].Name)
+		}
+	}
+	config.prepareJobSlots = slots
+	return nil
+}
+
+// prepareJobSlots holds the OSD prepare jobs of the namespace when their number is limited
+type prepareJobSlots struct {
+	running  *util.Set            // names of the jobs running or launched in this reconcile
+	launched map[string]time.Time // creation time of the existing jobs
+}
+
+// take returns whether the job can be launched without running more than maxJobs prepare jobs. A
+// job that is already running keeps its slot since it is left to run to completion.
+func (s *prepareJobSlots) take(jobName string, maxJobs int) bool {
+	if s.running.Contains(jobName) {
+		return true
+	}
+	if s.running.Count() >= maxJobs {
+		return false
+	}
+	s.running.Add(jobName)
+	return true
+}
+
+// launchedBefore orders the prepare jobs as a queue: the nodes and PVCs that have no prepare job
+// come first, then the ones whose job was launched the least recently. The jobs deferred by a
+// reconcile are thus launched by the next ones before the jobs that already ran.
+func (s *prepareJobSlots) launchedBefore(crushHostname, otherCrushHostname string) bool {
+	launched := s.launched[k8sutil.TruncateNodeName(prepareAppNameFmt, crushHostname)]
+	otherLaunched := s.launched[k8sutil.TruncateNodeName(prepareAppNameFmt, otherCrushHostname)]
+	return launched.Before(otherLaunched)
+}
+
+// prepareJobFinished returns whether the job completed or failed
+func prepareJobFinished(job *batch.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batch.JobComplete || condition.Type == batch.JobFailed) && condition.Status == v1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
 func createDaemonOnPVC(c *Cluster, osd OSDInfo, pvcName string, config *provisionConfig) error {
 	d, err := deploymentOnPVC(c, osd, pvcName, config)
 	if err != nil {
@@ -18,19 +18,26 @@ package osd
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	"github.com/rook/rook/pkg/clusterd"
 	cephclient "github.com/rook/rook/pkg/daemon/ceph/client"
 	cephver "github.com/rook/rook/pkg/operator/ceph/version"
+	"github.com/rook/rook/pkg/operator/k8sutil"
 	"github.com/rook/rook/pkg/operator/test"
 	"github.com/rook/rook/pkg/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/tevino/abool"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	apiresource "k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -302,6 +309,75 @@ func Test_createNewOSDsFromStatus(t *testing.T) {
 	})
 }
 
+func Test_maxOSDsPerNode(t *testing.T) {
+	ctx := context.TODO()
+	clientset := test.New(t, 2)
+	newDeployment := func(id int, hostname string, onPVC bool) {
+		name := deploymentName(id)
+		labels := map[string]string{k8sutil.AppAttr: AppName, k8sutil.ClusterAttr: "ns", OsdIdLabelKey: strconv.Itoa(id)}
+		if onPVC {
+			labels[OSDOverPVCLabelKey] = "pvc0"
+		}
+		d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: labels}}
+		d.Spec.Template.Spec.NodeSelector = map[string]string{corev1.LabelHostname: hostname}
+		_, err := clientset.AppsV1().Deployments("ns").Create(ctx, d, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+	newDeployment(0, "node0", false)
+	newDeployment(1, "node0", false)
+	// the OSDs on PVC are not limited
+	newDeployment(2, "node1", true)
+
+	useAllDevices := true
+	spec := cephv1.ClusterSpec{
+		DataDirHostPath: "/var/lib/rook",
+		Storage: cephv1.StorageScopeSpec{
+			UseAllNodes:    true,
+			Selection:      cephv1.Selection{UseAllDevices: &useAllDevices},
+			MaxOSDsPerNode: 3,
+		},
+	}
+	clusterInfo := &cephclient.ClusterInfo{Namespace: "ns", CephVersion: cephver.Nautilus}
+	clusterInfo.SetName("mycluster")
+	clusterInfo.OwnerInfo = cephclient.NewMinimumOwnerInfo(t)
+	ctxt := &clusterd.Context{Clientset: clientset, RequestCancelOrchestration: abool.New()}
+	c := New(ctxt, clusterInfo, spec, "rook/rook:master")
+
+	// the OSDs are counted once per reconcile with the existing deployments
+	config := c.newProvisionConfig()
+	errs := newProvisionErrors()
+	_, _, err := c.getOSDUpdateInfo(config, errs)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"node0": 2}, config.nodeOSDCounts)
+
+	// the prepare jobs are told how many OSDs they may create before provisioning the devices
+	clientset.ClearActions()
+	_, err = c.startProvisioningOverNodes(config, errs)
+	assert.NoError(t, err)
+	assert.Zero(t, errs.len())
+	for node, expected := range map[string]string{"node0": "1", "node1": "3"} {
+		job, err := clientset.BatchV1().Jobs("ns").Get(ctx, k8sutil.TruncateNodeName(prepareAppNameFmt, node), metav1.GetOptions{})
+		assert.NoError(t, err)
+		verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, "ROOK_MAX_NEW_OSDS", expected, true)
+	}
+	// the deployments are not listed again for each node
+	for _, action := range clientset.Actions() {
+		assert.False(t, action.Matches("list", "deployments"), "unexpected action %v", action)
+	}
+
+	// nodes above the limit do not get new OSDs
+	c.spec.Storage.MaxOSDsPerNode = 1
+	job, err := c.makeJob(osdProperties{crushHostname: "node0", maxNewOSDs: 0}, c.newProvisionConfig())
+	assert.NoError(t, err)
+	verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, "ROOK_MAX_NEW_OSDS", "0", true)
+
+	// no limit by default
+	c.spec.Storage.MaxOSDsPerNode = 0
+	job, err = c.makeJob(osdProperties{crushHostname: "node0"}, c.newProvisionConfig())
+	assert.NoError(t, err)
+	verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, "ROOK_MAX_NEW_OSDS", "", false)
+}
+
 func Test_startProvisioningOverPVCs(t *testing.T) {
 	namespace := "rook-ceph"
 
@@ -437,6 +513,33 @@ func Test_startProvisioningOverPVCs(t *testing.T) {
 		requestCancelOrchestration.UnSet()
 	})
 
+	t.Run("defer the OSDs until their PVCs are bound", func(t *testing.T) {
+		spec = cephv1.ClusterSpec{
+			Storage: cephv1.StorageScopeSpec{
+				StorageClassDeviceSets: []cephv1.StorageClassDeviceSet{
+					{
+						Name:  "set1",
+						Count: 2,
+						VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+							newDummyPVC("data", namespace, "10Gi", "gp2"),
+						},
+					},
+				},
+				PVCBindTimeoutSeconds: 60,
+			},
+		}
+		clientset = test.NewComplexClientset(t) // reset to empty fake k8s environment
+		doSetup()
+		awaitingStatusConfigMaps, err = c.startProvisioningOverPVCs(config, errs)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, awaitingStatusConfigMaps.Count())
+		// the errors requeue the reconcile
+		assert.Equal(t, 2, errs.len())
+		jobs, err := clientset.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{})
+		assert.NoError(t, err)
+		assert.Len(t, jobs.Items, 0)
+	})
+
 	t.Run("error if no volume claim template", func(t *testing.T) {
 		spec = cephv1.ClusterSpec{
 			Storage: cephv1.StorageScopeSpec{
@@ -702,3 +805,230 @@ func newDummyPVC(name, namespace string, capacity string, storageClassName strin
 		},
 	}
 }
+
+func Test_maxConcurrentPrepareJobs(t *testing.T) {
+	namespace := "rook-ceph"
+	clusterInfo := &cephclient.ClusterInfo{
+		Namespace:   namespace,
+		CephVersion: cephver.Nautilus,
+	}
+	clusterInfo.SetName("mycluster")
+	clusterInfo.OwnerInfo = cephclient.NewMinimumOwnerInfo(t)
+	useAllDevices := true
+
+	// runJobs provisions OSDs on 4 nodes where the given prepare jobs already exist. It returns the
+	// status configmaps awaited, the number of errors and the number of prepare jobs listed.
+	runJobs := func(maxJobs int, existing ...batchv1.Job) (*util.Set, int, int) {
+		clientset := test.New(t, 4)
+		for i := range existing {
+			existing[i].Namespace = namespace
+			existing[i].Labels = map[string]string{k8sutil.AppAttr: prepareAppName}
+			_, err := clientset.BatchV1().Jobs(namespace).Create(context.TODO(), &existing[i], metav1.CreateOptions{})
+			assert.NoError(t, err)
+		}
+		lists := 0
+		clientset.PrependReactor("list", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			lists++
+			return false, nil, nil
+		})
+
+		spec := cephv1.ClusterSpec{
+			Storage: cephv1.StorageScopeSpec{
+				UseAllNodes:              true,
+				Selection:                cephv1.Selection{UseAllDevices: &useAllDevices},
+				MaxConcurrentPrepareJobs: maxJobs,
+			},
+			DataDirHostPath: "/var/lib/mycluster",
+		}
+		ctx := &clusterd.Context{Clientset: clientset, RequestCancelOrchestration: abool.New()}
+		c := New(ctx, clusterInfo, spec, "rook/rook:master")
+		errs := newProvisionErrors()
+		prepareJobsRun, err := c.startProvisioningOverNodes(c.newProvisionConfig(), errs)
+		assert.NoError(t, err)
+		return prepareJobsRun, errs.len(), lists
+	}
+	finishedJob := func(node string) batchv1.Job {
+		job := batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: k8sutil.TruncateNodeName(prepareAppNameFmt, node)}}
+		job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+		return job
+	}
+	runningJob := func(name string) batchv1.Job {
+		return batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: batchv1.JobStatus{Active: 1}}
+	}
+
+	t.Run("no limit", func(t *testing.T) {
+		prepareJobsRun, errCount, lists := runJobs(0)
+		assert.Equal(t, 4, prepareJobsRun.Count())
+		assert.Zero(t, errCount)
+		assert.Zero(t, lists)
+	})
+
+	t.Run("the jobs above the limit are deferred", func(t *testing.T) {
+		prepareJobsRun, errCount, lists := runJobs(2)
+		assert.Equal(t, 2, prepareJobsRun.Count())
+		assert.Equal(t, 2, errCount)
+		// the running jobs are counted once for all the nodes
+		assert.Equal(t, 1, lists)
+	})
+
+	t.Run("running jobs of other nodes take a slot", func(t *testing.T) {
+		prepareJobsRun, errCount, _ := runJobs(2, runningJob("rook-ceph-osd-prepare-set1-data-0"))
+		assert.Equal(t, 1, prepareJobsRun.Count())
+		assert.Equal(t, 3, errCount)
+	})
+
+	t.Run("finished jobs do not take a slot", func(t *testing.T) {
+		prepareJobsRun, errCount, _ := runJobs(2, finishedJob("node0"), finishedJob("node1"))
+		assert.Equal(t, 2, prepareJobsRun.Count())
+		assert.Equal(t, 2, errCount)
+	})
+}
+
+func Test_prepareJobQueue(t *testing.T) {
+	namespace := "rook-ceph"
+	clusterInfo := &cephclient.ClusterInfo{
+		Namespace:   namespace,
+		CephVersion: cephver.Nautilus,
+	}
+	clusterInfo.SetName("mycluster")
+	clusterInfo.OwnerInfo = cephclient.NewMinimumOwnerInfo(t)
+	useAllDevices := true
+
+	clientset := test.New(t, 5)
+	launched := []string{}
+	maxRunning := 0
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.CreationTimestamp = metav1.NewTime(time.Unix(int64(len(launched)), 0))
+		launched = append(launched, job.Name)
+		return false, nil, nil
+	})
+	countRunning := func() int {
+		jobs, err := clientset.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{})
+		assert.NoError(t, err)
+		running := 0
+		for i := range jobs.Items {
+			if !prepareJobFinished(&jobs.Items[i]) {
+				running++
+			}
+		}
+		return running
+	}
+	completeJobs := func() {
+		jobs, err := clientset.BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{})
+		assert.NoError(t, err)
+		for i := range jobs.Items {
+			jobs.Items[i].Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+			_, err := clientset.BatchV1().Jobs(namespace).Update(context.TODO(), &jobs.Items[i], metav1.UpdateOptions{})
+			assert.NoError(t, err)
+		}
+	}
+
+	spec := cephv1.ClusterSpec{
+		Storage: cephv1.StorageScopeSpec{
+			UseAllNodes:              true,
+			Selection:                cephv1.Selection{UseAllDevices: &useAllDevices},
+			MaxConcurrentPrepareJobs: 2,
+		},
+		DataDirHostPath: "/var/lib/mycluster",
+	}
+	ctx := &clusterd.Context{Clientset: clientset, RequestCancelOrchestration: abool.New()}
+
+	// each reconcile launches the jobs deferred by the previous ones first, so that the 5 nodes are
+	// provisioned by 3 reconciles without running more than 2 jobs at the same time
+	for i := 0; i < 3; i++ {
+		c := New(ctx, clusterInfo, spec, "rook/rook:master")
+		_, err := c.startProvisioningOverNodes(c.newProvisionConfig(), newProvisionErrors())
+		assert.NoError(t, err)
+		if countRunning() > maxRunning {
+			maxRunning = countRunning()
+		}
+		completeJobs()
+	}
+	assert.Equal(t, 2, maxRunning)
+	// the last reconcile has a free slot left for the node prepared first
+	assert.Len(t, launched, 6)
+	for i := 0; i < 5; i++ {
+		assert.Contains(t, launched[:5], k8sutil.TruncateNodeName(prepareAppNameFmt, fmt.Sprintf("node%d", i)))
+	}
+}
+
+func Test_prepareJobSlots(t *testing.T) {
+	slots := &prepareJobSlots{running: util.NewSet(), launched: map[string]time.Time{}}
+	slots.running.Add("job-a")
+
+	// a running job keeps its slot even when the limit is reached
+	assert.True(t, slots.take("job-a", 1))
+	assert.False(t, slots.take("job-b", 1))
+
+	assert.True(t, slots.take("job-b", 2))
+	assert.True(t, slots.take("job-b", 2))
+	assert.False(t, slots.take("job-c", 2))
+	assert.Equal(t, 2, slots.running.Count())
+
+	// the nodes without a prepare job are queued first, then the least recently prepared ones
+	slots.launched["rook-ceph-osd-prepare-node1"] = time.Unix(10, 0)
+	slots.launched["rook-ceph-osd-prepare-node2"] = time.Unix(20, 0)
+	assert.True(t, slots.launchedBefore("node0", "node1"))
+	assert.True(t, slots.launchedBefore("node1", "node2"))
+	assert.False(t, slots.launchedBefore("node2", "node1"))
+	assert.False(t, slots.launchedBefore("node1", "node0"))
+	assert.False(t, slots.launchedBefore("node0", "node3"))
+}
+
+func TestCreateOrUpdatePrepareJob(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	newJob := func(image string) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph-osd-prepare-node1", Namespace: "ns"},
+			Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "provision", Image: image}}},
+				},
+			},
+		}
+	}
+	setActive := func(active int32) {
+		job, err := clientset.BatchV1().Jobs("ns").Get(context.TODO(), "rook-ceph-osd-prepare-node1", metav1.GetOptions{})
+		assert.NoError(t, err)
+		job.Status.Active = active
+		_, err = clientset.BatchV1().Jobs("ns").Update(context.TODO(), job, metav1.UpdateOptions{})
+		assert.NoError(t, err)
+	}
+	getImage := func() string {
+		job, err := clientset.BatchV1().Jobs("ns").Get(context.TODO(), "rook-ceph-osd-prepare-node1", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return job.Spec.Template.Spec.Containers[0].Image
+	}
+
+	created, err := CreateOrUpdatePrepareJob(clientset, newJob("ceph/ceph:v15"))
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	// a running job with the same spec is kept
+	setActive(1)
+	created, err = CreateOrUpdatePrepareJob(clientset, newJob("ceph/ceph:v15"))
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	// a running job with a different spec is kept too
+	created, err = CreateOrUpdatePrepareJob(clientset, newJob("ceph/ceph:v16"))
+	assert.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, "ceph/ceph:v15", getImage())
+
+	// a finished job is replaced
+	setActive(0)
+	created, err = CreateOrUpdatePrepareJob(clientset, newJob("ceph/ceph:v16"))
+	assert.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "ceph/ceph:v16", getImage())
+
+	// a job created by another caller in the meantime is kept
+	clientset.PrependReactor("get", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kerrors.NewNotFound(batchv1.Resource("jobs"), "rook-ceph-osd-prepare-node1")
+	})
+	created, err = CreateOrUpdatePrepareJob(clientset, newJob("ceph/ceph:v17"))
+	assert.NoError(t, err)
+	assert.False(t, created)
+}
@@ -21,15 +21,37 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	"github.com/rook/rook/pkg/clusterd"
+	osdconfig "github.com/rook/rook/pkg/operator/ceph/cluster/osd/config"
 	"github.com/rook/rook/pkg/operator/ceph/controller"
 	"github.com/rook/rook/pkg/operator/k8sutil"
 	"github.com/rook/rook/pkg/util"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// reasons of the events reported on the CephCluster while provisioning the device sets
+	pvcCreatedReason      = "PVCCreated"
+	pvcReusedReason       = "PVCReused"
+	deviceSetFailedReason = "DeviceSetFailed"
+	osdPVCMissingReason   = "OSDPVCMissing"
+
+	// pvcFinalizerAnnotation records the finalizer added to an OSD PVC so that it can be removed
+	// with the OSD even if the finalizer setting changed in the meantime. The finalizer complements
+	// kubernetes.io/pvc-protection, which only keeps a PVC while a pod uses it: the PVC of an OSD whose
+	// pod is not running, e.g. while its node is down, would otherwise be deleted with its data.
+	pvcFinalizerAnnotation = "ceph.rook.io/pvc-finalizer"
+
+	// deviceSetPVCAnnotation records the device set of a PVC created or adopted by Rook, so that the
+	// reuse of an existing PVC is only reported the first time the device set picks it up
+	deviceSetPVCAnnotation = "ceph.rook.io/device-set"
 )
 
 // deviceSet is the processed version of the StorageClassDeviceSet
@@ -64,12 +86,31 @@ type deviceSet struct {
 	SchedulerName string
 	// Whether to encrypt the deviceSet
 	Encrypted bool
+	// PendingPVCs are the names of the PVCs of the device set that are not bound yet and whose OSD is
+	// deferred until they are bound or storage.pvcBindTimeoutSeconds elapsed
+	PendingPVCs []string
+	// BoundVolumes are the names of the PVs bound to the PVCs of the device set
+	BoundVolumes []string
 }
 
 func (c *Cluster) prepareStorageClassDeviceSets(errs *provisionErrors) {
 	c.deviceSets = []deviceSet{}
-
-	existingPVCs, uniqueOSDsPerDeviceSet, err := GetExistingPVCs(c.context, c.clusterInfo.Namespace)
+	c.pvcsCreated = 0
+	start := metricsClock.Now()
+	defer func() {
+		deviceSetsPrepareDuration.WithLabelValues(c.clusterInfo.Namespace).Observe(metricsClock.Since(start).Seconds())
+		deviceSetPVCsCreated.WithLabelValues(c.clusterInfo.Namespace).Observe(float64(c.pvcsCreated))
+	}()
+
+	if err := validatePVCLabelPrefix(c.spec.Storage.PVCLabelPrefix); err != nil {
+		c.deviceSetFailed(errs, "failed to provision OSDs on PVC. %v", err)
+		return
+	}
+	if err := validatePVCFinalizer(c.spec.Storage.PVCFinalizer); err != nil {
+		c.deviceSetFailed(errs, "failed to provision OSDs on PVC. %v", err)
+		return
+	}
+	existingPVCs, uniqueOSDsPerDeviceSet, err := GetExistingPVCs(c.context, c.clusterInfo.Namespace, c.spec.Storage.PVCLabelPrefix)
 	if err != nil {
 		errs.addError("failed to detect existing OSD PVCs. %v", err)
 		return
@@ -77,13 +118,21 @@ func (c *Cluster) prepareStorageClassDeviceSets(errs *provisionErrors) {
 
 	// Iterate over deviceSet
 	for _, deviceSet := range c.spec.Storage.StorageClassDeviceSets {
-		if err := controller.CheckPodMemory(cephv1.ResourcesKeyPrepareOSD, deviceSet.Resources, cephOsdPodMinimumMemory); err != nil {
-			errs.addError("failed to provision OSDs on PVC for storageClassDeviceSet %q. %v", deviceSet.Name, err)
+		if err := controller.CheckPodMemory(cephv1.ResourcesKeyOSD, c.effectiveDeviceSetResources(deviceSet), cephOsdPodMinimumMemory); err != nil {
+			c.deviceSetFailed(errs, "failed to provision OSDs on PVC for storageClassDeviceSet %q. %v", deviceSet.Name, err)
 			continue
 		}
 		// Check if the volume claim template is specified
 		if len(deviceSet.VolumeClaimTemplates) == 0 {
-			errs.addError("failed to provision OSDs on PVC for storageClassDeviceSet %q. no volumeClaimTemplate is specified. user must specify a volumeClaimTemplate", deviceSet.Name)
+			c.deviceSetFailed(errs, "failed to provision OSDs on PVC for storageClassDeviceSet %q. no volumeClaimTemplate is specified. user must specify a volumeClaimTemplate", deviceSet.Name)
+			continue
+		}
+		if err := validateVolumeClaimTemplatesStorage(deviceSet); err != nil {
+			c.deviceSetFailed(errs, "failed to provision OSDs on PVC for storageClassDeviceSet %q. %v", deviceSet.Name, err)
+			continue
+		}
+		if err := validateVolumeClaimTemplatesVolumeMode(deviceSet); err != nil {
+			c.deviceSetFailed(errs, "failed to provision OSDs on PVC for storageClassDeviceSet %q. %v", deviceSet.Name, err)
 			continue
 		}
 
@@ -95,7 +144,7 @@ func (c *Cluster) prepareStorageClassDeviceSets(errs *provisionErrors) {
 			for existingID := range existingIDs.Iter() {
 				pvcID, err := strconv.Atoi(existingID)
 				if err != nil {
-					errs.addError("invalid PVC index %q found for device set %q", existingID, deviceSet.Name)
+					c.deviceSetFailed(errs, "invalid PVC index %q found for device set %q", existingID, deviceSet.Name)
 					continue
 				}
 				// keep track of the max PVC index found so we know what index to start with for new OSDs
@@ -122,6 +171,74 @@ func (c *Cluster) prepareStorageClassDeviceSets(errs *provisionErrors) {
 	}
 }
 
+// validateVolumeClaimTemplatesStorage checks that each volume claim template of the device set
+// requests a storage size, since the API server rejects the PVCs without it
+func validateVolumeClaimTemplatesStorage(deviceSet cephv1.StorageClassDeviceSet) error {
+	for _, template := range deviceSet.VolumeClaimTemplates {
+		name := template.Name
+		if name == "" {
+			name = bluestorePVCData
+		}
+		request, ok := template.Spec.Resources.Requests[v1.ResourceStorage]
+		if !ok {
+			return errors.Errorf("volume claim template %q does not specify a %q resource request", name, v1.ResourceStorage)
+		}
+		if request.Sign() <= 0 {
+			return errors.Errorf("invalid %q resource request %q of volume claim template %q, must be positive", v1.ResourceStorage, request.String(), name)
+		}
+	}
+	return nil
+}
+
+// validateVolumeClaimTemplatesVolumeMode checks that no volume claim template of the device set
+// requests a filesystem volume. The OSDs on PVC are bluestore OSDs consuming the PVCs as raw block
+// devices, so a filesystem volume, whatever its fsType, cannot back them.
+func validateVolumeClaimTemplatesVolumeMode(deviceSet cephv1.StorageClassDeviceSet) error {
+	storeType := deviceSet.Config[osdconfig.StoreTypeKey]
+	if err := osdconfig.ValidateStoreType(storeType); err != nil {
+		return err
+	}
+	for _, template := range deviceSet.VolumeClaimTemplates {
+		name := template.Name
+		if name == "" {
+			name = bluestorePVCData
+		}
+		if template.Spec.VolumeMode != nil && *template.Spec.VolumeMode != v1.PersistentVolumeBlock {
+			return errors.Errorf("volume claim template %q requests a %q volume, %s osds require a %q volume", name, *template.Spec.VolumeMode, osdconfig.Bluestore, v1.PersistentVolumeBlock)
+		}
+	}
+	return nil
+}
+
+// deviceSetFailed adds the device set error to the provisioning errors and reports it on the CephCluster
+func (c *Cluster) deviceSetFailed(errs *provisionErrors, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	errs.addError("%s", msg)
+	c.reportEvent(v1.EventTypeWarning, deviceSetFailedReason, msg)
+}
+
+// effectiveDeviceSetResources returns the resources of each OSD of the device set. The resources of
+// the device set apply to the single pod of an OSD, whatever the number of volume claim templates of
+// the OSD. Without resources in the device set, the OSDs get the OSD resources of the device class of
+// the data template.
+func (c *Cluster) effectiveDeviceSetResources(deviceSet cephv1.StorageClassDeviceSet) v1.ResourceRequirements {
+	if deviceSet.Resources.Limits != nil || deviceSet.Resources.Requests != nil {
+		return deviceSet.Resources
+	}
+	return cephv1.GetOSDResources(c.spec.Resources, deviceSetDataDeviceClass(deviceSet))
+}
+
+// deviceSetDataDeviceClass returns the crush device class set on the data template of the device set
+func deviceSetDataDeviceClass(deviceSet cephv1.StorageClassDeviceSet) string {
+	for _, template := range deviceSet.VolumeClaimTemplates {
+		// a single template is always the data template
+		if template.Name == bluestorePVCData || len(deviceSet.VolumeClaimTemplates) == 1 {
+			return template.Annotations["crushDeviceClass"]
+		}
+	}
+	return ""
+}
+
 func (c *Cluster) createDeviceSetPVCsForIndex(newDeviceSet cephv1.StorageClassDeviceSet, existingPVCs map[string]*v1.PersistentVolumeClaim, setIndex int, errs *provisionErrors) deviceSet {
 	// Create the PVC source for each of the data, metadata, and other types of templates if defined.
 	pvcSources := map[string]v1.PersistentVolumeClaimVolumeSource{}
@@ -130,6 +247,8 @@ func (c *Cluster) createDeviceSetPVCsForIndex(newDeviceSet cephv1.StorageClassDe
 	var crushDeviceClass string
 	var crushInitialWeight string
 	var crushPrimaryAffinity string
+	pendingPVCs := []string{}
+	boundVolumes := []string{}
 	typesFound := util.NewSet()
 	for _, pvcTemplate := range newDeviceSet.VolumeClaimTemplates {
 		if pvcTemplate.Name == "" {
@@ -137,16 +256,22 @@ func (c *Cluster) createDeviceSetPVCsForIndex(newDeviceSet cephv1.StorageClassDe
 			pvcTemplate.Name = bluestorePVCData
 		}
 		if typesFound.Contains(pvcTemplate.Name) {
-			errs.addError("found duplicate volume claim template %q for device set %q", pvcTemplate.Name, newDeviceSet.Name)
+			c.deviceSetFailed(errs, "found duplicate volume claim template %q for device set %q", pvcTemplate.Name, newDeviceSet.Name)
 			continue
 		}
 		typesFound.Add(pvcTemplate.Name)
 
-		pvc, err := c.createDeviceSetPVC(existingPVCs, newDeviceSet.Name, pvcTemplate, setIndex)
+		pvc, created, err := c.createDeviceSetPVC(existingPVCs, newDeviceSet.Name, pvcTemplate, setIndex)
 		if err != nil {
-			errs.addError("failed to provision PVC for device set %q index %d. %v", newDeviceSet.Name, setIndex, err)
+			c.deviceSetFailed(errs, "failed to provision PVC for device set %q index %d. %v", newDeviceSet.Name, setIndex, err)
 			continue
 		}
+		if c.waitForPVCBound(pvc, created) {
+			pendingPVCs = append(pendingPVCs, pvc.Name)
+		}
+		if pvc.Spec.VolumeName != "" {
+			boundVolumes = append(boundVolumes, pvc.Spec.VolumeName)
+		}
 
 		// The PVC type must be from a predefined set such as "data", "metadata", and "wal". These names must be enforced if the wal/db are specified
 		// with a separate device, but if there is a single volume template we can assume it is always the data template.
@@ -185,10 +310,84 @@ func (c *Cluster) createDeviceSetPVCsForIndex(newDeviceSet cephv1.StorageClassDe
 		CrushInitialWeight:   crushInitialWeight,
 		CrushPrimaryAffinity: crushPrimaryAffinity,
 		Encrypted:            newDeviceSet.Encrypted,
+		PendingPVCs:          pendingPVCs,
+		BoundVolumes:         boundVolumes,
+	}
+}
+
+// waitForPVCBound returns whether the OSD of the PVC must wait for the PVC to be bound, so that the
+// placement of the OSD can be derived from the bound PV. The PVC is waited for until
+// storage.pvcBindTimeoutSeconds elapsed since its creation, the reconcile is requeued in the meantime
+// instead of blocking. The PVCs still pending after the timeout, e.g. with a WaitForFirstConsumer
+// storage class whose consumer is the OSD prepare pod, are provisioned as they are.
+func (c *Cluster) waitForPVCBound(pvc *v1.PersistentVolumeClaim, created bool) bool {
+	timeout := time.Duration(c.spec.Storage.PVCBindTimeoutSeconds) * time.Second
+	if timeout <= 0 || pvc.Status.Phase == v1.ClaimBound {
+		return false
+	}
+	if created || time.Since(pvc.CreationTimestamp.Time) < timeout {
+		return true
+	}
+	logger.Warningf("PVC %q is still pending after %v, continuing with the pending PVC", pvc.Name, timeout)
+	return false
+}
+
+// requireLocalPVNodeAffinity requires the node affinity of the local PVs among the given PVs in the
+// placement of the prepare job, since a local PV is only reachable from its node. It only applies to
+// the prepare jobs about to be created, the OSD deployments keep their placement since the scheduler
+// already binds their pods to the node of their volumes.
+func (c *Cluster) requireLocalPVNodeAffinity(osdProps *osdProperties, volumeNames []string) error {
+	var preparePlacement *cephv1.Placement
+	for _, name := range volumeNames {
+		pv, err := c.context.Clientset.CoreV1().PersistentVolumes().Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "failed to get PV %q", name)
+		}
+		if pv.Spec.Local == nil || pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil || len(pv.Spec.NodeAffinity.Required.NodeSelectorTerms) == 0 {
+			continue
+		}
+		if preparePlacement == nil {
+			// the placement of the device set in the cluster spec must not be modified
+			placement := osdProps.getPreparePlacement()
+			preparePlacement = placement.DeepCopy()
+		}
+		logger.Debugf("requiring the node affinity of the local volume %q in the placement of the prepare job of PVC %q", name, osdProps.crushHostname)
+		addRequiredNodeSelector(preparePlacement, pv.Spec.NodeAffinity.Required)
+	}
+	if preparePlacement != nil {
+		osdProps.preparePlacement = preparePlacement
+	}
+	return nil
+}
+
+// addRequiredNodeSelector requires the node selector in addition to the required node affinity of
+// the placement. Since the terms of a node selector are ORed, each term of the placement is combined
+// with each term of the node selector.
+func addRequiredNodeSelector(placement *cephv1.Placement, selector *v1.NodeSelector) {
+	if placement.NodeAffinity == nil {
+		placement.NodeAffinity = &v1.NodeAffinity{}
+	}
+	required := placement.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		placement.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = selector.DeepCopy()
+		return
+	}
+
+	terms := []v1.NodeSelectorTerm{}
+	for _, term := range required.NodeSelectorTerms {
+		for _, selectorTerm := range selector.NodeSelectorTerms {
+			combined := term.DeepCopy()
+			combined.MatchExpressions = append(combined.MatchExpressions, selectorTerm.DeepCopy().MatchExpressions...)
+			combined.MatchFields = append(combined.MatchFields, selectorTerm.DeepCopy().MatchFields...)
+			terms = append(terms, *combined)
+		}
 	}
+	required.NodeSelectorTerms = terms
 }
 
-func (c *Cluster) createDeviceSetPVC(existingPVCs map[string]*v1.PersistentVolumeClaim, deviceSetName string, pvcTemplate v1.PersistentVolumeClaim, setIndex int) (*v1.PersistentVolumeClaim, error) {
+// createDeviceSetPVC creates the PVC of the template for the index of the device set unless it already
+// exists, and returns the PVC and whether it was created
+func (c *Cluster) createDeviceSetPVC(existingPVCs map[string]*v1.PersistentVolumeClaim, deviceSetName string, pvcTemplate v1.PersistentVolumeClaim, setIndex int) (*v1.PersistentVolumeClaim, bool, error) {
 	ctx := context.TODO()
 	// old labels and PVC ID for backward compatibility
 	pvcID := legacyDeviceSetPVCID(deviceSetName, setIndex)
@@ -200,32 +399,119 @@ func (c *Cluster) createDeviceSetPVC(existingPVCs map[string]*v1.PersistentVolum
 		pvcID = deviceSetPVCID(deviceSetName, pvcTemplate.GetName(), setIndex)
 		existingPVC = existingPVCs[pvcID]
 	}
-	pvc := makeDeviceSetPVC(deviceSetName, pvcID, setIndex, pvcTemplate, c.clusterInfo.Namespace)
-	err := c.clusterInfo.OwnerInfo.SetControllerReference(pvc)
+	pvc, err := c.generateDeviceSetPVC(deviceSetName, pvcID, pvcTemplate, setIndex)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to set owner reference to osd pvc %q", pvc.Name)
+		return nil, false, err
 	}
 
 	if existingPVC != nil {
 		logger.Infof("OSD PVC %q already exists", existingPVC.Name)
+		if _, ok := existingPVC.Annotations[deviceSetPVCAnnotation]; !ok {
+			adoptedPVC, err := c.adoptDeviceSetPVC(existingPVC, deviceSetName)
+			if err != nil {
+				// the adoption is retried on the next reconcile
+				logger.Warningf("failed to adopt existing PVC %q for device set %q. %v", existingPVC.Name, deviceSetName, err)
+			} else {
+				existingPVC = adoptedPVC
+				c.reportEvent(v1.EventTypeNormal, pvcReusedReason, fmt.Sprintf("reusing existing PVC %q for device set %q", existingPVC.Name, deviceSetName))
+			}
+		}
 
 		// Update the PVC in case the size changed
 		k8sutil.ExpandPVCIfRequired(c.context.Client, pvc, existingPVC)
-		return existingPVC, nil
+		return existingPVC, false, nil
 	}
 
 	// No PVC found, creating a new one
 	deployedPVC, err := c.context.Clientset.CoreV1().PersistentVolumeClaims(c.clusterInfo.Namespace).Create(ctx, pvc, metav1.CreateOptions{})
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to create PVC %q for device set %q", pvc.Name, deviceSetName)
+		return nil, false, errors.Wrapf(err, "failed to create PVC %q for device set %q", pvc.Name, deviceSetName)
 	}
 	logger.Infof("successfully provisioned PVC %q", deployedPVC.Name)
+	c.pvcsCreated++
+	c.reportEvent(v1.EventTypeNormal, pvcCreatedReason, fmt.Sprintf("created PVC %q for device set %q", deployedPVC.Name, deviceSetName))
+
+	return deployedPVC, true, nil
+}
+
+// adoptDeviceSetPVC annotates an existing PVC that was not created by the device set with the name of
+// the device set
+func (c *Cluster) adoptDeviceSetPVC(pvc *v1.PersistentVolumeClaim, deviceSetName string) (*v1.PersistentVolumeClaim, error) {
+	adopted := pvc.DeepCopy()
+	if adopted.Annotations == nil {
+		adopted.Annotations = map[string]string{}
+	}
+	adopted.Annotations[deviceSetPVCAnnotation] = deviceSetName
+	return c.context.Clientset.CoreV1().PersistentVolumeClaims(c.clusterInfo.Namespace).Update(context.TODO(), adopted, metav1.UpdateOptions{})
+}
+
+// generateDeviceSetPVC returns the PVC of the device set, owned by the cluster unless the owner
+// reference of the PVCs is disabled, without creating it
+func (c *Cluster) generateDeviceSetPVC(deviceSetName, pvcID string, pvcTemplate v1.PersistentVolumeClaim, setIndex int) (*v1.PersistentVolumeClaim, error) {
+	pvc := makeDeviceSetPVC(newPVCLabelKeys(c.spec.Storage.PVCLabelPrefix), deviceSetName, pvcID, setIndex, pvcTemplate, c.clusterInfo.Namespace, c.spec.Storage.PVCFinalizer)
+	if c.spec.Storage.PVCOwnerReference != nil && !*c.spec.Storage.PVCOwnerReference {
+		// the PVCs are kept when the cluster is deleted
+		return pvc, nil
+	}
+	err := c.clusterInfo.OwnerInfo.SetControllerReference(pvc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to set owner reference to osd pvc %q", pvc.Name)
+	}
+	return pvc, nil
+}
+
+// GenerateDeviceSetPVCs returns the PVCs of all the indexes of the storage class device sets as the
+// operator would create them in a new cluster, without accessing the API server. The PVCs can be
+// committed and applied out of band, e.g. by a GitOps workflow. The PVCs are named after their PVC ID
+// instead of the generated name of the operator so that applying them again is idempotent. The
+// operator finds the existing PVCs by their labels, so it reuses them whatever their name.
+func (c *Cluster) GenerateDeviceSetPVCs() ([]*v1.PersistentVolumeClaim, error) {
+	if err := validatePVCLabelPrefix(c.spec.Storage.PVCLabelPrefix); err != nil {
+		return nil, err
+	}
+	if err := validatePVCFinalizer(c.spec.Storage.PVCFinalizer); err != nil {
+		return nil, err
+	}
+
+	pvcs := []*v1.PersistentVolumeClaim{}
+	for _, deviceSet := range c.spec.Storage.StorageClassDeviceSets {
+		if len(deviceSet.VolumeClaimTemplates) == 0 {
+			return nil, errors.Errorf("no volumeClaimTemplate is specified for storageClassDeviceSet %q", deviceSet.Name)
+		}
+		if err := validateVolumeClaimTemplatesStorage(deviceSet); err != nil {
+			return nil, errors.Wrapf(err, "invalid storageClassDeviceSet %q", deviceSet.Name)
+		}
+		if err := validateVolumeClaimTemplatesVolumeMode(deviceSet); err != nil {
+			return nil, errors.Wrapf(err, "invalid storageClassDeviceSet %q", deviceSet.Name)
+		}
+		for setIndex := 0; setIndex < deviceSet.Count; setIndex++ {
+			typesFound := util.NewSet()
+			for _, pvcTemplate := range deviceSet.VolumeClaimTemplates {
+				if pvcTemplate.Name == "" {
+					// For backward compatibility a blank name must be treated as a data volume
+					pvcTemplate.Name = bluestorePVCData
+				}
+				if typesFound.Contains(pvcTemplate.Name) {
+					return nil, errors.Errorf("found duplicate volume claim template %q for device set %q", pvcTemplate.Name, deviceSet.Name)
+				}
+				typesFound.Add(pvcTemplate.Name)
 
-	return deployedPVC, nil
+				pvcID := deviceSetPVCID(deviceSet.Name, pvcTemplate.GetName(), setIndex)
+				pvc, err := c.generateDeviceSetPVC(deviceSet.Name, pvcID, pvcTemplate, setIndex)
+				if err != nil {
+					return nil, err
+				}
+				pvc.Name = pvcID
+				pvc.GenerateName = ""
+				pvcs = append(pvcs, pvc)
+			}
+		}
+	}
+	return pvcs, nil
 }
 
-func makeDeviceSetPVC(deviceSetName, pvcID string, setIndex int, pvcTemplate v1.PersistentVolumeClaim, namespace string) *v1.PersistentVolumeClaim {
-	pvcLabels := makeStorageClassDeviceSetPVCLabel(deviceSetName, pvcID, setIndex)
+func makeDeviceSetPVC(labelKeys pvcLabelKeys, deviceSetName, pvcID string, setIndex int, pvcTemplate v1.PersistentVolumeClaim, namespace, finalizer string) *v1.PersistentVolumeClaim {
+	pvcLabels := makeStorageClassDeviceSetPVCLabel(labelKeys, deviceSetName, pvcID, setIndex)
 
 	// Add user provided labels to pvcTemplates
 	for k, v := range pvcTemplate.GetLabels() {
@@ -233,46 +519,229 @@ func makeDeviceSetPVC(deviceSetName, pvcID string, setIndex int, pvcTemplate v1.
 	}
 
 	// pvc naming format rook-ceph-osd-<deviceSetName>-<SetNumber>-<PVCIndex>-<generatedSuffix>
-	return &v1.PersistentVolumeClaim{
+	pvc := &v1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
 			// Use a generated name to avoid the possibility of two OSDs being created with the same ID.
 			// If one is removed and a new one is created later with the same ID, the OSD would fail to start.
 			GenerateName: pvcID,
 			Namespace:    namespace,
 			Labels:       pvcLabels,
-			Annotations:  pvcTemplate.Annotations,
+			// copy the annotations to not modify the template
+			Annotations: map[string]string{deviceSetPVCAnnotation: deviceSetName},
 		},
 		Spec: pvcTemplate.Spec,
 	}
+
+	if finalizer != "" {
+		pvc.Annotations[pvcFinalizerAnnotation] = finalizer
+		pvc.Finalizers = []string{finalizer}
+	}
+	for k, v := range pvcTemplate.Annotations {
+		pvc.Annotations[k] = v
+	}
+	return pvc
 }
 
-// GetExistingPVCs fetches the list of OSD PVCs
-func GetExistingPVCs(clusterdContext *clusterd.Context, namespace string) (map[string]*v1.PersistentVolumeClaim, map[string]*util.Set, error) {
-	ctx := context.TODO()
-	selector := metav1.ListOptions{LabelSelector: CephDeviceSetPVCIDLabelKey}
-	pvcs, err := clusterdContext.Clientset.CoreV1().PersistentVolumeClaims(namespace).List(ctx, selector)
+func validatePVCFinalizer(finalizer string) error {
+	if finalizer == "" {
+		return nil
+	}
+	// like kubernetes, only accept the finalizers qualified by a domain
+	if errs := validation.IsQualifiedName(finalizer); len(errs) > 0 || !strings.Contains(finalizer, "/") {
+		return errors.Errorf("invalid pvc finalizer %q. the finalizer must be a qualified name with a domain prefix, e.g. \"example.com/osd-protection\"", finalizer)
+	}
+	return nil
+}
+
+// RemovePVCFinalizer removes the finalizer Rook added to the OSD PVC when it was created, so that the
+// PVC can be deleted. The other finalizers of the PVC are kept.
+func RemovePVCFinalizer(clientset kubernetes.Interface, pvc *v1.PersistentVolumeClaim) error {
+	finalizer := pvc.Annotations[pvcFinalizerAnnotation]
+	if finalizer == "" {
+		return nil
+	}
+	finalizers := []string{}
+	for _, f := range pvc.Finalizers {
+		if f != finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	if len(finalizers) == len(pvc.Finalizers) {
+		return nil
+	}
+
+	logger.Infof("removing finalizer %q from osd pvc %q", finalizer, pvc.Name)
+	updated := pvc.DeepCopy()
+	updated.Finalizers = finalizers
+	if _, err := clientset.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(context.TODO(), updated, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "failed to remove finalizer %q from osd pvc %q", finalizer, pvc.Name)
+	}
+	return nil
+}
+
+// RemoveAllPVCFinalizers removes the finalizer Rook added to the OSD PVCs of the namespace, e.g. when
+// the CephCluster is deleted, so that the PVCs are not stuck in the terminating state
+func RemoveAllPVCFinalizers(clientset kubernetes.Interface, namespace, labelPrefix string) error {
+	pvcs, err := listPVCsWithFinalizer(clientset, namespace, labelPrefix)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to detect PVCs")
+		return err
+	}
+	failures := 0
+	for i := range pvcs {
+		if err := RemovePVCFinalizer(clientset, &pvcs[i]); err != nil {
+			logger.Errorf("%v", err)
+			failures++
+		}
+	}
+	if failures > 0 {
+		return errors.Errorf("failed to remove the finalizer of %d osd pvcs in namespace %q", failures, namespace)
+	}
+	return nil
+}
+
+// releaseDeletedPVCs removes the finalizer Rook added to the OSD PVCs being deleted that are not
+// referenced by any OSD deployment anymore, e.g. after an OSD was removed manually. The PVCs of the
+// running OSDs keep their finalizer.
+func (c *Cluster) releaseDeletedPVCs() error {
+	pvcs, err := listPVCsWithFinalizer(c.context.Clientset, c.clusterInfo.Namespace, c.spec.Storage.PVCLabelPrefix)
+	if err != nil {
+		return err
+	}
+	deleted := []v1.PersistentVolumeClaim{}
+	for _, pvc := range pvcs {
+		if pvc.DeletionTimestamp != nil {
+			deleted = append(deleted, pvc)
+		}
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	deployments, err := ListOSDDeployments(c.context.Clientset, c.clusterInfo.Namespace)
+	if err != nil {
+		return err
+	}
+	referenced := util.NewSet()
+	for _, d := range deployments {
+		for _, volume := range d.Spec.Template.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				referenced.Add(volume.PersistentVolumeClaim.ClaimName)
+			}
+		}
+	}
+
+	for i, pvc := range deleted {
+		if referenced.Contains(pvc.Name) {
+			logger.Warningf("osd pvc %q is being deleted but is still used by an osd, keeping its finalizer", pvc.Name)
+			continue
+		}
+		if err := RemovePVCFinalizer(c.context.Clientset, &deleted[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listPVCsWithFinalizer returns the device set PVCs of the namespace to which Rook added a finalizer.
+// Only the PVCs labeled with the device set label key of the given prefix, or of the default prefix,
+// are listed.
+func listPVCsWithFinalizer(clientset kubernetes.Interface, namespace, labelPrefix string) ([]v1.PersistentVolumeClaim, error) {
+	labelKeys := []pvcLabelKeys{newPVCLabelKeys(labelPrefix)}
+	if labelPrefix != "" && labelPrefix != defaultPVCLabelPrefix {
+		labelKeys = append(labelKeys, newPVCLabelKeys(defaultPVCLabelPrefix))
 	}
+
+	result := []v1.PersistentVolumeClaim{}
+	found := util.NewSet()
+	for _, keys := range labelKeys {
+		selector := metav1.ListOptions{LabelSelector: keys.deviceSet}
+		pvcs, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), selector)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list the device set pvcs in namespace %q", namespace)
+		}
+		for _, pvc := range pvcs.Items {
+			if pvc.Annotations[pvcFinalizerAnnotation] != "" && found.Add(pvc.Name) {
+				result = append(result, pvc)
+			}
+		}
+	}
+	return result, nil
+}
+
+// GetExistingPVCs fetches the list of OSD PVCs labeled with the given label prefix, or with the
+// default prefix if empty. The PVCs labeled with the default prefix before a prefix was configured
+// are still found so that they are not provisioned again.
+func GetExistingPVCs(clusterdContext *clusterd.Context, namespace, labelPrefix string) (map[string]*v1.PersistentVolumeClaim, map[string]*util.Set, error) {
 	result := map[string]*v1.PersistentVolumeClaim{}
 	uniqueOSDsPerDeviceSet := map[string]*util.Set{}
-	for i, pvc := range pvcs.Items {
-		// Populate the PVCs based on their unique name across all the device sets
-		pvcID := pvc.Labels[CephDeviceSetPVCIDLabelKey]
-		result[pvcID] = &pvcs.Items[i]
+	labelKeys := []pvcLabelKeys{newPVCLabelKeys(labelPrefix)}
+	if labelPrefix != "" && labelPrefix != defaultPVCLabelPrefix {
+		labelKeys = append(labelKeys, newPVCLabelKeys(defaultPVCLabelPrefix))
+	}
 
-		// Create a map of the PVC IDs available in each device set based on PVC index
-		deviceSet := pvc.Labels[CephDeviceSetLabelKey]
-		pvcIndex := pvc.Labels[CephSetIndexLabelKey]
-		if _, ok := uniqueOSDsPerDeviceSet[deviceSet]; !ok {
-			uniqueOSDsPerDeviceSet[deviceSet] = util.NewSet()
+	for _, keys := range labelKeys {
+		selector := metav1.ListOptions{LabelSelector: keys.pvcID}
+		pvcs, err := clusterdContext.Clientset.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), selector)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to detect PVCs")
+		}
+		for i, pvc := range pvcs.Items {
+			// Populate the PVCs based on their unique name across all the device sets
+			pvcID := pvc.Labels[keys.pvcID]
+			if _, ok := result[pvcID]; ok {
+				continue
+			}
+			result[pvcID] = &pvcs.Items[i]
+
+			// Create a map of the PVC IDs available in each device set based on PVC index
+			deviceSet := pvc.Labels[keys.deviceSet]
+			pvcIndex := pvc.Labels[keys.setIndex]
+			if _, ok := uniqueOSDsPerDeviceSet[deviceSet]; !ok {
+				uniqueOSDsPerDeviceSet[deviceSet] = util.NewSet()
+			}
+			uniqueOSDsPerDeviceSet[deviceSet].Add(pvcIndex)
 		}
-		uniqueOSDsPerDeviceSet[deviceSet].Add(pvcIndex)
 	}
 
 	return result, uniqueOSDsPerDeviceSet, nil
 }
 
+// GetOSDPVCMapping returns the name of the PVC backing each OSD of the device sets in the namespace,
+// keyed by the OSD id. The OSDs are found from the PVC label of their deployments and only the PVCs
+// carrying the device set labels are reported.
+func GetOSDPVCMapping(clusterdContext *clusterd.Context, namespace, labelPrefix string) (map[int]string, error) {
+	pvcs, _, err := GetExistingPVCs(clusterdContext, namespace, labelPrefix)
+	if err != nil {
+		return nil, err
+	}
+	deviceSetPVCs := util.NewSet()
+	for _, pvc := range pvcs {
+		deviceSetPVCs.Add(pvc.Name)
+	}
+
+	listOpts := metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s,%s", k8sutil.AppAttr, AppName, OSDOverPVCLabelKey)}
+	deployments, err := clusterdContext.Clientset.AppsV1().Deployments(namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query existing OSD deployments")
+	}
+
+	result := map[int]string{}
+	for i := range deployments.Items {
+		pvcName := deployments.Items[i].Labels[OSDOverPVCLabelKey]
+		if !deviceSetPVCs.Contains(pvcName) {
+			logger.Debugf("skipping osd deployment %q, pvc %q is not part of a device set", deployments.Items[i].Name, pvcName)
+			continue
+		}
+		osdID, err := getOSDID(&deployments.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		result[osdID] = pvcName
+	}
+
+	return result, nil
+}
+
 func legacyDeviceSetPVCID(deviceSetName string, setIndex int) string {
 	return fmt.Sprintf("%s-%d", deviceSetName, setIndex)
 }
@@ -19,17 +19,24 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	"github.com/rook/rook/pkg/clusterd"
 	"github.com/rook/rook/pkg/daemon/ceph/client"
+	"github.com/rook/rook/pkg/operator/ceph/controller"
+	"github.com/rook/rook/pkg/operator/k8sutil"
 	testexec "github.com/rook/rook/pkg/operator/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/tevino/abool"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestPrepareDeviceSets(t *testing.T) {
@@ -227,6 +234,9 @@ func testVolumeClaim(name string) corev1.PersistentVolumeClaim {
 	storageClass := "mysource"
 	claim := corev1.PersistentVolumeClaim{Spec: corev1.PersistentVolumeClaimSpec{
 		StorageClassName: &storageClass,
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+		},
 	}}
 	claim.Name = name
 	return claim
@@ -270,3 +280,826 @@ func TestPrepareDeviceSetsWithCrushParams(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(pvcs.Items))
 }
+
+func TestDeviceSetEvents(t *testing.T) {
+	ctx := context.TODO()
+	clientset := testexec.New(t, 1)
+	// generate the names of the PVCs created with a generateName
+	clientset.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvc := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+		if pvc.Name == "" {
+			pvc.Name = pvc.GenerateName + "-gen"
+		}
+		return false, nil, nil
+	})
+	deviceSet := cephv1.StorageClassDeviceSet{
+		Name:                 "mydata",
+		Count:                1,
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{testVolumeClaim("data")},
+	}
+	cluster := &Cluster{
+		context:     &clusterd.Context{Clientset: clientset},
+		clusterInfo: client.AdminClusterInfo("testns"),
+		spec: cephv1.ClusterSpec{
+			Storage: cephv1.StorageScopeSpec{StorageClassDeviceSets: []cephv1.StorageClassDeviceSet{deviceSet}},
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	cluster.SetEventRecorder(recorder, &cephv1.CephCluster{ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "testns"}})
+	events := func() []string {
+		result := []string{}
+		for {
+			select {
+			case event := <-recorder.Events:
+				result = append(result, event)
+			default:
+				return result
+			}
+		}
+	}
+
+	t.Run("pvc created", func(t *testing.T) {
+		errs := newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		assert.Equal(t, 0, errs.len())
+		assert.Equal(t, []string{`Normal PVCCreated created PVC "mydata-data-0-gen" for device set "mydata"`}, events())
+	})
+
+	t.Run("pvc of the device set", func(t *testing.T) {
+		errs := newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		assert.Equal(t, 0, errs.len())
+		assert.Empty(t, events())
+	})
+
+	t.Run("pvc reused", func(t *testing.T) {
+		// a PVC that was not created by the device set, e.g. left over by a previous cluster
+		pvc, err := clientset.CoreV1().PersistentVolumeClaims("testns").Get(ctx, "mydata-data-0-gen", metav1.GetOptions{})
+		assert.NoError(t, err)
+		delete(pvc.Annotations, deviceSetPVCAnnotation)
+		_, err = clientset.CoreV1().PersistentVolumeClaims("testns").Update(ctx, pvc, metav1.UpdateOptions{})
+		assert.NoError(t, err)
+
+		errs := newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		assert.Equal(t, 0, errs.len())
+		assert.Equal(t, []string{`Normal PVCReused reusing existing PVC "mydata-data-0-gen" for device set "mydata"`}, events())
+		pvc, err = clientset.CoreV1().PersistentVolumeClaims("testns").Get(ctx, "mydata-data-0-gen", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "mydata", pvc.Annotations[deviceSetPVCAnnotation])
+
+		// the reuse is only reported when the PVC is adopted
+		cluster.prepareStorageClassDeviceSets(newProvisionErrors())
+		assert.Empty(t, events())
+	})
+
+	t.Run("device set failure", func(t *testing.T) {
+		cluster.spec.Storage.StorageClassDeviceSets[0].VolumeClaimTemplates = nil
+		errs := newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		assert.Equal(t, 1, errs.len())
+		e := events()
+		assert.Equal(t, 1, len(e))
+		assert.Contains(t, e[0], `Warning DeviceSetFailed failed to provision OSDs on PVC for storageClassDeviceSet "mydata". no volumeClaimTemplate is specified`)
+	})
+
+	t.Run("no events without a recorder", func(t *testing.T) {
+		cluster.recorder = nil
+		errs := newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		assert.Equal(t, 1, errs.len())
+		assert.Empty(t, events())
+	})
+}
+
+func TestDeviceSetLocalPVNodeAffinity(t *testing.T) {
+	clientset := testexec.New(t, 1)
+	// bind the PVCs of the "local" device set to the local PV
+	clientset.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvc := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+		pvc.Name = pvc.GenerateName + "-gen"
+		if pvc.Labels[CephDeviceSetLabelKey] == "local" {
+			pvc.Spec.VolumeName = "local-pv-0"
+		}
+		return false, nil, nil
+	})
+	pvGets := 0
+	clientset.PrependReactor("get", "persistentvolumes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvGets++
+		return false, nil, nil
+	})
+	pvNodeSelector := &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+		MatchExpressions: []corev1.NodeSelectorRequirement{
+			{Key: corev1.LabelHostname, Operator: corev1.NodeSelectorOpIn, Values: []string{"node1"}},
+		},
+	}}}
+	for _, pv := range []*corev1.PersistentVolume{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "local-pv-0"},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{Local: &corev1.LocalVolumeSource{Path: "/dev/sdb"}},
+				NodeAffinity:           &corev1.VolumeNodeAffinity{Required: pvNodeSelector},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "zonal-pv-0"},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{Driver: "ebs.csi.aws.com"}},
+				NodeAffinity:           &corev1.VolumeNodeAffinity{Required: pvNodeSelector},
+			},
+		},
+	} {
+		_, err := clientset.CoreV1().PersistentVolumes().Create(context.TODO(), pv, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+
+	zoneTerm := corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+		{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}},
+	}}
+	localDeviceSet := cephv1.StorageClassDeviceSet{
+		Name:                 "local",
+		Count:                1,
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{testVolumeClaim("data")},
+		Placement: cephv1.Placement{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{zoneTerm}},
+		}},
+	}
+	cluster := &Cluster{
+		context:     &clusterd.Context{Clientset: clientset},
+		clusterInfo: client.AdminClusterInfo("testns"),
+		spec: cephv1.ClusterSpec{
+			Storage: cephv1.StorageScopeSpec{StorageClassDeviceSets: []cephv1.StorageClassDeviceSet{localDeviceSet}},
+		},
+	}
+
+	// the device sets record the bound PVs without getting them
+	errs := newProvisionErrors()
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	assert.Equal(t, 1, len(cluster.deviceSets))
+	assert.Equal(t, []string{"local-pv-0"}, cluster.deviceSets[0].BoundVolumes)
+	assert.Equal(t, 0, pvGets)
+
+	t.Run("the prepare job of a local pv runs on its node", func(t *testing.T) {
+		osdProps := osdProperties{crushHostname: "local-data-0", placement: cluster.deviceSets[0].Placement}
+		assert.NoError(t, cluster.requireLocalPVNodeAffinity(&osdProps, cluster.deviceSets[0].BoundVolumes))
+		assert.Equal(t, []corev1.NodeSelectorTerm{{MatchExpressions: append(zoneTerm.MatchExpressions, pvNodeSelector.NodeSelectorTerms[0].MatchExpressions...)}},
+			osdProps.preparePlacement.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms)
+
+		// the osd placement and the cluster spec are not modified
+		assert.Equal(t, []corev1.NodeSelectorTerm{zoneTerm}, osdProps.placement.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms)
+		assert.Equal(t, []corev1.NodeSelectorTerm{zoneTerm},
+			cluster.spec.Storage.StorageClassDeviceSets[0].Placement.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms)
+	})
+
+	t.Run("the prepare placement is extended", func(t *testing.T) {
+		preparePlacement := &cephv1.Placement{Tolerations: []corev1.Toleration{{Key: "storage", Operator: corev1.TolerationOpExists}}}
+		osdProps := osdProperties{crushHostname: "local-data-0", placement: cluster.deviceSets[0].Placement, preparePlacement: preparePlacement}
+		assert.NoError(t, cluster.requireLocalPVNodeAffinity(&osdProps, []string{"local-pv-0"}))
+		assert.Equal(t, pvNodeSelector, osdProps.preparePlacement.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+		assert.Equal(t, 1, len(osdProps.preparePlacement.Tolerations))
+		assert.Nil(t, preparePlacement.NodeAffinity)
+	})
+
+	t.Run("other pvs do not constrain the placement", func(t *testing.T) {
+		osdProps := osdProperties{crushHostname: "zonal-data-0", placement: cluster.deviceSets[0].Placement}
+		assert.NoError(t, cluster.requireLocalPVNodeAffinity(&osdProps, []string{"zonal-pv-0"}))
+		assert.Nil(t, osdProps.preparePlacement)
+		assert.NoError(t, cluster.requireLocalPVNodeAffinity(&osdProps, nil))
+		assert.Nil(t, osdProps.preparePlacement)
+	})
+
+	t.Run("a missing pv is an error", func(t *testing.T) {
+		osdProps := osdProperties{crushHostname: "local-data-0"}
+		assert.Error(t, cluster.requireLocalPVNodeAffinity(&osdProps, []string{"missing-pv"}))
+	})
+}
+
+func TestDeviceSetPVCLabelPrefix(t *testing.T) {
+	ctx := context.TODO()
+	clientset := testexec.New(t, 1)
+	clientset.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvc := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+		pvc.Name = pvc.GenerateName + "-gen"
+		return false, nil, nil
+	})
+	deviceSet := cephv1.StorageClassDeviceSet{
+		Name:                 "set1",
+		Count:                1,
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{testVolumeClaim("data")},
+	}
+	cluster := &Cluster{
+		context:     &clusterd.Context{Clientset: clientset},
+		clusterInfo: client.AdminClusterInfo("testns"),
+		spec: cephv1.ClusterSpec{
+			Storage: cephv1.StorageScopeSpec{
+				PVCLabelPrefix:         "storage.example.com",
+				StorageClassDeviceSets: []cephv1.StorageClassDeviceSet{deviceSet},
+			},
+		},
+	}
+
+	errs := newProvisionErrors()
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims("testns").List(ctx, metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pvcs.Items))
+	assert.Equal(t, map[string]string{
+		"storage.example.com/DeviceSet":      "set1",
+		"storage.example.com/setIndex":       "0",
+		"storage.example.com/DeviceSetPVCId": "set1-data-0",
+	}, pvcs.Items[0].Labels)
+
+	// the PVCs are selected with the prefix so they are not created again
+	existing, perDeviceSet, err := GetExistingPVCs(cluster.context, "testns", "storage.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(existing))
+	assert.Equal(t, 1, perDeviceSet["set1"].Count())
+	existing, _, err = GetExistingPVCs(cluster.context, "testns", "")
+	assert.NoError(t, err)
+	assert.Empty(t, existing)
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	pvcs, err = clientset.CoreV1().PersistentVolumeClaims("testns").List(ctx, metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pvcs.Items))
+
+	// the PVCs labeled with the default prefix are still found
+	cluster.spec.Storage.StorageClassDeviceSets[0].Name = "set2"
+	cluster.spec.Storage.PVCLabelPrefix = ""
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	cluster.spec.Storage.PVCLabelPrefix = "storage.example.com"
+	existing, perDeviceSet, err = GetExistingPVCs(cluster.context, "testns", "storage.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(existing))
+	assert.Equal(t, 1, perDeviceSet["set2"].Count())
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	pvcs, err = clientset.CoreV1().PersistentVolumeClaims("testns").List(ctx, metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(pvcs.Items))
+
+	// an invalid prefix fails the device sets
+	cluster.spec.Storage.PVCLabelPrefix = "not a prefix"
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 1, errs.len())
+}
+
+func TestDeviceSetPVCFinalizer(t *testing.T) {
+	ctx := context.TODO()
+	clientset := testexec.New(t, 1)
+	clientset.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvc := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+		pvc.Name = pvc.GenerateName + "-gen"
+		return false, nil, nil
+	})
+	template := testVolumeClaim("data")
+	template.Annotations = map[string]string{"user": "annotation"}
+	deviceSet := cephv1.StorageClassDeviceSet{
+		Name:                 "set1",
+		Count:                1,
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{template},
+	}
+	cluster := &Cluster{
+		context:     &clusterd.Context{Clientset: clientset},
+		clusterInfo: client.AdminClusterInfo("testns"),
+		spec: cephv1.ClusterSpec{
+			Storage: cephv1.StorageScopeSpec{
+				PVCFinalizer:           "storage.example.com/osd-protection",
+				StorageClassDeviceSets: []cephv1.StorageClassDeviceSet{deviceSet},
+			},
+		},
+	}
+
+	errs := newProvisionErrors()
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims("testns").List(ctx, metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(pvcs.Items))
+	pvc := &pvcs.Items[0]
+	assert.Equal(t, []string{"storage.example.com/osd-protection"}, pvc.Finalizers)
+	assert.Equal(t, "annotation", pvc.Annotations["user"])
+	// the template is not modified
+	assert.Equal(t, map[string]string{"user": "annotation"}, cluster.spec.Storage.StorageClassDeviceSets[0].VolumeClaimTemplates[0].Annotations)
+
+	// only the finalizer added by rook is removed
+	pvc.Finalizers = append(pvc.Finalizers, "other.example.com/finalizer")
+	_, err = clientset.CoreV1().PersistentVolumeClaims("testns").Update(ctx, pvc, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	err = RemovePVCFinalizer(clientset, pvc)
+	assert.NoError(t, err)
+	pvc, err = clientset.CoreV1().PersistentVolumeClaims("testns").Get(ctx, pvc.Name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"other.example.com/finalizer"}, pvc.Finalizers)
+	// removing it again is a no-op
+	assert.NoError(t, RemovePVCFinalizer(clientset, pvc))
+
+	// no finalizer by default
+	cluster.spec.Storage.PVCFinalizer = ""
+	cluster.spec.Storage.StorageClassDeviceSets[0].Name = "set2"
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	pvcs, err = clientset.CoreV1().PersistentVolumeClaims("testns").List(ctx, metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(pvcs.Items))
+	for _, pvc := range pvcs.Items {
+		if pvc.Labels["ceph.rook.io/DeviceSet"] == "set2" {
+			assert.Empty(t, pvc.Finalizers)
+			assert.Empty(t, pvc.Annotations[pvcFinalizerAnnotation])
+		}
+	}
+
+	// the finalizer must be qualified by a domain
+	for _, invalid := range []string{"osd-protection", "not a finalizer/x"} {
+		cluster.spec.Storage.PVCFinalizer = invalid
+		errs = newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		assert.Equal(t, 1, errs.len(), invalid)
+	}
+}
+
+func TestReleaseOSDPVCFinalizers(t *testing.T) {
+	ctx := context.TODO()
+	clientset := testexec.New(t, 1)
+	finalizer := "storage.example.com/osd-protection"
+	newPVC := func(name string, deleting bool) {
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "testns",
+			Labels:      map[string]string{CephDeviceSetLabelKey: "set1"},
+			Annotations: map[string]string{pvcFinalizerAnnotation: finalizer},
+			Finalizers:  []string{finalizer, "other.example.com/finalizer"},
+		}}
+		if deleting {
+			pvc.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		}
+		_, err := clientset.CoreV1().PersistentVolumeClaims("testns").Create(ctx, pvc, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+	finalizers := func(name string) []string {
+		pvc, err := clientset.CoreV1().PersistentVolumeClaims("testns").Get(ctx, name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return pvc.Finalizers
+	}
+	newPVC("set1-data-0", true)  // deleted by accident while its osd runs
+	newPVC("set1-data-1", true)  // deleted after its osd was removed manually
+	newPVC("set1-data-2", false) // not deleted
+	// the pvcs which do not belong to a device set are never listed
+	_, err := clientset.CoreV1().PersistentVolumeClaims("testns").Create(ctx, &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name:              "other",
+		Namespace:         "testns",
+		Annotations:       map[string]string{pvcFinalizerAnnotation: finalizer},
+		Finalizers:        []string{finalizer},
+		DeletionTimestamp: &metav1.Time{Time: time.Now()},
+	}}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	clientset.PrependReactor("list", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		assert.Equal(t, CephDeviceSetLabelKey, action.(k8stesting.ListAction).GetListRestrictions().Labels.String())
+		return false, nil, nil
+	})
+	osdDeployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{
+		Name:      "rook-ceph-osd-0",
+		Namespace: "testns",
+		Labels:    map[string]string{k8sutil.AppAttr: AppName, k8sutil.ClusterAttr: "testns"},
+	}}
+	osdDeployment.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name:         "set1-data-0",
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "set1-data-0"}},
+	}}
+	_, err = clientset.AppsV1().Deployments("testns").Create(ctx, osdDeployment, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	// the reconcile only releases the deleted pvcs which are not used by an osd
+	cluster := &Cluster{context: &clusterd.Context{Clientset: clientset}, clusterInfo: client.AdminClusterInfo("testns")}
+	assert.NoError(t, cluster.releaseDeletedPVCs())
+	assert.Equal(t, []string{finalizer, "other.example.com/finalizer"}, finalizers("set1-data-0"))
+	assert.Equal(t, []string{"other.example.com/finalizer"}, finalizers("set1-data-1"))
+	assert.Equal(t, []string{finalizer, "other.example.com/finalizer"}, finalizers("set1-data-2"))
+
+	// all the pvcs are released when the cluster is deleted
+	assert.NoError(t, RemoveAllPVCFinalizers(clientset, "testns", ""))
+	for _, name := range []string{"set1-data-0", "set1-data-1", "set1-data-2"} {
+		assert.Equal(t, []string{"other.example.com/finalizer"}, finalizers(name), name)
+	}
+	assert.Equal(t, []string{finalizer}, finalizers("other"))
+}
+
+func TestGenerateDeviceSetPVCs(t *testing.T) {
+	clientset := testexec.New(t, 1)
+	clientset.ClearActions()
+	metadata := testVolumeClaim("metadata")
+	deviceSet := cephv1.StorageClassDeviceSet{
+		Name:                 "set1",
+		Count:                2,
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{testVolumeClaim(""), metadata},
+	}
+	cluster := &Cluster{
+		context:     &clusterd.Context{Clientset: clientset},
+		clusterInfo: client.AdminClusterInfo("testns"),
+		spec: cephv1.ClusterSpec{
+			Storage: cephv1.StorageScopeSpec{
+				PVCFinalizer:           "storage.example.com/osd-protection",
+				StorageClassDeviceSets: []cephv1.StorageClassDeviceSet{deviceSet},
+			},
+		},
+	}
+
+	pvcs, err := cluster.GenerateDeviceSetPVCs()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(pvcs))
+	names := []string{}
+	for _, pvc := range pvcs {
+		names = append(names, pvc.Name)
+		assert.Empty(t, pvc.GenerateName)
+		assert.Equal(t, "testns", pvc.Namespace)
+		assert.Equal(t, "set1", pvc.Labels["ceph.rook.io/DeviceSet"])
+		assert.Equal(t, []string{"storage.example.com/osd-protection"}, pvc.Finalizers)
+		assert.Len(t, pvc.OwnerReferences, 1)
+	}
+	assert.Equal(t, []string{"set1-data-0", "set1-metadata-0", "set1-data-1", "set1-metadata-1"}, names)
+	// the PVCs are only generated
+	assert.Empty(t, clientset.Actions())
+
+	// the names are the same on every generation
+	again, err := cluster.GenerateDeviceSetPVCs()
+	assert.NoError(t, err)
+	for i := range pvcs {
+		assert.Equal(t, pvcs[i].Name, again[i].Name)
+	}
+
+	// the operator reuses the generated PVCs once they are created
+	for _, pvc := range pvcs {
+		_, err := clientset.CoreV1().PersistentVolumeClaims("testns").Create(context.TODO(), pvc, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+	errs := newProvisionErrors()
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	assert.Equal(t, 0, cluster.pvcsCreated)
+	assert.Len(t, cluster.deviceSets, 2)
+	clientset.ClearActions()
+
+	// invalid device sets are reported
+	cluster.spec.Storage.StorageClassDeviceSets[0].VolumeClaimTemplates = []corev1.PersistentVolumeClaim{metadata, metadata}
+	_, err = cluster.GenerateDeviceSetPVCs()
+	assert.Error(t, err)
+	cluster.spec.Storage.StorageClassDeviceSets[0].VolumeClaimTemplates = nil
+	_, err = cluster.GenerateDeviceSetPVCs()
+	assert.Error(t, err)
+	assert.Empty(t, clientset.Actions())
+}
+
+func TestDeviceSetPVCOwnerReference(t *testing.T) {
+	ctx := context.TODO()
+	clientset := testexec.New(t, 1)
+	clientset.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvc := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+		pvc.Name = pvc.GenerateName + "-gen"
+		return false, nil, nil
+	})
+	deviceSet := cephv1.StorageClassDeviceSet{
+		Name:                 "set1",
+		Count:                1,
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{testVolumeClaim("data")},
+	}
+	cluster := &Cluster{
+		context:     &clusterd.Context{Clientset: clientset},
+		clusterInfo: client.AdminClusterInfo("testns"),
+		spec: cephv1.ClusterSpec{
+			Storage: cephv1.StorageScopeSpec{StorageClassDeviceSets: []cephv1.StorageClassDeviceSet{deviceSet}},
+		},
+	}
+	getPVC := func(deviceSetName string) *corev1.PersistentVolumeClaim {
+		pvcs, err := clientset.CoreV1().PersistentVolumeClaims("testns").List(ctx, metav1.ListOptions{})
+		assert.NoError(t, err)
+		for i := range pvcs.Items {
+			if pvcs.Items[i].Labels["ceph.rook.io/DeviceSet"] == deviceSetName {
+				return &pvcs.Items[i]
+			}
+		}
+		return nil
+	}
+
+	// the cluster owns the PVCs by default
+	errs := newProvisionErrors()
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	pvc := getPVC("set1")
+	assert.NotNil(t, pvc)
+	assert.Len(t, pvc.OwnerReferences, 1)
+	assert.True(t, *pvc.OwnerReferences[0].Controller)
+
+	enabled := true
+	cluster.spec.Storage.PVCOwnerReference = &enabled
+	cluster.spec.Storage.StorageClassDeviceSets[0].Name = "set2"
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	pvc = getPVC("set2")
+	assert.NotNil(t, pvc)
+	assert.Len(t, pvc.OwnerReferences, 1)
+
+	// the PVCs are not garbage collected with the cluster when disabled
+	disabled := false
+	cluster.spec.Storage.PVCOwnerReference = &disabled
+	cluster.spec.Storage.StorageClassDeviceSets[0].Name = "set3"
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	pvc = getPVC("set3")
+	assert.NotNil(t, pvc)
+	assert.Empty(t, pvc.OwnerReferences)
+	generated, err := cluster.GenerateDeviceSetPVCs()
+	assert.NoError(t, err)
+	assert.Empty(t, generated[0].OwnerReferences)
+}
+
+func TestDeviceSetStorageRequest(t *testing.T) {
+	ctx := context.TODO()
+	clientset := testexec.New(t, 1)
+	noRequest := testVolumeClaim("data")
+	noRequest.Spec.Resources.Requests = nil
+	deviceSet := cephv1.StorageClassDeviceSet{
+		Name:                 "set1",
+		Count:                1,
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{noRequest},
+	}
+	cluster := &Cluster{
+		context:     &clusterd.Context{Clientset: clientset},
+		clusterInfo: client.AdminClusterInfo("testns"),
+		spec: cephv1.ClusterSpec{
+			Storage: cephv1.StorageScopeSpec{StorageClassDeviceSets: []cephv1.StorageClassDeviceSet{deviceSet}},
+		},
+	}
+
+	errs := newProvisionErrors()
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 1, errs.len())
+	assert.Contains(t, errs.asMessages(), `storageClassDeviceSet "set1"`)
+	assert.Contains(t, errs.asMessages(), `volume claim template "data" does not specify a "storage" resource request`)
+	assert.Empty(t, cluster.deviceSets)
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims("testns").List(ctx, metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, pvcs.Items)
+	_, err = cluster.GenerateDeviceSetPVCs()
+	assert.Error(t, err)
+
+	// every template must request a positive storage size
+	zeroRequest := testVolumeClaim("metadata")
+	zeroRequest.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("0")
+	for _, invalid := range []corev1.PersistentVolumeClaim{noRequest, zeroRequest} {
+		invalid.Name = "metadata"
+		cluster.spec.Storage.StorageClassDeviceSets[0].VolumeClaimTemplates = []corev1.PersistentVolumeClaim{testVolumeClaim("data"), invalid}
+		errs = newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		assert.Equal(t, 1, errs.len())
+		assert.Contains(t, errs.asMessages(), `volume claim template "metadata"`)
+	}
+
+	cluster.spec.Storage.StorageClassDeviceSets[0].VolumeClaimTemplates = []corev1.PersistentVolumeClaim{testVolumeClaim("data")}
+	errs = newProvisionErrors()
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	assert.Len(t, cluster.deviceSets, 1)
+}
+
+func TestDeviceSetVolumeMode(t *testing.T) {
+	clientset := testexec.New(t, 1)
+	clientset.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvc := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+		pvc.Name = pvc.GenerateName + "-gen"
+		return false, nil, nil
+	})
+	block := corev1.PersistentVolumeBlock
+	filesystem := corev1.PersistentVolumeFilesystem
+	template := func(name string, mode *corev1.PersistentVolumeMode) corev1.PersistentVolumeClaim {
+		claim := testVolumeClaim(name)
+		claim.Spec.VolumeMode = mode
+		return claim
+	}
+	cluster := &Cluster{
+		context:     &clusterd.Context{Clientset: clientset},
+		clusterInfo: client.AdminClusterInfo("testns"),
+	}
+	prepare := func(deviceSet cephv1.StorageClassDeviceSet) *provisionErrors {
+		deviceSet.Name = "set1"
+		deviceSet.Count = 1
+		cluster.spec.Storage.StorageClassDeviceSets = []cephv1.StorageClassDeviceSet{deviceSet}
+		errs := newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		return errs
+	}
+
+	// block volumes and templates without volume mode are accepted
+	errs := prepare(cephv1.StorageClassDeviceSet{
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{template("data", &block), template("metadata", nil)},
+	})
+	assert.Equal(t, 0, errs.len())
+
+	// bluestore cannot be backed by a filesystem volume
+	errs = prepare(cephv1.StorageClassDeviceSet{
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{template("data", &block), template("metadata", &filesystem)},
+	})
+	assert.Equal(t, 1, errs.len())
+	assert.Contains(t, errs.asMessages(), `volume claim template "metadata" requests a "Filesystem" volume`)
+	_, err := cluster.GenerateDeviceSetPVCs()
+	assert.Error(t, err)
+
+	// the store type of the device set is checked too
+	errs = prepare(cephv1.StorageClassDeviceSet{
+		Config:               map[string]string{"storeType": "filestore"},
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{template("data", &filesystem)},
+	})
+	assert.Equal(t, 1, errs.len())
+	assert.Contains(t, errs.asMessages(), `store type "filestore" is not supported anymore`)
+}
+
+func TestGetOSDPVCMapping(t *testing.T) {
+	ctx := context.TODO()
+	clientset := testexec.New(t, 1)
+	clusterdContext := &clusterd.Context{Clientset: clientset}
+
+	newPVC := func(name string, labels map[string]string) {
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "testns", Labels: labels}}
+		_, err := clientset.CoreV1().PersistentVolumeClaims("testns").Create(ctx, pvc, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+	newDeployment := func(osdID, pvcName string) {
+		labels := map[string]string{"app": AppName, OsdIdLabelKey: osdID}
+		if pvcName != "" {
+			labels[OSDOverPVCLabelKey] = pvcName
+		}
+		d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph-osd-" + osdID, Namespace: "testns", Labels: labels}}
+		_, err := clientset.AppsV1().Deployments("testns").Create(ctx, d, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+
+	newPVC("set1-data-0-abcde", makeStorageClassDeviceSetPVCLabel(newPVCLabelKeys(""), "set1", "set1-data-0", 0))
+	newPVC("set1-data-1-fghij", makeStorageClassDeviceSetPVCLabel(newPVCLabelKeys(""), "set1", "set1-data-1", 1))
+	newPVC("set2-data-0-klmno", makeStorageClassDeviceSetPVCLabel(newPVCLabelKeys("storage.example.com"), "set2", "set2-data-0", 0))
+	newPVC("not-a-device-set", nil)
+	newDeployment("0", "set1-data-0-abcde")
+	newDeployment("3", "set1-data-1-fghij")
+	newDeployment("5", "set2-data-0-klmno")
+	// an osd on a pvc not created for a device set and an osd on a node are not mapped
+	newDeployment("6", "not-a-device-set")
+	newDeployment("7", "")
+
+	mapping, err := GetOSDPVCMapping(clusterdContext, "testns", "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{0: "set1-data-0-abcde", 3: "set1-data-1-fghij"}, mapping)
+
+	// the pvcs labeled with the default prefix are found along with the configured prefix
+	mapping, err = GetOSDPVCMapping(clusterdContext, "testns", "storage.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]string{0: "set1-data-0-abcde", 3: "set1-data-1-fghij", 5: "set2-data-0-klmno"}, mapping)
+
+	// other namespaces are not mapped
+	mapping, err = GetOSDPVCMapping(clusterdContext, "otherns", "")
+	assert.NoError(t, err)
+	assert.Empty(t, mapping)
+
+	// an osd deployment without a valid id fails the mapping
+	newDeployment("invalid", "set1-data-0-abcde")
+	_, err = GetOSDPVCMapping(clusterdContext, "testns", "")
+	assert.Error(t, err)
+}
+
+func TestEffectiveDeviceSetResources(t *testing.T) {
+	memory := func(limit, request string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{
+			Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(limit)},
+			Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(request)},
+		}
+	}
+	ssdTemplate := func(name string) corev1.PersistentVolumeClaim {
+		claim := testVolumeClaim(name)
+		claim.Annotations = map[string]string{"crushDeviceClass": "ssd"}
+		return claim
+	}
+	c := &Cluster{spec: cephv1.ClusterSpec{Resources: cephv1.ResourceSpec{
+		"osd":     memory("4Gi", "4Gi"),
+		"osd-ssd": memory("2Gi", "3Gi"),
+	}}}
+
+	// the resources of the device set apply to each osd whatever its templates
+	deviceSet := cephv1.StorageClassDeviceSet{
+		Name:                 "set1",
+		Count:                3,
+		Resources:            memory("5Gi", "5Gi"),
+		VolumeClaimTemplates: []corev1.PersistentVolumeClaim{ssdTemplate("data"), testVolumeClaim("metadata"), testVolumeClaim("wal")},
+	}
+	assert.Equal(t, memory("5Gi", "5Gi"), c.effectiveDeviceSetResources(deviceSet))
+	assert.NoError(t, controller.CheckPodMemory(cephv1.ResourcesKeyOSD, c.effectiveDeviceSetResources(deviceSet), cephOsdPodMinimumMemory))
+
+	// the osd resources of the device class of the data template are checked
+	deviceSet.Resources = corev1.ResourceRequirements{}
+	assert.Equal(t, memory("2Gi", "3Gi"), c.effectiveDeviceSetResources(deviceSet))
+	assert.Error(t, controller.CheckPodMemory(cephv1.ResourcesKeyOSD, c.effectiveDeviceSetResources(deviceSet), cephOsdPodMinimumMemory))
+
+	// the device class of the other templates is ignored
+	deviceSet.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{testVolumeClaim("data"), ssdTemplate("metadata")}
+	assert.Equal(t, memory("4Gi", "4Gi"), c.effectiveDeviceSetResources(deviceSet))
+
+	// a single template is the data template
+	deviceSet.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{ssdTemplate("anyname")}
+	assert.Equal(t, memory("2Gi", "3Gi"), c.effectiveDeviceSetResources(deviceSet))
+}
+
+func TestDeviceSetPVCBindWait(t *testing.T) {
+	clientset := testexec.New(t, 1)
+	clientset.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvc := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+		pvc.Name = pvc.GenerateName + "-gen"
+		pvc.Status.Phase = corev1.ClaimPending
+		return false, nil, nil
+	})
+	// the PVCs are never polled while they are pending
+	gets := 0
+	clientset.PrependReactor("get", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return false, nil, nil
+	})
+
+	newCluster := func(deviceSetName string, count, timeoutSeconds int) *Cluster {
+		return &Cluster{
+			context:     &clusterd.Context{Clientset: clientset, RequestCancelOrchestration: abool.New()},
+			clusterInfo: client.AdminClusterInfo("testns"),
+			spec: cephv1.ClusterSpec{
+				Storage: cephv1.StorageScopeSpec{
+					StorageClassDeviceSets: []cephv1.StorageClassDeviceSet{{
+						Name:                 deviceSetName,
+						Count:                count,
+						VolumeClaimTemplates: []corev1.PersistentVolumeClaim{testVolumeClaim("data")},
+					}},
+					PVCBindTimeoutSeconds: timeoutSeconds,
+				},
+			},
+		}
+	}
+	setPVCs := func(deviceSetName string, update func(pvc *corev1.PersistentVolumeClaim)) {
+		pvcs, err := clientset.CoreV1().PersistentVolumeClaims("testns").List(context.TODO(), metav1.ListOptions{LabelSelector: CephDeviceSetLabelKey + "=" + deviceSetName})
+		assert.NoError(t, err)
+		for i := range pvcs.Items {
+			update(&pvcs.Items[i])
+			_, err := clientset.CoreV1().PersistentVolumeClaims("testns").Update(context.TODO(), &pvcs.Items[i], metav1.UpdateOptions{})
+			assert.NoError(t, err)
+		}
+	}
+
+	t.Run("pending pvcs are not waited for by default", func(t *testing.T) {
+		cluster := newCluster("nowait", 1, 0)
+		errs := newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		assert.Equal(t, 0, errs.len())
+		assert.Empty(t, cluster.deviceSets[0].PendingPVCs)
+	})
+
+	t.Run("the new pending pvcs are waited for", func(t *testing.T) {
+		cluster := newCluster("wait", 2, 60)
+		errs := newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		assert.Equal(t, 0, errs.len())
+		assert.Equal(t, 2, len(cluster.deviceSets))
+		for _, deviceSet := range cluster.deviceSets {
+			assert.Equal(t, []string{deviceSet.PVCSources[bluestorePVCData].ClaimName}, deviceSet.PendingPVCs)
+		}
+		assert.Equal(t, 0, gets)
+	})
+
+	t.Run("the existing pvcs are waited for until the timeout", func(t *testing.T) {
+		setPVCs("wait", func(pvc *corev1.PersistentVolumeClaim) { pvc.CreationTimestamp = metav1.Now() })
+		cluster := newCluster("wait", 2, 60)
+		errs := newProvisionErrors()
+		cluster.prepareStorageClassDeviceSets(errs)
+		assert.Equal(t, 2, len(cluster.deviceSets))
+		for _, deviceSet := range cluster.deviceSets {
+			assert.Len(t, deviceSet.PendingPVCs, 1)
+		}
+
+		// the pending pvcs are used after the timeout
+		setPVCs("wait", func(pvc *corev1.PersistentVolumeClaim) {
+			pvc.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Minute))
+		})
+		cluster.prepareStorageClassDeviceSets(errs)
+		for _, deviceSet := range cluster.deviceSets {
+			assert.Empty(t, deviceSet.PendingPVCs)
+		}
+		assert.Equal(t, 0, errs.len())
+	})
+
+	t.Run("the bound pvcs are not waited for", func(t *testing.T) {
+		cluster := newCluster("bound", 1, 60)
+		cluster.prepareStorageClassDeviceSets(newProvisionErrors())
+		assert.Len(t, cluster.deviceSets[0].PendingPVCs, 1)
+
+		setPVCs("bound", func(pvc *corev1.PersistentVolumeClaim) {
+			pvc.CreationTimestamp = metav1.Now()
+			pvc.Status.Phase = corev1.ClaimBound
+		})
+		cluster.prepareStorageClassDeviceSets(newProvisionErrors())
+		assert.Empty(t, cluster.deviceSets[0].PendingPVCs)
+		assert.Equal(t, 0, gets)
+	})
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// DiffOSDDeployment returns true if the fields managed by Rook in the containers of the existing OSD
+// deployment differ from the desired deployment. The managed fields are the image, the args, the
+// resources and the env vars of the containers and init containers, matched by name. A container of
+// the desired deployment missing from the existing deployment is a difference too, while containers
+// only found in the existing deployment are ignored. The update of the existing OSDs reports an
+// event for the drifted deployments before applying the desired deployments.
+func DiffOSDDeployment(existing, desired *appsv1.Deployment) bool {
+	existingSpec := &existing.Spec.Template.Spec
+	desiredSpec := &desired.Spec.Template.Spec
+	return containersDiffer(existingSpec.InitContainers, desiredSpec.InitContainers) ||
+		containersDiffer(existingSpec.Containers, desiredSpec.Containers)
+}
+
+func containersDiffer(existing, desired []v1.Container) bool {
+	for i := range desired {
+		c := findContainer(existing, desired[i].Name)
+		if c == nil || !managedContainerFieldsEqual(c, &desired[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func managedContainerFieldsEqual(existing, desired *v1.Container) bool {
+	// semantic equality compares the resource quantities by value and nil slices equal to empty ones
+	return existing.Image == desired.Image &&
+		equality.Semantic.DeepEqual(existing.Args, desired.Args) &&
+		equality.Semantic.DeepEqual(existing.Resources, desired.Resources) &&
+		equality.Semantic.DeepEqual(existing.Env, desired.Env)
+}
+
+func findContainer(containers []v1.Container, name string) *v1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"testing"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestOSDDeploymentDrift(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		resources: v1.ResourceRequirements{
+			Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("4Gi")},
+		},
+	}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw", Location: "root=default host=node1"}
+	desired, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+
+	t.Run("no drift", func(t *testing.T) {
+		existing := desired.DeepCopy()
+		// equivalent quantities and unmanaged fields are not a drift
+		existing.Spec.Template.Spec.Containers[0].Resources.Limits[v1.ResourceMemory] = resource.MustParse("4096Mi")
+		existing.Spec.Template.Annotations = map[string]string{"user": "annotation"}
+		existing.Spec.Template.Spec.Containers[0].ImagePullPolicy = v1.PullAlways
+		existing.Spec.Template.Spec.Containers = append(existing.Spec.Template.Spec.Containers, v1.Container{Name: "user-sidecar", Image: "sidecar"})
+		assert.False(t, DiffOSDDeployment(existing, desired))
+	})
+
+	t.Run("drifted managed fields", func(t *testing.T) {
+		for name, drift := range map[string]func(container *v1.Container){
+			"image":     func(container *v1.Container) { container.Image = "ceph/ceph:custom" },
+			"args":      func(container *v1.Container) { container.Args = append(container.Args, "--debug-osd=20") },
+			"resources": func(container *v1.Container) { container.Resources = v1.ResourceRequirements{} },
+			"env": func(container *v1.Container) {
+				container.Env = append(container.Env, v1.EnvVar{Name: "FOO", Value: "bar"})
+			},
+		} {
+			existing := desired.DeepCopy()
+			drift(&existing.Spec.Template.Spec.Containers[0])
+			assert.True(t, DiffOSDDeployment(existing, desired), name)
+			existing = desired.DeepCopy()
+			drift(&existing.Spec.Template.Spec.InitContainers[0])
+			assert.True(t, DiffOSDDeployment(existing, desired), name)
+		}
+
+		// a removed container is a drift too
+		existing := desired.DeepCopy()
+		existing.Spec.Template.Spec.InitContainers = existing.Spec.Template.Spec.InitContainers[1:]
+		assert.True(t, DiffOSDDeployment(existing, desired))
+	})
+
+}
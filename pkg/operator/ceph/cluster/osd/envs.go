@@ -17,14 +17,21 @@ limitations under the License.
 package osd
 
 import (
+	"encoding/json"
+	"path"
 	"strconv"
+	"strings"
 
+	"github.com/pkg/errors"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	"github.com/rook/rook/pkg/daemon/ceph/client"
 	kms "github.com/rook/rook/pkg/daemon/ceph/osd/kms"
 	opmon "github.com/rook/rook/pkg/operator/ceph/cluster/mon"
+	osdconfig "github.com/rook/rook/pkg/operator/ceph/cluster/osd/config"
 	"github.com/rook/rook/pkg/operator/k8sutil"
 	"gopkg.in/ini.v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 const (
@@ -32,6 +39,18 @@ const (
 	osdWalSizeEnvVarName      = "ROOK_OSD_WAL_SIZE"
 	osdsPerDeviceEnvVarName   = "ROOK_OSDS_PER_DEVICE"
 	osdDeviceClassEnvVarName  = "ROOK_OSD_DEVICE_CLASS"
+	// osdBlueStoreRocksDBOptionsEnvVarName is the bluestore_rocksdb_options to set on the provisioned OSDs
+	osdBlueStoreRocksDBOptionsEnvVarName = "ROOK_OSD_BLUESTORE_ROCKSDB_OPTIONS"
+	// osdConfigOverridesEnvVarName lists the ceph config settings to write in the config section of the provisioned OSDs
+	osdConfigOverridesEnvVarName = "ROOK_OSD_CONFIG_OVERRIDES"
+	// osdPGAutoscaleModeEnvVarName is the default pg autoscale mode of the new pools set by the prepare job
+	osdPGAutoscaleModeEnvVarName = "ROOK_OSD_PG_AUTOSCALE_MODE"
+	// osdZonedEnvVarName makes the prepare job provision the devices with the zoned bluestore backend
+	osdZonedEnvVarName = "ROOK_OSD_ZONED"
+	// osdDeviceClassHintEnvVarName is the additional class the prepare job records on the OSDs it provisions
+	osdDeviceClassHintEnvVarName = "ROOK_OSD_CRUSH_DEVICE_CLASS_HINT"
+	// osdRawModeEnvVarName makes the prepare job provision the devices in raw mode or fail
+	osdRawModeEnvVarName = "ROOK_OSD_RAW_MODE"
 	// EncryptedDeviceEnvVarName is used in the pod spec to indicate whether the OSD is encrypted or not
 	EncryptedDeviceEnvVarName = "ROOK_ENCRYPTED_DEVICE"
 	PVCNameEnvVarName         = "ROOK_PVC_NAME"
@@ -106,13 +125,126 @@ func (c *Cluster) getConfigEnvVars(osdProps osdProperties, dataDir string) []v1.
 		envVars = append(envVars, v1.EnvVar{Name: EncryptedDeviceEnvVarName, Value: "true"})
 	}
 
+	if osdProps.storeConfig.BlueStoreRocksDBOptions != "" {
+		envVars = append(envVars, v1.EnvVar{Name: osdBlueStoreRocksDBOptionsEnvVarName, Value: osdProps.storeConfig.BlueStoreRocksDBOptions})
+	}
+
+	if osdProps.storeConfig.PGAutoscaleMode != "" {
+		envVars = append(envVars, v1.EnvVar{Name: osdPGAutoscaleModeEnvVarName, Value: osdProps.storeConfig.PGAutoscaleMode})
+	}
+
+	if len(osdProps.storeConfig.ConfigOverrides) != 0 {
+		envVars = append(envVars, v1.EnvVar{Name: osdConfigOverridesEnvVarName, Value: osdconfig.FormatConfigOverrides(osdProps.storeConfig.ConfigOverrides)})
+	}
+
+	if osdProps.storeConfig.Zoned {
+		envVars = append(envVars, v1.EnvVar{Name: osdZonedEnvVarName, Value: "true"})
+	}
+
+	if osdProps.storeConfig.RawMode {
+		envVars = append(envVars, v1.EnvVar{Name: osdRawModeEnvVarName, Value: "true"})
+	}
+
+	if osdProps.storeConfig.DeviceClassHint != "" {
+		envVars = append(envVars, v1.EnvVar{Name: osdDeviceClassHintEnvVarName, Value: osdProps.storeConfig.DeviceClassHint})
+	}
+
 	return envVars
 }
 
+// storeConfigFromEnvVars returns the store config set by getConfigEnvVars
+func storeConfigFromEnvVars(envVars []v1.EnvVar) (osdconfig.StoreConfig, error) {
+	storeConfig := osdconfig.NewStoreConfig()
+	var err error
+	for _, envVar := range envVars {
+		switch envVar.Name {
+		case osdDatabaseSizeEnvVarName:
+			storeConfig.DatabaseSizeMB, err = strconv.Atoi(envVar.Value)
+		case osdWalSizeEnvVarName:
+			storeConfig.WalSizeMB, err = strconv.Atoi(envVar.Value)
+		case osdsPerDeviceEnvVarName:
+			storeConfig.OSDsPerDevice, err = strconv.Atoi(envVar.Value)
+		case EncryptedDeviceEnvVarName:
+			storeConfig.EncryptedDevice = envVar.Value == "true"
+		case osdMetadataDeviceEnvVarName:
+			storeConfig.MetadataDevice = envVar.Value
+		case CrushDeviceClassVarName:
+			storeConfig.DeviceClass = envVar.Value
+		case CrushInitialWeightVarName:
+			storeConfig.InitialWeight = envVar.Value
+		case osdBlueStoreRocksDBOptionsEnvVarName:
+			storeConfig.BlueStoreRocksDBOptions = envVar.Value
+		case osdPGAutoscaleModeEnvVarName:
+			storeConfig.PGAutoscaleMode = envVar.Value
+		case osdConfigOverridesEnvVarName:
+			storeConfig.ConfigOverrides, err = osdconfig.ParseConfigOverrides(envVar.Value)
+		case osdZonedEnvVarName:
+			storeConfig.Zoned = envVar.Value == "true"
+		case osdRawModeEnvVarName:
+			storeConfig.RawMode = envVar.Value == "true"
+		case osdDeviceClassHintEnvVarName:
+			storeConfig.DeviceClassHint = envVar.Value
+		}
+		if err != nil {
+			return osdconfig.StoreConfig{}, errors.Wrapf(err, "failed to parse env var %q", envVar.Name)
+		}
+	}
+	return storeConfig, nil
+}
+
 func nodeNameEnvVar(name string) v1.EnvVar {
 	return v1.EnvVar{Name: "ROOK_NODE_NAME", Value: name}
 }
 
+// DeviceSelection is the selection of the devices an OSD prepare job provisions OSDs on. Only one of
+// the list of devices, the device filter, the device path filter and all the devices is used, in
+// that order of precedence. The device selector restricts the devices matched by a filter or by all
+// the devices, it is ignored with a list of devices.
+type DeviceSelection struct {
+	// Devices are the devices listed by name or path with their configuration
+	Devices []osdconfig.ConfiguredDevice
+	// DeviceFilter is a regular expression matching the names of the devices
+	DeviceFilter string
+	// DevicePathFilter is a regular expression matching the paths of the devices
+	DevicePathFilter string
+	// UseAllDevices selects all the devices of the node
+	UseAllDevices bool
+	// DeviceSelector restricts the devices matched by a filter or by all the devices
+	DeviceSelector *cephv1.DeviceSelector
+}
+
+// ToEnvVars returns the env vars passing the device selection to the OSD prepare job
+func (s DeviceSelection) ToEnvVars() ([]v1.EnvVar, error) {
+	var envVars []v1.EnvVar
+	switch {
+	case len(s.Devices) > 0:
+		marshalledDevices, err := json.Marshal(s.Devices)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to JSON marshal configured devices")
+		}
+		// the device selector does not apply to a list of devices
+		return []v1.EnvVar{dataDevicesEnvVar(string(marshalledDevices))}, nil
+	case s.DeviceFilter != "":
+		envVars = append(envVars, deviceFilterEnvVar(s.DeviceFilter))
+	case s.DevicePathFilter != "":
+		envVars = append(envVars, devicePathFilterEnvVar(s.DevicePathFilter))
+	case s.UseAllDevices:
+		envVars = append(envVars, deviceFilterEnvVar("all"))
+	default:
+		// no device is selected
+		return nil, nil
+	}
+
+	if s.DeviceSelector != nil {
+		selectorEnvVar, err := deviceSelectorEnvVar(s.DeviceSelector)
+		if err != nil {
+			return nil, err
+		}
+		envVars = append(envVars, selectorEnvVar)
+	}
+	return envVars, nil
+}
+
 func dataDevicesEnvVar(dataDevices string) v1.EnvVar {
 	return v1.EnvVar{Name: "ROOK_DATA_DEVICES", Value: dataDevices}
 }
@@ -125,10 +257,109 @@ func devicePathFilterEnvVar(filter string) v1.EnvVar {
 	return v1.EnvVar{Name: "ROOK_DATA_DEVICE_PATH_FILTER", Value: filter}
 }
 
+// deviceSelectorEnvVar serializes the device selector of the storage selection for the prepare job
+// with the sizes converted to bytes
+func deviceSelectorEnvVar(selector *cephv1.DeviceSelector) (v1.EnvVar, error) {
+	s := osdconfig.DeviceSelector{
+		Rotational: selector.Rotational,
+		Vendor:     selector.Vendor,
+		Model:      selector.Model,
+	}
+	var err error
+	if s.MinSizeBytes, err = deviceSelectorSize(selector.MinSize); err != nil {
+		return v1.EnvVar{}, errors.Wrap(err, "invalid minSize of the device selector")
+	}
+	if s.MaxSizeBytes, err = deviceSelectorSize(selector.MaxSize); err != nil {
+		return v1.EnvVar{}, errors.Wrap(err, "invalid maxSize of the device selector")
+	}
+	if s.MaxSizeBytes > 0 && s.MinSizeBytes > s.MaxSizeBytes {
+		return v1.EnvVar{}, errors.Errorf("minSize %q of the device selector is greater than maxSize %q", selector.MinSize, selector.MaxSize)
+	}
+	return v1.EnvVar{Name: "ROOK_DATA_DEVICE_SELECTOR", Value: osdconfig.FormatDeviceSelector(s)}, nil
+}
+
+func deviceSelectorSize(size string) (uint64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(size)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse size %q", size)
+	}
+	if q.Sign() <= 0 {
+		return 0, errors.Errorf("size %q must be positive", size)
+	}
+	return uint64(q.Value()), nil
+}
+
+func strictDeviceCheckEnvVar() v1.EnvVar {
+	return v1.EnvVar{Name: "ROOK_STRICT_DEVICE_CHECK", Value: "true"}
+}
+
+func wipeDeviceOnProvisionEnvVar() v1.EnvVar {
+	return v1.EnvVar{Name: "ROOK_WIPE_DEVICE_ON_PROVISION", Value: "true"}
+}
+
+func maxNewOSDsEnvVar(maxNewOSDs int) v1.EnvVar {
+	return v1.EnvVar{Name: "ROOK_MAX_NEW_OSDS", Value: strconv.Itoa(maxNewOSDs)}
+}
+
 func dataDeviceClassEnvVar(deviceClass string) v1.EnvVar {
 	return v1.EnvVar{Name: osdDeviceClassEnvVarName, Value: deviceClass}
 }
 
+// normalizeMetadataDevice returns the path of the metadata device below /dev so that a device is
+// always passed the same way, e.g. both "sdb" and "/dev/sdb" become "/dev/sdb"
+func normalizeMetadataDevice(metadataDevice string) (string, error) {
+	device := strings.TrimSpace(metadataDevice)
+	if device == "" || strings.ContainsAny(device, " \t\n") {
+		return "", errors.Errorf("invalid metadata device %q", metadataDevice)
+	}
+
+	if !strings.HasPrefix(device, "/") {
+		if strings.Contains(device, "/") {
+			logger.Warningf("metadata device %q is ambiguous, assuming it is relative to /dev", metadataDevice)
+		}
+		device = path.Join("/dev", device)
+	}
+
+	cleaned := path.Clean(device)
+	if !strings.HasPrefix(cleaned, "/dev/") {
+		return "", errors.Errorf("invalid metadata device %q, expected a device below /dev", metadataDevice)
+	}
+	if cleaned != device {
+		logger.Warningf("metadata device %q is ambiguous, using %q", metadataDevice, cleaned)
+	}
+	return cleaned, nil
+}
+
+// canonicalDeviceID returns the canonical form of a device listed by name or by path, so that the
+// same device is always passed the same way to the OSD prepare job. The devices directly below /dev
+// are passed by name, e.g. "sdb", " sdb " and "/dev/sdb" become "sdb", while the other paths below
+// /dev such as the /dev/disk/by-id links are cleaned but kept as paths since they can only be resolved
+// on the node.
+func canonicalDeviceID(device string) (string, error) {
+	id := strings.TrimSpace(device)
+	if id == "" || strings.ContainsAny(id, " \t\n") {
+		return "", errors.Errorf("invalid device %q", device)
+	}
+
+	cleaned := path.Clean(id)
+	if !strings.HasPrefix(id, "/") {
+		if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return "", errors.Errorf("invalid device %q", device)
+		}
+		return cleaned, nil
+	}
+	if !strings.HasPrefix(cleaned, "/dev/") {
+		return "", errors.Errorf("invalid device %q, expected a device below /dev", device)
+	}
+	if path.Dir(cleaned) == "/dev" {
+		return path.Base(cleaned), nil
+	}
+	return cleaned, nil
+}
+
 func metadataDeviceEnvVar(metadataDevice string) v1.EnvVar {
 	return v1.EnvVar{Name: osdMetadataDeviceEnvVarName, Value: metadataDevice}
 }
@@ -233,3 +464,20 @@ func getTcmallocMaxTotalThreadCacheBytesFromFile() string {
 
 	return iniCephEnvConfigFile.Section("").Key(tcmallocMaxTotalThreadCacheBytesEnv).String()
 }
+
+// addExtraEnvVars adds the extra env vars of the storage spec to the OSD daemon container. The env
+// vars set by Rook and the names reserved for Rook cannot be overridden.
+func (c *Cluster) addExtraEnvVars(container *v1.Container) error {
+	reserved := map[string]bool{}
+	for _, envVar := range container.Env {
+		reserved[envVar.Name] = true
+	}
+	for _, envVar := range c.spec.Storage.ExtraEnv {
+		if reserved[envVar.Name] || strings.HasPrefix(envVar.Name, "ROOK_") {
+			return errors.Errorf("extra env var %q is reserved by rook", envVar.Name)
+		}
+		reserved[envVar.Name] = true
+		container.Env = append(container.Env, envVar)
+	}
+	return nil
+}
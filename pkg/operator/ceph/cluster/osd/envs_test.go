@@ -17,11 +17,16 @@ limitations under the License.
 package osd
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"testing"
 
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	osdconfig "github.com/rook/rook/pkg/operator/ceph/cluster/osd/config"
+	cephver "github.com/rook/rook/pkg/operator/ceph/version"
 	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
 )
 
 var (
@@ -92,3 +97,627 @@ func TestGetTcmallocMaxTotalThreadCacheBytes(t *testing.T) {
 	v = getTcmallocMaxTotalThreadCacheBytes("")
 	assert.Equal(t, "134217728", v.Value)
 }
+
+func TestConfigOverridesEnvVar(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	osdProps := osdProperties{crushHostname: "node1"}
+
+	// no env var without overrides
+	for _, env := range c.getConfigEnvVars(osdProps, "/var/lib/rook") {
+		assert.NotEqual(t, osdConfigOverridesEnvVarName, env.Name)
+	}
+
+	osdProps.storeConfig = osdconfig.ToStoreConfig(map[string]string{
+		"databaseSizeMB":                      "1024",
+		"osdConfig.bluestore_cache_size":      "3221225472",
+		"osdConfig.osd_op_num_shards":         "4",
+		"osdConfig.debug_osd":                 "5/5",
+		"osdConfig.bluestore_rocksdb_options": "compression=kNoCompression",
+	})
+	assert.Equal(t, 1024, osdProps.storeConfig.DatabaseSizeMB)
+	envs := c.getConfigEnvVars(osdProps, "/var/lib/rook")
+	verifyEnvVar(t, envs, osdConfigOverridesEnvVarName, `{"bluestore_cache_size":"3221225472","bluestore_rocksdb_options":"compression=kNoCompression","debug_osd":"5/5","osd_op_num_shards":"4"}`, true)
+
+	for _, env := range envs {
+		if env.Name == osdConfigOverridesEnvVarName {
+			overrides, err := osdconfig.ParseConfigOverrides(env.Value)
+			assert.NoError(t, err)
+			assert.Equal(t, osdProps.storeConfig.ConfigOverrides, overrides)
+		}
+	}
+
+	// values with commas, spaces and quotes round-trip
+	overrides := map[string]string{
+		"bluestore_rocksdb_options": "compression=kNoCompression,max_write_buffer_number=4",
+		"osd_crush_location":        `root=default host="my host"`,
+	}
+	parsed, err := osdconfig.ParseConfigOverrides(osdconfig.FormatConfigOverrides(overrides))
+	assert.NoError(t, err)
+	assert.Equal(t, overrides, parsed)
+
+	// the comma separated format of the older prepare jobs is still supported
+	parsed, err = osdconfig.ParseConfigOverrides("bluestore_cache_size=3221225472,debug_osd=5/5")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"bluestore_cache_size": "3221225472", "debug_osd": "5/5"}, parsed)
+
+	_, err = osdconfig.ParseConfigOverrides("bluestore_cache_size")
+	assert.Error(t, err)
+	_, err = osdconfig.ParseConfigOverrides(`{"bluestore_cache_size":`)
+	assert.Error(t, err)
+	parsed, err = osdconfig.ParseConfigOverrides("")
+	assert.NoError(t, err)
+	assert.Empty(t, parsed)
+}
+
+func TestBlueStoreRocksDBOptions(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	osdProps := osdProperties{crushHostname: "node1"}
+	dataPathMap := testProvisionConfig(c)
+
+	// not set by default
+	verifyEnvVar(t, c.getConfigEnvVars(osdProps, "/var/lib/rook"), osdBlueStoreRocksDBOptionsEnvVarName, "", false)
+
+	options := "compression=kLZ4Compression;max_write_buffer_number=4;write_buffer_size=268435456"
+	osdProps.storeConfig = osdconfig.ToStoreConfig(map[string]string{"bluestoreRocksDBOptions": options})
+	verifyEnvVar(t, c.getConfigEnvVars(osdProps, "/var/lib/rook"), osdBlueStoreRocksDBOptionsEnvVarName, options, true)
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, osdBlueStoreRocksDBOptionsEnvVarName, options, true)
+
+	// the prepare job is not generated with malformed options
+	osdProps.storeConfig.BlueStoreRocksDBOptions = "compression;max_write_buffer_number=4"
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.Error(t, err)
+
+	for _, valid := range []string{
+		"compression=kNoCompression",
+		"compression=kNoCompression;max_write_buffer_number=4;",
+		"compression=kNoCompression,max_write_buffer_number=4,min_write_buffer_number_to_merge=1",
+		"compression=kNoCompression; max_write_buffer_number=4",
+	} {
+		assert.NoError(t, osdconfig.ValidateRocksDBOptions(valid), valid)
+	}
+	for _, invalid := range []string{
+		"",
+		";;",
+		"compression",
+		"=kNoCompression",
+		"compression=",
+		"max write buffer number=4",
+		"compression=kNoCompression;max_write_buffer_number",
+	} {
+		assert.Error(t, osdconfig.ValidateRocksDBOptions(invalid), invalid)
+	}
+}
+
+func TestPGAutoscaleModeEnvVar(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	osdProps := osdProperties{crushHostname: "node1"}
+	dataPathMap := testProvisionConfig(c)
+
+	// not set by default
+	verifyEnvVar(t, c.getConfigEnvVars(osdProps, "/var/lib/rook"), osdPGAutoscaleModeEnvVarName, "", false)
+
+	osdProps.storeConfig = osdconfig.ToStoreConfig(map[string]string{"pgAutoscaleMode": "warn"})
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	env := job.Spec.Template.Spec.Containers[0].Env
+	verifyEnvVar(t, env, osdPGAutoscaleModeEnvVarName, "warn", true)
+	storeConfig, err := storeConfigFromEnvVars(env)
+	assert.NoError(t, err)
+	assert.Equal(t, "warn", storeConfig.PGAutoscaleMode)
+
+	// the prepare job is not generated with an unknown mode
+	osdProps.storeConfig.PGAutoscaleMode = "auto"
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.Error(t, err)
+
+	for _, mode := range []string{"on", "off", "warn"} {
+		assert.NoError(t, osdconfig.ValidatePGAutoscaleMode(mode), mode)
+	}
+	for _, mode := range []string{"", "On", "true"} {
+		assert.Error(t, osdconfig.ValidatePGAutoscaleMode(mode), mode)
+	}
+}
+
+func TestExtraEnvVars(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "some-uuid", BlockPath: "/dev/vdb", CVMode: "lvm"}
+	osdProps := osdProperties{crushHostname: "node1"}
+
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	defaultEnv := deployment.Spec.Template.Spec.Containers[0].Env
+	verifyEnvVar(t, defaultEnv, "HTTPS_PROXY", "", false)
+
+	kmsToken := v1.EnvVar{
+		Name: "KMS_TOKEN",
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "kms"}, Key: "token"},
+		},
+	}
+	c.spec.Storage.ExtraEnv = []v1.EnvVar{{Name: "HTTPS_PROXY", Value: "http://proxy:3128"}, kmsToken}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	env := deployment.Spec.Template.Spec.Containers[0].Env
+	// the env set by rook is kept as is and the extra env is added after it
+	assert.Equal(t, len(defaultEnv)+2, len(env))
+	assert.Equal(t, defaultEnv, env[:len(defaultEnv)])
+	verifyEnvVar(t, env, "HTTPS_PROXY", "http://proxy:3128", true)
+	assert.Contains(t, env, kmsToken)
+	// only the daemon container gets the extra env
+	for _, container := range deployment.Spec.Template.Spec.InitContainers {
+		verifyEnvVar(t, container.Env, "HTTPS_PROXY", "", false)
+	}
+
+	// the env set by rook cannot be overridden
+	c.spec.Storage.ExtraEnv = []v1.EnvVar{{Name: "CEPH_VOLUME_DEBUG", Value: "0"}}
+	_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.Error(t, err)
+
+	// names starting with ROOK_ are reserved even if rook does not set them on this OSD
+	c.spec.Storage.ExtraEnv = []v1.EnvVar{{Name: "ROOK_SOMETHING_NEW", Value: "true"}}
+	_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.Error(t, err)
+
+	// duplicate extra env
+	c.spec.Storage.ExtraEnv = []v1.EnvVar{{Name: "HTTPS_PROXY", Value: "a"}, {Name: "HTTPS_PROXY", Value: "b"}}
+	_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.Error(t, err)
+}
+
+func TestNormalizeMetadataDevice(t *testing.T) {
+	for input, expected := range map[string]string{
+		"sdb":                          "/dev/sdb",
+		"/dev/sdb":                     "/dev/sdb",
+		" sdb ":                        "/dev/sdb",
+		"nvme0n1":                      "/dev/nvme0n1",
+		"/dev/nvme0n1p2":               "/dev/nvme0n1p2",
+		"disk/by-id/nvme-Samsung_SSD":  "/dev/disk/by-id/nvme-Samsung_SSD",
+		"/dev/disk/by-path/pci-0:0:1":  "/dev/disk/by-path/pci-0:0:1",
+		"/dev//sdb":                    "/dev/sdb",
+		"/dev/mapper/vg-lv":            "/dev/mapper/vg-lv",
+		"/dev/disk/../sdb":             "/dev/sdb",
+		"mapper/ceph--block--dbs-lv1/": "/dev/mapper/ceph--block--dbs-lv1",
+	} {
+		device, err := normalizeMetadataDevice(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, device, input)
+	}
+
+	for _, invalid := range []string{
+		"",
+		"  ",
+		"sd b",
+		"/sdb",
+		"/dev",
+		"/dev/",
+		"/mnt/sdb",
+		"/dev/../sdb",
+		"../sdb",
+	} {
+		_, err := normalizeMetadataDevice(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCanonicalDeviceID(t *testing.T) {
+	for input, expected := range map[string]string{
+		"sdb":                         "sdb",
+		" sdb ":                       "sdb",
+		"/dev/sdb":                    "sdb",
+		"/dev//sdb":                   "sdb",
+		"/dev/disk/../sdb":            "sdb",
+		"nvme0n1p2":                   "nvme0n1p2",
+		"/dev/nvme0n1p2":              "nvme0n1p2",
+		"cciss/c0d0":                  "cciss/c0d0",
+		"/dev/disk/by-id/wwn-0x5000":  "/dev/disk/by-id/wwn-0x5000",
+		"/dev/disk/by-path//pci-0:1/": "/dev/disk/by-path/pci-0:1",
+		"/dev/mapper/vg-lv":           "/dev/mapper/vg-lv",
+	} {
+		id, err := canonicalDeviceID(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, id, input)
+	}
+
+	for _, invalid := range []string{"", "  ", "sd b", ".", "../sdb", "/sdb", "/dev", "/dev/", "/mnt/sdb", "/dev/../sdb"} {
+		_, err := canonicalDeviceID(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestDeviceSelectionCanonicalDevices(t *testing.T) {
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		devices: []cephv1.Device{
+			{Name: "/dev/sdb"},
+			{Name: "sdc"},
+			{Name: "sdb", Config: map[string]string{"deviceClass": "ssd"}},
+			{Name: "sdd", FullPath: "/dev/sdc"},
+			{FullPath: "/dev/disk/by-id/wwn-0x5000"},
+			{FullPath: "/dev/disk/by-id//wwn-0x5000"},
+		},
+	}
+	selection, err := getDeviceSelection(osdProps)
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, device := range selection.Devices {
+		ids = append(ids, device.ID)
+	}
+	// the devices listed more than once are only prepared once with their first configuration
+	assert.Equal(t, []string{"sdb", "sdc", "/dev/disk/by-id/wwn-0x5000"}, ids)
+	assert.Empty(t, selection.Devices[0].StoreConfig.DeviceClass)
+
+	envVars, err := selection.ToEnvVars()
+	assert.NoError(t, err)
+	assert.Contains(t, envVars[0].Value, `"id":"sdb"`)
+
+	osdProps.devices = []cephv1.Device{{Name: "/mnt/sdb"}}
+	_, err = getDeviceSelection(osdProps)
+	assert.Error(t, err)
+}
+
+func TestMetadataDeviceEnvVar(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{
+		crushHostname:  "node1",
+		metadataDevice: "nvme0n1",
+		devices: []cephv1.Device{
+			{Name: "sda", Config: map[string]string{"metadataDevice": "/dev/nvme1n1"}},
+			{Name: "sdb", Config: map[string]string{"metadataDevice": "nvme1n1"}},
+			{Name: "sdc"},
+		},
+	}
+
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	env := job.Spec.Template.Spec.Containers[0].Env
+	verifyEnvVar(t, env, "ROOK_METADATA_DEVICE", "/dev/nvme0n1", true)
+
+	// the devices sharing a metadata device refer to it the same way
+	var devices []osdconfig.ConfiguredDevice
+	for _, envVar := range env {
+		if envVar.Name == "ROOK_DATA_DEVICES" {
+			assert.NoError(t, json.Unmarshal([]byte(envVar.Value), &devices))
+		}
+	}
+	assert.Equal(t, 3, len(devices))
+	assert.Equal(t, "/dev/nvme1n1", devices[0].StoreConfig.MetadataDevice)
+	assert.Equal(t, "/dev/nvme1n1", devices[1].StoreConfig.MetadataDevice)
+	assert.Equal(t, "", devices[2].StoreConfig.MetadataDevice)
+
+	// the prepare job is not generated with an invalid metadata device
+	osdProps.metadataDevice = "/mnt/nvme0n1"
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.Error(t, err)
+
+	osdProps.metadataDevice = ""
+	osdProps.devices[2].Config = map[string]string{"metadataDevice": "nvme 1"}
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.Error(t, err)
+}
+
+func TestDeviceSelectorEnvVar(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	rotational := false
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		selection: cephv1.Selection{
+			DeviceFilter: "^sd.",
+			DeviceSelector: &cephv1.DeviceSelector{
+				MinSize:    "100Gi",
+				MaxSize:    "2Ti",
+				Rotational: &rotational,
+				Vendor:     "ATA",
+				Model:      "Samsung SSD 860",
+			},
+		},
+	}
+
+	getSelector := func(env []v1.EnvVar) *osdconfig.DeviceSelector {
+		for _, envVar := range env {
+			if envVar.Name == "ROOK_DATA_DEVICE_SELECTOR" {
+				selector, err := osdconfig.ParseDeviceSelector(envVar.Value)
+				assert.NoError(t, err)
+				return selector
+			}
+		}
+		return nil
+	}
+
+	t.Run("combined criteria", func(t *testing.T) {
+		job, err := c.makeJob(osdProps, dataPathMap)
+		assert.NoError(t, err)
+		env := job.Spec.Template.Spec.Containers[0].Env
+		verifyEnvVar(t, env, "ROOK_DATA_DEVICE_FILTER", "^sd.", true)
+		selector := getSelector(env)
+		assert.Equal(t, &osdconfig.DeviceSelector{
+			MinSizeBytes: 100 * 1024 * 1024 * 1024,
+			MaxSizeBytes: 2 * 1024 * 1024 * 1024 * 1024,
+			Rotational:   &rotational,
+			Vendor:       "ATA",
+			Model:        "Samsung SSD 860",
+		}, selector)
+
+		// all the criteria must match
+		matched, _ := selector.Matches(500*1024*1024*1024, false, "ata ", "SAMSUNG SSD 860")
+		assert.True(t, matched)
+		matched, reason := selector.Matches(50*1024*1024*1024, false, "ATA", "Samsung SSD 860")
+		assert.False(t, matched)
+		assert.Contains(t, reason, "minimum size")
+		matched, reason = selector.Matches(500*1024*1024*1024, true, "ATA", "Samsung SSD 860")
+		assert.False(t, matched)
+		assert.Contains(t, reason, "rotational")
+		matched, reason = selector.Matches(500*1024*1024*1024, false, "ATA", "Samsung SSD 870")
+		assert.False(t, matched)
+		assert.Contains(t, reason, "model")
+	})
+
+	t.Run("single criterion with all the devices", func(t *testing.T) {
+		useAllDevices := true
+		props := osdProps
+		props.selection = cephv1.Selection{UseAllDevices: &useAllDevices, DeviceSelector: &cephv1.DeviceSelector{MinSize: "1T"}}
+		job, err := c.makeJob(props, dataPathMap)
+		assert.NoError(t, err)
+		env := job.Spec.Template.Spec.Containers[0].Env
+		verifyEnvVar(t, env, "ROOK_DATA_DEVICE_FILTER", "all", true)
+		verifyEnvVar(t, env, "ROOK_DATA_DEVICE_SELECTOR", `{"minSizeBytes":1000000000000}`, true)
+	})
+
+	t.Run("ignored with a list of devices", func(t *testing.T) {
+		props := osdProps
+		props.devices = []cephv1.Device{{Name: "sda"}}
+		job, err := c.makeJob(props, dataPathMap)
+		assert.NoError(t, err)
+		assert.Nil(t, getSelector(job.Spec.Template.Spec.Containers[0].Env))
+	})
+
+	t.Run("invalid sizes", func(t *testing.T) {
+		for _, selector := range []cephv1.DeviceSelector{
+			{MinSize: "lots"},
+			{MaxSize: "-1Gi"},
+			{MinSize: "2Ti", MaxSize: "1Ti"},
+		} {
+			selector := selector
+			props := osdProps
+			props.selection = cephv1.Selection{DeviceFilter: "^sd.", DeviceSelector: &selector}
+			_, err := c.makeJob(props, dataPathMap)
+			assert.Error(t, err, selector)
+		}
+	})
+
+	// a nil selector selects all the devices
+	var selector *osdconfig.DeviceSelector
+	matched, _ := selector.Matches(0, true, "", "")
+	assert.True(t, matched)
+}
+
+func TestDeviceSelectionToEnvVars(t *testing.T) {
+	selector := &cephv1.DeviceSelector{MinSize: "1T"}
+	devices := []osdconfig.ConfiguredDevice{{ID: "sda"}, {ID: "/dev/disk/by-id/disk-b", StoreConfig: osdconfig.StoreConfig{DeviceClass: "ssd"}}}
+	envNames := func(envVars []v1.EnvVar) []string {
+		names := []string{}
+		for _, envVar := range envVars {
+			names = append(names, envVar.Name)
+		}
+		return names
+	}
+
+	t.Run("devices", func(t *testing.T) {
+		// the list of devices takes precedence over the filters and the selector
+		envVars, err := DeviceSelection{Devices: devices, DeviceFilter: "^sd.", UseAllDevices: true, DeviceSelector: selector}.ToEnvVars()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"ROOK_DATA_DEVICES"}, envNames(envVars))
+		var decoded []osdconfig.ConfiguredDevice
+		assert.NoError(t, json.Unmarshal([]byte(envVars[0].Value), &decoded))
+		assert.Equal(t, devices, decoded)
+	})
+
+	t.Run("device filter", func(t *testing.T) {
+		envVars, err := DeviceSelection{DeviceFilter: "^sd.", DevicePathFilter: "^/dev/disk/by-id/.*", UseAllDevices: true}.ToEnvVars()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"ROOK_DATA_DEVICE_FILTER"}, envNames(envVars))
+		verifyEnvVar(t, envVars, "ROOK_DATA_DEVICE_FILTER", "^sd.", true)
+	})
+
+	t.Run("device path filter", func(t *testing.T) {
+		envVars, err := DeviceSelection{DevicePathFilter: "^/dev/disk/by-id/.*", UseAllDevices: true, DeviceSelector: selector}.ToEnvVars()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"ROOK_DATA_DEVICE_PATH_FILTER", "ROOK_DATA_DEVICE_SELECTOR"}, envNames(envVars))
+		verifyEnvVar(t, envVars, "ROOK_DATA_DEVICE_PATH_FILTER", "^/dev/disk/by-id/.*", true)
+	})
+
+	t.Run("all devices", func(t *testing.T) {
+		envVars, err := DeviceSelection{UseAllDevices: true, DeviceSelector: selector}.ToEnvVars()
+		assert.NoError(t, err)
+		verifyEnvVar(t, envVars, "ROOK_DATA_DEVICE_FILTER", "all", true)
+		verifyEnvVar(t, envVars, "ROOK_DATA_DEVICE_SELECTOR", `{"minSizeBytes":1000000000000}`, true)
+	})
+
+	t.Run("no devices", func(t *testing.T) {
+		// the selector alone does not select any device
+		envVars, err := DeviceSelection{DeviceSelector: selector}.ToEnvVars()
+		assert.NoError(t, err)
+		assert.Empty(t, envVars)
+	})
+
+	t.Run("invalid selector", func(t *testing.T) {
+		_, err := DeviceSelection{UseAllDevices: true, DeviceSelector: &cephv1.DeviceSelector{MinSize: "-1"}}.ToEnvVars()
+		assert.Error(t, err)
+	})
+}
+
+func TestPerDeviceDatabaseSize(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		storeConfig:   osdconfig.StoreConfig{DatabaseSizeMB: 1024},
+		devices: []cephv1.Device{
+			{Name: "sda", Config: map[string]string{"metadataDevice": "nvme0n1", "databaseSizeMB": "4096"}},
+			// the journal size of filestore is ignored
+			{Name: "sdb", Config: map[string]string{"metadataDevice": "nvme0n1", "journalSizeMB": "2048"}},
+		},
+	}
+
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	env := job.Spec.Template.Spec.Containers[0].Env
+	// the size of the node is the default of the devices
+	verifyEnvVar(t, env, "ROOK_OSD_DATABASE_SIZE", "1024", true)
+	var devices []osdconfig.ConfiguredDevice
+	for _, envVar := range env {
+		if envVar.Name == "ROOK_DATA_DEVICES" {
+			assert.NoError(t, json.Unmarshal([]byte(envVar.Value), &devices))
+		}
+	}
+	assert.Equal(t, 2, len(devices))
+	assert.Equal(t, 4096, devices[0].StoreConfig.DatabaseSizeMB)
+	assert.Equal(t, 0, devices[1].StoreConfig.DatabaseSizeMB)
+}
+
+func TestZonedDevices(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	c.clusterInfo.CephVersion = cephver.CephVersion{Major: 16, Minor: 2, Extra: 0}
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		devices: []cephv1.Device{
+			{Name: "sda", Config: map[string]string{"zoned": "true"}},
+			{Name: "sdb"},
+		},
+	}
+
+	// the zoned devices are marked in the device list
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	env := job.Spec.Template.Spec.Containers[0].Env
+	verifyEnvVar(t, env, "ROOK_OSD_ZONED", "", false)
+	var devices []osdconfig.ConfiguredDevice
+	for _, envVar := range env {
+		if envVar.Name == "ROOK_DATA_DEVICES" {
+			assert.NoError(t, json.Unmarshal([]byte(envVar.Value), &devices))
+		}
+	}
+	assert.Equal(t, 2, len(devices))
+	assert.True(t, devices[0].StoreConfig.Zoned)
+	assert.False(t, devices[1].StoreConfig.Zoned)
+
+	// all the devices of the node are zoned
+	osdProps.devices = nil
+	osdProps.selection = cephv1.Selection{DeviceFilter: "^sd."}
+	osdProps.storeConfig = osdconfig.ToStoreConfig(map[string]string{"zoned": "true"})
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	env = job.Spec.Template.Spec.Containers[0].Env
+	verifyEnvVar(t, env, "ROOK_OSD_ZONED", "true", true)
+	storeConfig, err := storeConfigFromEnvVars(env)
+	assert.NoError(t, err)
+	assert.True(t, storeConfig.Zoned)
+
+	// zoned devices require pacific
+	c.clusterInfo.CephVersion = cephver.Octopus
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.Error(t, err)
+	osdProps.storeConfig = osdconfig.NewStoreConfig()
+	osdProps.devices = []cephv1.Device{{Name: "sda", Config: map[string]string{"zoned": "true"}}}
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.Error(t, err)
+	osdProps.devices[0].Config = nil
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+}
+
+func TestRawMode(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	c.clusterInfo.CephVersion = cephver.CephVersion{Major: 15, Minor: 2, Extra: 9}
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		devices:       []cephv1.Device{{Name: "sda"}},
+		storeConfig:   osdconfig.ToStoreConfig(map[string]string{"rawMode": "true"}),
+	}
+	hasVolume := func(volumes []v1.Volume, name string) bool {
+		for _, volume := range volumes {
+			if volume.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	// the prepare job does not check the lvm package of the host
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	env := job.Spec.Template.Spec.Containers[0].Env
+	verifyEnvVar(t, env, "ROOK_OSD_RAW_MODE", "true", true)
+	storeConfig, err := storeConfigFromEnvVars(env)
+	assert.NoError(t, err)
+	assert.True(t, storeConfig.RawMode)
+	assert.False(t, hasVolume(job.Spec.Template.Spec.Volumes, "rootfs"))
+	// the devices are still discovered with udev
+	assert.True(t, hasVolume(job.Spec.Template.Spec.Volumes, "udev"))
+
+	// the raw osds do not mount the udev of the host
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sda", CVMode: "raw"}
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.False(t, hasVolume(deployment.Spec.Template.Spec.Volumes, udevVolName))
+	for _, mount := range deployment.Spec.Template.Spec.Containers[0].VolumeMounts {
+		assert.NotEqual(t, udevVolName, mount.Name)
+	}
+	// the osds prepared with lvm before the raw mode was required keep it
+	osd.CVMode = "lvm"
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.True(t, hasVolume(deployment.Spec.Template.Spec.Volumes, udevVolName))
+
+	// the raw mode is not required by default
+	osdProps.storeConfig = osdconfig.NewStoreConfig()
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, "ROOK_OSD_RAW_MODE", "", false)
+	assert.True(t, hasVolume(job.Spec.Template.Spec.Volumes, "rootfs"))
+	osd.CVMode = "raw"
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.True(t, hasVolume(deployment.Spec.Template.Spec.Volumes, udevVolName))
+
+	// the raw mode on nodes requires the lock retries of ceph-volume
+	osdProps.storeConfig.RawMode = true
+	c.clusterInfo.CephVersion = cephver.CephVersion{Major: 15, Minor: 2, Extra: 8}
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.Error(t, err)
+	c.clusterInfo.CephVersion = cephver.CephVersion{Major: 14, Minor: 2, Extra: 14}
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+}
+
+func TestDeviceClassHint(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sda"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sda", CVMode: "raw"}
+
+	// omitted by default
+	verifyEnvVar(t, c.getConfigEnvVars(osdProps, "/var/lib/rook"), "ROOK_OSD_CRUSH_DEVICE_CLASS_HINT", "", false)
+
+	// the prepare job records the hint on the osds it provisions
+	osdProps.storeConfig = osdconfig.ToStoreConfig(map[string]string{"deviceClass": "ssd", "deviceClassHint": "nvme-gen4"})
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	env := job.Spec.Template.Spec.Containers[0].Env
+	verifyEnvVar(t, env, "ROOK_OSD_CRUSH_DEVICE_CLASS_HINT", "nvme-gen4", true)
+	// the device class is not changed
+	verifyEnvVar(t, env, "ROOK_OSD_CRUSH_DEVICE_CLASS", "ssd", true)
+
+	// the hint is read back from the osd deployment
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	storeConfig, err := storeConfigFromEnvVars(deployment.Spec.Template.Spec.Containers[0].Env)
+	assert.NoError(t, err)
+	assert.Equal(t, "nvme-gen4", storeConfig.DeviceClassHint)
+}
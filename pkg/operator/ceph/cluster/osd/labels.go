@@ -21,7 +21,9 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/rook/rook/pkg/operator/ceph/controller"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 const (
@@ -33,15 +35,52 @@ const (
 	CephDeviceSetPVCIDLabelKey = "ceph.rook.io/DeviceSetPVCId"
 	// OSDOverPVCLabelKey is the Rook PVC label key
 	OSDOverPVCLabelKey = "ceph.rook.io/pvc"
+	// DeviceClassLabelKey is the label key whose value is the crush device class of the OSD
+	DeviceClassLabelKey = "device-class"
+	// DeviceClassHintLabelKey is the label key whose value is the additional device class of the OSD
+	DeviceClassHintLabelKey = "device-class-hint"
 	// TopologyLocationLabel is the crush location label added to OSD deployments
 	TopologyLocationLabel = "topology-location-%s"
+	// defaultPVCLabelPrefix is the prefix of the keys of the device set PVC labels if none is configured
+	defaultPVCLabelPrefix = "ceph.rook.io"
 )
 
-func makeStorageClassDeviceSetPVCLabel(storageClassDeviceSetName, pvcStorageClassDeviceSetPVCId string, setIndex int) map[string]string {
+// pvcLabelKeys are the keys of the labels identifying the PVCs of the device sets
+type pvcLabelKeys struct {
+	deviceSet string
+	setIndex  string
+	pvcID     string
+}
+
+// newPVCLabelKeys returns the keys of the device set PVC labels with the given prefix, the keys are
+// CephDeviceSetLabelKey, CephSetIndexLabelKey and CephDeviceSetPVCIDLabelKey with the default prefix
+func newPVCLabelKeys(prefix string) pvcLabelKeys {
+	if prefix == "" {
+		prefix = defaultPVCLabelPrefix
+	}
+	return pvcLabelKeys{
+		deviceSet: prefix + "/DeviceSet",
+		setIndex:  prefix + "/setIndex",
+		pvcID:     prefix + "/DeviceSetPVCId",
+	}
+}
+
+// validatePVCLabelPrefix checks that the prefix is a valid prefix of label keys
+func validatePVCLabelPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if errs := validation.IsDNS1123Subdomain(prefix); len(errs) > 0 {
+		return errors.Errorf("invalid pvc label prefix %q. %s", prefix, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func makeStorageClassDeviceSetPVCLabel(labelKeys pvcLabelKeys, storageClassDeviceSetName, pvcStorageClassDeviceSetPVCId string, setIndex int) map[string]string {
 	return map[string]string{
-		CephDeviceSetLabelKey:      storageClassDeviceSetName,
-		CephSetIndexLabelKey:       fmt.Sprintf("%d", setIndex),
-		CephDeviceSetPVCIDLabelKey: pvcStorageClassDeviceSetPVCId,
+		labelKeys.deviceSet: storageClassDeviceSetName,
+		labelKeys.setIndex:  fmt.Sprintf("%d", setIndex),
+		labelKeys.pvcID:     pvcStorageClassDeviceSetPVCId,
 	}
 }
 
@@ -57,6 +96,29 @@ func (c *Cluster) getOSDLabels(osd OSDInfo, failureDomainValue string, portable
 	return labels
 }
 
+// addDeviceClassLabel sets the device class label of the OSD. The label is not set if the device class
+// is unknown or is not a valid label value.
+func addDeviceClassLabel(labels map[string]string, deviceClass string) {
+	addClassLabel(labels, DeviceClassLabelKey, deviceClass)
+}
+
+// addDeviceClassHintLabel sets the device class hint label of the OSD, with the same rules as the
+// device class label
+func addDeviceClassHintLabel(labels map[string]string, deviceClassHint string) {
+	addClassLabel(labels, DeviceClassHintLabelKey, deviceClassHint)
+}
+
+func addClassLabel(labels map[string]string, key, class string) {
+	if class == "" {
+		return
+	}
+	if errs := validation.IsValidLabelValue(class); len(errs) > 0 {
+		logger.Warningf("not labeling the osd with %s %q. %s", key, class, strings.Join(errs, ", "))
+		return
+	}
+	labels[key] = class
+}
+
 func getOSDTopologyLocationLabels(topologyLocation string) map[string]string {
 	labels := map[string]string{}
 	locations := strings.Split(topologyLocation, " ")
@@ -19,6 +19,8 @@ package osd
 import (
 	"testing"
 
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/rook/rook/pkg/operator/ceph/cluster/osd/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -29,3 +31,89 @@ func TestOSDTopologyLabels(t *testing.T) {
 	assert.Equal(t, "ocs-deviceset-gp2-1-data-0-wh5wl", result["topology-location-host"])
 	assert.Equal(t, "us-east-1c", result["topology-location-zone"])
 }
+
+func TestPVCLabelKeys(t *testing.T) {
+	// the default keys are the ceph.rook.io keys
+	keys := newPVCLabelKeys("")
+	assert.Equal(t, map[string]string{
+		CephDeviceSetLabelKey:      "set1",
+		CephSetIndexLabelKey:       "2",
+		CephDeviceSetPVCIDLabelKey: "set1-data-2",
+	}, makeStorageClassDeviceSetPVCLabel(keys, "set1", "set1-data-2", 2))
+
+	keys = newPVCLabelKeys("storage.example.com")
+	assert.Equal(t, map[string]string{
+		"storage.example.com/DeviceSet":      "set1",
+		"storage.example.com/setIndex":       "2",
+		"storage.example.com/DeviceSetPVCId": "set1-data-2",
+	}, makeStorageClassDeviceSetPVCLabel(keys, "set1", "set1-data-2", 2))
+
+	assert.NoError(t, validatePVCLabelPrefix(""))
+	assert.NoError(t, validatePVCLabelPrefix("storage.example.com"))
+	assert.Error(t, validatePVCLabelPrefix("Storage_Example"))
+	assert.Error(t, validatePVCLabelPrefix("example.com/osd"))
+}
+
+func TestOSDDeviceClassLabel(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+	deviceClassLabel := func() (string, bool) {
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		assert.Equal(t, deployment.Labels[DeviceClassLabelKey], deployment.Spec.Template.Labels[DeviceClassLabelKey])
+		value, ok := deployment.Spec.Template.Labels[DeviceClassLabelKey]
+		return value, ok
+	}
+
+	// absent when the device class is unknown
+	_, ok := deviceClassLabel()
+	assert.False(t, ok)
+
+	// the configured device class
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{"deviceClass": "fast"})
+	value, ok := deviceClassLabel()
+	assert.True(t, ok)
+	assert.Equal(t, "fast", value)
+
+	// the device class of the osd wins
+	osd.DeviceClass = "ssd"
+	value, _ = deviceClassLabel()
+	assert.Equal(t, "ssd", value)
+
+	// not a valid label value
+	osd.DeviceClass = "fast disks"
+	_, ok = deviceClassLabel()
+	assert.False(t, ok)
+}
+
+func TestOSDDeviceClassHintLabel(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw", DeviceClass: "ssd"}
+
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.NotContains(t, deployment.Spec.Template.Labels, DeviceClassHintLabelKey)
+
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{"deviceClassHint": "nvme-gen4"})
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "nvme-gen4", deployment.Spec.Template.Labels[DeviceClassHintLabelKey])
+	assert.Equal(t, "ssd", deployment.Spec.Template.Labels[DeviceClassLabelKey])
+
+	// the hint recorded by the prepare job takes precedence over the configured one
+	osd.DeviceClassHint = "nvme-gen5"
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "nvme-gen5", deployment.Spec.Template.Labels[DeviceClassHintLabelKey])
+	osd.DeviceClassHint = ""
+
+	// not a valid label value
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{"deviceClassHint": "nvme gen4"})
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.NotContains(t, deployment.Spec.Template.Labels, DeviceClassHintLabelKey)
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricsNamespace = "rook"
+	metricsSubsystem = "ceph_osd"
+)
+
+var (
+	// metricsClock measures the durations reported by the metrics, replaced by a fake clock in the tests
+	metricsClock clock.PassiveClock = clock.RealClock{}
+
+	// prepareJobDuration is the time from the launch of an OSD prepare job by the operator until the
+	// job reports that the provisioning completed or failed
+	prepareJobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "prepare_job_duration_seconds",
+		Help:      "Time from the launch of an OSD prepare job until it reports the provisioning as completed or failed.",
+		Buckets:   []float64{15, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"namespace", "result"})
+
+	// deviceSetsPrepareDuration is the time taken to create and verify the PVCs of all the storage
+	// class device sets of a cluster in a reconcile
+	deviceSetsPrepareDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "device_sets_prepare_duration_seconds",
+		Help:      "Time taken to create and verify the PVCs of the storage class device sets in a reconcile.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace"})
+
+	// deviceSetPVCsCreated is the number of PVCs created for the storage class device sets of a
+	// cluster in a reconcile, the sum is the total number of PVCs created
+	deviceSetPVCsCreated = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "device_set_pvcs_created",
+		Help:      "Number of PVCs created for the storage class device sets in a reconcile.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	}, []string{"namespace"})
+)
+
+func init() {
+	// the metrics are served by the controller-runtime manager of the operator
+	metrics.Registry.MustRegister(prepareJobDuration, deviceSetsPrepareDuration, deviceSetPVCsCreated)
+}
+
+// recordPrepareJobStart records the launch of the OSD prepare job of the node or PVC
+func (c *Cluster) recordPrepareJobStart(nodeOrPVCName string) {
+	if c.prepareJobStarts == nil {
+		c.prepareJobStarts = map[string]time.Time{}
+	}
+	c.prepareJobStarts[nodeOrPVCName] = metricsClock.Now()
+}
+
+// observePrepareJobDuration reports the duration of the OSD prepare job of the node or PVC once it
+// completed or failed. Nothing is reported for the jobs not launched by this orchestration.
+func (c *Cluster) observePrepareJobDuration(nodeOrPVCName, result string) {
+	start, ok := c.prepareJobStarts[nodeOrPVCName]
+	if !ok {
+		return
+	}
+	delete(c.prepareJobStarts, nodeOrPVCName)
+	prepareJobDuration.WithLabelValues(c.clusterInfo.Namespace, result).Observe(metricsClock.Since(start).Seconds())
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/rook/rook/pkg/clusterd"
+	"github.com/rook/rook/pkg/daemon/ceph/client"
+	testexec "github.com/rook/rook/pkg/operator/test"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/clock"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// histogramSamples returns the number of observations and their sum for the given labels
+func histogramSamples(t *testing.T, histogram *prometheus.HistogramVec, labels ...string) (uint64, float64) {
+	metric := &dto.Metric{}
+	err := histogram.WithLabelValues(labels...).(prometheus.Metric).Write(metric)
+	assert.NoError(t, err)
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestProvisioningMetricsRegistered(t *testing.T) {
+	for _, collector := range []prometheus.Collector{prepareJobDuration, deviceSetsPrepareDuration, deviceSetPVCsCreated} {
+		err := metrics.Registry.Register(collector)
+		assert.IsType(t, prometheus.AlreadyRegisteredError{}, err)
+	}
+}
+
+func TestPrepareJobDurationMetric(t *testing.T) {
+	oldClock := metricsClock
+	defer func() { metricsClock = oldClock }()
+	fakeClock := clock.NewFakeClock(time.Now())
+	metricsClock = fakeClock
+
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	countBefore, sumBefore := histogramSamples(t, prepareJobDuration, "ns", OrchestrationStatusFailed)
+	reportStatus := func(nodeName string) {
+		cmName := c.updateOSDStatus(nodeName, OrchestrationStatus{Status: OrchestrationStatusFailed})
+		cm, err := c.context.Clientset.CoreV1().ConfigMaps("ns").Get(context.TODO(), cmName, metav1.GetOptions{})
+		assert.NoError(t, err)
+		c.createOSDsForStatusMap(cm, c.newCreateConfig(testProvisionConfig(c), nil, nil), newProvisionErrors())
+	}
+
+	// the duration is measured from the launch of the job until its status is reported
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	err := c.runPrepareJob(&osdProps, testProvisionConfig(c))
+	assert.NoError(t, err)
+	fakeClock.Step(90 * time.Second)
+	reportStatus("node1")
+	count, sum := histogramSamples(t, prepareJobDuration, "ns", OrchestrationStatusFailed)
+	assert.Equal(t, countBefore+1, count)
+	assert.Equal(t, sumBefore+90, sum)
+
+	// the jobs not launched by this orchestration are not measured
+	reportStatus("node2")
+	count, _ = histogramSamples(t, prepareJobDuration, "ns", OrchestrationStatusFailed)
+	assert.Equal(t, countBefore+1, count)
+}
+
+func TestDeviceSetPVCsCreatedMetric(t *testing.T) {
+	oldClock := metricsClock
+	defer func() { metricsClock = oldClock }()
+	metricsClock = clock.NewFakeClock(time.Now())
+
+	clientset := testexec.New(t, 1)
+	clientset.PrependReactor("create", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pvc := action.(k8stesting.CreateAction).GetObject().(*corev1.PersistentVolumeClaim)
+		pvc.Name = pvc.GenerateName + "-gen"
+		return false, nil, nil
+	})
+	cluster := &Cluster{
+		context:     &clusterd.Context{Clientset: clientset},
+		clusterInfo: client.AdminClusterInfo("metricsns"),
+		spec: cephv1.ClusterSpec{
+			Storage: cephv1.StorageScopeSpec{StorageClassDeviceSets: []cephv1.StorageClassDeviceSet{{
+				Name:                 "set1",
+				Count:                2,
+				VolumeClaimTemplates: []corev1.PersistentVolumeClaim{testVolumeClaim("data")},
+			}}},
+		},
+	}
+
+	// the new pvcs are counted once per reconcile
+	errs := newProvisionErrors()
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	count, sum := histogramSamples(t, deviceSetPVCsCreated, "metricsns")
+	assert.Equal(t, uint64(1), count)
+	assert.Equal(t, float64(2), sum)
+	count, sum = histogramSamples(t, deviceSetsPrepareDuration, "metricsns")
+	assert.Equal(t, uint64(1), count)
+	assert.Equal(t, float64(0), sum)
+
+	// no pvc is created once they exist
+	cluster.prepareStorageClassDeviceSets(errs)
+	assert.Equal(t, 0, errs.len())
+	count, sum = histogramSamples(t, deviceSetPVCsCreated, "metricsns")
+	assert.Equal(t, uint64(2), count)
+	assert.Equal(t, float64(2), sum)
+	count, _ = histogramSamples(t, deviceSetsPrepareDuration, "metricsns")
+	assert.Equal(t, uint64(2), count)
+}
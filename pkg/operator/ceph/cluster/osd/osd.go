@@ -20,6 +20,7 @@ package osd
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strconv"
@@ -42,12 +43,19 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 )
 
 var (
 	logger                                            = capnslog.NewPackageLogger("github.com/rook/rook", "op-osd")
 	cephVolumeRawEncryptionModeMinNautilusCephVersion = cephver.CephVersion{Major: 14, Minor: 2, Extra: 11}
 	cephVolumeRawEncryptionModeMinOctopusCephVersion  = cephver.CephVersion{Major: 15, Minor: 2, Extra: 5}
+	// zonedMinCephVersion is the first version of bluestore supporting zoned devices
+	zonedMinCephVersion = cephver.CephVersion{Major: 16, Minor: 2, Extra: 0}
+	// the first versions of ceph-volume retrying to lock the devices, required by the raw mode on nodes
+	rawModeOnNodesMinNautilusCephVersion = cephver.CephVersion{Major: 14, Minor: 2, Extra: 14}
+	rawModeOnNodesMinOctopusCephVersion  = cephver.CephVersion{Major: 15, Minor: 2, Extra: 9}
 )
 
 const (
@@ -78,6 +86,12 @@ type Cluster struct {
 	ValidStorage cephv1.StorageScopeSpec // valid subset of `Storage`, computed at runtime
 	kv           *k8sutil.ConfigMapKVStore
 	deviceSets   []deviceSet
+	// events about the OSD provisioning are reported on the cephCluster if a recorder is set
+	recorder    record.EventRecorder
+	cephCluster runtime.Object
+	// the launch times of the prepare jobs and the number of PVCs created, reported by the metrics
+	prepareJobStarts map[string]time.Time
+	pvcsCreated      int
 }
 
 // New creates an instance of the OSD manager
@@ -91,6 +105,19 @@ func New(context *clusterd.Context, clusterInfo *cephclient.ClusterInfo, spec ce
 	}
 }
 
+// SetEventRecorder sets the recorder used to report the OSD provisioning events on the CephCluster
+func (c *Cluster) SetEventRecorder(recorder record.EventRecorder, cephCluster runtime.Object) {
+	c.recorder = recorder
+	c.cephCluster = cephCluster
+}
+
+func (c *Cluster) reportEvent(eventType, reason, msg string) {
+	if c.recorder == nil || c.cephCluster == nil {
+		return
+	}
+	c.recorder.Event(c.cephCluster, eventType, reason, msg)
+}
+
 // OSDInfo represent all the properties of a given OSD
 type OSDInfo struct {
 	ID             int    `json:"id"`
@@ -98,6 +125,9 @@ type OSDInfo struct {
 	UUID           string `json:"uuid"`
 	DevicePartUUID string `json:"device-part-uuid"`
 	DeviceClass    string `json:"device-class"`
+	// DeviceClassHint is the additional class recorded by the prepare job, the crush device class is
+	// not changed since an OSD has a single device class in the crush map
+	DeviceClassHint string `json:"device-class-hint,omitempty"`
 	// BlockPath is the logical Volume path for an OSD created by Ceph-volume with format '/dev/<Volume Group>/<Logical Volume>' or simply /dev/vdb if block mode is used
 	BlockPath     string `json:"lv-path"`
 	MetadataPath  string `json:"metadata-path"`
@@ -111,6 +141,25 @@ type OSDInfo struct {
 	TopologyAffinity string `json:"topologyAffinity"`
 }
 
+// MarshalOSDInfo serializes the OSD info to JSON, e.g. to store it as a value of a configmap. All
+// the fields are serialized with the same keys as in the orchestration status of the OSDs.
+func MarshalOSDInfo(osd OSDInfo) (string, error) {
+	data, err := json.Marshal(osd)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to serialize the info of osd %d", osd.ID)
+	}
+	return string(data), nil
+}
+
+// UnmarshalOSDInfo deserializes the OSD info serialized by MarshalOSDInfo
+func UnmarshalOSDInfo(data string) (OSDInfo, error) {
+	var osd OSDInfo
+	if err := json.Unmarshal([]byte(data), &osd); err != nil {
+		return OSDInfo{}, errors.Wrap(err, "failed to deserialize the osd info")
+	}
+	return osd, nil
+}
+
 // OrchestrationStatus represents the status of an OSD orchestration
 type OrchestrationStatus struct {
 	OSDs         []OSDInfo `json:"osds"`
@@ -139,6 +188,12 @@ type osdProperties struct {
 	schedulerName       string
 	encrypted           bool
 	deviceSetName       string
+	// maxNewOSDs is the number of OSDs the prepare job may create on the node when
+	// storage.maxOSDsPerNode is set
+	maxNewOSDs int
+	// nodeMemoryTarget is the memory target of the OSD derived from the allocatable memory of its node
+	// when storage.nodeMemoryTarget is set, 0 if it is not derived
+	nodeMemoryTarget int64
 }
 
 func (osdProps osdProperties) onPVC() bool {
@@ -153,6 +208,20 @@ func (osdProps osdProperties) onPVCWithWal() bool {
 	return osdProps.walPVC.ClaimName != ""
 }
 
+// encryptsDevices returns whether the devices of the OSDs are encrypted, either all of them or only
+// some devices selected in their config
+func (osdProps osdProperties) encryptsDevices() bool {
+	if osdProps.storeConfig.EncryptedDevice || osdProps.encrypted {
+		return true
+	}
+	for _, device := range osdProps.devices {
+		if osdconfig.ToStoreConfig(device.Config).EncryptedDevice {
+			return true
+		}
+	}
+	return false
+}
+
 func (osdProps osdProperties) getPreparePlacement() cephv1.Placement {
 	// If the osd prepare placement is specified, use it
 	if osdProps.preparePlacement != nil {
@@ -177,6 +246,9 @@ func (c *Cluster) Start() error {
 			}
 		}
 	}
+	if err := c.validateRestrictedMode(); err != nil {
+		return errors.Wrap(err, "invalid storage spec for restricted mode")
+	}
 	logger.Infof("start running osds in namespace %q", namespace)
 
 	if !c.spec.Storage.UseAllNodes && len(c.spec.Storage.Nodes) == 0 && len(c.spec.Storage.StorageClassDeviceSets) == 0 {
@@ -190,8 +262,16 @@ func (c *Cluster) Start() error {
 	}
 	logger.Infof("wait timeout for healthy OSDs during upgrade or restart is %q", c.clusterInfo.OsdUpgradeTimeout)
 
+	// the OSDs whose PVC was deleted cannot start until the PVC is restored
+	c.reportOSDsMissingPVCs()
+
+	// the PVCs of the OSDs removed manually can be deleted
+	if err := c.releaseDeletedPVCs(); err != nil {
+		logger.Warningf("failed to release the deleted osd pvcs. %v", err)
+	}
+
 	// prepare for updating existing OSDs
-	updateQueue, deployments, err := c.getOSDUpdateInfo(errs)
+	updateQueue, deployments, err := c.getOSDUpdateInfo(config, errs)
 	if err != nil {
 		return errors.Wrapf(err, "failed to get information about currently-running OSD Deployments in namespace %q", namespace)
 	}
@@ -239,6 +319,93 @@ func (c *Cluster) Start() error {
 	return nil
 }
 
+// ListOSDDeployments returns the OSD deployments managed by Rook for the cluster in the namespace,
+// selected by the app and cluster labels set on the OSD deployments
+func ListOSDDeployments(clientset kubernetes.Interface, namespace string) ([]appsv1.Deployment, error) {
+	selector := fmt.Sprintf("%s=%s,%s=%s", k8sutil.AppAttr, AppName, k8sutil.ClusterAttr, namespace)
+	listOpts := metav1.ListOptions{LabelSelector: selector}
+	deployments, err := clientset.AppsV1().Deployments(namespace).List(context.TODO(), listOpts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the osd deployments in namespace %q", namespace)
+	}
+	return deployments.Items, nil
+}
+
+// OSDMissingPVC is a PVC referenced by an OSD deployment that does not exist in the namespace
+type OSDMissingPVC struct {
+	// OSDID is the ID of the OSD, -1 if the deployment is missing the OSD ID label
+	OSDID int
+	// DeploymentName is the name of the OSD deployment referencing the PVC
+	DeploymentName string
+	// PVCName is the name of the missing PVC
+	PVCName string
+}
+
+// FindOSDsMissingPVCs returns the PVCs referenced by the OSD deployments in the namespace that do
+// not exist anymore, sorted by OSD deployment and PVC name
+func FindOSDsMissingPVCs(clientset kubernetes.Interface, namespace string) ([]OSDMissingPVC, error) {
+	deployments, err := ListOSDDeployments(clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(deployments) == 0 {
+		return []OSDMissingPVC{}, nil
+	}
+
+	pvcs, err := clientset.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list the pvcs in namespace %q", namespace)
+	}
+	existingPVCs := util.NewSet()
+	for _, pvc := range pvcs.Items {
+		existingPVCs.Add(pvc.Name)
+	}
+
+	missing := []OSDMissingPVC{}
+	for i, d := range deployments {
+		// the same PVC may back several volumes of the pod, e.g. the block and the bridge volumes
+		reported := util.NewSet()
+		for _, volume := range d.Spec.Template.Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil {
+				continue
+			}
+			claimName := volume.PersistentVolumeClaim.ClaimName
+			if existingPVCs.Contains(claimName) || reported.Contains(claimName) {
+				continue
+			}
+			reported.Add(claimName)
+			osdID, err := getOSDID(&deployments[i])
+			if err != nil {
+				logger.Warningf("%v", err)
+			}
+			missing = append(missing, OSDMissingPVC{OSDID: osdID, DeploymentName: d.Name, PVCName: claimName})
+		}
+	}
+
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].DeploymentName != missing[j].DeploymentName {
+			return missing[i].DeploymentName < missing[j].DeploymentName
+		}
+		return missing[i].PVCName < missing[j].PVCName
+	})
+	return missing, nil
+}
+
+// reportOSDsMissingPVCs warns about the OSDs whose PVC does not exist anymore. The PVCs are not
+// recreated since a new PVC would be an empty volume that does not hold the data of the OSD.
+func (c *Cluster) reportOSDsMissingPVCs() {
+	missing, err := FindOSDsMissingPVCs(c.context.Clientset, c.clusterInfo.Namespace)
+	if err != nil {
+		logger.Warningf("failed to check the pvcs of the osds. %v", err)
+		return
+	}
+	for _, m := range missing {
+		msg := fmt.Sprintf("osd %d deployment %q references pvc %q which does not exist. the osd cannot start until the pvc is restored or the osd is removed", m.OSDID, m.DeploymentName, m.PVCName)
+		logger.Warning(msg)
+		c.reportEvent(corev1.EventTypeWarning, osdPVCMissingReason, msg)
+	}
+}
+
 func (c *Cluster) getExistingOSDDeploymentsOnPVCs() (*util.Set, error) {
 	ctx := context.TODO()
 	listOpts := metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s,%s", k8sutil.AppAttr, AppName, OSDOverPVCLabelKey)}
@@ -265,6 +432,7 @@ func deploymentOnNode(c *Cluster, osd OSDInfo, nodeName string, config *provisio
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to generate config for %s", osdLongName)
 	}
+	osdProps.nodeMemoryTarget = c.getNodeMemoryTarget(osdProps, osd)
 
 	d, err := c.makeDeployment(osdProps, osd, config)
 	if err != nil {
@@ -342,6 +510,15 @@ func (c *Cluster) getOSDPropsForNode(nodeName, deviceClass string) (osdPropertie
 	return osdProps, nil
 }
 
+// getSchedulerName returns the scheduler of the OSD pods, the scheduler of the device set takes
+// precedence over the scheduler of the storage spec
+func (c *Cluster) getSchedulerName(osdProps osdProperties) string {
+	if osdProps.schedulerName != "" {
+		return osdProps.schedulerName
+	}
+	return c.spec.Storage.SchedulerName
+}
+
 func (c *Cluster) getOSDPropsForPVC(pvcName, osdDeviceClass string) (osdProperties, error) {
 	for _, deviceSet := range c.deviceSets {
 		// The data PVC template is required.
@@ -455,18 +632,67 @@ func getOSDID(d *appsv1.Deployment) (int, error) {
 }
 
 func (c *Cluster) getOSDInfo(d *appsv1.Deployment) (OSDInfo, error) {
+	osd, isPVC, err := osdInfoFromDeployment(d)
+	if err != nil {
+		return OSDInfo{}, err
+	}
+
+	// Needed for upgrade from v1.5 to v1.6. Rook v1.5 did not set ROOK_BLOCK_PATH for OSDs on nodes
+	// where the 'activate' init container was needed.
+	if !isPVC && osd.BlockPath == "" {
+		osd.BlockPath, err = getBlockPathFromActivateInitContainer(d)
+		if err != nil {
+			return OSDInfo{}, errors.Wrapf(err, "failed to extract legacy OSD block path from deployment %q", d.Name)
+		}
+	}
+
+	// If CVMode is empty, this likely means we upgraded Rook
+	// This property did not exist before so we need to initialize it
+	if osd.CVMode == "" {
+		logger.Infof("required CVMode for OSD %d was not found. assuming this is an LVM OSD", osd.ID)
+		osd.CVMode = "lvm"
+	}
+
+	// if the ROOK_TOPOLOGY_AFFINITY env var was not found in the loop above, detect it from the node
+	if isPVC && osd.TopologyAffinity == "" {
+		osd.TopologyAffinity, err = getTopologyFromNode(c.context.Clientset, d, osd)
+		if err != nil {
+			logger.Errorf("failed to get topology affinity for osd %d. %v", osd.ID, err)
+		}
+	}
+
+	if _, locationFound := getCrushLocationArg(d.Spec.Template.Spec.Containers[0].Args); !locationFound {
+		location, _, err := getLocationFromPod(c.context.Clientset, d, cephclient.GetCrushRootFromSpec(&c.spec))
+		if err != nil {
+			logger.Errorf("failed to get location. %v", err)
+		} else {
+			osd.Location = location
+		}
+	}
+
+	if osd.UUID == "" || osd.BlockPath == "" {
+		return OSDInfo{}, errors.Errorf("failed to get required osdInfo. %+v", osd)
+	}
+
+	return osd, nil
+}
+
+// osdInfoFromDeployment returns the OSD info stored in the env vars and args of the OSD container
+// of the deployment and whether the OSD is on PVC. The info that older versions of Rook did not
+// store in the deployment is left empty.
+func osdInfoFromDeployment(d *appsv1.Deployment) (OSDInfo, bool, error) {
 	container := d.Spec.Template.Spec.Containers[0]
 	var osd OSDInfo
 
 	osdID, err := getOSDID(d)
 	if err != nil {
-		return OSDInfo{}, err
+		return OSDInfo{}, false, err
 	}
 	osd.ID = osdID
 
 	isPVC := false
 
-	for _, envVar := range d.Spec.Template.Spec.Containers[0].Env {
+	for _, envVar := range container.Env {
 		if envVar.Name == "ROOK_OSD_UUID" {
 			osd.UUID = envVar.Value
 		}
@@ -485,7 +711,7 @@ func (c *Cluster) getOSDInfo(d *appsv1.Deployment) (OSDInfo, error) {
 		if envVar.Name == "ROOK_LV_BACKED_PV" {
 			lvBackedPV, err := strconv.ParseBool(envVar.Value)
 			if err != nil {
-				return OSDInfo{}, errors.Wrap(err, "failed to parse ROOK_LV_BACKED_PV")
+				return OSDInfo{}, false, errors.Wrap(err, "failed to parse ROOK_LV_BACKED_PV")
 			}
 			osd.LVBackedPV = lvBackedPV
 		}
@@ -500,55 +726,84 @@ func (c *Cluster) getOSDInfo(d *appsv1.Deployment) (OSDInfo, error) {
 		}
 	}
 
-	// Needed for upgrade from v1.5 to v1.6. Rook v1.5 did not set ROOK_BLOCK_PATH for OSDs on nodes
-	// where the 'activate' init container was needed.
-	if !isPVC && osd.BlockPath == "" {
-		osd.BlockPath, err = getBlockPathFromActivateInitContainer(d)
-		if err != nil {
-			return OSDInfo{}, errors.Wrapf(err, "failed to extract legacy OSD block path from deployment %q", d.Name)
-		}
-	}
+	osd.Location, _ = getCrushLocationArg(container.Args)
 
-	// If CVMode is empty, this likely means we upgraded Rook
-	// This property did not exist before so we need to initialize it
-	if osd.CVMode == "" {
-		logger.Infof("required CVMode for OSD %d was not found. assuming this is an LVM OSD", osd.ID)
-		osd.CVMode = "lvm"
-	}
-
-	// if the ROOK_TOPOLOGY_AFFINITY env var was not found in the loop above, detect it from the node
-	if isPVC && osd.TopologyAffinity == "" {
-		osd.TopologyAffinity, err = getTopologyFromNode(c.context.Clientset, d, osd)
-		if err != nil {
-			logger.Errorf("failed to get topology affinity for osd %d. %v", osd.ID, err)
-		}
-	}
+	return osd, isPVC, nil
+}
 
+// getCrushLocationArg returns the CRUSH location passed to the OSD and whether it was found
+func getCrushLocationArg(args []string) (string, bool) {
+	location := ""
 	locationFound := false
-	for _, a := range container.Args {
+	for _, a := range args {
 		locationPrefix := "--crush-location="
 		if strings.HasPrefix(a, locationPrefix) {
 			locationFound = true
 			// Extract the same CRUSH location as originally determined by the OSD prepare pod
 			// by cutting off the prefix: --crush-location=
-			osd.Location = a[len(locationPrefix):]
+			location = a[len(locationPrefix):]
 		}
 	}
+	return location, locationFound
+}
 
-	if !locationFound {
-		location, _, err := getLocationFromPod(c.context.Clientset, d, cephclient.GetCrushRootFromSpec(&c.spec))
-		if err != nil {
-			logger.Errorf("failed to get location. %v", err)
-		} else {
-			osd.Location = location
+// DeploymentConfig is the configuration of an OSD as found in its deployment
+type DeploymentConfig struct {
+	// OSD is the OSD info: id, uuid, devices, CRUSH location...
+	OSD OSDInfo `json:"osd"`
+	// NodeOrPVCName is the name of the node or of the PVC the OSD runs on
+	NodeOrPVCName string `json:"nodeOrPVCName"`
+	// OnPVC is whether the OSD runs on a PVC
+	OnPVC bool `json:"onPVC"`
+	// StoreConfig is the store config the OSD was deployed with
+	StoreConfig osdconfig.StoreConfig `json:"storeConfig"`
+}
+
+// ParseOSDConfigFromDeployment returns the configuration of the OSD deployed by the given deployment.
+// This is meant for the tools that need to inspect existing OSDs, e.g. during upgrades or migrations.
+// Unlike the operator, it does not query the cluster for the settings that older versions of Rook did
+// not store in the deployment, so they are left empty.
+func ParseOSDConfigFromDeployment(d *appsv1.Deployment) (*DeploymentConfig, error) {
+	if len(d.Spec.Template.Spec.Containers) == 0 {
+		return nil, errors.Errorf("no container found in osd deployment %q", d.Name)
+	}
+
+	osd, isPVC, err := osdInfoFromDeployment(d)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse osd info from deployment %q", d.Name)
+	}
+
+	// the metadata and wal devices of the OSDs on nodes are only passed to the activate init container
+	for _, initContainer := range d.Spec.Template.Spec.InitContainers {
+		if initContainer.Name != activatePVCOSDInitContainer {
+			continue
+		}
+		for _, envVar := range initContainer.Env {
+			if envVar.Name == osdMetadataDeviceEnvVarName && osd.MetadataPath == "" {
+				osd.MetadataPath = envVar.Value
+			}
+			if envVar.Name == osdWalDeviceEnvVarName && osd.WalPath == "" {
+				osd.WalPath = envVar.Value
+			}
 		}
 	}
 
-	if osd.UUID == "" || osd.BlockPath == "" {
-		return OSDInfo{}, errors.Errorf("failed to get required osdInfo. %+v", osd)
+	nodeOrPVCName, err := getNodeOrPVCName(d)
+	if err != nil {
+		return nil, err
 	}
 
-	return osd, nil
+	storeConfig, err := storeConfigFromEnvVars(d.Spec.Template.Spec.Containers[0].Env)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse store config from deployment %q", d.Name)
+	}
+
+	return &DeploymentConfig{
+		OSD:           osd,
+		NodeOrPVCName: nodeOrPVCName,
+		OnPVC:         isPVC || osdIsOnPVC(d),
+		StoreConfig:   storeConfig,
+	}, nil
 }
 
 func osdIsOnPVC(d *appsv1.Deployment) bool {
@@ -45,6 +45,7 @@ import (
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
 	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestOSDProperties(t *testing.T) {
@@ -413,6 +414,105 @@ func TestGetPVCHostName(t *testing.T) {
 	assert.Equal(t, "testnode", name)
 }
 
+func TestListOSDDeployments(t *testing.T) {
+	ctx := context.TODO()
+	clientset := fake.NewSimpleClientset()
+	newDeployment := func(name, namespace string, labels map[string]string) {
+		d := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}}
+		_, err := clientset.AppsV1().Deployments(namespace).Create(ctx, d, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+	newDeployment("rook-ceph-osd-0", "ns", map[string]string{k8sutil.AppAttr: AppName, k8sutil.ClusterAttr: "ns", OsdIdLabelKey: "0"})
+	newDeployment("rook-ceph-osd-1", "ns", map[string]string{k8sutil.AppAttr: AppName, k8sutil.ClusterAttr: "ns", OsdIdLabelKey: "1"})
+	// the deployments of other apps, of other clusters or without the cluster label are not listed
+	newDeployment("rook-ceph-mon-a", "ns", map[string]string{k8sutil.AppAttr: "rook-ceph-mon", k8sutil.ClusterAttr: "ns"})
+	newDeployment("rook-ceph-osd-2", "ns", map[string]string{k8sutil.AppAttr: AppName})
+	newDeployment("rook-ceph-osd-0", "other", map[string]string{k8sutil.AppAttr: AppName, k8sutil.ClusterAttr: "other"})
+
+	deployments, err := ListOSDDeployments(clientset, "ns")
+	assert.NoError(t, err)
+	names := []string{}
+	for _, d := range deployments {
+		names = append(names, d.Name)
+	}
+	assert.ElementsMatch(t, []string{"rook-ceph-osd-0", "rook-ceph-osd-1"}, names)
+
+	deployments, err = ListOSDDeployments(clientset, "empty")
+	assert.NoError(t, err)
+	assert.Empty(t, deployments)
+
+	clientset.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("induced error")
+	})
+	_, err = ListOSDDeployments(clientset, "ns")
+	assert.Error(t, err)
+}
+
+func TestFindOSDsMissingPVCs(t *testing.T) {
+	ctx := context.TODO()
+	clientset := fake.NewSimpleClientset()
+	newOSDDeployment := func(id, pvcName string) {
+		labels := map[string]string{k8sutil.AppAttr: AppName, k8sutil.ClusterAttr: "ns", OsdIdLabelKey: id, OSDOverPVCLabelKey: pvcName}
+		pvcSource := corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvcName}}
+		d := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph-osd-" + id, Namespace: "ns", Labels: labels}}
+		d.Spec.Template.Spec.Volumes = []corev1.Volume{
+			{Name: pvcName, VolumeSource: pvcSource},
+			{Name: pvcName + "-bridge", VolumeSource: pvcSource},
+			{Name: "rook-data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		}
+		_, err := clientset.AppsV1().Deployments("ns").Create(ctx, d, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+	newPVC := func(name, namespace string) {
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		_, err := clientset.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, pvc, metav1.CreateOptions{})
+		assert.NoError(t, err)
+	}
+	newOSDDeployment("0", "set1-data-0")
+	newOSDDeployment("1", "set1-data-1")
+	newPVC("set1-data-0", "ns")
+	newPVC("set1-data-1", "ns")
+
+	missing, err := FindOSDsMissingPVCs(clientset, "ns")
+	assert.NoError(t, err)
+	assert.Empty(t, missing)
+
+	// a pvc with the same name in another namespace does not back the osd
+	err = clientset.CoreV1().PersistentVolumeClaims("ns").Delete(ctx, "set1-data-1", metav1.DeleteOptions{})
+	assert.NoError(t, err)
+	newPVC("set1-data-1", "other")
+	missing, err = FindOSDsMissingPVCs(clientset, "ns")
+	assert.NoError(t, err)
+	assert.Equal(t, []OSDMissingPVC{{OSDID: 1, DeploymentName: "rook-ceph-osd-1", PVCName: "set1-data-1"}}, missing)
+
+	t.Run("missing pvc reported as event", func(t *testing.T) {
+		c := &Cluster{context: &clusterd.Context{Clientset: clientset}, clusterInfo: cephclient.AdminClusterInfo("ns")}
+		recorder := record.NewFakeRecorder(10)
+		c.SetEventRecorder(recorder, &cephv1.CephCluster{ObjectMeta: metav1.ObjectMeta{Name: "my-cluster", Namespace: "ns"}})
+		c.reportOSDsMissingPVCs()
+		assert.Len(t, recorder.Events, 1)
+		assert.Equal(t, `Warning OSDPVCMissing osd 1 deployment "rook-ceph-osd-1" references pvc "set1-data-1" which does not exist. the osd cannot start until the pvc is restored or the osd is removed`, <-recorder.Events)
+
+		// the missing pvc is not recreated
+		_, err := clientset.CoreV1().PersistentVolumeClaims("ns").Get(ctx, "set1-data-1", metav1.GetOptions{})
+		assert.True(t, k8serrors.IsNotFound(err))
+	})
+
+	t.Run("no osd deployments", func(t *testing.T) {
+		missing, err := FindOSDsMissingPVCs(clientset, "empty")
+		assert.NoError(t, err)
+		assert.Empty(t, missing)
+	})
+
+	t.Run("failure to list the pvcs", func(t *testing.T) {
+		clientset.PrependReactor("list", "persistentvolumeclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("induced error")
+		})
+		_, err := FindOSDsMissingPVCs(clientset, "ns")
+		assert.Error(t, err)
+	})
+}
+
 func TestGetOSDInfo(t *testing.T) {
 	clusterInfo := &cephclient.ClusterInfo{Namespace: "ns"}
 	clusterInfo.SetName("test")
@@ -634,3 +734,119 @@ func TestGetOSDInfoWithCustomRoot(t *testing.T) {
 	_, err := c.getOSDInfo(d3)
 	assert.Error(t, err)
 }
+
+func TestParseOSDConfigFromDeployment(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+
+	t.Run("osd on node", func(t *testing.T) {
+		osd := OSDInfo{
+			ID:           3,
+			UUID:         "5a2ef6a8-4d2f-4f8b-9c39-df36e9a7b2f1",
+			BlockPath:    "/dev/vdb",
+			MetadataPath: "/dev/nvme0n1",
+			CVMode:       "raw",
+			DeviceClass:  "hdd",
+			Location:     "root=default host=node1",
+		}
+		osdProps := osdProperties{
+			crushHostname: "node1",
+			storeConfig: config.StoreConfig{
+				DatabaseSizeMB:  1024,
+				OSDsPerDevice:   1,
+				ConfigOverrides: map[string]string{"bluestore_cache_size": "3221225472"},
+			},
+		}
+		d, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+
+		osdConfig, err := ParseOSDConfigFromDeployment(d)
+		assert.NoError(t, err)
+		assert.Equal(t, osd.ID, osdConfig.OSD.ID)
+		assert.Equal(t, osd.UUID, osdConfig.OSD.UUID)
+		assert.Equal(t, osd.BlockPath, osdConfig.OSD.BlockPath)
+		assert.Equal(t, osd.MetadataPath, osdConfig.OSD.MetadataPath)
+		assert.Equal(t, osd.CVMode, osdConfig.OSD.CVMode)
+		assert.Equal(t, osd.DeviceClass, osdConfig.OSD.DeviceClass)
+		assert.Equal(t, osd.Location, osdConfig.OSD.Location)
+		assert.Equal(t, "node1", osdConfig.NodeOrPVCName)
+		assert.False(t, osdConfig.OnPVC)
+		assert.Equal(t, osdProps.storeConfig, osdConfig.StoreConfig)
+	})
+
+	t.Run("osd on pvc", func(t *testing.T) {
+		osd := OSDInfo{
+			ID:        1,
+			UUID:      "c1f0d2a7-0f5b-4b9a-a5c6-3f0c0b6e0d11",
+			BlockPath: "/mnt/set1-data-0",
+			CVMode:    "raw",
+			Location:  "root=default host=set1-data-0",
+		}
+		osdProps := osdProperties{
+			crushHostname: "set1-data-0",
+			pvc:           corev1.PersistentVolumeClaimVolumeSource{ClaimName: "set1-data-0"},
+			deviceSetName: "set1",
+			storeConfig:   config.StoreConfig{OSDsPerDevice: 1, EncryptedDevice: true},
+		}
+		d, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+
+		osdConfig, err := ParseOSDConfigFromDeployment(d)
+		assert.NoError(t, err)
+		assert.Equal(t, osd.UUID, osdConfig.OSD.UUID)
+		assert.Equal(t, osd.BlockPath, osdConfig.OSD.BlockPath)
+		assert.Equal(t, osd.Location, osdConfig.OSD.Location)
+		assert.Equal(t, "set1-data-0", osdConfig.NodeOrPVCName)
+		assert.True(t, osdConfig.OnPVC)
+		assert.True(t, osdConfig.StoreConfig.EncryptedDevice)
+	})
+
+	t.Run("invalid deployment", func(t *testing.T) {
+		d := &apps.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph-osd-0"}}
+		_, err := ParseOSDConfigFromDeployment(d)
+		assert.Error(t, err)
+
+		d.Spec.Template.Spec.Containers = []corev1.Container{{Name: "osd"}}
+		_, err = ParseOSDConfigFromDeployment(d)
+		assert.Error(t, err)
+	})
+}
+
+func TestMarshalOSDInfo(t *testing.T) {
+	for name, osd := range map[string]OSDInfo{
+		"raw device on a node": {
+			ID: 0, Cluster: "ceph", UUID: "3a9f6ff3-1d4c-4f5e-9bc7-2c9a4d3b1e21", DevicePartUUID: "b1f3c4a2-7d6e-4c1b-8a3e-2d5f6a7b8c9d",
+			DeviceClass: "hdd", BlockPath: "/dev/sdb", CVMode: "raw", Store: "bluestore", Location: "root=default host=node1",
+		},
+		"lvm device with metadata and wal": {
+			ID: 3, Cluster: "ceph", UUID: "9e8d7c6b-5a4f-3e2d-1c0b-a9f8e7d6c5b4", DeviceClass: "ssd",
+			BlockPath: "/dev/ceph-block-0/osd-block-9e8d", MetadataPath: "/dev/ceph-db-0/osd-db-9e8d", WalPath: "/dev/ceph-wal-0/osd-wal-9e8d",
+			CVMode: "lvm", Store: "bluestore", Location: "root=default rack=rack1 host=node2",
+		},
+		"pvc": {
+			ID: 7, Cluster: "ceph", UUID: "0f1e2d3c-4b5a-6978-8a9b-0c1d2e3f4a5b", DeviceClass: "nvme",
+			BlockPath: "/mnt/set1-data-0-abcde", CVMode: "lvm", Store: "bluestore", SkipLVRelease: true, LVBackedPV: true,
+			Location: "root=default host=set1-data-0-abcde", TopologyAffinity: "topology.kubernetes.io/zone=zone-a",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := MarshalOSDInfo(osd)
+			assert.NoError(t, err)
+			// the osd info can be stored in a configmap
+			cm := &corev1.ConfigMap{Data: map[string]string{"osd": data}}
+			restored, err := UnmarshalOSDInfo(cm.Data["osd"])
+			assert.NoError(t, err)
+			assert.Equal(t, osd, restored)
+		})
+	}
+
+	// the keys match the orchestration status of the osds
+	data, err := MarshalOSDInfo(OSDInfo{ID: 1, BlockPath: "/dev/sdc", CVMode: "raw"})
+	assert.NoError(t, err)
+	assert.Contains(t, data, `"id":1`)
+	assert.Contains(t, data, `"lv-path":"/dev/sdc"`)
+	assert.Contains(t, data, `"lv-mode":"raw"`)
+
+	_, err = UnmarshalOSDInfo("not json")
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/rook/rook/pkg/operator/k8sutil"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// DefaultPDBName is the name of the poddisruptionbudget covering all the OSDs of a cluster
+	DefaultPDBName = AppName
+	// defaultPDBMaxUnavailable is the number of OSDs the default poddisruptionbudget allows to be
+	// unavailable if none is configured
+	defaultPDBMaxUnavailable = 1
+)
+
+// GetPDBMaxUnavailable returns the number of OSDs allowed to be unavailable by the default OSD
+// poddisruptionbudget. The default pdb covers the OSDs of all the failure domains, so the budget is
+// clamped to a single OSD unless all the OSDs are in the same failure domain. Otherwise the unavailable
+// OSDs could span several failure domains before the drain of a failure domain is detected.
+func GetPDBMaxUnavailable(spec cephv1.DisruptionManagementSpec, failureDomains int) int {
+	if spec.OSDMaxUnavailable <= 0 {
+		return defaultPDBMaxUnavailable
+	}
+	if spec.OSDMaxUnavailable > defaultPDBMaxUnavailable && failureDomains > 1 {
+		return defaultPDBMaxUnavailable
+	}
+	return spec.OSDMaxUnavailable
+}
+
+// MakeDefaultPDB returns the poddisruptionbudget selecting all the OSD pods of the namespace. It
+// allows the number of OSDs set in the disruption management spec to be unavailable at the same time,
+// as long as the OSDs are in a single one of the given number of failure domains.
+func MakeDefaultPDB(namespace string, spec cephv1.DisruptionManagementSpec, failureDomains int) *policyv1beta1.PodDisruptionBudget {
+	maxUnavailable := intstr.FromInt(GetPDBMaxUnavailable(spec, failureDomains))
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultPDBName,
+			Namespace: namespace,
+		},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MaxUnavailable: &maxUnavailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{k8sutil.AppAttr: AppName},
+			},
+		},
+	}
+}
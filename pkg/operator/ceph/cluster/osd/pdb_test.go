@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"testing"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestMakeDefaultPDB(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+
+	pdb := MakeDefaultPDB("ns", cephv1.DisruptionManagementSpec{}, 3)
+	assert.Equal(t, DefaultPDBName, pdb.Name)
+	assert.Equal(t, "ns", pdb.Namespace)
+	assert.Equal(t, 1, pdb.Spec.MaxUnavailable.IntValue())
+
+	// the selector matches the labels of the osd pods only
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	assert.NoError(t, err)
+	osd := OSDInfo{ID: 3, Location: "root=default host=node1"}
+	assert.True(t, selector.Matches(labels.Set(c.getOSDLabels(osd, "node1", false))))
+	assert.True(t, selector.Matches(labels.Set(c.getOSDLabels(osd, "pvc1", true))))
+	assert.False(t, selector.Matches(labels.Set{"app": prepareAppName}))
+
+	// the configured limit is honored in a single failure domain
+	pdb = MakeDefaultPDB("ns", cephv1.DisruptionManagementSpec{OSDMaxUnavailable: 3}, 1)
+	assert.Equal(t, 3, pdb.Spec.MaxUnavailable.IntValue())
+
+	// the limit is clamped to one osd with several failure domains
+	pdb = MakeDefaultPDB("ns", cephv1.DisruptionManagementSpec{OSDMaxUnavailable: 3}, 3)
+	assert.Equal(t, 1, pdb.Spec.MaxUnavailable.IntValue())
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/rook/rook/pkg/operator/k8sutil"
+	v1 "k8s.io/api/core/v1"
+)
+
+// PreviewPlacement returns whether at least one of the nodes would be selected by the placement of
+// the OSDs, so that a misconfigured placement is detected before it is applied. If no node matches,
+// the reason the first node is excluded is returned. The nodes are checked against the required node
+// affinity, the tolerations and the topology keys of the topology spread constraints that cannot be
+// violated. The pod affinities and the preferred terms depend on the running pods and are ignored.
+func PreviewPlacement(placement cephv1.Placement, nodes []v1.Node) (bool, string, error) {
+	if len(nodes) == 0 {
+		return false, "no nodes to place the osds on", nil
+	}
+
+	firstReason := ""
+	for _, node := range nodes {
+		reason, err := nodeExclusionReason(placement, node)
+		if err != nil {
+			return false, "", errors.Wrapf(err, "failed to check the placement of the osds on node %q", node.Name)
+		}
+		if reason == "" {
+			return true, "", nil
+		}
+		if firstReason == "" {
+			firstReason = reason
+		}
+	}
+	return false, firstReason, nil
+}
+
+// nodeExclusionReason returns why the placement excludes the node, or an empty string if the OSDs
+// can be placed on the node
+func nodeExclusionReason(placement cephv1.Placement, node v1.Node) (string, error) {
+	if !k8sutil.GetNodeSchedulable(node) {
+		return fmt.Sprintf("node %q is unschedulable", node.Name), nil
+	}
+	if !k8sutil.NodeIsReady(node) {
+		return fmt.Sprintf("node %q is not ready", node.Name), nil
+	}
+
+	matches, err := k8sutil.NodeMeetsAffinityTerms(node, placement.NodeAffinity)
+	if err != nil {
+		return "", err
+	}
+	if !matches {
+		return fmt.Sprintf("node %q does not match the node affinity", node.Name), nil
+	}
+
+	for i := range node.Spec.Taints {
+		if !taintIsTolerated(&node.Spec.Taints[i], placement.Tolerations) {
+			return fmt.Sprintf("taint %q of node %q is not tolerated", node.Spec.Taints[i].ToString(), node.Name), nil
+		}
+	}
+
+	for _, constraint := range placement.TopologySpreadConstraints {
+		if constraint.WhenUnsatisfiable != v1.DoNotSchedule {
+			continue
+		}
+		if _, ok := node.Labels[constraint.TopologyKey]; !ok {
+			return fmt.Sprintf("node %q does not have the label %q of the topology spread constraints", node.Name, constraint.TopologyKey), nil
+		}
+	}
+	return "", nil
+}
+
+func taintIsTolerated(taint *v1.Taint, tolerations []v1.Toleration) bool {
+	for i := range tolerations {
+		if tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
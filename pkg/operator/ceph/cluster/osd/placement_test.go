@@ -0,0 +1,107 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"testing"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPreviewPlacement(t *testing.T) {
+	newNode := func(name string, labels map[string]string, taints ...v1.Taint) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Spec:       v1.NodeSpec{Taints: taints},
+			Status:     v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}},
+		}
+	}
+	storageTaint := v1.Taint{Key: "storage-node", Value: "true", Effect: v1.TaintEffectNoSchedule}
+	placement := cephv1.Placement{
+		NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchExpressions: []v1.NodeSelectorRequirement{{Key: "role", Operator: v1.NodeSelectorOpIn, Values: []string{"storage"}}},
+				}},
+			},
+		},
+		Tolerations: []v1.Toleration{{Key: "storage-node", Operator: v1.TolerationOpExists}},
+		TopologySpreadConstraints: []v1.TopologySpreadConstraint{
+			{MaxSkew: 1, TopologyKey: v1.LabelTopologyZone, WhenUnsatisfiable: v1.DoNotSchedule},
+			{MaxSkew: 1, TopologyKey: v1.LabelHostname, WhenUnsatisfiable: v1.ScheduleAnyway},
+		},
+	}
+	storageLabels := map[string]string{"role": "storage", v1.LabelTopologyZone: "a"}
+
+	t.Run("matching nodes", func(t *testing.T) {
+		nodes := []v1.Node{
+			newNode("node0", map[string]string{"role": "compute"}),
+			newNode("node1", storageLabels, storageTaint),
+		}
+		matches, reason, err := PreviewPlacement(placement, nodes)
+		assert.NoError(t, err)
+		assert.True(t, matches)
+		assert.Empty(t, reason)
+
+		// any node matches an empty placement
+		matches, _, err = PreviewPlacement(cephv1.Placement{}, nodes[:1])
+		assert.NoError(t, err)
+		assert.True(t, matches)
+	})
+
+	t.Run("no matching node", func(t *testing.T) {
+		unschedulable := newNode("node1", storageLabels)
+		unschedulable.Spec.Unschedulable = true
+		notReady := newNode("node1", storageLabels)
+		notReady.Status.Conditions = nil
+
+		for expected, node := range map[string]v1.Node{
+			`node "node1" does not match the node affinity`: newNode("node1", map[string]string{"role": "compute"}),
+			`taint "other=true:NoExecute" of node "node1" is not tolerated`: newNode("node1", storageLabels, storageTaint,
+				v1.Taint{Key: "other", Value: "true", Effect: v1.TaintEffectNoExecute}),
+			`node "node1" does not have the label "topology.kubernetes.io/zone" of the topology spread constraints`: newNode("node1", map[string]string{"role": "storage"}),
+			`node "node1" is unschedulable`: unschedulable,
+			`node "node1" is not ready`:     notReady,
+		} {
+			matches, reason, err := PreviewPlacement(placement, []v1.Node{node, newNode("node2", nil)})
+			assert.NoError(t, err)
+			assert.False(t, matches)
+			// the reason of the first excluded node is reported
+			assert.Equal(t, expected, reason)
+		}
+
+		matches, reason, err := PreviewPlacement(placement, nil)
+		assert.NoError(t, err)
+		assert.False(t, matches)
+		assert.NotEmpty(t, reason)
+	})
+
+	t.Run("invalid placement", func(t *testing.T) {
+		invalid := cephv1.Placement{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+				NodeSelectorTerms: []v1.NodeSelectorTerm{{
+					MatchExpressions: []v1.NodeSelectorRequirement{{Key: "role", Operator: "Invalid"}},
+				}},
+			},
+		}}
+		_, _, err := PreviewPlacement(invalid, []v1.Node{newNode("node1", storageLabels)})
+		assert.Error(t, err)
+	})
+}
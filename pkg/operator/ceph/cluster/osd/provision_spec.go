@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path"
+	"regexp"
 
 	"github.com/libopenstorage/secrets"
 	"github.com/pkg/errors"
@@ -33,6 +34,41 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+var (
+	// partitionNamePatterns match the kernel names of partitions, e.g. sdb1 or nvme0n1p2, and the
+	// udev links of partitions, e.g. /dev/disk/by-id/wwn-0x5000c500a0b1c2d3-part1
+	partitionNamePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`^(/dev/)?(sd|vd|xvd|hd)[a-z]+[0-9]+$`),
+		regexp.MustCompile(`^(/dev/)?(nvme[0-9]+n[0-9]+|mmcblk[0-9]+|loop[0-9]+)p[0-9]+$`),
+		regexp.MustCompile(`^/dev/disk/by-[a-z]+/.+-part[0-9]+$`),
+	}
+)
+
+// isPartition returns whether the device name designates a partition rather than a whole device
+func isPartition(name string) bool {
+	for _, pattern := range partitionNamePatterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePartition checks that the partition can be prepared. Partitions are only supported by
+// ceph-volume raw mode so the settings requiring ceph-volume lvm batch cannot be used with them.
+func validatePartition(device config.ConfiguredDevice, osdProps osdProperties) error {
+	if device.StoreConfig.OSDsPerDevice > 1 || osdProps.storeConfig.OSDsPerDevice > 1 {
+		return errors.Errorf("partition %q cannot hold more than one osd", device.ID)
+	}
+	if device.StoreConfig.EncryptedDevice || osdProps.storeConfig.EncryptedDevice {
+		return errors.Errorf("partition %q cannot be encrypted", device.ID)
+	}
+	if device.StoreConfig.MetadataDevice != "" || osdProps.metadataDevice != "" {
+		return errors.Errorf("partition %q cannot use a metadata device", device.ID)
+	}
+	return nil
+}
+
 func (c *Cluster) makeJob(osdProps osdProperties, provisionConfig *provisionConfig) (*batch.Job, error) {
 	podSpec, err := c.provisionPodTemplateSpec(osdProps, v1.RestartPolicyOnFailure, provisionConfig)
 	if err != nil {
@@ -40,7 +76,12 @@ func (c *Cluster) makeJob(osdProps osdProperties, provisionConfig *provisionConf
 	}
 
 	if !osdProps.onPVC() {
-		podSpec.Spec.NodeSelector = map[string]string{v1.LabelHostname: osdProps.crushHostname}
+		hostname := osdProps.crushHostname
+		if override, ok := c.spec.Storage.PrepareHostnames[osdProps.crushHostname]; ok && override != "" {
+			logger.Infof("osd prepare job of node %q will run on node with hostname %q", osdProps.crushHostname, override)
+			hostname = override
+		}
+		podSpec.Spec.NodeSelector = map[string]string{v1.LabelHostname: hostname}
 	} else {
 		// This is not needed in raw mode and 14.2.8 brings it
 		// but we still want to do this not to lose backward compatibility with lvm based OSDs...
@@ -63,7 +104,8 @@ func (c *Cluster) makeJob(osdProps osdProperties, provisionConfig *provisionConf
 			},
 		},
 		Spec: batch.JobSpec{
-			Template: *podSpec,
+			Template:                *podSpec,
+			TTLSecondsAfterFinished: c.spec.Storage.PrepareJobTTLSecondsAfterFinished,
 		},
 	}
 
@@ -82,10 +124,22 @@ func (c *Cluster) makeJob(osdProps osdProperties, provisionConfig *provisionConf
 	}
 
 	// override the resources of all the init containers and main container with the expected osd prepare resources
-	c.applyResourcesToAllContainers(&podSpec.Spec, cephv1.GetPrepareOSDResources(c.spec.Resources))
+	c.applyResourcesToAllContainers(&podSpec.Spec, c.getPrepareOSDResources(osdProps))
+	c.applySeccompProfileToAllContainers(&job.Spec.Template.Spec)
+	c.applyTerminationMessagePolicyToAllContainers(&job.Spec.Template.Spec)
 	return job, nil
 }
 
+// getPrepareOSDResources returns the resources of the osd prepare pod, the resources of the OSD are
+// used if no osd prepare resources are set
+func (c *Cluster) getPrepareOSDResources(osdProps osdProperties) v1.ResourceRequirements {
+	resources := cephv1.GetPrepareOSDResources(c.spec.Resources)
+	if resources.Limits == nil && resources.Requests == nil {
+		return osdProps.resources
+	}
+	return resources
+}
+
 // applyResourcesToAllContainers applies consistent resource requests for all containers and all init containers in the pod
 func (c *Cluster) applyResourcesToAllContainers(spec *v1.PodSpec, resources v1.ResourceRequirements) {
 	for i := range spec.InitContainers {
@@ -97,7 +151,11 @@ func (c *Cluster) applyResourcesToAllContainers(spec *v1.PodSpec, resources v1.R
 }
 
 func (c *Cluster) provisionPodTemplateSpec(osdProps osdProperties, restart v1.RestartPolicy, provisionConfig *provisionConfig) (*v1.PodTemplateSpec, error) {
-	copyBinariesVolume, copyBinariesContainer := c.getCopyBinariesContainer()
+	binariesDir, err := c.getBinariesMountPath()
+	if err != nil {
+		return nil, err
+	}
+	copyBinariesVolume, copyBinariesContainer := c.getCopyBinariesContainer(binariesDir)
 
 	// ceph-volume is currently set up to use /etc/ceph/ceph.conf; this means no user config
 	// overrides will apply to ceph-volume, but this is unnecessary anyway
@@ -110,14 +168,14 @@ func (c *Cluster) provisionPodTemplateSpec(osdProps osdProperties, restart v1.Re
 	volumes = append(volumes, udevVolume)
 
 	// If not running on PVC we mount the rootfs of the host to validate the presence of the LVM package
-	if !osdProps.onPVC() {
+	if !osdProps.onPVC() && !usesRawModeOnly(osdProps) {
 		rootFSVolume := v1.Volume{Name: "rootfs", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/"}}}
 		volumes = append(volumes, rootFSVolume)
 	}
 
 	if osdProps.onPVC() {
 		// Create volume config for PVCs
-		volumes = append(volumes, getPVCOSDVolumes(&osdProps, c.spec.DataDirHostPath, c.clusterInfo.Namespace, true)...)
+		volumes = append(volumes, getPVCOSDVolumes(&osdProps, c.spec.DataDirHostPath, c.clusterInfo.Namespace, true, c.bridgeVolumeEmptyDir())...)
 		if osdProps.encrypted {
 			// If a KMS is configured we populate
 			if c.spec.Security.KeyManagementService.IsEnabled() {
@@ -151,7 +209,8 @@ func (c *Cluster) provisionPodTemplateSpec(osdProps osdProperties, restart v1.Re
 		Volumes:           volumes,
 		HostNetwork:       c.spec.Network.IsHost(),
 		PriorityClassName: cephv1.GetOSDPriorityClassName(c.spec.PriorityClassNames),
-		SchedulerName:     osdProps.schedulerName,
+		SchedulerName:     c.getSchedulerName(osdProps),
+		RuntimeClassName:  c.spec.Storage.RuntimeClassName,
 	}
 	if c.spec.Network.IsHost() {
 		podSpec.DNSPolicy = v1.DNSClusterFirstWithHostNet
@@ -187,7 +246,24 @@ func (c *Cluster) provisionPodTemplateSpec(osdProps osdProperties, restart v1.Re
 
 	// ceph-volume --dmcrypt uses cryptsetup that synchronizes with udev on
 	// host through semaphore
-	podSpec.HostIPC = osdProps.storeConfig.EncryptedDevice || osdProps.encrypted
+	podSpec.HostIPC = osdProps.encryptsDevices()
+
+	c.applyHostPathTypes(&podSpec)
+	if err := c.addCABundle(&podSpec); err != nil {
+		return nil, errors.Wrap(err, "failed to mount the ca bundle in the osd prepare pod")
+	}
+	if err := c.addCephConfigDir(&podSpec); err != nil {
+		return nil, errors.Wrap(err, "failed to mount the ceph config directory in the osd prepare pod")
+	}
+	if err := c.applyLogHostPath(&podSpec); err != nil {
+		return nil, errors.Wrap(err, "failed to set the log host path of the osd prepare pod")
+	}
+	if c.spec.Storage.Sysfs != nil && c.spec.Storage.Sysfs.Prepare && !osdProps.onPVC() {
+		addSysfs(&podSpec, &podSpec.Containers[0])
+	}
+	if err := validatePrepareHostPaths(&podSpec); err != nil {
+		return nil, errors.Wrap(err, "invalid host paths of the osd prepare pod")
+	}
 
 	return &v1.PodTemplateSpec{
 		ObjectMeta: podMeta,
@@ -195,44 +271,175 @@ func (c *Cluster) provisionPodTemplateSpec(osdProps osdProperties, restart v1.Re
 	}, nil
 }
 
-func (c *Cluster) provisionOSDContainer(osdProps osdProperties, copyBinariesMount v1.VolumeMount, provisionConfig *provisionConfig) (v1.Container, error) {
-	envVars := c.getConfigEnvVars(osdProps, k8sutil.DataDir)
+// PrepareHostPathVolumes returns the hostPath volumes of the OSD prepare pod that would run on the
+// given node for the given storage selection. This allows validating that the host paths exist
+// before provisioning the OSDs.
+func (c *Cluster) PrepareHostPathVolumes(nodeName string, selection cephv1.Selection) ([]v1.Volume, error) {
+	osdProps := osdProperties{
+		crushHostname: nodeName,
+		devices:       selection.Devices,
+		selection:     selection,
+		storeConfig:   config.NewStoreConfig(),
+	}
+	podTemplateSpec, err := c.provisionPodTemplateSpec(osdProps, v1.RestartPolicyOnFailure, c.newProvisionConfig())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to generate the osd prepare pod for node %q", nodeName)
+	}
 
-	// enable debug logging in the prepare job
-	envVars = append(envVars, setDebugLogLevelEnvVar(true))
+	volumes := []v1.Volume{}
+	for _, volume := range podTemplateSpec.Spec.Volumes {
+		if volume.HostPath != nil {
+			volumes = append(volumes, volume)
+		}
+	}
+	return volumes, nil
+}
+
+// getDeviceSelection returns the selection of the devices of the node with the configuration of
+// each listed device validated
+func getDeviceSelection(osdProps osdProperties) (DeviceSelection, error) {
+	selection := DeviceSelection{
+		DeviceFilter:     osdProps.selection.DeviceFilter,
+		DevicePathFilter: osdProps.selection.DevicePathFilter,
+		UseAllDevices:    osdProps.selection.GetUseAllDevices(),
+		DeviceSelector:   osdProps.selection.DeviceSelector,
+	}
+	if len(osdProps.devices) > 0 && selection.DeviceSelector != nil {
+		logger.Warningf("ignoring the device selector on node %q since a list of devices is specified", osdProps.crushHostname)
+	}
 
-	// only 1 of device list, device filter, device path filter and use all devices can be specified.  We prioritize in that order.
-	if len(osdProps.devices) > 0 {
-		configuredDevices := []config.ConfiguredDevice{}
-		for _, device := range osdProps.devices {
-			id := device.Name
-			if device.FullPath != "" {
-				id = device.FullPath
+	listed := map[string]bool{}
+	for _, device := range osdProps.devices {
+		id := device.Name
+		if device.FullPath != "" {
+			id = device.FullPath
+		}
+		id, err := canonicalDeviceID(id)
+		if err != nil {
+			return DeviceSelection{}, errors.Wrapf(err, "invalid device on node %q", osdProps.crushHostname)
+		}
+		if listed[id] {
+			logger.Warningf("ignoring device %q listed more than once on node %q", id, osdProps.crushHostname)
+			continue
+		}
+		listed[id] = true
+		cd := config.ConfiguredDevice{
+			ID:          id,
+			StoreConfig: config.ToStoreConfig(device.Config),
+		}
+		if err := config.ValidateStoreType(cd.StoreConfig.StoreType); err != nil {
+			return DeviceSelection{}, errors.Wrapf(err, "invalid %q of device %q on node %q", config.StoreTypeKey, id, osdProps.crushHostname)
+		}
+		if _, ok := device.Config[config.JournalSizeMBKey]; ok {
+			// the journal was only used by filestore, the bluestore equivalent is the database size
+			logger.Warningf("ignoring %q of device %q on node %q since only bluestore is supported, set %q instead", config.JournalSizeMBKey, id, osdProps.crushHostname, config.DatabaseSizeMBKey)
+		}
+		if cd.StoreConfig.MetadataDevice != "" {
+			metadataDevice, err := normalizeMetadataDevice(cd.StoreConfig.MetadataDevice)
+			if err != nil {
+				return DeviceSelection{}, errors.Wrapf(err, "invalid metadata device of device %q on node %q", id, osdProps.crushHostname)
 			}
-			cd := config.ConfiguredDevice{
-				ID:          id,
-				StoreConfig: config.ToStoreConfig(device.Config),
+			cd.StoreConfig.MetadataDevice = metadataDevice
+		}
+		if isPartition(id) {
+			if err := validatePartition(cd, osdProps); err != nil {
+				return DeviceSelection{}, errors.Wrapf(err, "invalid device on node %q", osdProps.crushHostname)
 			}
-			configuredDevices = append(configuredDevices, cd)
+			logger.Infof("device %q on node %q is a partition, it will be prepared in raw mode", id, osdProps.crushHostname)
 		}
-		marshalledDevices, err := json.Marshal(configuredDevices)
-		if err != nil {
-			return v1.Container{}, errors.Wrapf(err, "failed to JSON marshal configured devices for node %q", osdProps.crushHostname)
+		selection.Devices = append(selection.Devices, cd)
+	}
+	return selection, nil
+}
+
+// validateZonedDevices returns an error if zoned devices are selected on the node while the ceph
+// version does not support zoned devices
+func (c *Cluster) validateZonedDevices(osdProps osdProperties, selection DeviceSelection) error {
+	zoned := osdProps.storeConfig.Zoned
+	for _, device := range selection.Devices {
+		zoned = zoned || device.StoreConfig.Zoned
+	}
+	if zoned && !c.clusterInfo.CephVersion.IsAtLeast(zonedMinCephVersion) {
+		return errors.Errorf("zoned devices on node %q require ceph version %q or newer, the cluster runs %q", osdProps.crushHostname, zonedMinCephVersion.String(), c.clusterInfo.CephVersion.String())
+	}
+	return nil
+}
+
+// validateRawMode returns an error if the raw mode is required on the node while the ceph version
+// cannot provision the devices of nodes in raw mode
+func (c *Cluster) validateRawMode(osdProps osdProperties) error {
+	if !osdProps.storeConfig.RawMode || osdProps.onPVC() {
+		return nil
+	}
+	version := c.clusterInfo.CephVersion
+	if version.IsAtLeastPacific() ||
+		(version.IsOctopus() && version.IsAtLeast(rawModeOnNodesMinOctopusCephVersion)) ||
+		(version.IsNautilus() && version.IsAtLeast(rawModeOnNodesMinNautilusCephVersion)) {
+		return nil
+	}
+	return errors.Errorf("raw mode on node %q requires ceph version %q, %q or newer, the cluster runs %q", osdProps.crushHostname, rawModeOnNodesMinNautilusCephVersion.String(), rawModeOnNodesMinOctopusCephVersion.String(), version.String())
+}
+
+// usesRawModeOnly returns whether the OSDs of the node are only provisioned in raw mode, so the
+// host does not need to provide LVM
+func usesRawModeOnly(osdProps osdProperties) bool {
+	return osdProps.storeConfig.RawMode && !osdProps.onPVC()
+}
+
+func (c *Cluster) provisionOSDContainer(osdProps osdProperties, copyBinariesMount v1.VolumeMount, provisionConfig *provisionConfig) (v1.Container, error) {
+	if err := config.ValidateStoreType(osdProps.storeConfig.StoreType); err != nil {
+		return v1.Container{}, errors.Wrapf(err, "invalid %q for %q", config.StoreTypeKey, osdProps.crushHostname)
+	}
+	if osdProps.storeConfig.BlueStoreRocksDBOptions != "" {
+		if err := config.ValidateRocksDBOptions(osdProps.storeConfig.BlueStoreRocksDBOptions); err != nil {
+			return v1.Container{}, errors.Wrapf(err, "invalid %q for %q", config.BlueStoreRocksDBOptionsKey, osdProps.crushHostname)
 		}
-		envVars = append(envVars, dataDevicesEnvVar(string(marshalledDevices)))
-	} else if osdProps.selection.DeviceFilter != "" {
-		envVars = append(envVars, deviceFilterEnvVar(osdProps.selection.DeviceFilter))
-	} else if osdProps.selection.DevicePathFilter != "" {
-		envVars = append(envVars, devicePathFilterEnvVar(osdProps.selection.DevicePathFilter))
-	} else if osdProps.selection.GetUseAllDevices() {
-		envVars = append(envVars, deviceFilterEnvVar("all"))
+	}
+	if osdProps.storeConfig.PGAutoscaleMode != "" {
+		if err := config.ValidatePGAutoscaleMode(osdProps.storeConfig.PGAutoscaleMode); err != nil {
+			return v1.Container{}, errors.Wrapf(err, "invalid %q for %q", config.PGAutoscaleModeKey, osdProps.crushHostname)
+		}
+	}
+
+	envVars := c.getConfigEnvVars(osdProps, k8sutil.DataDir)
+
+	// enable debug logging in the prepare job
+	envVars = append(envVars, setDebugLogLevelEnvVar(true))
+
+	selection, err := getDeviceSelection(osdProps)
+	if err != nil {
+		return v1.Container{}, err
+	}
+	if err := c.validateZonedDevices(osdProps, selection); err != nil {
+		return v1.Container{}, err
+	}
+	if err := c.validateRawMode(osdProps); err != nil {
+		return v1.Container{}, err
+	}
+	selectionEnvVars, err := selection.ToEnvVars()
+	if err != nil {
+		return v1.Container{}, errors.Wrapf(err, "invalid device selection on node %q", osdProps.crushHostname)
+	}
+	envVars = append(envVars, selectionEnvVars...)
+	if c.spec.Storage.StrictDeviceCheck {
+		envVars = append(envVars, strictDeviceCheckEnvVar())
+	}
+	if c.spec.Storage.WipeDevicesOnProvision && !osdProps.onPVC() {
+		envVars = append(envVars, wipeDeviceOnProvisionEnvVar())
+	}
+	if c.spec.Storage.MaxOSDsPerNode > 0 && !osdProps.onPVC() {
+		envVars = append(envVars, maxNewOSDsEnvVar(osdProps.maxNewOSDs))
 	}
 	envVars = append(envVars, v1.EnvVar{Name: "ROOK_CEPH_VERSION", Value: c.clusterInfo.CephVersion.CephVersionFormatted()})
 	envVars = append(envVars, crushDeviceClassEnvVar(osdProps.storeConfig.DeviceClass))
 	envVars = append(envVars, crushInitialWeightEnvVar(osdProps.storeConfig.InitialWeight))
 
 	if osdProps.metadataDevice != "" {
-		envVars = append(envVars, metadataDeviceEnvVar(osdProps.metadataDevice))
+		metadataDevice, err := normalizeMetadataDevice(osdProps.metadataDevice)
+		if err != nil {
+			return v1.Container{}, errors.Wrapf(err, "invalid metadata device for %q", osdProps.crushHostname)
+		}
+		envVars = append(envVars, metadataDeviceEnvVar(metadataDevice))
 	}
 
 	volumeMounts := append(controller.CephVolumeMounts(provisionConfig.DataPathMap, true), []v1.VolumeMount{
@@ -242,7 +449,7 @@ func (c *Cluster) provisionOSDContainer(osdProps osdProperties, copyBinariesMoun
 	}...)
 
 	// If not running on PVC we mount the rootfs of the host to validate the presence of the LVM package
-	if !osdProps.onPVC() {
+	if !osdProps.onPVC() && !usesRawModeOnly(osdProps) {
 		volumeMounts = append(volumeMounts, v1.VolumeMount{Name: "rootfs", MountPath: "/rootfs", ReadOnly: true})
 	}
 
@@ -303,8 +510,8 @@ func (c *Cluster) provisionOSDContainer(osdProps osdProperties, copyBinariesMoun
 	readOnlyRootFilesystem := false
 
 	osdProvisionContainer := v1.Container{
-		Command:      []string{path.Join(rookBinariesMountPath, "tini")},
-		Args:         []string{"--", path.Join(rookBinariesMountPath, "rook"), "ceph", "osd", "provision"},
+		Command:      []string{path.Join(copyBinariesMount.MountPath, "tini")},
+		Args:         []string{"--", path.Join(copyBinariesMount.MountPath, "rook"), "ceph", "osd", "provision"},
 		Name:         "provision",
 		Image:        c.spec.CephVersion.Image,
 		VolumeMounts: volumeMounts,
@@ -315,7 +522,13 @@ func (c *Cluster) provisionOSDContainer(osdProps osdProperties, copyBinariesMoun
 			RunAsNonRoot:           &runAsNonRoot,
 			ReadOnlyRootFilesystem: &readOnlyRootFilesystem,
 		},
-		Resources: cephv1.GetPrepareOSDResources(c.spec.Resources),
+		Resources: c.getPrepareOSDResources(osdProps),
+	}
+
+	// a custom command replaces the rook binary, the env vars and the volume mounts are kept
+	if len(c.spec.Storage.ProvisionCommand) > 0 {
+		osdProvisionContainer.Command = c.spec.Storage.ProvisionCommand
+		osdProvisionContainer.Args = nil
 	}
 
 	return osdProvisionContainer, nil
@@ -0,0 +1,67 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"github.com/pkg/errors"
+	osdconfig "github.com/rook/rook/pkg/operator/ceph/cluster/osd/config"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// recommendedOSDMemoryMB is the memory recommended per bluestore OSD, it matches the default
+	// osd_memory_target of Ceph
+	recommendedOSDMemoryMB int64 = 4096
+)
+
+// recommendedOSDMilliCPU is the CPU recommended per OSD for each device class, the OSDs on faster
+// devices need more CPU to serve their IOPS
+var recommendedOSDMilliCPU = map[string]int64{
+	"hdd":  1000,
+	"ssd":  2000,
+	"nvme": 4000,
+}
+
+// RecommendedResources returns the resource requests and limits recommended for the given number of
+// OSDs of a device class and store type. The OSDs of an unknown device class get the recommendation of
+// the hdd OSDs. Only the memory is limited, a CPU limit would throttle the OSDs during recoveries.
+func RecommendedResources(osdCount int, deviceClass, storeType string) (v1.ResourceRequirements, error) {
+	if osdCount < 1 {
+		return v1.ResourceRequirements{}, errors.Errorf("invalid osd count %d, must be at least 1", osdCount)
+	}
+	if err := osdconfig.ValidateStoreType(storeType); err != nil {
+		return v1.ResourceRequirements{}, err
+	}
+
+	milliCPU, ok := recommendedOSDMilliCPU[deviceClass]
+	if !ok {
+		milliCPU = recommendedOSDMilliCPU["hdd"]
+	}
+	cpu := resource.NewMilliQuantity(milliCPU*int64(osdCount), resource.DecimalSI)
+	memory := resource.NewQuantity(recommendedOSDMemoryMB*1024*1024*int64(osdCount), resource.BinarySI)
+
+	return v1.ResourceRequirements{
+		Requests: v1.ResourceList{
+			v1.ResourceCPU:    *cpu,
+			v1.ResourceMemory: *memory,
+		},
+		Limits: v1.ResourceList{
+			v1.ResourceMemory: *memory,
+		},
+	}, nil
+}
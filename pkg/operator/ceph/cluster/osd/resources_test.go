@@ -0,0 +1,62 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecommendedResources(t *testing.T) {
+	// one cpu and 4Gi per hdd bluestore osd
+	r, err := RecommendedResources(1, "hdd", "bluestore")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", r.Requests.Cpu().String())
+	assert.Equal(t, "4Gi", r.Requests.Memory().String())
+	assert.Equal(t, "4Gi", r.Limits.Memory().String())
+	assert.True(t, r.Limits.Cpu().IsZero())
+
+	// the recommendation scales with the number of osds
+	r, err = RecommendedResources(3, "hdd", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "3", r.Requests.Cpu().String())
+	assert.Equal(t, "12Gi", r.Requests.Memory().String())
+	assert.Equal(t, "12Gi", r.Limits.Memory().String())
+
+	// faster devices need more cpu
+	r, err = RecommendedResources(2, "ssd", "bluestore")
+	assert.NoError(t, err)
+	assert.Equal(t, "4", r.Requests.Cpu().String())
+	assert.Equal(t, "8Gi", r.Requests.Memory().String())
+	r, err = RecommendedResources(1, "nvme", "bluestore")
+	assert.NoError(t, err)
+	assert.Equal(t, "4", r.Requests.Cpu().String())
+
+	// an unknown device class gets the hdd recommendation
+	r, err = RecommendedResources(1, "archive", "bluestore")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", r.Requests.Cpu().String())
+
+	// the unsupported store types and counts fail
+	_, err = RecommendedResources(1, "hdd", "filestore")
+	assert.Error(t, err)
+	_, err = RecommendedResources(1, "hdd", "unknown")
+	assert.Error(t, err)
+	_, err = RecommendedResources(0, "hdd", "bluestore")
+	assert.Error(t, err)
+}
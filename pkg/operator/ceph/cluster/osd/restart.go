@@ -0,0 +1,126 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	cephclient "github.com/rook/rook/pkg/daemon/ceph/client"
+	"github.com/rook/rook/pkg/operator/k8sutil"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+const (
+	// restartedAtAnnotation is the pod template annotation also used by 'kubectl rollout restart'
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+)
+
+var (
+	// allow unit tests to override these values
+	isClusterCleanFunc           = cephclient.IsClusterClean
+	restartDeploymentAndWaitFunc = k8sutil.UpdateDeploymentAndWait
+	restartCleanCheckInterval    = 10 * time.Second
+	restartCleanCheckRetries     = 90
+)
+
+// RestartOSDsOneByOne restarts the given OSD deployments in order, one at a time. Before each
+// restart, it waits for all the PGs to be active+clean so that restarting an OSD never makes data
+// unavailable. The rollout stops with an error if the PGs are not clean in time or if a restarted
+// OSD does not come back.
+func (c *Cluster) RestartOSDsOneByOne(deployments []*appsv1.Deployment) error {
+	for i, d := range deployments {
+		restarted := d.DeepCopy()
+		if restarted.Spec.Template.Annotations == nil {
+			restarted.Spec.Template.Annotations = map[string]string{}
+		}
+		restarted.Spec.Template.Annotations[restartedAtAnnotation] = time.Now().Format(time.RFC3339)
+
+		logger.Infof("restarting osd deployment %q (%d of %d)", d.Name, i+1, len(deployments))
+		verifyCallback := func(action string) error {
+			if action != "stop" {
+				return nil
+			}
+			return c.waitForCleanPGs()
+		}
+		if err := restartDeploymentAndWaitFunc(c.context, restarted, c.clusterInfo.Namespace, verifyCallback); err != nil {
+			return errors.Wrapf(err, "failed to restart osd deployment %q, %d of %d osds were restarted", d.Name, i, len(deployments))
+		}
+	}
+	return nil
+}
+
+// OSDRestartRequired returns true if the OSD pods must be restarted to go from the old OSD and pod
+// template to the new ones. The store type of the OSD, the volumes of the pod and the image, command,
+// args, env vars, resources, volume mounts and volume devices of the containers and init containers
+// are compared. The other differences, e.g. of the labels or the annotations, do not require a
+// restart. Both templates are expected to be generated by Rook, a template read from the API server
+// has defaulted fields that would always differ.
+func OSDRestartRequired(oldOSD, newOSD OSDInfo, oldSpec, newSpec *v1.PodTemplateSpec) bool {
+	if oldOSD.Store != newOSD.Store {
+		return true
+	}
+	// semantic equality compares the resource quantities by value and nil slices equal to empty ones
+	if !equality.Semantic.DeepEqual(oldSpec.Spec.Volumes, newSpec.Spec.Volumes) {
+		return true
+	}
+	return restartRequiredForContainers(oldSpec.Spec.InitContainers, newSpec.Spec.InitContainers) ||
+		restartRequiredForContainers(oldSpec.Spec.Containers, newSpec.Spec.Containers)
+}
+
+func restartRequiredForContainers(old, new []v1.Container) bool {
+	if len(old) != len(new) {
+		return true
+	}
+	for i := range new {
+		c := findContainer(old, new[i].Name)
+		if c == nil {
+			return true
+		}
+		if c.Image != new[i].Image ||
+			!equality.Semantic.DeepEqual(c.Command, new[i].Command) ||
+			!equality.Semantic.DeepEqual(c.Args, new[i].Args) ||
+			!equality.Semantic.DeepEqual(c.Env, new[i].Env) ||
+			!equality.Semantic.DeepEqual(c.Resources, new[i].Resources) ||
+			!equality.Semantic.DeepEqual(c.VolumeMounts, new[i].VolumeMounts) ||
+			!equality.Semantic.DeepEqual(c.VolumeDevices, new[i].VolumeDevices) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForCleanPGs waits for all the PGs to be active+clean
+func (c *Cluster) waitForCleanPGs() error {
+	var msg string
+	for i := 0; i < restartCleanCheckRetries; i++ {
+		var clean bool
+		var err error
+		msg, clean, err = isClusterCleanFunc(c.context, c.clusterInfo)
+		if err != nil {
+			logger.Warningf("failed to check whether the pgs are clean. %v", err)
+		} else if clean {
+			return nil
+		} else {
+			logger.Infof("waiting for the pgs to be clean before restarting the next osd. %s", msg)
+		}
+		time.Sleep(restartCleanCheckInterval)
+	}
+	return errors.Errorf("pgs are not clean after %d checks. %s", restartCleanCheckRetries, msg)
+}
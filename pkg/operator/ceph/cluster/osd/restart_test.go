@@ -0,0 +1,192 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/rook/rook/pkg/clusterd"
+	cephclient "github.com/rook/rook/pkg/daemon/ceph/client"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRestartOSDsOneByOne(t *testing.T) {
+	oldCleanFunc := isClusterCleanFunc
+	oldRestartFunc := restartDeploymentAndWaitFunc
+	oldInterval := restartCleanCheckInterval
+	oldRetries := restartCleanCheckRetries
+	defer func() {
+		isClusterCleanFunc = oldCleanFunc
+		restartDeploymentAndWaitFunc = oldRestartFunc
+		restartCleanCheckInterval = oldInterval
+		restartCleanCheckRetries = oldRetries
+	}()
+	restartCleanCheckInterval = 0
+	restartCleanCheckRetries = 5
+
+	// events records the health checks and the restarts in the order they happen
+	var events []string
+	uncleanChecks := 0 // the number of health checks reporting unclean PGs before they are clean
+	isClusterCleanFunc = func(context *clusterd.Context, clusterInfo *cephclient.ClusterInfo) (string, bool, error) {
+		if uncleanChecks > 0 {
+			uncleanChecks--
+			events = append(events, "unclean")
+			return "pgs are recovering", false, nil
+		}
+		events = append(events, "clean")
+		return "all pgs are clean", true, nil
+	}
+	restartDeploymentAndWaitFunc = func(clusterContext *clusterd.Context, d *appsv1.Deployment, namespace string, verifyCallback func(action string) error) error {
+		if err := verifyCallback("stop"); err != nil {
+			return err
+		}
+		assert.NotEmpty(t, d.Spec.Template.Annotations[restartedAtAnnotation])
+		events = append(events, "restart "+d.Name)
+		return verifyCallback("continue")
+	}
+
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	newDeployments := func() []*appsv1.Deployment {
+		deployments := []*appsv1.Deployment{}
+		for _, name := range []string{"rook-ceph-osd-2", "rook-ceph-osd-0", "rook-ceph-osd-1"} {
+			deployments = append(deployments, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns"}})
+		}
+		return deployments
+	}
+
+	t.Run("osds are restarted in order once the pgs are clean", func(t *testing.T) {
+		events = nil
+		deployments := newDeployments()
+		err := c.RestartOSDsOneByOne(deployments)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"clean", "restart rook-ceph-osd-2",
+			"clean", "restart rook-ceph-osd-0",
+			"clean", "restart rook-ceph-osd-1",
+		}, events)
+		// the given deployments are not modified
+		assert.Empty(t, deployments[0].Spec.Template.Annotations)
+	})
+
+	t.Run("unclean pgs pause the rollout", func(t *testing.T) {
+		events = nil
+		uncleanChecks = 2
+		err := c.RestartOSDsOneByOne(newDeployments())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"unclean", "unclean", "clean", "restart rook-ceph-osd-2",
+			"clean", "restart rook-ceph-osd-0",
+			"clean", "restart rook-ceph-osd-1",
+		}, events)
+	})
+
+	t.Run("the rollout stops if the pgs do not become clean", func(t *testing.T) {
+		events = nil
+		uncleanChecks = 100
+		err := c.RestartOSDsOneByOne(newDeployments())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "0 of 3 osds were restarted")
+		assert.Equal(t, []string{"unclean", "unclean", "unclean", "unclean", "unclean"}, events)
+	})
+
+	t.Run("the rollout stops if the health check keeps failing", func(t *testing.T) {
+		events = nil
+		uncleanChecks = 0
+		isClusterCleanFunc = func(context *clusterd.Context, clusterInfo *cephclient.ClusterInfo) (string, bool, error) {
+			return "unable to get PG health", false, errors.New("induced failure")
+		}
+		err := c.RestartOSDsOneByOne(newDeployments())
+		assert.Error(t, err)
+		assert.Empty(t, events)
+	})
+}
+
+func TestOSDRestartRequired(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		resources: v1.ResourceRequirements{
+			Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("4Gi")},
+		},
+	}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw", Store: "bluestore", Location: "root=default host=node1"}
+	d, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	oldSpec := &d.Spec.Template
+
+	t.Run("cosmetic changes", func(t *testing.T) {
+		assert.False(t, OSDRestartRequired(osd, osd, oldSpec, oldSpec.DeepCopy()))
+
+		newSpec := oldSpec.DeepCopy()
+		newSpec.Labels["foo"] = "bar"
+		newSpec.Annotations = map[string]string{"foo": "bar"}
+		// equivalent quantities are the same resources
+		newSpec.Spec.Containers[0].Resources.Limits[v1.ResourceMemory] = resource.MustParse("4096Mi")
+		newOSD := osd
+		newOSD.Location = "root=default host=node2"
+		assert.False(t, OSDRestartRequired(osd, newOSD, oldSpec, newSpec))
+	})
+
+	t.Run("meaningful changes", func(t *testing.T) {
+		for name, change := range map[string]func(spec *v1.PodSpec){
+			"image":     func(spec *v1.PodSpec) { spec.Containers[0].Image = "ceph/ceph:v16" },
+			"command":   func(spec *v1.PodSpec) { spec.Containers[0].Command = []string{"/bin/sh"} },
+			"args":      func(spec *v1.PodSpec) { spec.Containers[0].Args = append(spec.Containers[0].Args, "--debug-osd=20") },
+			"resources": func(spec *v1.PodSpec) { spec.Containers[0].Resources = v1.ResourceRequirements{} },
+			"env": func(spec *v1.PodSpec) {
+				spec.Containers[0].Env = append(spec.Containers[0].Env, v1.EnvVar{Name: "FOO", Value: "bar"})
+			},
+			"env value": func(spec *v1.PodSpec) { spec.Containers[0].Env[0].Value = "changed" },
+			"mount": func(spec *v1.PodSpec) {
+				spec.Containers[0].VolumeMounts = append(spec.Containers[0].VolumeMounts, v1.VolumeMount{Name: "extra", MountPath: "/extra"})
+			},
+			"mount path": func(spec *v1.PodSpec) { spec.Containers[0].VolumeMounts[0].MountPath = "/changed" },
+			"device": func(spec *v1.PodSpec) {
+				spec.Containers[0].VolumeDevices = []v1.VolumeDevice{{Name: "pvc", DevicePath: "/pvc"}}
+			},
+			"volume": func(spec *v1.PodSpec) {
+				spec.Volumes = append(spec.Volumes, v1.Volume{Name: "extra", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}})
+			},
+			"volume source": func(spec *v1.PodSpec) {
+				spec.Volumes[0].VolumeSource = v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/changed"}}
+			},
+			"init container": func(spec *v1.PodSpec) { spec.InitContainers[0].Image = "ceph/ceph:v16" },
+			"init env": func(spec *v1.PodSpec) {
+				spec.InitContainers[0].Env = append(spec.InitContainers[0].Env, v1.EnvVar{Name: "FOO", Value: "bar"})
+			},
+			"removed": func(spec *v1.PodSpec) { spec.InitContainers = spec.InitContainers[1:] },
+			"added": func(spec *v1.PodSpec) {
+				spec.Containers = append(spec.Containers, v1.Container{Name: "sidecar", Image: "sidecar"})
+			},
+		} {
+			newSpec := oldSpec.DeepCopy()
+			change(&newSpec.Spec)
+			assert.True(t, OSDRestartRequired(osd, osd, oldSpec, newSpec), name)
+		}
+
+		newOSD := osd
+		newOSD.Store = "filestore"
+		assert.True(t, OSDRestartRequired(osd, newOSD, oldSpec, oldSpec.DeepCopy()))
+	})
+}
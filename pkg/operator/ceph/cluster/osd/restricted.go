@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+)
+
+// validateRestrictedMode checks that the storage spec is compatible with the restricted mode, where
+// the OSDs run without host paths, host namespaces or privileged containers. Only the OSDs on PVC
+// of the device sets can run in restricted mode.
+func (c *Cluster) validateRestrictedMode() error {
+	if !c.spec.Storage.Restricted {
+		return nil
+	}
+	if c.spec.Storage.UseAllNodes || len(c.spec.Storage.Nodes) > 0 {
+		return errors.New("the devices of the nodes cannot be used in restricted mode, only the storageClassDeviceSets")
+	}
+	if !c.clusterInfo.CephVersion.IsAtLeastOctopus() {
+		return errors.Errorf("restricted mode requires ceph octopus or newer to run the osds without the host pid namespace, the cluster runs %q", c.clusterInfo.CephVersion.String())
+	}
+	if c.spec.Network.IsHost() {
+		return errors.New("restricted mode cannot be used with host networking")
+	}
+	for _, deviceSet := range c.spec.Storage.StorageClassDeviceSets {
+		if deviceSet.Encrypted {
+			return errors.Errorf("the osds of encrypted storageClassDeviceSet %q cannot run in restricted mode", deviceSet.Name)
+		}
+	}
+	return nil
+}
+
+// applyRestrictedMode removes the host paths and the privileges from the pod of the OSD. The host
+// paths of the logs, the crashes and the PVC bridge are replaced by emptyDirs, the udev mount is
+// removed and the privileged containers are granted the capabilities of the OSD and the devices of
+// the PVCs instead.
+func applyRestrictedMode(spec *v1.PodSpec, osdProps osdProperties, osd OSDInfo) error {
+	if !supportsUnprivilegedDaemon(osdProps, osd) {
+		return errors.Errorf("osd %d cannot run in restricted mode since it is not a raw mode osd on pvc without encryption", osd.ID)
+	}
+
+	volumes := make([]v1.Volume, 0, len(spec.Volumes))
+	for _, volume := range spec.Volumes {
+		if volume.Name == udevVolName {
+			continue
+		}
+		if volume.HostPath != nil {
+			volume.VolumeSource = v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}
+		}
+		volumes = append(volumes, volume)
+	}
+	spec.Volumes = volumes
+
+	restrictContainer := func(container *v1.Container) {
+		mounts := make([]v1.VolumeMount, 0, len(container.VolumeMounts))
+		for _, mount := range container.VolumeMounts {
+			if mount.Name != udevVolName {
+				mounts = append(mounts, mount)
+			}
+		}
+		container.VolumeMounts = mounts
+		if container.SecurityContext != nil && container.SecurityContext.Privileged != nil && *container.SecurityContext.Privileged {
+			container.SecurityContext = capabilitiesSecurityContext(container.SecurityContext)
+			// the containers that were privileged may access the block devices copied to the bridge,
+			// which the device cgroup only allows if the devices of the PVCs are attached
+			if len(container.VolumeDevices) == 0 {
+				container.VolumeDevices = getPVCVolumeDevices(osdProps)
+			}
+		}
+	}
+	for i := range spec.InitContainers {
+		restrictContainer(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		restrictContainer(&spec.Containers[i])
+	}
+
+	spec.HostPID = false
+	spec.HostIPC = false
+	return nil
+}
+
+// validateRestrictedPodSpec checks that the pod does not use any host path, host namespace or
+// privileged container, e.g. added by the extra volumes of the storage spec
+func validateRestrictedPodSpec(spec *v1.PodSpec) error {
+	if spec.HostNetwork || spec.HostPID || spec.HostIPC {
+		return errors.New("the host namespaces cannot be used in restricted mode")
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			return errors.Errorf("host path volume %q cannot be used in restricted mode", volume.Name)
+		}
+	}
+	for _, container := range append(append([]v1.Container{}, spec.InitContainers...), spec.Containers...) {
+		if container.SecurityContext != nil && container.SecurityContext.Privileged != nil && *container.SecurityContext.Privileged {
+			return errors.Errorf("privileged container %q cannot be used in restricted mode", container.Name)
+		}
+	}
+	return nil
+}
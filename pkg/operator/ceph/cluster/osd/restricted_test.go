@@ -0,0 +1,108 @@
+/*
+Copyright 2021 The Rook Authors. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package osd
+
+import (
+	"testing"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	cephver "github.com/rook/rook/pkg/operator/ceph/version"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestValidateRestrictedMode(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	c.spec.Storage.StorageClassDeviceSets = []cephv1.StorageClassDeviceSet{{Name: "set1"}}
+	assert.NoError(t, c.validateRestrictedMode())
+
+	c.spec.Storage.Restricted = true
+	assert.NoError(t, c.validateRestrictedMode())
+
+	for name, change := range map[string]func(c *Cluster){
+		"all nodes":    func(c *Cluster) { c.spec.Storage.UseAllNodes = true },
+		"nodes":        func(c *Cluster) { c.spec.Storage.Nodes = []cephv1.Node{{Name: "node1"}} },
+		"host network": func(c *Cluster) { c.spec.Network.Provider = "host" },
+		"nautilus":     func(c *Cluster) { c.clusterInfo.CephVersion = cephver.Nautilus },
+		"encrypted": func(c *Cluster) {
+			c.spec.Storage.StorageClassDeviceSets = []cephv1.StorageClassDeviceSet{{Name: "set1", Encrypted: true}}
+		},
+	} {
+		c := newTestCluster(t, cephv1.ClusterSpec{})
+		c.spec.Storage.Restricted = true
+		change(c)
+		assert.Error(t, c.validateRestrictedMode(), name)
+	}
+}
+
+func TestRestrictedOSDDeployment(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{DataDirHostPath: "/var/lib/rook"})
+	c.spec.Storage.Restricted = true
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", CVMode: "raw"}
+	pvcProps := osdProperties{
+		crushHostname: "node1",
+		pvc:           v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"},
+		metadataPVC:   v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc-metadata"},
+		portable:      true,
+	}
+
+	deployment, err := c.makeDeployment(pvcProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	spec := deployment.Spec.Template.Spec
+	assert.NoError(t, validateRestrictedPodSpec(&spec))
+	assert.False(t, spec.HostPID)
+	assert.False(t, spec.HostIPC)
+	assert.False(t, spec.HostNetwork)
+	for _, volume := range spec.Volumes {
+		assert.Nil(t, volume.HostPath, volume.Name)
+		assert.NotEqual(t, udevVolName, volume.Name)
+	}
+	for _, container := range append(spec.InitContainers, spec.Containers...) {
+		if container.SecurityContext != nil && container.SecurityContext.Privileged != nil {
+			assert.False(t, *container.SecurityContext.Privileged, container.Name)
+		}
+		for _, mount := range container.VolumeMounts {
+			assert.NotEqual(t, udevVolName, mount.Name, container.Name)
+		}
+	}
+	// the daemon and the init containers reading the block devices are given the devices of the pvcs
+	devices := []v1.VolumeDevice{
+		{Name: "mypvc", DevicePath: "/mypvc"},
+		{Name: "mypvc-metadata", DevicePath: "/mypvc-metadata"},
+	}
+	assert.Equal(t, devices, spec.Containers[0].VolumeDevices)
+	for _, init := range spec.InitContainers {
+		switch init.Name {
+		case activatePVCOSDInitContainer, blockPVCMapperInitContainer, blockPVCMetadataMapperInitContainer:
+			assert.NotEmpty(t, init.VolumeDevices, init.Name)
+		case expandPVCOSDInitContainer:
+			assert.Equal(t, devices, init.VolumeDevices, init.Name)
+		}
+	}
+
+	// the osds needing privileges cannot run in restricted mode
+	_, err = c.makeDeployment(osdProperties{crushHostname: "node1"}, osd, dataPathMap)
+	assert.Error(t, err)
+	_, err = c.makeDeployment(pvcProps, OSDInfo{ID: 0, UUID: "uuid-0", CVMode: "lvm"}, dataPathMap)
+	assert.Error(t, err)
+
+	// host path extra volumes are refused
+	c.spec.Storage.ExtraVolumes = []v1.Volume{{Name: "extra", VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/extra"}}}}
+	_, err = c.makeDeployment(pvcProps, osd, dataPathMap)
+	assert.Error(t, err)
+}
@@ -18,21 +18,26 @@ limitations under the License.
 package osd
 
 import (
+	"context"
 	"fmt"
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/libopenstorage/secrets"
 	"github.com/pkg/errors"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	kms "github.com/rook/rook/pkg/daemon/ceph/osd/kms"
+	osdconfig "github.com/rook/rook/pkg/operator/ceph/cluster/osd/config"
 	opconfig "github.com/rook/rook/pkg/operator/ceph/config"
 	"github.com/rook/rook/pkg/operator/ceph/controller"
 	"github.com/rook/rook/pkg/operator/k8sutil"
 	apps "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 const (
@@ -54,6 +59,11 @@ const (
 	expandPVCOSDInitContainer                     = "expand-bluefs"
 	expandEncryptedPVCOSDInitContainer            = "expand-encrypted-bluefs"
 	encryptedPVCStatusOSDInitContainer            = "encrypted-block-status"
+	validateDataPathInitContainer                 = "validate-data-path"
+	restartThrottleInitContainer                  = "restart-throttle"
+	restartThrottleVolumeName                     = "rook-restart-throttle"
+	defaultRestartThrottleFailureThreshold        = 3
+	defaultRestartThrottleWindowSeconds           = 600
 	encryptionKeyFileName                         = "luks_key"
 	// DmcryptBlockType is a portion of the device mapper name for the encrypted OSD on PVC block.db (rocksdb db)
 	DmcryptBlockType = "block-dmcrypt"
@@ -64,6 +74,10 @@ const (
 	bluestoreBlockName    = "block"
 	bluestoreMetadataName = "block.db"
 	bluestoreWalName      = "block.wal"
+	// defaultClusterName is the name of the ceph cluster of the OSDs without a cluster name
+	defaultClusterName = "ceph"
+	// minOSDMemoryTarget is the smallest osd_memory_target accepted by Ceph, 896MiB
+	minOSDMemoryTarget = 896 * 1024 * 1024
 )
 
 const (
@@ -268,6 +282,51 @@ python3 -c "import sys, json; print(json.load(sys.stdin)${PYTHON_DATA_PARSE}[\"$
 
 # purge payload file
 rm -f "$CURL_PAYLOAD"
+`
+
+	validateDataPathCode = `
+OSD_DATA_DIR=%s
+
+if [ ! -d "$OSD_DATA_DIR" ]; then
+	echo "osd data path $OSD_DATA_DIR does not exist, the osd was not activated" >&2
+	exit 1
+fi
+if [ ! -w "$OSD_DATA_DIR" ]; then
+	echo "osd data path $OSD_DATA_DIR is not writable" >&2
+	exit 1
+fi
+echo "osd data path $OSD_DATA_DIR exists and is writable"
+`
+
+	// The marker file holds the number of failed starts in a row and the time of the last start. A start
+	// within the window of the previous one is a failed start, the OSD did not stay up in between.
+	restartThrottleCode = `
+MARKER=%s
+FAILURE_THRESHOLD=%d
+DELAY_SECONDS=%d
+WINDOW_SECONDS=%d
+
+failures=0
+last_start=0
+if [ -f "$MARKER" ]; then
+	read -r failures last_start < "$MARKER" || true
+fi
+[[ "$failures" =~ ^[0-9]+$ ]] || failures=0
+[[ "$last_start" =~ ^[0-9]+$ ]] || last_start=0
+
+now=$(date +%%s)
+if [ $((now - last_start)) -lt "$WINDOW_SECONDS" ]; then
+	failures=$((failures + 1))
+else
+	failures=0
+fi
+mkdir -p "$(dirname "$MARKER")"
+echo "$failures $now" > "$MARKER"
+
+if [ "$failures" -gt "$FAILURE_THRESHOLD" ]; then
+	echo "the osd failed to start $failures times in a row, delaying its start by $DELAY_SECONDS seconds"
+	sleep "$DELAY_SECONDS"
+fi
 `
 
 	// If the disk identifier changes (different major and minor) we must force copy
@@ -363,7 +422,7 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 	// If the OSD runs on PVC
 	if osdProps.onPVC() {
 		// Create volume config for PVCs
-		volumes = append(volumes, getPVCOSDVolumes(&osdProps, c.spec.DataDirHostPath, c.clusterInfo.Namespace, false)...)
+		volumes = append(volumes, getPVCOSDVolumes(&osdProps, c.spec.DataDirHostPath, c.clusterInfo.Namespace, false, c.bridgeVolumeEmptyDir())...)
 		// If encrypted let's add the secret key mount path
 		if osdProps.encrypted && osd.CVMode == "raw" {
 			encryptedVol, _ := c.getEncryptionVolume(osdProps)
@@ -409,48 +468,20 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 		getTcmallocMaxTotalThreadCacheBytes(""),
 	}...)
 
-	var command []string
-	var args []string
-	// If the OSD was prepared with ceph-volume and running on PVC and using the LVM mode
-	if osdProps.onPVC() && osd.CVMode == "lvm" {
-		// if the osd was provisioned by ceph-volume, we need to launch it with rook as the parent process
-		command = []string{path.Join(rookBinariesMountPath, "tini")}
-		args = []string{
-			"--", path.Join(rookBinariesMountPath, "rook"),
-			"ceph", "osd", "start",
-			"--",
-			"--foreground",
-			"--id", osdID,
-			"--fsid", c.clusterInfo.FSID,
-			"--cluster", "ceph",
-			"--setuser", "ceph",
-			"--setgroup", "ceph",
-			fmt.Sprintf("--crush-location=%s", osd.Location),
-		}
-	} else if osdProps.onPVC() && osd.CVMode == "raw" {
-		doBinaryCopyInit = false
-		doConfigInit = false
-		command = []string{"ceph-osd"}
-		args = []string{
-			"--foreground",
-			"--id", osdID,
-			"--fsid", c.clusterInfo.FSID,
-			"--setuser", "ceph",
-			"--setgroup", "ceph",
-			fmt.Sprintf("--crush-location=%s", osd.Location),
-		}
-	} else {
+	crushLocation, err := overrideCrushLocation(osd.Location, c.spec.Storage.OSDCrushLocations[osdID])
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to set the crush location of osd %d", osd.ID)
+	}
+
+	binariesDir, err := c.getBinariesMountPath()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to configure the binaries of osd %d", osd.ID)
+	}
+	command, args := BuildOSDContainerCommandInDir(osd, osdProps.onPVC(), c.clusterInfo.FSID, crushLocation, binariesDir)
+	// only the lvm OSDs on PVC are launched by the rook binary with the ceph.conf initialized by rook
+	if !osdProps.onPVC() || osd.CVMode != "lvm" {
 		doBinaryCopyInit = false
 		doConfigInit = false
-		command = []string{"ceph-osd"}
-		args = []string{
-			"--foreground",
-			"--id", osdID,
-			"--fsid", c.clusterInfo.FSID,
-			"--setuser", "ceph",
-			"--setgroup", "ceph",
-			fmt.Sprintf("--crush-location=%s", osd.Location),
-		}
 	}
 
 	// Ceph expects initial weight as float value in tera-bytes units
@@ -458,6 +489,16 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 		args = append(args, fmt.Sprintf("--osd-crush-initial-weight=%s", osdProps.storeConfig.InitialWeight))
 	}
 
+	adminSocketDir, err := c.getAdminSocketDir()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to configure the admin socket of osd %d", osd.ID)
+	}
+	daemonArgs, err := c.getDaemonArgs(osdProps, osd, adminSocketDir)
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, daemonArgs...)
+
 	// If the OSD runs on PVC
 	if osdProps.onPVC() {
 		// add the PVC size to the pod spec so that if the size changes the OSD will be restarted and pick up the change
@@ -467,18 +508,21 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 			envVars = append(envVars, v1.EnvVar{Name: "ROOK_TOPOLOGY_AFFINITY", Value: osd.TopologyAffinity})
 		}
 
-		// Append slow tuning flag if necessary
+		// Append slow tuning flag if necessary, the settings configured by the user are not overridden
 		if osdProps.tuneSlowDeviceClass {
-			args = append(args, defaultTuneSlowSettings...)
+			args = append(args, unsetFlags(args, defaultTuneSlowSettings)...)
 		} else if osdProps.tuneFastDeviceClass { // Append fast tuning flag if necessary
-			args = append(args, defaultTuneFastSettings...)
+			args = append(args, unsetFlags(args, defaultTuneFastSettings)...)
 		}
 	}
 
 	// The osd itself needs to talk to udev to report information about the device (vendor/serial etc)
-	udevVolume, udevVolumeMount := getUdevVolume()
-	volumes = append(volumes, udevVolume)
-	volumeMounts = append(volumeMounts, udevVolumeMount)
+	// The raw osds of the nodes required to run without the host's udev and lvm do not mount it
+	if !usesRawModeOnly(osdProps) || osd.CVMode != "raw" {
+		udevVolume, udevVolumeMount := getUdevVolume()
+		volumes = append(volumes, udevVolume)
+		volumeMounts = append(volumeMounts, udevVolumeMount)
+	}
 
 	// If the PV is encrypted let's mount the device mapper path
 	if osdProps.encrypted {
@@ -488,7 +532,7 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 	}
 
 	// Add the volume to the spec and the mount to the daemon container
-	copyBinariesVolume, copyBinariesContainer := c.getCopyBinariesContainer()
+	copyBinariesVolume, copyBinariesContainer := c.getCopyBinariesContainer(binariesDir)
 	if doBinaryCopyInit {
 		volumes = append(volumes, copyBinariesVolume)
 		volumeMounts = append(volumeMounts, copyBinariesContainer.VolumeMounts[0])
@@ -505,8 +549,7 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 	}
 
 	args = append(args, opconfig.LoggingFlags()...)
-	args = append(args, osdOnSDNFlag(c.spec.Network)...)
-	args = append(args, controller.NetworkBindingFlags(c.clusterInfo, &c.spec)...)
+	args = append(args, c.osdNetworkFlags()...)
 
 	osdDataDirPath := activateOSDMountPath + osdID
 	if osdProps.onPVC() && osd.CVMode == "lvm" {
@@ -534,10 +577,23 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 		ReadOnlyRootFilesystem: &readOnlyRootFilesystem,
 	}
 
+	securityContext, daemonSecurityContext, daemonVolumeDevices := c.getUnprivilegedSecurityContexts(securityContext, osdProps, osd)
+
 	// needed for luksOpen synchronization when devices are encrypted and the osd is prepared with LVM
-	hostIPC := osdProps.storeConfig.EncryptedDevice || osdProps.encrypted
+	hostIPC := osdProps.encryptsDevices()
 
 	initContainers := make([]v1.Container, 0, 4)
+	if c.spec.Storage.RestartThrottle != nil {
+		// the start is delayed before the osd is activated
+		throttleContainer, err := c.getRestartThrottleInitContainer(osdID, osdProps.onPVC(), securityContext)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to generate the restart throttle of osd %d", osd.ID)
+		}
+		initContainers = append(initContainers, throttleContainer)
+		if osdProps.onPVC() {
+			volumes = append(volumes, c.getRestartThrottleVolume())
+		}
+	}
 	if doConfigInit {
 		initContainers = append(initContainers,
 			v1.Container{
@@ -594,6 +650,10 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 		dataPath = activateOSDMountPath + osdID
 	}
 
+	if c.spec.Storage.ValidateDataPath {
+		initContainers = append(initContainers, c.getValidateDataPathInitContainer(osdProps, osdID, volumeMounts))
+	}
+
 	// Doing a chown in a post start lifecycle hook does not reliably complete before the OSD
 	// process starts, which can cause the pod to fail without the lifecycle hook's chown command
 	// completing. It can take an arbitrarily long time for a pod restart to successfully chown the
@@ -608,10 +668,13 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 			securityContext,
 		))
 
+	labels := c.getOSDLabels(osd, failureDomainValue, osdProps.portable)
+	addOSDDeviceClassLabels(labels, osd, osdProps.storeConfig)
+
 	podTemplateSpec := v1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:   AppName,
-			Labels: c.getOSDLabels(osd, failureDomainValue, osdProps.portable),
+			Labels: labels,
 		},
 		Spec: v1.PodSpec{
 			RestartPolicy:      v1.RestartPolicyAlways,
@@ -628,15 +691,18 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 					Name:            "osd",
 					Image:           c.spec.CephVersion.Image,
 					VolumeMounts:    volumeMounts,
+					VolumeDevices:   daemonVolumeDevices,
 					Env:             envVars,
 					Resources:       osdProps.resources,
-					SecurityContext: securityContext,
-					LivenessProbe:   controller.GenerateLivenessProbeExecDaemon(opconfig.OsdType, osdID),
+					SecurityContext: daemonSecurityContext,
+					LivenessProbe:   controller.GenerateLivenessProbeExecDaemonInDir(opconfig.OsdType, osdID, adminSocketDir),
+					ReadinessProbe:  controller.GenerateReadinessProbeExecOSDInDir(osdID, adminSocketDir),
 					WorkingDir:      opconfig.VarLogCephDir,
 				},
 			},
-			Volumes:       volumes,
-			SchedulerName: osdProps.schedulerName,
+			Volumes:          volumes,
+			SchedulerName:    c.getSchedulerName(osdProps),
+			RuntimeClassName: c.spec.Storage.RuntimeClassName,
 		},
 	}
 
@@ -652,6 +718,8 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 
 	// If the liveness probe is enabled
 	podTemplateSpec.Spec.Containers[0] = opconfig.ConfigureLivenessProbe(cephv1.KeyOSD, podTemplateSpec.Spec.Containers[0], c.spec.HealthCheck)
+	// If the readiness probe is enabled
+	podTemplateSpec.Spec.Containers[0] = opconfig.ConfigureReadinessProbe(cephv1.KeyOSD, podTemplateSpec.Spec.Containers[0], c.spec.HealthCheck)
 
 	if c.spec.Network.IsHost() {
 		podTemplateSpec.Spec.DNSPolicy = v1.DNSClusterFirstWithHostNet
@@ -662,6 +730,9 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 	}
 
 	k8sutil.RemoveDuplicateEnvVars(&podTemplateSpec.Spec)
+	if err := c.applyStorageOptions(&podTemplateSpec.Spec, osdProps, osd, adminSocketDir); err != nil {
+		return nil, err
+	}
 
 	deployment := &apps.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
@@ -680,8 +751,9 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 			Strategy: apps.DeploymentStrategy{
 				Type: apps.RecreateDeploymentStrategyType,
 			},
-			Template: podTemplateSpec,
-			Replicas: &replicaCount,
+			ProgressDeadlineSeconds: c.getProgressDeadlineSeconds(),
+			Template:                podTemplateSpec,
+			Replicas:                &replicaCount,
 		},
 	}
 	if osdProps.onPVC() {
@@ -691,13 +763,19 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 		k8sutil.AddLabelToPod(CephDeviceSetLabelKey, osdProps.deviceSetName, &deployment.Spec.Template)
 	}
 	if !osdProps.portable {
-		deployment.Spec.Template.Spec.NodeSelector = map[string]string{v1.LabelHostname: osdProps.crushHostname}
+		hostname := c.osdHostname(osdProps, osd)
+		if hostname != osdProps.crushHostname {
+			logger.Infof("osd %d will run on node with hostname %q instead of %q", osd.ID, hostname, osdProps.crushHostname)
+		}
+		deployment.Spec.Template.Spec.NodeSelector = c.osdNodeSelector(hostname)
 	}
 	// Replace default unreachable node toleration if the osd pod is portable and based in PVC
 	if osdProps.onPVC() && osdProps.portable {
 		k8sutil.AddUnreachableNodeToleration(&deployment.Spec.Template.Spec)
 	}
 
+	c.applyOSDImage(&deployment.Spec.Template.Spec, osd)
+
 	k8sutil.AddRookVersionLabelToDeployment(deployment)
 	cephv1.GetOSDAnnotations(c.spec.Annotations).ApplyToObjectMeta(&deployment.ObjectMeta)
 	cephv1.GetOSDAnnotations(c.spec.Annotations).ApplyToObjectMeta(&deployment.Spec.Template.ObjectMeta)
@@ -705,7 +783,7 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 	cephv1.GetOSDLabels(c.spec.Labels).ApplyToObjectMeta(&deployment.Spec.Template.ObjectMeta)
 	controller.AddCephVersionLabelToDeployment(c.clusterInfo.CephVersion, deployment)
 	controller.AddCephVersionLabelToDeployment(c.clusterInfo.CephVersion, deployment)
-	err := c.clusterInfo.OwnerInfo.SetControllerReference(deployment)
+	err = c.clusterInfo.OwnerInfo.SetControllerReference(deployment)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to set owner reference to osd deployment %q", deployment.Name)
 	}
@@ -741,6 +819,194 @@ func (c *Cluster) makeDeployment(osdProps osdProperties, osd OSDInfo, provisionC
 	return deployment, nil
 }
 
+// getDaemonArgs returns the args of the osd daemon configured by the storage spec and the store
+// config, after the args of the osd command
+func (c *Cluster) getDaemonArgs(osdProps osdProperties, osd OSDInfo, adminSocketDir string) ([]string, error) {
+	args := []string{}
+	memoryTargetArgs, err := c.getMemoryTargetArgs(osdProps, osd)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to configure the memory target of osd %d", osd.ID)
+	}
+	args = append(args, memoryTargetArgs...)
+
+	if adminSocketDir != "" {
+		args = append(args, opconfig.NewFlag("admin-socket", controller.DaemonSocketPath(opconfig.OsdType, strconv.Itoa(osd.ID), adminSocketDir)))
+	}
+
+	// The OSDs do not update their crush location when the crush map is managed externally
+	if c.spec.Storage.CrushUpdateOnStart != nil && !*c.spec.Storage.CrushUpdateOnStart {
+		args = append(args, "--osd-crush-update-on-start=false")
+	}
+
+	scrubArgs, err := getScrubArgs(osdProps.storeConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to configure scrubbing of osd %d", osd.ID)
+	}
+	args = append(args, scrubArgs...)
+
+	objectSizeArgs, err := getObjectSizeArgs(osdProps.storeConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to configure the object size limits of osd %d", osd.ID)
+	}
+	args = append(args, objectSizeArgs...)
+
+	opShardArgs, err := getOpShardArgs(osdProps.storeConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to configure the op shards of osd %d", osd.ID)
+	}
+	args = append(args, opShardArgs...)
+
+	recoveryArgs, err := getRecoveryArgs(osdProps.storeConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to configure the recovery of osd %d", osd.ID)
+	}
+	args = append(args, recoveryArgs...)
+
+	if osdProps.storeConfig.NUMANode != "" {
+		if err := osdconfig.ValidateNonNegativeInteger(osdconfig.NUMANodeKey, osdProps.storeConfig.NUMANode); err != nil {
+			return nil, errors.Wrapf(err, "failed to configure the numa node of osd %d", osd.ID)
+		}
+		args = append(args, fmt.Sprintf("--osd-numa-node=%s", osdProps.storeConfig.NUMANode))
+	}
+	return args, nil
+}
+
+// getUnprivilegedSecurityContexts returns the security context of the init containers and the one of
+// the daemon container, and the block devices of the daemon when it does not run privileged.
+// Only the restart throttle, config init, chown and daemon containers of raw mode OSDs on PVC are
+// granted capabilities instead of running privileged. The init containers mapping, activating,
+// expanding or validating the devices always run privileged. The daemon is given the PVC block
+// devices since an unprivileged container cannot open the other devices of the host.
+func (c *Cluster) getUnprivilegedSecurityContexts(securityContext *v1.SecurityContext, osdProps osdProperties, osd OSDInfo) (*v1.SecurityContext, *v1.SecurityContext, []v1.VolumeDevice) {
+	var daemonVolumeDevices []v1.VolumeDevice
+	if c.spec.Storage.UseCapabilities || c.spec.Storage.Restricted {
+		if supportsUnprivilegedDaemon(osdProps, osd) {
+			securityContext = capabilitiesSecurityContext(securityContext)
+			daemonVolumeDevices = getPVCVolumeDevices(osdProps)
+		} else {
+			logger.Infof("osd %d must run privileged since it is not a raw mode OSD on PVC without encryption", osd.ID)
+		}
+	}
+
+	// The init containers keep running as root to prepare the OSD data dir for the daemon
+	daemonSecurityContext := securityContext
+	if c.spec.Storage.RunAsCephUser {
+		if supportsUnprivilegedDaemon(osdProps, osd) {
+			daemonSecurityContext = cephUserSecurityContext(securityContext)
+		} else {
+			logger.Infof("osd %d must run as root since it is not a raw mode OSD on PVC without encryption", osd.ID)
+		}
+	}
+	return securityContext, daemonSecurityContext, daemonVolumeDevices
+}
+
+// addOSDDeviceClassLabels labels the osd with the device class and the device class hint found when
+// the osd was prepared, or else with the ones of the store config
+func addOSDDeviceClassLabels(labels map[string]string, osd OSDInfo, storeConfig osdconfig.StoreConfig) {
+	if osd.DeviceClass != "" {
+		addDeviceClassLabel(labels, osd.DeviceClass)
+	} else {
+		addDeviceClassLabel(labels, storeConfig.DeviceClass)
+	}
+	if osd.DeviceClassHint != "" {
+		addDeviceClassHintLabel(labels, osd.DeviceClassHint)
+	} else {
+		addDeviceClassHintLabel(labels, storeConfig.DeviceClassHint)
+	}
+}
+
+// applyStorageOptions applies the options of the storage spec to the pod spec of the osd, once all
+// its containers and volumes are generated
+func (c *Cluster) applyStorageOptions(spec *v1.PodSpec, osdProps osdProperties, osd OSDInfo, adminSocketDir string) error {
+	if err := c.addOSDKeyring(spec); err != nil {
+		return errors.Wrapf(err, "failed to add the keyring to osd %d", osd.ID)
+	}
+	if err := c.addCABundle(spec); err != nil {
+		return errors.Wrapf(err, "failed to mount the ca bundle in osd %d", osd.ID)
+	}
+	if err := c.addCephConfigDir(spec); err != nil {
+		return errors.Wrapf(err, "failed to mount the ceph config directory in osd %d", osd.ID)
+	}
+	if c.spec.Storage.Restricted {
+		if err := applyRestrictedMode(spec, osdProps, osd); err != nil {
+			return err
+		}
+	}
+	if err := c.applyLogHostPath(spec); err != nil {
+		return errors.Wrapf(err, "failed to set the log host path of osd %d", osd.ID)
+	}
+	if err := c.addExtraVolumes(spec); err != nil {
+		return errors.Wrapf(err, "failed to add the extra volumes to osd %d", osd.ID)
+	}
+	if err := c.addExtraEnvVars(&spec.Containers[0]); err != nil {
+		return errors.Wrapf(err, "failed to add the extra env vars to osd %d", osd.ID)
+	}
+	c.applySeccompProfileToAllContainers(spec)
+	c.applyTerminationMessagePolicyToAllContainers(spec)
+	c.applyAutomountServiceAccountToken(spec, osd, osdProps)
+	c.applyHostPathTypes(spec)
+	c.applyDebugOptions(&spec.Containers[0])
+	c.applyReadOnlyRootFilesystem(spec, osdProps, osd)
+	if err := c.applyPodHostname(spec, osd); err != nil {
+		return errors.Wrapf(err, "failed to set the hostname of osd %d", osd.ID)
+	}
+	c.applySysfs(spec, osdProps)
+	c.applyAdminSocketDir(spec, adminSocketDir)
+	if c.spec.Storage.Restricted {
+		if err := validateRestrictedPodSpec(spec); err != nil {
+			return errors.Wrapf(err, "failed to run osd %d in restricted mode", osd.ID)
+		}
+	}
+	return nil
+}
+
+// applyReadOnlyRootFilesystem makes the root filesystem of the containers of the osd read-only when
+// storage.readOnlyRootFilesystem is set
+func (c *Cluster) applyReadOnlyRootFilesystem(spec *v1.PodSpec, osdProps osdProperties, osd OSDInfo) {
+	if !c.spec.Storage.ReadOnlyRootFilesystem {
+		return
+	}
+	if osdProps.onPVC() && osd.CVMode == "lvm" {
+		// the daemon container activates the osd with ceph-volume, which rewrites /etc/lvm/lvm.conf
+		// from the image and writes the lvm and osd metadata, so its root filesystem must be writable
+		logger.Warningf("not making the root filesystem of osd %d read-only since its lvm mode on PVC activates it in the daemon container", osd.ID)
+		return
+	}
+	setReadOnlyRootFilesystem(spec, &spec.Containers[0])
+}
+
+// applySysfs mounts the sysfs of the host in the daemon container of the osds on nodes when
+// storage.sysfs.daemon is set
+func (c *Cluster) applySysfs(spec *v1.PodSpec, osdProps osdProperties) {
+	if c.spec.Storage.Sysfs != nil && c.spec.Storage.Sysfs.Daemon && !osdProps.onPVC() {
+		addSysfs(spec, &spec.Containers[0])
+	}
+}
+
+// applyAdminSocketDir mounts the directory of the admin socket of the osd, an empty dir when
+// storage.adminSocketEmptyDir is set
+func (c *Cluster) applyAdminSocketDir(spec *v1.PodSpec, adminSocketDir string) {
+	if c.spec.Storage.AdminSocketEmptyDir {
+		socketDir := adminSocketDir
+		if socketDir == "" {
+			socketDir = controller.DaemonSocketDir
+		}
+		addAdminSocketEmptyDir(spec, socketDir)
+	} else if adminSocketDir != "" && adminSocketDir != controller.DaemonSocketDir {
+		// the custom directory does not exist in the ceph image, the daemon creates its socket there
+		// and the probes check it in the daemon container
+		addAdminSocketDirVolume(spec, &spec.Containers[0], adminSocketDir)
+	}
+}
+
+// applyOSDImage runs the osd with the image configured for it in storage.osdImages, if any
+func (c *Cluster) applyOSDImage(spec *v1.PodSpec, osd OSDInfo) {
+	if image := c.spec.Storage.OSDImages[strconv.Itoa(osd.ID)]; image != "" {
+		logger.Infof("osd %d will run with image %q instead of %q", osd.ID, image, c.spec.CephVersion.Image)
+		c.replaceCephImage(spec, image)
+	}
+}
+
 func applyTopologyAffinity(spec *v1.PodSpec, osd OSDInfo) error {
 	if osd.TopologyAffinity == "" {
 		logger.Debugf("no topology affinity to set for osd %d", osd.ID)
@@ -761,14 +1027,15 @@ func applyTopologyAffinity(spec *v1.PodSpec, osd OSDInfo) error {
 // To get rook inside the container, the config init container needs to copy "tini" and "rook" binaries into a volume.
 // Get the config flag so rook will copy the binaries and create the volume and mount that will be shared between
 // the init container and the daemon container
-func (c *Cluster) getCopyBinariesContainer() (v1.Volume, *v1.Container) {
-	volume := v1.Volume{Name: rookBinariesVolumeName, VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}}
-	mount := v1.VolumeMount{Name: rookBinariesVolumeName, MountPath: rookBinariesMountPath}
+func (c *Cluster) getCopyBinariesContainer(binariesDir string) (v1.Volume, *v1.Container) {
+	emptyDir := c.binariesVolumeEmptyDir()
+	volume := v1.Volume{Name: rookBinariesVolumeName, VolumeSource: v1.VolumeSource{EmptyDir: &emptyDir}}
+	mount := v1.VolumeMount{Name: rookBinariesVolumeName, MountPath: binariesDir}
 
 	return volume, &v1.Container{
 		Args: []string{
 			"copy-binaries",
-			"--copy-to-dir", rookBinariesMountPath},
+			"--copy-to-dir", binariesDir},
 		Name:         "copy-bins",
 		Image:        c.rookVersion,
 		VolumeMounts: []v1.VolumeMount{mount},
@@ -838,7 +1105,7 @@ func (c *Cluster) getActivateOSDInitContainer(configDir, namespace, osdID string
 func (c *Cluster) getPVCInitContainer(osdProps osdProperties) v1.Container {
 	return v1.Container{
 		Name:  blockPVCMapperInitContainer,
-		Image: c.spec.CephVersion.Image,
+		Image: c.blockDevMapperImage(),
 		Command: []string{
 			"/bin/bash",
 			"-c",
@@ -856,6 +1123,14 @@ func (c *Cluster) getPVCInitContainer(osdProps osdProperties) v1.Container {
 	}
 }
 
+// blockDevMapperImage returns the image of the init containers copying the block devices of OSDs on PVC
+func (c *Cluster) blockDevMapperImage() string {
+	if c.spec.Storage.BlockDevMapperImage != "" {
+		return c.spec.Storage.BlockDevMapperImage
+	}
+	return c.spec.CephVersion.Image
+}
+
 func (c *Cluster) getPVCInitContainerActivate(mountPath string, osdProps osdProperties) v1.Container {
 	cpDestinationName := path.Join(mountPath, bluestoreBlockName)
 	// Encrypted is a special
@@ -870,7 +1145,7 @@ func (c *Cluster) getPVCInitContainerActivate(mountPath string, osdProps osdProp
 
 	return v1.Container{
 		Name:  blockPVCMapperInitContainer,
-		Image: c.spec.CephVersion.Image,
+		Image: c.blockDevMapperImage(),
 		Command: []string{
 			"/bin/bash",
 			"-c",
@@ -976,7 +1251,7 @@ func (c *Cluster) getPVCEncryptionOpenInitContainerActivate(mountPath string, os
 func (c *Cluster) generateEncryptionCopyBlockContainer(resources v1.ResourceRequirements, containerName, pvcName, mountPath, volumeMountPVCName, blockName, blockType string) v1.Container {
 	return v1.Container{
 		Name:  containerName,
-		Image: c.spec.CephVersion.Image,
+		Image: c.blockDevMapperImage(),
 		Command: []string{
 			"/bin/bash",
 			"-c",
@@ -1014,7 +1289,7 @@ func (c *Cluster) getPVCEncryptionInitContainerActivate(mountPath string, osdPro
 func (c *Cluster) getPVCMetadataInitContainer(mountPath string, osdProps osdProperties) v1.Container {
 	return v1.Container{
 		Name:  blockPVCMetadataMapperInitContainer,
-		Image: c.spec.CephVersion.Image,
+		Image: c.blockDevMapperImage(),
 		Command: []string{
 			"/bin/bash",
 			"-c",
@@ -1051,7 +1326,7 @@ func (c *Cluster) getPVCMetadataInitContainerActivate(mountPath string, osdProps
 
 	return v1.Container{
 		Name:  blockPVCMetadataMapperInitContainer,
-		Image: c.spec.CephVersion.Image,
+		Image: c.blockDevMapperImage(),
 		Command: []string{
 			"/bin/bash",
 			"-c",
@@ -1074,7 +1349,7 @@ func (c *Cluster) getPVCMetadataInitContainerActivate(mountPath string, osdProps
 func (c *Cluster) getPVCWalInitContainer(mountPath string, osdProps osdProperties) v1.Container {
 	return v1.Container{
 		Name:  blockPVCWalMapperInitContainer,
-		Image: c.spec.CephVersion.Image,
+		Image: c.blockDevMapperImage(),
 		Command: []string{
 			"/bin/bash",
 			"-c",
@@ -1111,7 +1386,7 @@ func (c *Cluster) getPVCWalInitContainerActivate(mountPath string, osdProps osdP
 
 	return v1.Container{
 		Name:  blockPVCWalMapperInitContainer,
-		Image: c.spec.CephVersion.Image,
+		Image: c.blockDevMapperImage(),
 		Command: []string{
 			"/bin/bash",
 			"-c",
@@ -1182,6 +1457,78 @@ func (c *Cluster) getExpandPVCInitContainer(osdProps osdProperties, osdID string
 	}
 }
 
+// getValidateDataPathInitContainer returns the init container failing with a clear message if the
+// data path of the OSD was not activated or cannot be written, before the OSD daemon starts
+func (c *Cluster) getValidateDataPathInitContainer(osdProps osdProperties, osdID string, volumeMounts []v1.VolumeMount) v1.Container {
+	return v1.Container{
+		Name:  validateDataPathInitContainer,
+		Image: c.spec.CephVersion.Image,
+		Command: []string{
+			"/bin/bash",
+			"-c",
+			fmt.Sprintf(validateDataPathCode, activateOSDMountPath+osdID),
+		},
+		VolumeMounts:    volumeMounts,
+		SecurityContext: PrivilegedContext(),
+		Resources:       osdProps.resources,
+	}
+}
+
+// getRestartThrottleInitContainer returns the init container delaying the start of the OSD pod once
+// it failed to start too many times in a row. The starts are recorded in a marker file of the data
+// dir of the node so they are kept across the pods of the OSD. The data dir of the OSDs on PVC is an
+// emptyDir, so the data dir of the node is mounted from the restart throttle volume instead.
+func (c *Cluster) getRestartThrottleInitContainer(osdID string, onPVC bool, securityContext *v1.SecurityContext) (v1.Container, error) {
+	throttle := c.spec.Storage.RestartThrottle
+	if throttle.DelaySeconds <= 0 {
+		return v1.Container{}, errors.Errorf("the delay must be a positive number of seconds, got %d", throttle.DelaySeconds)
+	}
+	if throttle.FailureThreshold < 0 || throttle.WindowSeconds < 0 {
+		return v1.Container{}, errors.New("the failure threshold and the window cannot be negative")
+	}
+	failureThreshold := throttle.FailureThreshold
+	if failureThreshold == 0 {
+		failureThreshold = defaultRestartThrottleFailureThreshold
+	}
+	windowSeconds := throttle.WindowSeconds
+	if windowSeconds == 0 {
+		windowSeconds = defaultRestartThrottleWindowSeconds
+	}
+
+	volumeName := k8sutil.DataDirVolume
+	if onPVC {
+		if c.spec.DataDirHostPath == "" {
+			return v1.Container{}, errors.New("the restart throttle of the osds on pvc requires the dataDirHostPath")
+		}
+		volumeName = restartThrottleVolumeName
+	}
+
+	marker := path.Join(k8sutil.DataDir, c.clusterInfo.Namespace, fmt.Sprintf("osd%s-restarts", osdID))
+	return v1.Container{
+		Name:  restartThrottleInitContainer,
+		Image: c.spec.CephVersion.Image,
+		Command: []string{
+			"/bin/bash",
+			"-c",
+			fmt.Sprintf(restartThrottleCode, marker, failureThreshold, throttle.DelaySeconds, windowSeconds),
+		},
+		VolumeMounts:    []v1.VolumeMount{{Name: volumeName, MountPath: k8sutil.DataDir}},
+		SecurityContext: securityContext,
+	}, nil
+}
+
+// getRestartThrottleVolume returns the host path volume of the data dir of the node where the restart
+// throttle of the OSDs on PVC records the starts of the pods
+func (c *Cluster) getRestartThrottleVolume() v1.Volume {
+	hostPathType := v1.HostPathDirectoryOrCreate
+	return v1.Volume{
+		Name: restartThrottleVolumeName,
+		VolumeSource: v1.VolumeSource{
+			HostPath: &v1.HostPathVolumeSource{Path: c.spec.DataDirHostPath, Type: &hostPathType},
+		},
+	}
+}
+
 func (c *Cluster) getExpandEncryptedPVCInitContainer(mountPath string, osdProps osdProperties) v1.Container {
 	/* Command example
 	   [root@rook-ceph-osd-0-59b9947547-w8mdq /]# cryptsetup resize set1-data-2-8n462-block-dmcrypt
@@ -1238,3 +1585,314 @@ func (c *Cluster) getEncryptedStatusPVCInitContainer(mountPath string, osdProps
 		Resources:       osdProps.resources,
 	}
 }
+
+// BuildOSDContainerCommand returns the command and the base args launching the OSD daemon of the
+// given OSD. The OSDs prepared with ceph-volume in lvm mode on a PVC are started by the rook binary
+// under tini, all the other OSDs run ceph-osd directly. Both are started with the cluster name of the
+// OSD. ceph-osd only gets the --cluster flag for a cluster name other than "ceph", its default, so
+// that the args of the existing OSDs do not change. The args common to all the daemons, e.g. the
+// logging and network flags, are appended by makeDeployment.
+func BuildOSDContainerCommand(osd OSDInfo, onPVC bool, fsid, crushLocation string) (command, args []string) {
+	return BuildOSDContainerCommandInDir(osd, onPVC, fsid, crushLocation, rookBinariesMountPath)
+}
+
+// BuildOSDContainerCommandInDir is BuildOSDContainerCommand with the rook binaries copied to the
+// given directory
+func BuildOSDContainerCommandInDir(osd OSDInfo, onPVC bool, fsid, crushLocation, binariesDir string) (command, args []string) {
+	osdID := strconv.Itoa(osd.ID)
+	clusterName := osdClusterName(osd)
+	if onPVC && osd.CVMode == "lvm" {
+		// if the osd was provisioned by ceph-volume, we need to launch it with rook as the parent process
+		command = []string{path.Join(binariesDir, "tini")}
+		args = []string{
+			"--", path.Join(binariesDir, "rook"),
+			"ceph", "osd", "start",
+			"--",
+			"--foreground",
+			"--id", osdID,
+			"--fsid", fsid,
+			"--cluster", clusterName,
+			"--setuser", "ceph",
+			"--setgroup", "ceph",
+			fmt.Sprintf("--crush-location=%s", crushLocation),
+		}
+		return command, args
+	}
+
+	command = []string{"ceph-osd"}
+	args = []string{
+		"--foreground",
+		"--id", osdID,
+		"--fsid", fsid,
+	}
+	if clusterName != defaultClusterName {
+		args = append(args, "--cluster", clusterName)
+	}
+	args = append(args,
+		"--setuser", "ceph",
+		"--setgroup", "ceph",
+		fmt.Sprintf("--crush-location=%s", crushLocation),
+	)
+	return command, args
+}
+
+// osdClusterName returns the name of the ceph cluster of the OSD, "ceph" if the OSD info does not
+// have one, e.g. for the OSDs created by older versions of Rook
+func osdClusterName(osd OSDInfo) string {
+	if osd.Cluster == "" {
+		return defaultClusterName
+	}
+	return osd.Cluster
+}
+
+// osdNodeSelector returns the node selector of the OSD pods running on the node with the given
+// hostname, with the additional labels of the storage spec
+func (c *Cluster) osdNodeSelector(hostname string) map[string]string {
+	nodeSelector := map[string]string{}
+	for key, value := range c.spec.Storage.NodeSelector {
+		nodeSelector[key] = value
+	}
+	// the osd must run on its node
+	nodeSelector[v1.LabelHostname] = hostname
+	return nodeSelector
+}
+
+// getAdminSocketDir returns the directory of the admin sockets of the OSDs, empty for the default
+// directory
+func (c *Cluster) getAdminSocketDir() (string, error) {
+	dir := c.spec.Storage.AdminSocketDir
+	if dir == "" {
+		return "", nil
+	}
+	if !filepath.IsAbs(dir) || filepath.Clean(dir) == "/" {
+		return "", errors.Errorf("invalid admin socket directory %q. the path must be an absolute path that is not the root", dir)
+	}
+	return filepath.Clean(dir), nil
+}
+
+// getBinariesMountPath returns the directory the rook binaries are copied to in the OSD and OSD
+// prepare containers
+func (c *Cluster) getBinariesMountPath() (string, error) {
+	dir := c.spec.Storage.BinariesMountPath
+	if dir == "" {
+		return rookBinariesMountPath, nil
+	}
+	if !filepath.IsAbs(dir) || filepath.Clean(dir) == "/" {
+		return "", errors.Errorf("invalid binaries mount path %q. the path must be an absolute path that is not the root", dir)
+	}
+	return filepath.Clean(dir), nil
+}
+
+// getProgressDeadlineSeconds returns the progress deadline of the OSD deployments, nil if none is set
+// so that the default of Kubernetes applies and the existing deployments are not changed
+func (c *Cluster) getProgressDeadlineSeconds() *int32 {
+	if c.spec.Storage.ProgressDeadlineSeconds == nil {
+		return nil
+	}
+	deadline := *c.spec.Storage.ProgressDeadlineSeconds
+	return &deadline
+}
+
+// osdHostname returns the hostname label of the node the OSD runs on
+func (c *Cluster) osdHostname(osdProps osdProperties, osd OSDInfo) string {
+	if override, ok := c.spec.Storage.OSDHostnames[strconv.Itoa(osd.ID)]; ok && override != "" {
+		return override
+	}
+	return osdProps.crushHostname
+}
+
+// applyPodHostname sets the hostname and the subdomain of the OSD pod if they are configured
+func (c *Cluster) applyPodHostname(spec *v1.PodSpec, osd OSDInfo) error {
+	if c.spec.Storage.PodHostname != "" {
+		hostname := fmt.Sprintf("%s-%d", c.spec.Storage.PodHostname, osd.ID)
+		if errs := validation.IsDNS1123Label(hostname); len(errs) > 0 {
+			return errors.Errorf("invalid pod hostname %q. %s", hostname, strings.Join(errs, ", "))
+		}
+		spec.Hostname = hostname
+	}
+	if c.spec.Storage.PodSubdomain != "" {
+		if errs := validation.IsDNS1123Label(c.spec.Storage.PodSubdomain); len(errs) > 0 {
+			return errors.Errorf("invalid pod subdomain %q. %s", c.spec.Storage.PodSubdomain, strings.Join(errs, ", "))
+		}
+		spec.Subdomain = c.spec.Storage.PodSubdomain
+	}
+	return nil
+}
+
+// replaceCephImage sets the given image on the containers and init containers running the ceph image
+// of the cluster. The containers running the rook image or another image are not changed.
+func (c *Cluster) replaceCephImage(spec *v1.PodSpec, image string) {
+	for i := range spec.InitContainers {
+		if spec.InitContainers[i].Image == c.spec.CephVersion.Image {
+			spec.InitContainers[i].Image = image
+		}
+	}
+	for i := range spec.Containers {
+		if spec.Containers[i].Image == c.spec.CephVersion.Image {
+			spec.Containers[i].Image = image
+		}
+	}
+}
+
+// getMemoryTargetArgs returns the flag setting the memory target of the OSD if a memory target is set
+// for its device class, or if the memory target of an OSD without memory limit is derived from the
+// allocatable memory of its node. Otherwise no flag is returned and Ceph computes the memory target
+// from the memory limit of the pod.
+func (c *Cluster) getMemoryTargetArgs(osdProps osdProperties, osd OSDInfo) ([]string, error) {
+	target, ok := c.spec.Storage.MemoryTargets[osd.DeviceClass]
+	if !ok || osd.DeviceClass == "" {
+		if osdProps.nodeMemoryTarget == 0 {
+			return []string{}, nil
+		}
+		return []string{fmt.Sprintf("--osd-memory-target=%d", osdProps.nodeMemoryTarget)}, nil
+	}
+	if target.Sign() <= 0 {
+		return nil, errors.Errorf("invalid memory target %q for device class %q, it must be positive", target.String(), osd.DeviceClass)
+	}
+	return []string{fmt.Sprintf("--osd-memory-target=%d", target.Value())}, nil
+}
+
+// getNodeMemoryTarget returns the memory target of an OSD without memory limit derived from the
+// allocatable memory of its node, or 0 if the memory target is not derived for the OSD. The OSD is
+// started without memory target if its node cannot be found. The node is looked up before the
+// deployment is generated so that makeDeployment does not call the API.
+func (c *Cluster) getNodeMemoryTarget(osdProps osdProperties, osd OSDInfo) int64 {
+	if c.spec.Storage.NodeMemoryTarget == nil || osdProps.onPVC() || !osdProps.resources.Limits.Memory().IsZero() {
+		return 0
+	}
+	if _, ok := c.spec.Storage.MemoryTargets[osd.DeviceClass]; ok && osd.DeviceClass != "" {
+		// the target of the device class takes precedence
+		return 0
+	}
+
+	hostname := c.osdHostname(osdProps, osd)
+	nodeName, err := k8sutil.GetNodeNameFromHostname(c.context.Clientset, hostname)
+	if err != nil {
+		logger.Warningf("failed to find the node with hostname %q to derive the memory target of osd %d. %v", hostname, osd.ID, err)
+		return 0
+	}
+	node, err := c.context.Clientset.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		logger.Warningf("failed to get node %q to derive the memory target of osd %d. %v", nodeName, osd.ID, err)
+		return 0
+	}
+
+	target, err := nodeMemoryTarget(*node.Status.Allocatable.Memory(), c.spec.Storage.NodeMemoryTarget.ExpectedOSDsPerNode)
+	if err != nil {
+		logger.Warningf("failed to derive the memory target of osd %d from node %q. %v", osd.ID, nodeName, err)
+		return 0
+	}
+	logger.Debugf("memory target of osd %d derived from the allocatable memory of node %q is %d", osd.ID, nodeName, target)
+	return target
+}
+
+// nodeMemoryTarget divides the allocatable memory of a node between the expected number of OSDs on
+// the node. The memory target is never below the minimum memory target of Ceph.
+func nodeMemoryTarget(allocatable resource.Quantity, osdsPerNode int) (int64, error) {
+	if osdsPerNode < 1 {
+		return 0, errors.Errorf("invalid number of osds per node %d, it must be at least 1", osdsPerNode)
+	}
+	if allocatable.IsZero() {
+		return 0, errors.New("no allocatable memory")
+	}
+	target := allocatable.Value() / int64(osdsPerNode)
+	if target < minOSDMemoryTarget {
+		logger.Warningf("memory target %d derived from the allocatable memory %q is below the minimum, using %d", target, allocatable.String(), minOSDMemoryTarget)
+		return minOSDMemoryTarget, nil
+	}
+	return target, nil
+}
+
+// getScrubArgs returns the flags restricting when the OSD is allowed to scrub, only the settings
+// that are configured are passed to the OSD
+func getScrubArgs(storeConfig osdconfig.StoreConfig) ([]string, error) {
+	args := []string{}
+	if storeConfig.ScrubBeginHour != "" {
+		if err := osdconfig.ValidateScrubHour(storeConfig.ScrubBeginHour); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--osd-scrub-begin-hour=%s", storeConfig.ScrubBeginHour))
+	}
+	if storeConfig.ScrubEndHour != "" {
+		if err := osdconfig.ValidateScrubHour(storeConfig.ScrubEndHour); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--osd-scrub-end-hour=%s", storeConfig.ScrubEndHour))
+	}
+	if storeConfig.ScrubLoadThreshold != "" {
+		if err := osdconfig.ValidateScrubLoadThreshold(storeConfig.ScrubLoadThreshold); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--osd-scrub-load-threshold=%s", storeConfig.ScrubLoadThreshold))
+	}
+	return args, nil
+}
+
+// getObjectSizeArgs returns the flags raising the size limits of the objects and the writes
+// accepted by the OSD, only the limits that are configured are passed to the OSD
+func getObjectSizeArgs(storeConfig osdconfig.StoreConfig) ([]string, error) {
+	if err := osdconfig.ValidateObjectSizeLimits(storeConfig.MaxObjectSize, storeConfig.MaxWriteSizeMB); err != nil {
+		return nil, err
+	}
+	args := []string{}
+	if storeConfig.MaxObjectSize != "" {
+		args = append(args, fmt.Sprintf("--osd-max-object-size=%s", storeConfig.MaxObjectSize))
+	}
+	if storeConfig.MaxWriteSizeMB != "" {
+		args = append(args, fmt.Sprintf("--osd-max-write-size=%s", storeConfig.MaxWriteSizeMB))
+	}
+	return args, nil
+}
+
+// getOpShardArgs returns the flags configuring the number of shards of the op queue of the OSD and
+// the number of threads of each shard, only the settings that are configured are passed to the OSD
+func getOpShardArgs(storeConfig osdconfig.StoreConfig) ([]string, error) {
+	args := []string{}
+	if storeConfig.OpNumShards != "" {
+		if err := osdconfig.ValidatePositiveInteger(osdconfig.OpNumShardsKey, storeConfig.OpNumShards); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--osd-op-num-shards=%s", storeConfig.OpNumShards))
+	}
+	if storeConfig.OpNumThreadsPerShard != "" {
+		if err := osdconfig.ValidatePositiveInteger(osdconfig.OpNumThreadsPerShardKey, storeConfig.OpNumThreadsPerShard); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--osd-op-num-threads-per-shard=%s", storeConfig.OpNumThreadsPerShard))
+	}
+	return args, nil
+}
+
+// unsetFlags returns the flags of the given defaults that are not already set in the args
+func unsetFlags(args, defaults []string) []string {
+	set := map[string]bool{}
+	for _, arg := range args {
+		set[strings.SplitN(arg, "=", 2)[0]] = true
+	}
+	flags := []string{}
+	for _, flag := range defaults {
+		if !set[strings.SplitN(flag, "=", 2)[0]] {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// getRecoveryArgs returns the flags limiting the number of active recovery requests and of backfills
+// of the OSD, only the settings that are configured are passed to the OSD
+func getRecoveryArgs(storeConfig osdconfig.StoreConfig) ([]string, error) {
+	args := []string{}
+	if storeConfig.RecoveryMaxActive != "" {
+		if err := osdconfig.ValidatePositiveInteger(osdconfig.RecoveryMaxActiveKey, storeConfig.RecoveryMaxActive); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--osd-recovery-max-active=%s", storeConfig.RecoveryMaxActive))
+	}
+	if storeConfig.MaxBackfills != "" {
+		if err := osdconfig.ValidatePositiveInteger(osdconfig.MaxBackfillsKey, storeConfig.MaxBackfills); err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("--osd-max-backfills=%s", storeConfig.MaxBackfills))
+	}
+	return args, nil
+}
@@ -18,6 +18,9 @@ limitations under the License.
 package osd
 
 import (
+	"context"
+	"encoding/json"
+	"strings"
 	"testing"
 
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
@@ -26,6 +29,7 @@ import (
 	cephclient "github.com/rook/rook/pkg/daemon/ceph/client"
 	"github.com/rook/rook/pkg/operator/ceph/cluster/osd/config"
 	opconfig "github.com/rook/rook/pkg/operator/ceph/config"
+	"github.com/rook/rook/pkg/operator/ceph/controller"
 	operatortest "github.com/rook/rook/pkg/operator/ceph/test"
 	cephver "github.com/rook/rook/pkg/operator/ceph/version"
 	"github.com/rook/rook/pkg/operator/k8sutil"
@@ -34,6 +38,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
@@ -533,7 +538,7 @@ func TestStorageSpecConfig(t *testing.T) {
 	assert.NotNil(t, container)
 	verifyEnvVar(t, container.Env, "ROOK_OSD_DATABASE_SIZE", "10", true)
 	verifyEnvVar(t, container.Env, "ROOK_OSD_WAL_SIZE", "20", true)
-	verifyEnvVar(t, container.Env, "ROOK_METADATA_DEVICE", "nvme093", true)
+	verifyEnvVar(t, container.Env, "ROOK_METADATA_DEVICE", "/dev/nvme093", true)
 	verifyEnvVar(t, container.Env, CrushRootVarName, "custom-root", true)
 }
 
@@ -707,3 +712,2465 @@ func getDummyDeploymentOnNode(clientset *fake.Clientset, c *Cluster, nodeName st
 	}
 	return d
 }
+
+// newTestCluster returns an octopus cluster with the given spec for the deployment generation tests
+func newTestCluster(t *testing.T, spec cephv1.ClusterSpec) *Cluster {
+	clientset := fake.NewSimpleClientset()
+	clusterInfo := &cephclient.ClusterInfo{
+		Namespace:   "ns",
+		CephVersion: cephver.Octopus,
+	}
+	clusterInfo.SetName("test")
+	clusterInfo.OwnerInfo = cephclient.NewMinimumOwnerInfo(t)
+	context := &clusterd.Context{Clientset: clientset, ConfigDir: "/var/lib/rook", Executor: &exectest.MockExecutor{}}
+	if spec.CephVersion.Image == "" {
+		spec.CephVersion.Image = "ceph/ceph:v15"
+	}
+	return New(context, clusterInfo, spec, "rook/rook:myversion")
+}
+
+func testProvisionConfig(c *Cluster) *provisionConfig {
+	return &provisionConfig{
+		DataPathMap: opconfig.NewDatalessDaemonDataPathMap(c.clusterInfo.Namespace, "/var/lib/rook"),
+	}
+}
+
+func TestBlockDevMapperImage(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+
+	osdProp := osdProperties{
+		crushHostname: "node1",
+		pvc:           v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"},
+		metadataPVC:   v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc-metadata"},
+	}
+	osd := OSDInfo{
+		ID:     0,
+		CVMode: "raw",
+	}
+	dataPathMap := testProvisionConfig(c)
+
+	// the ceph image is used by default
+	deployment, err := c.makeDeployment(osdProp, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, blockPVCMapperInitContainer, deployment.Spec.Template.Spec.InitContainers[0].Name)
+	assert.Equal(t, "ceph/ceph:v15", deployment.Spec.Template.Spec.InitContainers[0].Image)
+	assert.Equal(t, blockPVCMetadataMapperInitContainer, deployment.Spec.Template.Spec.InitContainers[1].Name)
+	assert.Equal(t, "ceph/ceph:v15", deployment.Spec.Template.Spec.InitContainers[1].Image)
+
+	// the override only applies to the block copy init containers
+	c.spec.Storage.BlockDevMapperImage = "registry.example.com/blkdevmapper:v1"
+	deployment, err = c.makeDeployment(osdProp, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "registry.example.com/blkdevmapper:v1", deployment.Spec.Template.Spec.InitContainers[0].Image)
+	assert.Equal(t, "registry.example.com/blkdevmapper:v1", deployment.Spec.Template.Spec.InitContainers[1].Image)
+	assert.Equal(t, activatePVCOSDInitContainer, deployment.Spec.Template.Spec.InitContainers[2].Name)
+	assert.Equal(t, "ceph/ceph:v15", deployment.Spec.Template.Spec.InitContainers[2].Image)
+	assert.Equal(t, "ceph/ceph:v15", deployment.Spec.Template.Spec.Containers[0].Image)
+
+	// the prepare job copies the block with the override image too
+	job, err := c.makeJob(osdProp, dataPathMap)
+	assert.NoError(t, err)
+	for _, container := range job.Spec.Template.Spec.InitContainers {
+		if container.Name == blockPVCMapperInitContainer || container.Name == blockPVCMetadataMapperInitContainer {
+			assert.Equal(t, "registry.example.com/blkdevmapper:v1", container.Image)
+		}
+	}
+	assert.Equal(t, "ceph/ceph:v15", job.Spec.Template.Spec.Containers[0].Image)
+}
+
+func TestRunAsCephUser(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	rawOSD := OSDInfo{ID: 0, CVMode: "raw"}
+	lvmOSD := OSDInfo{ID: 0, CVMode: "lvm"}
+	nodeProps := osdProperties{crushHostname: "node1"}
+	pvcProps := osdProperties{crushHostname: "node1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"}}
+	encryptedPVCProps := pvcProps
+	encryptedPVCProps.encrypted = true
+
+	assertDaemonUser := func(osdProps osdProperties, osd OSDInfo, uid int64) {
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		daemon := deployment.Spec.Template.Spec.Containers[0]
+		assert.Equal(t, uid, *daemon.SecurityContext.RunAsUser)
+		if uid != 0 {
+			assert.Equal(t, cephGroupID, *daemon.SecurityContext.RunAsGroup)
+		}
+		// the init containers preparing the data dir keep running as root
+		for _, init := range deployment.Spec.Template.Spec.InitContainers {
+			if init.SecurityContext != nil && init.SecurityContext.RunAsUser != nil {
+				assert.Equal(t, int64(0), *init.SecurityContext.RunAsUser, init.Name)
+			}
+		}
+	}
+
+	// root by default
+	assertDaemonUser(pvcProps, rawOSD, 0)
+
+	c.spec.Storage.RunAsCephUser = true
+	assertDaemonUser(pvcProps, rawOSD, cephUserID)
+	assertDaemonUser(nodeProps, rawOSD, 0)
+	assertDaemonUser(pvcProps, lvmOSD, 0)
+	assertDaemonUser(encryptedPVCProps, rawOSD, 0)
+
+	// provisioning always runs as root
+	job, err := c.makeJob(pvcProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), *job.Spec.Template.Spec.Containers[0].SecurityContext.RunAsUser)
+}
+
+func TestSeccompProfile(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, CVMode: "raw"}
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		pvc:           v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"},
+		metadataPVC:   v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc-metadata"},
+	}
+
+	allContainers := func(spec v1.PodSpec) []v1.Container {
+		return append(append([]v1.Container{}, spec.InitContainers...), spec.Containers...)
+	}
+
+	// no profile by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	for _, container := range allContainers(deployment.Spec.Template.Spec) {
+		if container.SecurityContext != nil {
+			assert.Nil(t, container.SecurityContext.SeccompProfile, container.Name)
+		}
+	}
+
+	localhostProfile := "profiles/ceph-osd.json"
+	c.spec.Storage.SeccompProfile = &v1.SeccompProfile{
+		Type:             v1.SeccompProfileTypeLocalhost,
+		LocalhostProfile: &localhostProfile,
+	}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	for _, spec := range []v1.PodSpec{deployment.Spec.Template.Spec, job.Spec.Template.Spec} {
+		for _, container := range allContainers(spec) {
+			assert.Equal(t, c.spec.Storage.SeccompProfile, container.SecurityContext.SeccompProfile, container.Name)
+		}
+	}
+	// the privileged settings are kept
+	assert.True(t, *deployment.Spec.Template.Spec.Containers[0].SecurityContext.Privileged)
+}
+
+func TestUseCapabilities(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	rawOSD := OSDInfo{ID: 0, CVMode: "raw"}
+	lvmOSD := OSDInfo{ID: 0, CVMode: "lvm"}
+	pvcProps := osdProperties{
+		crushHostname: "node1",
+		pvc:           v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"},
+		metadataPVC:   v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc-metadata"},
+	}
+	encryptedPVCProps := pvcProps
+	encryptedPVCProps.encrypted = true
+
+	assertPrivileged := func(osdProps osdProperties, osd OSDInfo) {
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		daemon := deployment.Spec.Template.Spec.Containers[0]
+		assert.True(t, *daemon.SecurityContext.Privileged)
+		assert.Nil(t, daemon.SecurityContext.Capabilities)
+		assert.Empty(t, daemon.VolumeDevices)
+	}
+
+	// privileged by default
+	assertPrivileged(pvcProps, rawOSD)
+
+	c.spec.Storage.UseCapabilities = true
+	assertPrivileged(osdProperties{crushHostname: "node1"}, rawOSD)
+	assertPrivileged(pvcProps, lvmOSD)
+	assertPrivileged(encryptedPVCProps, rawOSD)
+
+	deployment, err := c.makeDeployment(pvcProps, rawOSD, dataPathMap)
+	assert.NoError(t, err)
+	spec := deployment.Spec.Template.Spec
+	daemon := spec.Containers[0]
+	assert.False(t, *daemon.SecurityContext.Privileged)
+	assert.Equal(t, osdCapabilities, daemon.SecurityContext.Capabilities.Add)
+	assert.Equal(t, []v1.Capability{"ALL"}, daemon.SecurityContext.Capabilities.Drop)
+	assert.Equal(t, int64(0), *daemon.SecurityContext.RunAsUser)
+	assert.Equal(t, []v1.VolumeDevice{
+		{Name: "mypvc", DevicePath: "/mypvc"},
+		{Name: "mypvc-metadata", DevicePath: "/mypvc-metadata"},
+	}, daemon.VolumeDevices)
+	for _, init := range spec.InitContainers {
+		switch init.Name {
+		case "chown-container-data-dir":
+			assert.False(t, *init.SecurityContext.Privileged)
+			assert.Equal(t, osdCapabilities, init.SecurityContext.Capabilities.Add)
+		case "activate", "expand-bluefs":
+			// the devices are accessed by ceph-bluestore-tool
+			assert.True(t, *init.SecurityContext.Privileged, init.Name)
+		}
+	}
+
+	// the capabilities are kept when running as the ceph user
+	c.spec.Storage.RunAsCephUser = true
+	deployment, err = c.makeDeployment(pvcProps, rawOSD, dataPathMap)
+	assert.NoError(t, err)
+	daemon = deployment.Spec.Template.Spec.Containers[0]
+	assert.False(t, *daemon.SecurityContext.Privileged)
+	assert.Equal(t, osdCapabilities, daemon.SecurityContext.Capabilities.Add)
+	assert.Equal(t, cephUserID, *daemon.SecurityContext.RunAsUser)
+}
+
+func TestPodVolumeNamesUnique(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{DataDirHostPath: "/var/lib/rook"})
+	c.spec.Security.KeyManagementService.ConnectionDetails = map[string]string{"KMS_PROVIDER": "vault"}
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "some-uuid", BlockPath: "/dev/vdb", CVMode: "raw"}
+	pvcProps := osdProperties{
+		crushHostname: "mypvc",
+		pvc:           v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"},
+		metadataPVC:   v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc-metadata"},
+		walPVC:        v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc-wal"},
+	}
+	encryptedPVCProps := pvcProps
+	encryptedPVCProps.encrypted = true
+
+	assertUnique := func(spec v1.PodSpec) {
+		names := map[string]bool{}
+		for _, volume := range spec.Volumes {
+			assert.False(t, names[volume.Name], "duplicate volume %q", volume.Name)
+			names[volume.Name] = true
+		}
+		// every mount refers to a volume of the pod
+		for _, container := range append(append([]v1.Container{}, spec.InitContainers...), spec.Containers...) {
+			for _, mount := range container.VolumeMounts {
+				assert.True(t, names[mount.Name], "mount %q of container %q has no volume", mount.Name, container.Name)
+			}
+		}
+	}
+
+	for _, osdProps := range []osdProperties{{crushHostname: "node1"}, pvcProps, encryptedPVCProps} {
+		job, err := c.makeJob(osdProps, dataPathMap)
+		assert.NoError(t, err)
+		assertUnique(job.Spec.Template.Spec)
+
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		assertUnique(deployment.Spec.Template.Spec)
+	}
+}
+
+func TestPerDeviceEncryption(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "some-uuid", BlockPath: "/dev/vdb", CVMode: "lvm"}
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		devices: []cephv1.Device{
+			{Name: "sda"},
+			{Name: "sdb"},
+		},
+	}
+
+	// no encryption, no host IPC
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.False(t, job.Spec.Template.Spec.HostIPC)
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.False(t, deployment.Spec.Template.Spec.HostIPC)
+
+	// only sdb is encrypted
+	osdProps.devices[1].Config = map[string]string{"encryptedDevice": "true"}
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.True(t, job.Spec.Template.Spec.HostIPC)
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.True(t, deployment.Spec.Template.Spec.HostIPC)
+
+	var devices []config.ConfiguredDevice
+	for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == "ROOK_DATA_DEVICES" {
+			assert.NoError(t, json.Unmarshal([]byte(env.Value), &devices))
+		}
+		// the whole node is not encrypted
+		assert.NotEqual(t, EncryptedDeviceEnvVarName, env.Name)
+	}
+	assert.Equal(t, 2, len(devices))
+	assert.Equal(t, "sda", devices[0].ID)
+	assert.False(t, devices[0].StoreConfig.EncryptedDevice)
+	assert.Equal(t, "sdb", devices[1].ID)
+	assert.True(t, devices[1].StoreConfig.EncryptedDevice)
+}
+
+func TestPrepareHostPathVolumes(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{DataDirHostPath: "/var/lib/rook"})
+	useAllDevices := true
+
+	for _, selection := range []cephv1.Selection{
+		{UseAllDevices: &useAllDevices},
+		{DeviceFilter: "^sd."},
+		{Devices: []cephv1.Device{{Name: "sda"}, {Name: "nvme0n1", Config: map[string]string{"metadataDevice": "nvme1n1"}}}},
+	} {
+		volumes, err := c.PrepareHostPathVolumes("node1", selection)
+		assert.NoError(t, err)
+
+		// the same host paths as the actual prepare job
+		job, err := c.makeJob(osdProperties{crushHostname: "node1", devices: selection.Devices, selection: selection}, c.newProvisionConfig())
+		assert.NoError(t, err)
+		expected := []v1.Volume{}
+		for _, volume := range job.Spec.Template.Spec.Volumes {
+			if volume.HostPath != nil {
+				expected = append(expected, volume)
+			}
+		}
+		assert.Equal(t, expected, volumes)
+
+		paths := []string{}
+		for _, volume := range volumes {
+			paths = append(paths, volume.HostPath.Path)
+		}
+		assert.Contains(t, paths, "/dev")
+		assert.Contains(t, paths, "/run/udev")
+		assert.Contains(t, paths, "/")
+		assert.Contains(t, paths, "/var/lib/rook/ns/log")
+	}
+}
+
+func TestScrubArgs(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "some-uuid", BlockPath: "/dev/vdb", CVMode: "lvm"}
+	osdProps := osdProperties{crushHostname: "node1"}
+
+	hasArg := func(args []string, prefix string) bool {
+		for _, arg := range args {
+			if strings.HasPrefix(arg, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// omitted when unset
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	args := deployment.Spec.Template.Spec.Containers[0].Args
+	assert.False(t, hasArg(args, "--osd-scrub-begin-hour"))
+	assert.False(t, hasArg(args, "--osd-scrub-end-hour"))
+	assert.False(t, hasArg(args, "--osd-scrub-load-threshold"))
+
+	// configured values are passed to the osd
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{
+		"scrubBeginHour":     "22",
+		"scrubEndHour":       "6",
+		"scrubLoadThreshold": "0.5",
+	})
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	args = deployment.Spec.Template.Spec.Containers[0].Args
+	assert.Contains(t, args, "--osd-scrub-begin-hour=22")
+	assert.Contains(t, args, "--osd-scrub-end-hour=6")
+	assert.Contains(t, args, "--osd-scrub-load-threshold=0.5")
+
+	// only the begin hour
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{"scrubBeginHour": "0"})
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	args = deployment.Spec.Template.Spec.Containers[0].Args
+	assert.Contains(t, args, "--osd-scrub-begin-hour=0")
+	assert.False(t, hasArg(args, "--osd-scrub-end-hour"))
+
+	// invalid values
+	for _, cfg := range []map[string]string{
+		{"scrubBeginHour": "24"},
+		{"scrubEndHour": "-1"},
+		{"scrubEndHour": "noon"},
+		{"scrubLoadThreshold": "high"},
+	} {
+		osdProps.storeConfig = config.ToStoreConfig(cfg)
+		_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestObjectSizeArgs(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "some-uuid", BlockPath: "/dev/vdb", CVMode: "lvm"}
+	osdProps := osdProperties{crushHostname: "node1"}
+
+	// omitted when unset
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	for _, arg := range deployment.Spec.Template.Spec.Containers[0].Args {
+		assert.False(t, strings.HasPrefix(arg, "--osd-max-object-size"))
+		assert.False(t, strings.HasPrefix(arg, "--osd-max-write-size"))
+	}
+
+	// configured values are passed to the osd
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{
+		"maxObjectSize":  "1073741824",
+		"maxWriteSizeMB": "256",
+	})
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	args := deployment.Spec.Template.Spec.Containers[0].Args
+	assert.Contains(t, args, "--osd-max-object-size=1073741824")
+	assert.Contains(t, args, "--osd-max-write-size=256")
+
+	// the write size is checked against the default object size
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{"maxWriteSizeMB": "128"})
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--osd-max-write-size=128")
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{"maxWriteSizeMB": "129"})
+	_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.Error(t, err)
+
+	// larger writes require a larger object size
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{"maxObjectSize": "4294967296", "maxWriteSizeMB": "4096"})
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--osd-max-write-size=4096")
+
+	// invalid values
+	for _, cfg := range []map[string]string{
+		{"maxObjectSize": "128M"},
+		{"maxObjectSize": "1024"},
+		{"maxObjectSize": "4294967297"},
+		{"maxWriteSizeMB": "0"},
+		{"maxWriteSizeMB": "4097"},
+		{"maxObjectSize": "134217728", "maxWriteSizeMB": "256"},
+	} {
+		osdProps.storeConfig = config.ToStoreConfig(cfg)
+		_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestOpShardArgs(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "some-uuid", BlockPath: "/dev/vdb", CVMode: "lvm"}
+	osdProps := osdProperties{crushHostname: "node1"}
+
+	// omitted when unset
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	for _, arg := range deployment.Spec.Template.Spec.Containers[0].Args {
+		assert.False(t, strings.HasPrefix(arg, "--osd-op-num-shards"))
+		assert.False(t, strings.HasPrefix(arg, "--osd-op-num-threads-per-shard"))
+	}
+
+	// configured values are passed to the osd
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{
+		"opNumShards":          "8",
+		"opNumThreadsPerShard": "2",
+	})
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	args := deployment.Spec.Template.Spec.Containers[0].Args
+	assert.Equal(t, 1, countArg(args, "--osd-op-num-shards=8"))
+	assert.Equal(t, 1, countArg(args, "--osd-op-num-threads-per-shard=2"))
+
+	// the configured values take precedence over the tuning of the fast device class
+	pvcProps := osdProperties{
+		crushHostname:       "pvc1",
+		pvc:                 v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"},
+		tuneFastDeviceClass: true,
+		storeConfig: config.ToStoreConfig(map[string]string{
+			"opNumShards":          "16",
+			"opNumThreadsPerShard": "4",
+		}),
+	}
+	deployment, err = c.makeDeployment(pvcProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	args = deployment.Spec.Template.Spec.Containers[0].Args
+	assert.Equal(t, 1, countArg(args, "--osd-op-num-shards=16"))
+	assert.Equal(t, 1, countArg(args, "--osd-op-num-threads-per-shard=4"))
+	assert.Equal(t, 0, countArg(args, "--osd-op-num-shards=8"))
+	assert.Equal(t, 0, countArg(args, "--osd-op-num-threads-per-shard=2"))
+	assert.Equal(t, 1, countArg(args, "--osd-recovery-sleep=0"))
+
+	// the tuning of the fast device class applies when nothing is configured
+	pvcProps.storeConfig = config.ToStoreConfig(map[string]string{"opNumShards": "16"})
+	deployment, err = c.makeDeployment(pvcProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	args = deployment.Spec.Template.Spec.Containers[0].Args
+	assert.Equal(t, 1, countArg(args, "--osd-op-num-shards=16"))
+	assert.Equal(t, 0, countArg(args, "--osd-op-num-shards=8"))
+	assert.Equal(t, 1, countArg(args, "--osd-op-num-threads-per-shard=2"))
+
+	// invalid values
+	for _, cfg := range []map[string]string{
+		{"opNumShards": "0"},
+		{"opNumShards": "-1"},
+		{"opNumShards": "eight"},
+		{"opNumThreadsPerShard": "0"},
+		{"opNumThreadsPerShard": "1.5"},
+	} {
+		osdProps.storeConfig = config.ToStoreConfig(cfg)
+		_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestRecoveryArgs(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "some-uuid", BlockPath: "/dev/vdb", CVMode: "lvm"}
+	osdProps := osdProperties{crushHostname: "node1"}
+
+	// omitted when unset
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	for _, arg := range deployment.Spec.Template.Spec.Containers[0].Args {
+		assert.False(t, strings.HasPrefix(arg, "--osd-recovery-max-active"))
+		assert.False(t, strings.HasPrefix(arg, "--osd-max-backfills"))
+	}
+
+	// configured values are passed to the osd
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{
+		"recoveryMaxActive": "3",
+		"maxBackfills":      "1",
+	})
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	args := deployment.Spec.Template.Spec.Containers[0].Args
+	assert.Equal(t, 1, countArg(args, "--osd-recovery-max-active=3"))
+	assert.Equal(t, 1, countArg(args, "--osd-max-backfills=1"))
+
+	// each setting is passed on its own
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{"maxBackfills": "2"})
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	args = deployment.Spec.Template.Spec.Containers[0].Args
+	assert.Equal(t, 1, countArg(args, "--osd-max-backfills=2"))
+	for _, arg := range args {
+		assert.False(t, strings.HasPrefix(arg, "--osd-recovery-max-active"))
+	}
+
+	// invalid values
+	for _, cfg := range []map[string]string{
+		{"recoveryMaxActive": "0"},
+		{"recoveryMaxActive": "-1"},
+		{"recoveryMaxActive": "three"},
+		{"maxBackfills": "0"},
+		{"maxBackfills": "1.5"},
+	} {
+		osdProps.storeConfig = config.ToStoreConfig(cfg)
+		_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestNUMANodeArg(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "some-uuid", BlockPath: "/dev/vdb", CVMode: "lvm"}
+	osdProps := osdProperties{crushHostname: "node1"}
+
+	// omitted when unset
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	for _, arg := range deployment.Spec.Template.Spec.Containers[0].Args {
+		assert.False(t, strings.HasPrefix(arg, "--osd-numa-node"))
+	}
+
+	for _, node := range []string{"0", "1"} {
+		osdProps.storeConfig = config.ToStoreConfig(map[string]string{"numaNode": node})
+		deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, countArg(deployment.Spec.Template.Spec.Containers[0].Args, "--osd-numa-node="+node))
+	}
+
+	for _, invalid := range []string{"-1", "one", "1.5", " 1"} {
+		osdProps.storeConfig = config.ToStoreConfig(map[string]string{"numaNode": invalid})
+		_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestExtraVolumes(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "some-uuid", BlockPath: "/dev/vdb", CVMode: "lvm"}
+	osdProps := osdProperties{crushHostname: "node1"}
+
+	extraVolume := v1.Volume{
+		Name:         "kms-token",
+		VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "kms-token"}},
+	}
+	extraVolumeMount := v1.VolumeMount{Name: "kms-token", MountPath: "/etc/kms", ReadOnly: true}
+
+	// nothing is added by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	defaultVolumes := len(deployment.Spec.Template.Spec.Volumes)
+	defaultMounts := len(deployment.Spec.Template.Spec.Containers[0].VolumeMounts)
+
+	c.spec.Storage.ExtraVolumes = []v1.Volume{extraVolume}
+	c.spec.Storage.ExtraVolumeMounts = []v1.VolumeMount{extraVolumeMount}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	podSpec := deployment.Spec.Template.Spec
+	assert.Equal(t, defaultVolumes+1, len(podSpec.Volumes))
+	assert.Contains(t, podSpec.Volumes, extraVolume)
+	assert.Equal(t, defaultMounts+1, len(podSpec.Containers[0].VolumeMounts))
+	assert.Contains(t, podSpec.Containers[0].VolumeMounts, extraVolumeMount)
+	// only the daemon container gets the extra mounts
+	for _, container := range podSpec.InitContainers {
+		assert.NotContains(t, container.VolumeMounts, extraVolumeMount, container.Name)
+	}
+
+	t.Run("volume name collides with a rook volume", func(t *testing.T) {
+		c.spec.Storage.ExtraVolumes = []v1.Volume{{Name: "rook-config-override"}}
+		c.spec.Storage.ExtraVolumeMounts = nil
+		_, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "collides with a volume managed by rook")
+	})
+
+	t.Run("mount refers to an unknown volume", func(t *testing.T) {
+		c.spec.Storage.ExtraVolumes = []v1.Volume{extraVolume}
+		c.spec.Storage.ExtraVolumeMounts = []v1.VolumeMount{{Name: "unknown", MountPath: "/etc/unknown"}}
+		_, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "does not refer to a volume")
+	})
+
+	t.Run("mount path collides with a rook mount", func(t *testing.T) {
+		c.spec.Storage.ExtraVolumes = []v1.Volume{extraVolume}
+		c.spec.Storage.ExtraVolumeMounts = []v1.VolumeMount{{Name: "kms-token", MountPath: "/etc/ceph"}}
+		_, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "collides with the mount path")
+	})
+}
+
+func TestOSDDataPathVolume(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{DataDirHostPath: "/mnt/custom/rook"})
+	c.clusterInfo.FSID = "fsid"
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 3, UUID: "some-uuid", BlockPath: "/dev/vdb", CVMode: "raw"}
+	osdProps := osdProperties{crushHostname: "node1"}
+
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	podSpec := deployment.Spec.Template.Spec
+
+	// the osd data dir lives on the host below the data dir host path
+	found := false
+	for _, volume := range podSpec.Volumes {
+		if volume.Name == activateOSDVolumeName {
+			found = true
+			assert.NotNil(t, volume.HostPath)
+			assert.Equal(t, "/mnt/custom/rook/ns/fsid_some-uuid", volume.HostPath.Path)
+		}
+	}
+	assert.True(t, found)
+
+	// the activate init container and the daemon both mount it at the osd data dir
+	expectedMount := v1.VolumeMount{Name: activateOSDVolumeName, MountPath: "/var/lib/ceph/osd/ceph-3"}
+	assert.Contains(t, podSpec.Containers[0].VolumeMounts, expectedMount)
+	activateFound := false
+	for _, container := range podSpec.InitContainers {
+		if container.Name == "activate" {
+			activateFound = true
+			assert.Contains(t, container.VolumeMounts, expectedMount)
+		}
+	}
+	assert.True(t, activateFound)
+
+	// osds on pvc do not use a host path for their data dir
+	osdProps.pvc = v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		assert.NotEqual(t, activateOSDVolumeName, volume.Name)
+	}
+}
+
+func TestOSDCrushLocationArgs(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1"}
+	osd0 := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/vdb", CVMode: "raw", Location: "root=default host=node1"}
+	osd1 := OSDInfo{ID: 1, UUID: "uuid-1", BlockPath: "/dev/vdc", CVMode: "raw", Location: "root=default host=node1"}
+
+	c.spec.Storage.OSDCrushLocations = map[string]map[string]string{
+		"1": {"rack": "rack1", "datacenter": "dc1"},
+	}
+
+	// the osd without an override keeps its provisioned location
+	deployment, err := c.makeDeployment(osdProps, osd0, dataPathMap)
+	assert.NoError(t, err)
+	assert.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--crush-location=root=default host=node1")
+
+	deployment, err = c.makeDeployment(osdProps, osd1, dataPathMap)
+	assert.NoError(t, err)
+	args := deployment.Spec.Template.Spec.Containers[0].Args
+	assert.Contains(t, args, "--crush-location=root=default host=node1 rack=rack1 datacenter=dc1")
+	location, found := getCrushLocationArg(args)
+	assert.True(t, found)
+	assert.Equal(t, "root=default host=node1 rack=rack1 datacenter=dc1", location)
+
+	// lvm osds on pvc get the override too
+	osdProps.pvc = v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}
+	osd1.CVMode = "lvm"
+	deployment, err = c.makeDeployment(osdProps, osd1, dataPathMap)
+	assert.NoError(t, err)
+	assert.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--crush-location=root=default host=node1 rack=rack1 datacenter=dc1")
+
+	// an invalid override fails the deployment
+	c.spec.Storage.OSDCrushLocations["1"] = map[string]string{"shelf": "s1"}
+	_, err = c.makeDeployment(osdProps, osd1, dataPathMap)
+	assert.Error(t, err)
+}
+
+func TestPartitionDevices(t *testing.T) {
+	for _, name := range []string{"sdb1", "/dev/sdb1", "vdc12", "xvdba3", "nvme0n1p2", "/dev/nvme10n2p1", "mmcblk0p1", "/dev/disk/by-id/wwn-0x5000c500a0b1c2d3-part1"} {
+		assert.True(t, isPartition(name), name)
+	}
+	for _, name := range []string{"sdb", "/dev/sdb", "nvme0n1", "/dev/nvme0n1", "mmcblk0", "dm-1", "/dev/mapper/vg-lv", "/dev/disk/by-id/wwn-0x5000c500a0b1c2d3"} {
+		assert.False(t, isPartition(name), name)
+	}
+
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		devices: []cephv1.Device{
+			{Name: "sdb1"},
+			{Name: "nvme0n1p2", Config: map[string]string{"deviceClass": "ssd"}},
+			{Name: "sdc"},
+		},
+	}
+
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	var devices []config.ConfiguredDevice
+	for _, env := range job.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == "ROOK_DATA_DEVICES" {
+			assert.NoError(t, json.Unmarshal([]byte(env.Value), &devices))
+		}
+	}
+	assert.Equal(t, 3, len(devices))
+	assert.Equal(t, "sdb1", devices[0].ID)
+	assert.Equal(t, "nvme0n1p2", devices[1].ID)
+	assert.Equal(t, "ssd", devices[1].StoreConfig.DeviceClass)
+	assert.Equal(t, "sdc", devices[2].ID)
+
+	// the osd prepared on a partition is activated in raw mode from the partition
+	osd := OSDInfo{ID: 0, UUID: "some-uuid", BlockPath: "/dev/sdb1", CVMode: "raw"}
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	verifyEnvVar(t, deployment.Spec.Template.Spec.Containers[0].Env, "ROOK_BLOCK_PATH", "/dev/sdb1", true)
+	verifyEnvVar(t, deployment.Spec.Template.Spec.Containers[0].Env, "ROOK_CV_MODE", "raw", true)
+
+	// the settings requiring lvm mode cannot be used with partitions
+	for _, invalid := range []osdProperties{
+		{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb1", Config: map[string]string{"osdsPerDevice": "2"}}}},
+		{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb1", Config: map[string]string{"encryptedDevice": "true"}}}},
+		{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb1", Config: map[string]string{"metadataDevice": "nvme0n1"}}}},
+		{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb1"}}, metadataDevice: "nvme0n1"},
+		{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb1"}}, storeConfig: config.StoreConfig{OSDsPerDevice: 3}},
+		{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb1"}}, storeConfig: config.StoreConfig{EncryptedDevice: true}},
+	} {
+		_, err := c.makeJob(invalid, dataPathMap)
+		assert.Error(t, err, invalid.devices[0].Config)
+	}
+
+	// the whole device can still use them
+	osdProps.devices = []cephv1.Device{{Name: "sdb", Config: map[string]string{"osdsPerDevice": "2"}}}
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+}
+
+func TestOSDHostnames(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1"}
+	osd0 := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/vdb", CVMode: "raw", Location: "root=default host=node1"}
+	osd1 := OSDInfo{ID: 1, UUID: "uuid-1", BlockPath: "/dev/vdc", CVMode: "raw", Location: "root=default host=node1"}
+	c.spec.Storage.OSDHostnames = map[string]string{"1": "node1-replacement"}
+
+	// without an override the osd is pinned to the node it was prepared on
+	deployment, err := c.makeDeployment(osdProps, osd0, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{v1.LabelHostname: "node1"}, deployment.Spec.Template.Spec.NodeSelector)
+
+	// the override is used for the node selector but not for the crush location
+	deployment, err = c.makeDeployment(osdProps, osd1, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{v1.LabelHostname: "node1-replacement"}, deployment.Spec.Template.Spec.NodeSelector)
+	assert.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--crush-location=root=default host=node1")
+
+	// non-portable osds on pvc get the override too
+	osdProps.pvc = v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}
+	deployment, err = c.makeDeployment(osdProps, osd1, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{v1.LabelHostname: "node1-replacement"}, deployment.Spec.Template.Spec.NodeSelector)
+
+	// portable osds are not pinned to a node
+	osdProps.portable = true
+	deployment, err = c.makeDeployment(osdProps, osd1, dataPathMap)
+	assert.NoError(t, err)
+	assert.Empty(t, deployment.Spec.Template.Spec.NodeSelector)
+}
+
+func TestOSDKeyring(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1"}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/vdb", CVMode: "raw", Location: "root=default host=node1"}
+
+	findKeyringVolume := func(spec v1.PodSpec) *v1.Volume {
+		for i := range spec.Volumes {
+			if spec.Volumes[i].Name == osdKeyringVolName {
+				return &spec.Volumes[i]
+			}
+		}
+		return nil
+	}
+
+	t.Run("default keyring", func(t *testing.T) {
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		assert.Nil(t, findKeyringVolume(deployment.Spec.Template.Spec))
+		for _, arg := range deployment.Spec.Template.Spec.Containers[0].Args {
+			assert.False(t, strings.HasPrefix(arg, "--keyring"), arg)
+		}
+	})
+
+	t.Run("keyring from a secret", func(t *testing.T) {
+		c.spec.Storage.OSDKeyring = &cephv1.OSDKeyringSpec{SecretName: "osd-keyrings", Path: "/etc/ceph/osd-keyring/keyring"}
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		spec := deployment.Spec.Template.Spec
+		assert.Contains(t, spec.Containers[0].Args, "--keyring=/etc/ceph/osd-keyring/keyring")
+
+		volume := findKeyringVolume(spec)
+		if assert.NotNil(t, volume) && assert.NotNil(t, volume.Secret) {
+			assert.Equal(t, "osd-keyrings", volume.Secret.SecretName)
+			assert.Equal(t, []v1.KeyToPath{{Key: "keyring", Path: "keyring"}}, volume.Secret.Items)
+		}
+		assert.Contains(t, spec.Containers[0].VolumeMounts, v1.VolumeMount{Name: osdKeyringVolName, MountPath: "/etc/ceph/osd-keyring", ReadOnly: true})
+	})
+
+	t.Run("invalid keyrings", func(t *testing.T) {
+		for _, osdKeyring := range []cephv1.OSDKeyringSpec{
+			{SecretName: "", Path: "/etc/ceph/osd-keyring/keyring"},
+			{SecretName: "osd-keyrings", Path: "keyring"},
+			{SecretName: "osd-keyrings", Path: "/keyring"},
+			// the directory would hide the files of the osd data directory
+			{SecretName: "osd-keyrings", Path: "/var/lib/ceph/osd/ceph-0/keyring"},
+		} {
+			c.spec.Storage.OSDKeyring = &osdKeyring
+			_, err := c.makeDeployment(osdProps, osd, dataPathMap)
+			assert.Error(t, err, osdKeyring.Path)
+		}
+	})
+}
+
+func TestActivateOSDStore(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1"}
+
+	// all the osds are activated as bluestore, filestore osds are not supported anymore
+	for _, cvMode := range []string{"lvm", "raw"} {
+		osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/vdb", CVMode: cvMode}
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		activateFound := false
+		for _, container := range deployment.Spec.Template.Spec.InitContainers {
+			if container.Name != "activate" {
+				continue
+			}
+			activateFound = true
+			script := strings.Join(container.Command, " ")
+			assert.Contains(t, script, `OSD_STORE_FLAG="--bluestore"`, cvMode)
+			assert.NotContains(t, script, "filestore", cvMode)
+		}
+		assert.True(t, activateFound, cvMode)
+	}
+}
+
+func TestStoreTypeValidation(t *testing.T) {
+	assert.NoError(t, config.ValidateStoreType(""))
+	assert.NoError(t, config.ValidateStoreType("bluestore"))
+	for _, storeType := range []string{"filestore", "bluestor", "BlueStore"} {
+		assert.Error(t, config.ValidateStoreType(storeType), storeType)
+	}
+
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+
+	// a valid store type is accepted on the node and on the devices
+	osdProps := osdProperties{
+		crushHostname: "node1",
+		devices:       []cephv1.Device{{Name: "sdb", Config: map[string]string{"storeType": "bluestore"}}},
+		storeConfig:   config.ToStoreConfig(map[string]string{"storeType": "bluestore"}),
+	}
+	_, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+
+	// a bad store type on the node fails the prepare job generation
+	osdProps.storeConfig = config.ToStoreConfig(map[string]string{"storeType": "bluestor"})
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown store type "bluestor"`)
+
+	// a bad store type on a device fails the prepare job generation
+	osdProps.storeConfig = config.NewStoreConfig()
+	osdProps.devices = []cephv1.Device{{Name: "sdb", Config: map[string]string{"storeType": "filestore"}}}
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `store type "filestore" is not supported anymore`)
+}
+
+func TestOSDRuntimeClassName(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// no runtime class by default
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, job.Spec.Template.Spec.RuntimeClassName)
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, deployment.Spec.Template.Spec.RuntimeClassName)
+
+	// the runtime class is set on the prepare and daemon pods
+	runtimeClassName := "kata"
+	c.spec.Storage.RuntimeClassName = &runtimeClassName
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	if assert.NotNil(t, job.Spec.Template.Spec.RuntimeClassName) {
+		assert.Equal(t, "kata", *job.Spec.Template.Spec.RuntimeClassName)
+	}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	if assert.NotNil(t, deployment.Spec.Template.Spec.RuntimeClassName) {
+		assert.Equal(t, "kata", *deployment.Spec.Template.Spec.RuntimeClassName)
+	}
+
+	// osds on pvc get it as well
+	osdProps = osdProperties{crushHostname: "pvc1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}}
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, &runtimeClassName, job.Spec.Template.Spec.RuntimeClassName)
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, &runtimeClassName, deployment.Spec.Template.Spec.RuntimeClassName)
+}
+
+func TestOSDAutomountServiceAccountToken(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// the default of kubernetes applies if not set
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, deployment.Spec.Template.Spec.AutomountServiceAccountToken)
+
+	disabled := false
+	c.spec.Storage.AutomountServiceAccountToken = &disabled
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	if assert.NotNil(t, deployment.Spec.Template.Spec.AutomountServiceAccountToken) {
+		assert.False(t, *deployment.Spec.Template.Spec.AutomountServiceAccountToken)
+	}
+
+	// the prepare pods always need the token to report the provisioned osds
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, job.Spec.Template.Spec.AutomountServiceAccountToken)
+
+	// raw osds on pvc do not need the token
+	pvcProps := osdProperties{crushHostname: "pvc1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}}
+	deployment, err = c.makeDeployment(pvcProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	if assert.NotNil(t, deployment.Spec.Template.Spec.AutomountServiceAccountToken) {
+		assert.False(t, *deployment.Spec.Template.Spec.AutomountServiceAccountToken)
+	}
+
+	// lvm osds on pvc are started by rook which needs the token
+	lvmOSD := OSDInfo{ID: 1, UUID: "uuid-1", BlockPath: "/dev/vg/lv", CVMode: "lvm"}
+	deployment, err = c.makeDeployment(pvcProps, lvmOSD, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, deployment.Spec.Template.Spec.AutomountServiceAccountToken)
+
+	// the token can be explicitly enabled
+	enabled := true
+	c.spec.Storage.AutomountServiceAccountToken = &enabled
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	if assert.NotNil(t, deployment.Spec.Template.Spec.AutomountServiceAccountToken) {
+		assert.True(t, *deployment.Spec.Template.Spec.AutomountServiceAccountToken)
+	}
+}
+
+func TestOSDTerminationMessagePolicy(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	verifyPolicy := func(spec v1.PodSpec, expected v1.TerminationMessagePolicy) {
+		assert.NotEmpty(t, spec.InitContainers)
+		for _, container := range append(spec.InitContainers, spec.Containers...) {
+			assert.Equal(t, expected, container.TerminationMessagePolicy, container.Name)
+		}
+	}
+
+	// the policy is not set by default
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	verifyPolicy(job.Spec.Template.Spec, "")
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	verifyPolicy(deployment.Spec.Template.Spec, "")
+
+	c.spec.Storage.TerminationMessagePolicy = v1.TerminationMessageFallbackToLogsOnError
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	verifyPolicy(job.Spec.Template.Spec, v1.TerminationMessageFallbackToLogsOnError)
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	verifyPolicy(deployment.Spec.Template.Spec, v1.TerminationMessageFallbackToLogsOnError)
+
+	// the log collector sidecar gets it too
+	c.spec.LogCollector.Enabled = true
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Len(t, deployment.Spec.Template.Spec.Containers, 2)
+	verifyPolicy(deployment.Spec.Template.Spec, v1.TerminationMessageFallbackToLogsOnError)
+}
+
+func TestOSDMultusNetwork(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{
+		Network: cephv1.NetworkSpec{
+			Provider: "multus",
+			Selectors: map[string]string{
+				"public":  `{"name": "public-net", "interface": "public0"}`,
+				"cluster": `{"name": "cluster-net", "interface": "cluster0"}`,
+			},
+		},
+	})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// the osds are attached to both the public and cluster networks and bind to their interfaces
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"name": "cluster-net", "interface": "cluster0"}, {"name": "public-net", "interface": "public0"}]`,
+		deployment.Spec.Template.Annotations["k8s.v1.cni.cncf.io/networks"])
+	assert.False(t, deployment.Spec.Template.Spec.HostNetwork)
+	args := deployment.Spec.Template.Spec.Containers[0].Args
+	assert.Contains(t, args, "--ms-learn-addr-from-peer=false")
+	assert.Contains(t, args, "--public-network-interface=public0")
+	assert.Contains(t, args, "--cluster-network-interface=cluster0")
+
+	// the interfaces are not known with the short syntax
+	c.spec.Network.Selectors = map[string]string{"public": "public-net", "cluster": "cluster-net"}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "cluster-net, public-net", deployment.Spec.Template.Annotations["k8s.v1.cni.cncf.io/networks"])
+	for _, arg := range deployment.Spec.Template.Spec.Containers[0].Args {
+		assert.NotContains(t, arg, "network-interface")
+	}
+
+	// mixing the syntaxes is an error
+	c.spec.Network.Selectors["cluster"] = `{"name": "cluster-net"}`
+	_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.Error(t, err)
+}
+
+func TestStrictDeviceCheckEnvVar(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", selection: cephv1.Selection{DeviceFilter: "^sd."}}
+
+	// not set by default
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, "ROOK_STRICT_DEVICE_CHECK", "", false)
+
+	c.spec.Storage.StrictDeviceCheck = true
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, "ROOK_STRICT_DEVICE_CHECK", "true", true)
+}
+
+func TestBuildOSDContainerCommand(t *testing.T) {
+	crushLocation := "root=default host=node1"
+
+	t.Run("osd on a host device", func(t *testing.T) {
+		osd := OSDInfo{ID: 3, CVMode: "lvm"}
+		command, args := BuildOSDContainerCommand(osd, false, "fsid", crushLocation)
+		assert.Equal(t, []string{"ceph-osd"}, command)
+		assert.Equal(t, []string{
+			"--foreground", "--id", "3", "--fsid", "fsid", "--setuser", "ceph", "--setgroup", "ceph",
+			"--crush-location=root=default host=node1",
+		}, args)
+	})
+
+	t.Run("raw osd on pvc", func(t *testing.T) {
+		osd := OSDInfo{ID: 1, CVMode: "raw"}
+		command, args := BuildOSDContainerCommand(osd, true, "fsid", crushLocation)
+		assert.Equal(t, []string{"ceph-osd"}, command)
+		assert.Equal(t, []string{
+			"--foreground", "--id", "1", "--fsid", "fsid", "--setuser", "ceph", "--setgroup", "ceph",
+			"--crush-location=root=default host=node1",
+		}, args)
+	})
+
+	t.Run("lvm osd on pvc", func(t *testing.T) {
+		osd := OSDInfo{ID: 0, CVMode: "lvm"}
+		command, args := BuildOSDContainerCommand(osd, true, "fsid", crushLocation)
+		assert.Equal(t, []string{"/rook/tini"}, command)
+		assert.Equal(t, []string{
+			"--", "/rook/rook", "ceph", "osd", "start", "--",
+			"--foreground", "--id", "0", "--fsid", "fsid", "--cluster", "ceph", "--setuser", "ceph", "--setgroup", "ceph",
+			"--crush-location=root=default host=node1",
+		}, args)
+	})
+
+	t.Run("default cluster name", func(t *testing.T) {
+		// ceph-osd defaults to the ceph cluster, only the rook launcher always gets the flag
+		for _, mode := range []string{"lvm", "raw"} {
+			osd := OSDInfo{ID: 2, Cluster: "ceph", CVMode: mode}
+			_, args := BuildOSDContainerCommand(osd, false, "fsid", crushLocation)
+			assert.Equal(t, 0, countArg(args, "--cluster"), mode)
+		}
+		_, args := BuildOSDContainerCommand(OSDInfo{ID: 2, Cluster: "ceph", CVMode: "lvm"}, true, "fsid", crushLocation)
+		assert.Contains(t, strings.Join(args, " "), "--cluster ceph")
+	})
+
+	t.Run("non-default cluster name", func(t *testing.T) {
+		for _, onPVC := range []bool{false, true} {
+			for _, mode := range []string{"lvm", "raw"} {
+				osd := OSDInfo{ID: 2, Cluster: "backup", CVMode: mode}
+				_, args := BuildOSDContainerCommand(osd, onPVC, "fsid", crushLocation)
+				assert.Equal(t, 1, countArg(args, "--cluster"), "onPVC=%t mode=%s", onPVC, mode)
+				assert.Contains(t, strings.Join(args, " "), "--cluster backup", "onPVC=%t mode=%s", onPVC, mode)
+			}
+		}
+	})
+
+	t.Run("the deployment starts with the same args", func(t *testing.T) {
+		c := newTestCluster(t, cephv1.ClusterSpec{})
+		c.clusterInfo.FSID = "fsid"
+		osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+		osd := OSDInfo{ID: 3, UUID: "uuid-3", BlockPath: "/dev/sdb", CVMode: "raw", Location: crushLocation}
+		deployment, err := c.makeDeployment(osdProps, osd, testProvisionConfig(c))
+		assert.NoError(t, err)
+		command, args := BuildOSDContainerCommand(osd, false, "fsid", crushLocation)
+		container := deployment.Spec.Template.Spec.Containers[0]
+		assert.Equal(t, command, container.Command)
+		assert.Equal(t, args, container.Args[:len(args)])
+	})
+}
+
+func countArg(args []string, arg string) int {
+	count := 0
+	for _, a := range args {
+		if a == arg {
+			count++
+		}
+	}
+	return count
+}
+
+func TestPrepareJobTTLSecondsAfterFinished(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+
+	// the finished jobs are kept by default
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, job.Spec.TTLSecondsAfterFinished)
+
+	ttl := int32(600)
+	c.spec.Storage.PrepareJobTTLSecondsAfterFinished = &ttl
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	if assert.NotNil(t, job.Spec.TTLSecondsAfterFinished) {
+		assert.Equal(t, int32(600), *job.Spec.TTLSecondsAfterFinished)
+	}
+
+	// the jobs of the osds on pvc too
+	osdProps = osdProperties{crushHostname: "pvc1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}}
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	if assert.NotNil(t, job.Spec.TTLSecondsAfterFinished) {
+		assert.Equal(t, int32(600), *job.Spec.TTLSecondsAfterFinished)
+	}
+}
+
+func TestOSDReadinessProbe(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 7, UUID: "uuid-7", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// the probe is opt-in so that the existing osds are not restarted
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, deployment.Spec.Template.Spec.Containers[0].ReadinessProbe)
+
+	// the probe waits for the osd to be active on its socket
+	c.spec.HealthCheck.ReadinessProbe = map[rook.KeyType]*cephv1.ProbeSpec{cephv1.KeyOSD: {}}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	probe := deployment.Spec.Template.Spec.Containers[0].ReadinessProbe
+	if assert.NotNil(t, probe) {
+		command := probe.Handler.Exec.Command
+		assert.Contains(t, command[len(command)-1], "ceph --admin-daemon /run/ceph/ceph-osd.7.asok status")
+		assert.Contains(t, command[len(command)-1], `"active"`)
+	}
+
+	// the thresholds can be changed
+	c.spec.HealthCheck.ReadinessProbe = map[rook.KeyType]*cephv1.ProbeSpec{
+		cephv1.KeyOSD: {Probe: &v1.Probe{FailureThreshold: 20}},
+	}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	probe = deployment.Spec.Template.Spec.Containers[0].ReadinessProbe
+	if assert.NotNil(t, probe) {
+		assert.Equal(t, int32(20), probe.FailureThreshold)
+		assert.NotNil(t, probe.Handler.Exec)
+	}
+
+	// the probe can be disabled
+	c.spec.HealthCheck.ReadinessProbe[cephv1.KeyOSD].Disabled = true
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, deployment.Spec.Template.Spec.Containers[0].ReadinessProbe)
+}
+
+func TestOSDSchedulerName(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// the default scheduler is used by default
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "", job.Spec.Template.Spec.SchedulerName)
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "", deployment.Spec.Template.Spec.SchedulerName)
+
+	// the scheduler is set on the prepare and daemon pods
+	c.spec.Storage.SchedulerName = "numa-scheduler"
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "numa-scheduler", job.Spec.Template.Spec.SchedulerName)
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "numa-scheduler", deployment.Spec.Template.Spec.SchedulerName)
+
+	// the scheduler of the device set takes precedence
+	osdProps = osdProperties{crushHostname: "pvc1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}, schedulerName: "custom-scheduler"}
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "custom-scheduler", job.Spec.Template.Spec.SchedulerName)
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "custom-scheduler", deployment.Spec.Template.Spec.SchedulerName)
+}
+
+func TestOSDPrepareResources(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdResources := v1.ResourceRequirements{
+		Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("4Gi")},
+	}
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}, resources: osdResources}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// the prepare pod uses the osd resources if the prepare resources are not set
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	for _, container := range append(job.Spec.Template.Spec.InitContainers, job.Spec.Template.Spec.Containers...) {
+		assert.Equal(t, osdResources, container.Resources, container.Name)
+	}
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, osdResources, deployment.Spec.Template.Spec.Containers[0].Resources)
+
+	// distinct resources are applied to the prepare pod and the osd daemon
+	prepareResources := v1.ResourceRequirements{
+		Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("500Mi")},
+	}
+	c.spec.Resources = cephv1.ResourceSpec{cephv1.ResourcesKeyPrepareOSD: prepareResources}
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	for _, container := range append(job.Spec.Template.Spec.InitContainers, job.Spec.Template.Spec.Containers...) {
+		assert.Equal(t, prepareResources, container.Resources, container.Name)
+	}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, osdResources, deployment.Spec.Template.Spec.Containers[0].Resources)
+
+	// no resources at all by default
+	c.spec.Resources = nil
+	job, err = c.makeJob(osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, v1.ResourceRequirements{}, job.Spec.Template.Spec.Containers[0].Resources)
+}
+
+func TestOSDHostPathTypes(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{DataDirHostPath: "/var/lib/rook"})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+	hostPathType := func(volumes []v1.Volume, name string) *v1.HostPathType {
+		for _, volume := range volumes {
+			if volume.Name == name {
+				assert.NotNil(t, volume.HostPath, name)
+				return volume.HostPath.Type
+			}
+		}
+		assert.Fail(t, "volume not found", name)
+		return nil
+	}
+
+	// no type is set by default
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, hostPathType(job.Spec.Template.Spec.Volumes, "devices"))
+	assert.Nil(t, hostPathType(job.Spec.Template.Spec.Volumes, k8sutil.DataDirVolume))
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, hostPathType(deployment.Spec.Template.Spec.Volumes, "devices"))
+	assert.Nil(t, hostPathType(deployment.Spec.Template.Spec.Volumes, k8sutil.DataDirVolume))
+
+	// the types are set on the volumes of the prepare and daemon pods
+	devicesType := v1.HostPathDirectory
+	dataDirType := v1.HostPathDirectoryOrCreate
+	c.spec.Storage.DevicesHostPathType = &devicesType
+	c.spec.Storage.DataDirHostPathType = &dataDirType
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, &devicesType, hostPathType(job.Spec.Template.Spec.Volumes, "devices"))
+	assert.Equal(t, &dataDirType, hostPathType(job.Spec.Template.Spec.Volumes, k8sutil.DataDirVolume))
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, &devicesType, hostPathType(deployment.Spec.Template.Spec.Volumes, "devices"))
+	assert.Equal(t, &dataDirType, hostPathType(deployment.Spec.Template.Spec.Volumes, k8sutil.DataDirVolume))
+	// the other host paths are not changed
+	assert.Nil(t, hostPathType(job.Spec.Template.Spec.Volumes, "udev"))
+}
+
+func TestOSDCrushUpdateOnStart(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+	flag := "--osd-crush-update-on-start=false"
+
+	// the osds update their crush location by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.NotContains(t, deployment.Spec.Template.Spec.Containers[0].Args, flag)
+	update := true
+	c.spec.Storage.CrushUpdateOnStart = &update
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.NotContains(t, deployment.Spec.Template.Spec.Containers[0].Args, flag)
+
+	// the update is disabled
+	update = false
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, flag)
+
+	// lvm osds on pvc pass it to the osd daemon too
+	osdProps = osdProperties{crushHostname: "pvc1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}}
+	osd.CVMode = "lvm"
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, flag)
+}
+
+func TestOSDCABundle(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+	findVolume := func(spec v1.PodSpec) *v1.Volume {
+		for i := range spec.Volumes {
+			if spec.Volumes[i].Name == caBundleVolName {
+				return &spec.Volumes[i]
+			}
+		}
+		return nil
+	}
+	assertMounted := func(spec v1.PodSpec, mountPath string) {
+		for _, container := range append(spec.InitContainers, spec.Containers...) {
+			assert.Contains(t, container.VolumeMounts, v1.VolumeMount{Name: caBundleVolName, MountPath: mountPath, ReadOnly: true}, container.Name)
+		}
+	}
+
+	// nothing is mounted by default
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, findVolume(job.Spec.Template.Spec))
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, findVolume(deployment.Spec.Template.Spec))
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		for _, mount := range container.VolumeMounts {
+			assert.NotEqual(t, caBundleVolName, mount.Name)
+		}
+	}
+
+	// a configmap is mounted at the default path in the prepare and daemon pods
+	c.spec.Storage.CABundle = &cephv1.OSDCABundleSpec{ConfigMapName: "internal-ca"}
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	if volume := findVolume(job.Spec.Template.Spec); assert.NotNil(t, volume) {
+		assert.Equal(t, "internal-ca", volume.ConfigMap.Name)
+	}
+	assertMounted(job.Spec.Template.Spec, "/etc/rook/ca-bundle")
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	if volume := findVolume(deployment.Spec.Template.Spec); assert.NotNil(t, volume) {
+		assert.Equal(t, "internal-ca", volume.ConfigMap.Name)
+	}
+	assertMounted(deployment.Spec.Template.Spec, "/etc/rook/ca-bundle")
+
+	// a secret is mounted at the configured path
+	c.spec.Storage.CABundle = &cephv1.OSDCABundleSpec{SecretName: "internal-ca", MountPath: "/etc/pki/internal/"}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	if volume := findVolume(deployment.Spec.Template.Spec); assert.NotNil(t, volume) {
+		assert.Equal(t, "internal-ca", volume.Secret.SecretName)
+	}
+	assertMounted(deployment.Spec.Template.Spec, "/etc/pki/internal")
+
+	t.Run("invalid settings", func(t *testing.T) {
+		for name, caBundle := range map[string]*cephv1.OSDCABundleSpec{
+			"no source":          {},
+			"both sources":       {ConfigMapName: "ca", SecretName: "ca"},
+			"relative path":      {ConfigMapName: "ca", MountPath: "etc/ca"},
+			"root path":          {ConfigMapName: "ca", MountPath: "/"},
+			"path of a rook dir": {ConfigMapName: "ca", MountPath: "/etc/ceph"},
+		} {
+			c.spec.Storage.CABundle = caBundle
+			_, err := c.makeDeployment(osdProps, osd, dataPathMap)
+			assert.Error(t, err, name)
+			_, err = c.makeJob(osdProps, dataPathMap)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestOSDMemoryTargets(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	memoryTargetArgs := func(osd OSDInfo) []string {
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		args := []string{}
+		for _, arg := range deployment.Spec.Template.Spec.Containers[0].Args {
+			if strings.HasPrefix(arg, "--osd-memory-target") {
+				args = append(args, arg)
+			}
+		}
+		return args
+	}
+	hddOSD := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw", DeviceClass: "hdd"}
+	ssdOSD := OSDInfo{ID: 1, UUID: "uuid-1", BlockPath: "/dev/sdc", CVMode: "raw", DeviceClass: "ssd"}
+	noClassOSD := OSDInfo{ID: 2, UUID: "uuid-2", BlockPath: "/dev/sdd", CVMode: "raw"}
+
+	// the memory target is computed by ceph by default
+	assert.Empty(t, memoryTargetArgs(hddOSD))
+	assert.Empty(t, memoryTargetArgs(ssdOSD))
+
+	// the target of the device class of the osd is selected
+	c.spec.Storage.MemoryTargets = map[string]resource.Quantity{
+		"hdd": resource.MustParse("4Gi"),
+		"ssd": resource.MustParse("6Gi"),
+	}
+	assert.Equal(t, []string{"--osd-memory-target=4294967296"}, memoryTargetArgs(hddOSD))
+	assert.Equal(t, []string{"--osd-memory-target=6442450944"}, memoryTargetArgs(ssdOSD))
+	assert.Empty(t, memoryTargetArgs(noClassOSD))
+
+	// the other device classes fall back to the computed target
+	delete(c.spec.Storage.MemoryTargets, "hdd")
+	assert.Empty(t, memoryTargetArgs(hddOSD))
+
+	// the target must be positive
+	c.spec.Storage.MemoryTargets["ssd"] = resource.MustParse("0")
+	_, err := c.makeDeployment(osdProps, ssdOSD, dataPathMap)
+	assert.Error(t, err)
+}
+
+func TestNodeMemoryTarget(t *testing.T) {
+	t.Run("derived target", func(t *testing.T) {
+		target, err := nodeMemoryTarget(resource.MustParse("64Gi"), 8)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(8*1024*1024*1024), target)
+
+		// the target is never below the minimum of ceph
+		target, err = nodeMemoryTarget(resource.MustParse("4Gi"), 8)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(minOSDMemoryTarget), target)
+
+		_, err = nodeMemoryTarget(resource.MustParse("64Gi"), 0)
+		assert.Error(t, err)
+		_, err = nodeMemoryTarget(resource.Quantity{}, 8)
+		assert.Error(t, err)
+	})
+
+	t.Run("deployment args", func(t *testing.T) {
+		c := newTestCluster(t, cephv1.ClusterSpec{})
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1.example.com", Labels: map[string]string{v1.LabelHostname: "node1"}},
+			Status:     v1.NodeStatus{Allocatable: v1.ResourceList{v1.ResourceMemory: resource.MustParse("48Gi")}},
+		}
+		_, err := c.context.Clientset.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+		assert.NoError(t, err)
+		dataPathMap := testProvisionConfig(c)
+		osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+		osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw", DeviceClass: "hdd"}
+		memoryTargetArgs := func() []string {
+			// the node is looked up by the caller of makeDeployment
+			props := osdProps
+			props.nodeMemoryTarget = c.getNodeMemoryTarget(props, osd)
+			deployment, err := c.makeDeployment(props, osd, dataPathMap)
+			assert.NoError(t, err)
+			args := []string{}
+			for _, arg := range deployment.Spec.Template.Spec.Containers[0].Args {
+				if strings.HasPrefix(arg, "--osd-memory-target") {
+					args = append(args, arg)
+				}
+			}
+			return args
+		}
+
+		// not derived by default
+		assert.Empty(t, memoryTargetArgs())
+
+		c.spec.Storage.NodeMemoryTarget = &cephv1.OSDNodeMemoryTargetSpec{ExpectedOSDsPerNode: 6}
+		assert.Equal(t, []string{"--osd-memory-target=8589934592"}, memoryTargetArgs())
+
+		// the target of the device class wins
+		c.spec.Storage.MemoryTargets = map[string]resource.Quantity{"hdd": resource.MustParse("4Gi")}
+		assert.Equal(t, []string{"--osd-memory-target=4294967296"}, memoryTargetArgs())
+		c.spec.Storage.MemoryTargets = nil
+
+		// ceph computes the target from the memory limit
+		osdProps.resources = v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("4Gi")}}
+		assert.Empty(t, memoryTargetArgs())
+		osdProps.resources = v1.ResourceRequirements{}
+
+		// unknown node
+		osdProps.crushHostname = "node2"
+		assert.Empty(t, memoryTargetArgs())
+
+		// the deployment is generated from the derived target without looking up the node
+		osdProps.nodeMemoryTarget = 2 * 1024 * 1024 * 1024
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		assert.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--osd-memory-target=2147483648")
+	})
+}
+
+func TestWipeDeviceOnProvisionEnvVar(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+
+	// not set unless opted in
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, "ROOK_WIPE_DEVICE_ON_PROVISION", "", false)
+
+	c.spec.Storage.WipeDevicesOnProvision = true
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, "ROOK_WIPE_DEVICE_ON_PROVISION", "true", true)
+
+	// the PVCs are never wiped, a PVC reused after its OSD was purged must be zapped manually
+	osdProps = osdProperties{crushHostname: "pvc1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}}
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, "ROOK_WIPE_DEVICE_ON_PROVISION", "", false)
+}
+
+func TestOSDDebugOptions(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// disabled by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.False(t, deployment.Spec.Template.Spec.Containers[0].Stdin)
+	assert.False(t, deployment.Spec.Template.Spec.Containers[0].TTY)
+
+	c.spec.Storage.Debug = &cephv1.OSDDebugSpec{Stdin: true, TTY: true}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.True(t, deployment.Spec.Template.Spec.Containers[0].Stdin)
+	assert.True(t, deployment.Spec.Template.Spec.Containers[0].TTY)
+	// only the osd daemon container is changed
+	for _, container := range deployment.Spec.Template.Spec.InitContainers {
+		assert.False(t, container.Stdin, container.Name)
+		assert.False(t, container.TTY, container.Name)
+	}
+
+	c.spec.Storage.Debug = &cephv1.OSDDebugSpec{Stdin: true}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.True(t, deployment.Spec.Template.Spec.Containers[0].Stdin)
+	assert.False(t, deployment.Spec.Template.Spec.Containers[0].TTY)
+}
+
+func TestOSDLogHostPath(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+	logHostPath := func(spec v1.PodSpec) string {
+		var logVolume string
+		for _, m := range spec.Containers[0].VolumeMounts {
+			if m.MountPath == "/var/log/ceph" {
+				logVolume = m.Name
+			}
+		}
+		assert.NotEmpty(t, logVolume)
+		for _, v := range spec.Volumes {
+			if v.Name == logVolume {
+				return v.HostPath.Path
+			}
+		}
+		return ""
+	}
+
+	// the logs are stored below the data dir host path by default
+	c.spec.Storage.LogHostPath = "/var/log/rook-osd"
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	defaultPath := logHostPath(deployment.Spec.Template.Spec)
+	assert.NotEqual(t, "/var/log/rook-osd", defaultPath)
+
+	// the log host path is used when the logs are written to files
+	c.spec.LogCollector.Enabled = true
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/log/rook-osd", logHostPath(deployment.Spec.Template.Spec))
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/log/rook-osd", logHostPath(job.Spec.Template.Spec))
+
+	c.spec.Storage.LogHostPath = ""
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultPath, logHostPath(deployment.Spec.Template.Spec))
+
+	// the path must be absolute
+	for _, invalid := range []string{"var/log/rook-osd", "/"} {
+		c.spec.Storage.LogHostPath = invalid
+		_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err, invalid)
+		_, err = c.makeJob(osdProps, dataPathMap)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestProvisionCommand(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+
+	// the rook binary is run by default
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	defaultContainer := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, []string{"/rook/tini"}, defaultContainer.Command)
+	assert.Equal(t, []string{"--", "/rook/rook", "ceph", "osd", "provision"}, defaultContainer.Args)
+
+	// a custom command replaces the command and the args only
+	c.spec.Storage.ProvisionCommand = []string{"/usr/local/bin/provision-wrapper", "--", "/rook/rook", "ceph", "osd", "provision"}
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	container := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, c.spec.Storage.ProvisionCommand, container.Command)
+	assert.Empty(t, container.Args)
+	assert.Equal(t, defaultContainer.Env, container.Env)
+	assert.Equal(t, defaultContainer.VolumeMounts, container.VolumeMounts)
+	assert.Equal(t, defaultContainer.Image, container.Image)
+}
+
+func TestPrepareHostnames(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// the prepare job runs on the node by default
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{v1.LabelHostname: "node1"}, job.Spec.Template.Spec.NodeSelector)
+
+	// the prepare job runs on the staging node while the osd runs on the node
+	c.spec.Storage.PrepareHostnames = map[string]string{"node1": "staging", "node2": "other"}
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{v1.LabelHostname: "staging"}, job.Spec.Template.Spec.NodeSelector)
+	assert.Equal(t, "rook-ceph-osd-prepare-node1", job.Name)
+	verifyEnvVar(t, job.Spec.Template.Spec.Containers[0].Env, "ROOK_NODE_NAME", "node1", true)
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{v1.LabelHostname: "node1"}, deployment.Spec.Template.Spec.NodeSelector)
+
+	// the prepare jobs of the other nodes are not affected
+	osdProps.crushHostname = "node3"
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{v1.LabelHostname: "node3"}, job.Spec.Template.Spec.NodeSelector)
+}
+
+func TestOSDProgressDeadlineSeconds(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// the default of kubernetes applies if no deadline is set
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, deployment.Spec.ProgressDeadlineSeconds)
+
+	deadline := int32(3600)
+	c.spec.Storage.ProgressDeadlineSeconds = &deadline
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3600), *deployment.Spec.ProgressDeadlineSeconds)
+	// the deployment does not share the value of the spec
+	*deployment.Spec.ProgressDeadlineSeconds = 60
+	assert.Equal(t, int32(3600), deadline)
+}
+
+func TestOSDAdminSocketDir(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// the default admin socket is used by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	for _, arg := range container.Args {
+		assert.NotContains(t, arg, "--admin-socket")
+	}
+	assert.Contains(t, container.LivenessProbe.Handler.Exec.Command[4], "/run/ceph/ceph-osd.0.asok")
+	socketMounts := func(deployment *appsv1.Deployment) map[string]string {
+		mounts := map[string]string{}
+		podSpec := deployment.Spec.Template.Spec
+		for _, container := range append(podSpec.InitContainers, podSpec.Containers...) {
+			for _, mount := range container.VolumeMounts {
+				if mount.Name == adminSocketVolName {
+					mounts[container.Name] = mount.MountPath
+				}
+			}
+		}
+		return mounts
+	}
+	assert.Empty(t, socketMounts(deployment))
+
+	// the custom directory is mounted in the daemon container running the probes
+	c.spec.Storage.AdminSocketDir = "/var/run/custom/"
+	c.spec.HealthCheck.ReadinessProbe = map[rook.KeyType]*cephv1.ProbeSpec{cephv1.KeyOSD: {}}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	container = deployment.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, 1, countArg(container.Args, "--admin-socket=/var/run/custom/ceph-osd.0.asok"))
+	assert.Contains(t, container.LivenessProbe.Handler.Exec.Command[4], "/var/run/custom/ceph-osd.0.asok")
+	assert.Contains(t, container.ReadinessProbe.Handler.Exec.Command[4], "/var/run/custom/ceph-osd.0.asok")
+	assert.Equal(t, map[string]string{container.Name: "/var/run/custom"}, socketMounts(deployment))
+	emptyDirs := 0
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if volume.Name == adminSocketVolName {
+			assert.NotNil(t, volume.EmptyDir)
+			emptyDirs++
+		}
+	}
+	assert.Equal(t, 1, emptyDirs)
+
+	// the default directory exists in the image
+	c.spec.Storage.AdminSocketDir = "/run/ceph"
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Empty(t, socketMounts(deployment))
+
+	for _, dir := range []string{"run/ceph", "/", "//"} {
+		c.spec.Storage.AdminSocketDir = dir
+		_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err, dir)
+	}
+}
+
+func TestOSDNodeSelector(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// only the hostname is selected by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{v1.LabelHostname: "node1"}, deployment.Spec.Template.Spec.NodeSelector)
+
+	// the additional labels are merged with the hostname, which cannot be overridden
+	c.spec.Storage.NodeSelector = map[string]string{"storage-tier": "fast", v1.LabelHostname: "node2"}
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{v1.LabelHostname: "node1", "storage-tier": "fast"}, deployment.Spec.Template.Spec.NodeSelector)
+	// the storage spec is not modified
+	assert.Equal(t, "node2", c.spec.Storage.NodeSelector[v1.LabelHostname])
+
+	// the portable osds on pvc are not pinned to a node
+	osdProps.pvc = v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}
+	osdProps.portable = true
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Empty(t, deployment.Spec.Template.Spec.NodeSelector)
+}
+
+func TestValidateDataPathInitContainer(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 3, UUID: "uuid-3", BlockPath: "/dev/sdb", CVMode: "raw"}
+	findInitContainer := func(containers []v1.Container) (int, *v1.Container) {
+		for i := range containers {
+			if containers[i].Name == validateDataPathInitContainer {
+				return i, &containers[i]
+			}
+		}
+		return -1, nil
+	}
+
+	// not added by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	_, container := findInitContainer(deployment.Spec.Template.Spec.InitContainers)
+	assert.Nil(t, container)
+
+	c.spec.Storage.ValidateDataPath = true
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	initContainers := deployment.Spec.Template.Spec.InitContainers
+	i, container := findInitContainer(initContainers)
+	assert.NotNil(t, container)
+	assert.Contains(t, container.Command[2], "OSD_DATA_DIR=/var/lib/ceph/osd/ceph-3\n")
+	// the data path is checked once the osd is activated and with the mounts of the daemon
+	assert.Equal(t, "activate", initContainers[i-1].Name)
+	assert.Equal(t, deployment.Spec.Template.Spec.Containers[0].VolumeMounts, container.VolumeMounts)
+	mounted := false
+	for _, mount := range container.VolumeMounts {
+		mounted = mounted || mount.MountPath == "/var/lib/ceph/osd/ceph-3"
+	}
+	assert.True(t, mounted)
+}
+
+func TestOSDRestartThrottle(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 3, UUID: "uuid-3", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// not added by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Nil(t, findContainer(deployment.Spec.Template.Spec.InitContainers, restartThrottleInitContainer))
+
+	t.Run("the throttle is the first init container", func(t *testing.T) {
+		c.spec.Storage.RestartThrottle = &cephv1.OSDRestartThrottleSpec{DelaySeconds: 120}
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		container := deployment.Spec.Template.Spec.InitContainers[0]
+		assert.Equal(t, restartThrottleInitContainer, container.Name)
+		assert.Contains(t, container.Command[2], "MARKER=/var/lib/rook/ns/osd3-restarts\n")
+		assert.Contains(t, container.Command[2], "FAILURE_THRESHOLD=3\nDELAY_SECONDS=120\nWINDOW_SECONDS=600\n")
+		assert.Equal(t, []v1.VolumeMount{{Name: k8sutil.DataDirVolume, MountPath: k8sutil.DataDir}}, container.VolumeMounts)
+	})
+
+	t.Run("custom threshold and window", func(t *testing.T) {
+		c.spec.Storage.RestartThrottle = &cephv1.OSDRestartThrottleSpec{FailureThreshold: 5, DelaySeconds: 60, WindowSeconds: 300}
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		container := findContainer(deployment.Spec.Template.Spec.InitContainers, restartThrottleInitContainer)
+		assert.NotNil(t, container)
+		assert.Contains(t, container.Command[2], "FAILURE_THRESHOLD=5\nDELAY_SECONDS=60\nWINDOW_SECONDS=300\n")
+	})
+
+	t.Run("the marker of the osds on pvc is kept on the node", func(t *testing.T) {
+		c.spec.Storage.RestartThrottle = &cephv1.OSDRestartThrottleSpec{DelaySeconds: 120}
+		c.spec.DataDirHostPath = "/var/lib/rook"
+		pvcProps := osdProperties{crushHostname: "pvc1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}}
+		deployment, err := c.makeDeployment(pvcProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		podSpec := deployment.Spec.Template.Spec
+		container := podSpec.InitContainers[0]
+		assert.Equal(t, restartThrottleInitContainer, container.Name)
+		assert.Contains(t, container.Command[2], "MARKER=/var/lib/rook/ns/osd3-restarts\n")
+		assert.Equal(t, []v1.VolumeMount{{Name: restartThrottleVolumeName, MountPath: k8sutil.DataDir}}, container.VolumeMounts)
+		var throttleVolume, dataVolume *v1.Volume
+		for i := range podSpec.Volumes {
+			switch podSpec.Volumes[i].Name {
+			case restartThrottleVolumeName:
+				throttleVolume = &podSpec.Volumes[i]
+			case k8sutil.DataDirVolume:
+				dataVolume = &podSpec.Volumes[i]
+			}
+		}
+		// the data dir of the osd is an emptyDir wiped with every pod, the marker is on the host
+		assert.NotNil(t, dataVolume)
+		assert.NotNil(t, dataVolume.EmptyDir)
+		assert.NotNil(t, throttleVolume)
+		assert.Equal(t, "/var/lib/rook", throttleVolume.HostPath.Path)
+
+		c.spec.DataDirHostPath = ""
+		_, err = c.makeDeployment(pvcProps, osd, dataPathMap)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid throttle", func(t *testing.T) {
+		for _, throttle := range []cephv1.OSDRestartThrottleSpec{
+			{},
+			{DelaySeconds: -1},
+			{DelaySeconds: 60, FailureThreshold: -1},
+			{DelaySeconds: 60, WindowSeconds: -1},
+		} {
+			throttle := throttle
+			c.spec.Storage.RestartThrottle = &throttle
+			_, err := c.makeDeployment(osdProps, osd, dataPathMap)
+			assert.Error(t, err)
+		}
+	})
+}
+
+func TestOSDAdminSocketEmptyDir(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+	socketMountPaths := func(containers []v1.Container) []string {
+		paths := []string{}
+		for _, container := range containers {
+			for _, mount := range container.VolumeMounts {
+				if mount.Name == adminSocketVolName {
+					paths = append(paths, mount.MountPath)
+				}
+			}
+		}
+		return paths
+	}
+
+	// not mounted by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		assert.NotEqual(t, adminSocketVolName, volume.Name)
+	}
+
+	// the emptyDir is shared by all the containers at the default socket directory
+	c.spec.Storage.AdminSocketEmptyDir = true
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	podSpec := deployment.Spec.Template.Spec
+	volumes := 0
+	for _, volume := range podSpec.Volumes {
+		if volume.Name == adminSocketVolName {
+			volumes++
+			assert.NotNil(t, volume.EmptyDir)
+		}
+	}
+	assert.Equal(t, 1, volumes)
+	paths := append(socketMountPaths(podSpec.InitContainers), socketMountPaths(podSpec.Containers)...)
+	assert.Len(t, paths, len(podSpec.InitContainers)+len(podSpec.Containers))
+	for _, path := range paths {
+		assert.Equal(t, "/run/ceph", path)
+	}
+
+	// the emptyDir follows the admin socket directory
+	c.spec.Storage.AdminSocketDir = "/var/run/custom"
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	podSpec = deployment.Spec.Template.Spec
+	paths = append(socketMountPaths(podSpec.InitContainers), socketMountPaths(podSpec.Containers)...)
+	assert.Len(t, paths, len(podSpec.InitContainers)+len(podSpec.Containers))
+	for _, path := range paths {
+		assert.Equal(t, "/var/run/custom", path)
+	}
+}
+
+func TestOSDBinariesMountPath(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", CVMode: "lvm"}
+	osdProps := osdProperties{crushHostname: "node1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"}}
+	assertBinariesDir := func(dir string) {
+		deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.NoError(t, err)
+		job, err := c.makeJob(osdProps, dataPathMap)
+		assert.NoError(t, err)
+		for _, podSpec := range []v1.PodSpec{deployment.Spec.Template.Spec, job.Spec.Template.Spec} {
+			copyBins := findContainer(podSpec.InitContainers, "copy-bins")
+			assert.NotNil(t, copyBins)
+			assert.Equal(t, []string{"copy-binaries", "--copy-to-dir", dir}, copyBins.Args)
+			// the binaries are started from the directory they are copied to
+			container := podSpec.Containers[0]
+			assert.Equal(t, []string{dir + "/tini"}, container.Command)
+			assert.Equal(t, dir+"/rook", container.Args[1])
+			for _, ct := range []v1.Container{*copyBins, container} {
+				mounted := false
+				for _, mount := range ct.VolumeMounts {
+					if mount.Name == rookBinariesVolumeName {
+						mounted = true
+						assert.Equal(t, dir, mount.MountPath, ct.Name)
+					}
+				}
+				assert.True(t, mounted, ct.Name)
+			}
+		}
+	}
+
+	assertBinariesDir("/rook")
+
+	c.spec.Storage.BinariesMountPath = "/opt/rook-binaries/"
+	assertBinariesDir("/opt/rook-binaries")
+
+	for _, dir := range []string{"opt/rook", "/", "//"} {
+		c.spec.Storage.BinariesMountPath = dir
+		_, err := c.makeDeployment(osdProps, osd, dataPathMap)
+		assert.Error(t, err, dir)
+		_, err = c.makeJob(osdProps, dataPathMap)
+		assert.Error(t, err, dir)
+	}
+}
+
+func TestOSDCephConfigDir(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+	items := []v1.KeyToPath{{Key: "fragment", Path: "ceph.client.conf"}}
+	configMapProjections := func(spec v1.PodSpec) []*v1.ConfigMapProjection {
+		projections := []*v1.ConfigMapProjection{}
+		for _, volume := range spec.Volumes {
+			if volume.Name == k8sutil.ConfigOverrideName && volume.Projected != nil {
+				for _, source := range volume.Projected.Sources {
+					projections = append(projections, source.ConfigMap)
+				}
+			}
+		}
+		return projections
+	}
+
+	// only the config override is projected by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Len(t, configMapProjections(deployment.Spec.Template.Spec), 1)
+
+	// the configmap is merged into the read-only config directory of the daemon pods
+	c.spec.Storage.CephConfigDir = &cephv1.OSDCephConfigDirSpec{ConfigMapName: "extra-conf", Items: items}
+	pvcProps := osdProperties{crushHostname: "node1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"}}
+	for _, props := range []osdProperties{osdProps, pvcProps} {
+		deployment, err = c.makeDeployment(props, osd, dataPathMap)
+		assert.NoError(t, err)
+		spec := deployment.Spec.Template.Spec
+		projections := configMapProjections(spec)
+		if assert.Len(t, projections, 2) {
+			assert.Equal(t, k8sutil.ConfigOverrideName, projections[0].Name)
+			assert.Equal(t, "extra-conf", projections[1].Name)
+			assert.Equal(t, items, projections[1].Items)
+		}
+		for _, volume := range spec.Volumes {
+			assert.NotEqual(t, cephConfigDirVolName, volume.Name)
+		}
+	}
+
+	// the files are mounted into the config directory generated in the prepare pod
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	spec := job.Spec.Template.Spec
+	volumes := 0
+	for _, volume := range spec.Volumes {
+		if volume.Name == cephConfigDirVolName {
+			volumes++
+			assert.Equal(t, "extra-conf", volume.ConfigMap.Name)
+		}
+	}
+	assert.Equal(t, 1, volumes)
+	generatedConfigVolume, _ := controller.ConfGeneratedInPodVolumeAndMount()
+	mounted := 0
+	for _, container := range append(spec.InitContainers, spec.Containers...) {
+		sharesConfig := false
+		for _, mount := range container.VolumeMounts {
+			sharesConfig = sharesConfig || mount.Name == generatedConfigVolume.Name
+		}
+		expected := v1.VolumeMount{Name: cephConfigDirVolName, MountPath: "/etc/ceph/ceph.client.conf", SubPath: "ceph.client.conf", ReadOnly: true}
+		if sharesConfig {
+			mounted++
+			assert.Contains(t, container.VolumeMounts, expected, container.Name)
+		} else {
+			assert.NotContains(t, container.VolumeMounts, expected, container.Name)
+		}
+	}
+	assert.NotZero(t, mounted)
+
+	t.Run("invalid settings", func(t *testing.T) {
+		for name, configDir := range map[string]*cephv1.OSDCephConfigDirSpec{
+			"no configmap": {Items: items},
+			"no items":     {ConfigMapName: "extra-conf"},
+			"no key":       {ConfigMapName: "extra-conf", Items: []v1.KeyToPath{{Path: "extra.conf"}}},
+			"subdirectory": {ConfigMapName: "extra-conf", Items: []v1.KeyToPath{{Key: "a", Path: "conf.d/extra.conf"}}},
+			"hidden":       {ConfigMapName: "extra-conf", Items: []v1.KeyToPath{{Key: "a", Path: "..data"}}},
+			"ceph.conf":    {ConfigMapName: "extra-conf", Items: []v1.KeyToPath{{Key: "a", Path: "ceph.conf"}}},
+			"keyring":      {ConfigMapName: "extra-conf", Items: []v1.KeyToPath{{Key: "a", Path: "keyring"}}},
+		} {
+			c.spec.Storage.CephConfigDir = configDir
+			_, err := c.makeDeployment(osdProps, osd, dataPathMap)
+			assert.Error(t, err, name)
+			_, err = c.makeJob(osdProps, dataPathMap)
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestOSDImages(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	nodeProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}, {Name: "sdc"}}}
+	pvcProps := osdProperties{crushHostname: "node1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"}}
+	osd0 := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+	osd1 := OSDInfo{ID: 1, UUID: "uuid-1", BlockPath: "/mnt/mypvc", CVMode: "lvm"}
+	osd2 := OSDInfo{ID: 2, UUID: "uuid-2", BlockPath: "/dev/sdc", CVMode: "raw"}
+	c.spec.Storage.OSDImages = map[string]string{"0": "ceph/ceph:v14", "1": "ceph/ceph:v14"}
+	// assertImages checks the image of the ceph containers and returns the number of rook containers
+	assertImages := func(spec v1.PodSpec, cephImage string) int {
+		rookContainers := 0
+		for _, container := range append(spec.InitContainers, spec.Containers...) {
+			if container.Image == c.rookVersion {
+				rookContainers++
+				continue
+			}
+			assert.Equal(t, cephImage, container.Image, container.Name)
+		}
+		return rookContainers
+	}
+
+	// only the overridden osds run the image
+	deployment, err := c.makeDeployment(nodeProps, osd0, dataPathMap)
+	assert.NoError(t, err)
+	assertImages(deployment.Spec.Template.Spec, "ceph/ceph:v14")
+	deployment, err = c.makeDeployment(nodeProps, osd2, dataPathMap)
+	assert.NoError(t, err)
+	assertImages(deployment.Spec.Template.Spec, "ceph/ceph:v15")
+	// the rook containers of the lvm osds on pvc keep their image
+	deployment, err = c.makeDeployment(pvcProps, osd1, dataPathMap)
+	assert.NoError(t, err)
+	assert.NotZero(t, assertImages(deployment.Spec.Template.Spec, "ceph/ceph:v14"))
+
+	// the prepare jobs always run the cluster image
+	job, err := c.makeJob(pvcProps, dataPathMap)
+	assert.NoError(t, err)
+	assertImages(job.Spec.Template.Spec, "ceph/ceph:v15")
+	job, err = c.makeJob(nodeProps, dataPathMap)
+	assert.NoError(t, err)
+	assertImages(job.Spec.Template.Spec, "ceph/ceph:v15")
+}
+
+func TestOSDReadOnlyRootFilesystem(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+	mountPaths := func(container v1.Container) []string {
+		paths := []string{}
+		for _, mount := range container.VolumeMounts {
+			paths = append(paths, mount.MountPath)
+		}
+		return paths
+	}
+
+	// the root filesystem is writable by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	spec := deployment.Spec.Template.Spec
+	assert.False(t, *spec.Containers[0].SecurityContext.ReadOnlyRootFilesystem)
+	assert.NotContains(t, mountPaths(spec.Containers[0]), "/tmp")
+	assert.NotContains(t, mountPaths(spec.Containers[0]), "/var/run")
+
+	c.spec.Storage.ReadOnlyRootFilesystem = true
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	spec = deployment.Spec.Template.Spec
+	assert.True(t, *spec.Containers[0].SecurityContext.ReadOnlyRootFilesystem)
+	assert.Contains(t, mountPaths(spec.Containers[0]), "/tmp")
+	assert.Contains(t, mountPaths(spec.Containers[0]), "/var/run")
+	emptyDirs := 0
+	for _, volume := range spec.Volumes {
+		if volume.Name == tmpVolName || volume.Name == varRunVolName {
+			assert.NotNil(t, volume.EmptyDir)
+			emptyDirs++
+		}
+	}
+	assert.Equal(t, 2, emptyDirs)
+	// the init containers keep a writable root filesystem and no additional mounts
+	for _, container := range spec.InitContainers {
+		readOnly := container.SecurityContext != nil && container.SecurityContext.ReadOnlyRootFilesystem != nil && *container.SecurityContext.ReadOnlyRootFilesystem
+		assert.False(t, readOnly, container.Name)
+		assert.NotContains(t, mountPaths(container), "/tmp", container.Name)
+	}
+
+	// the osds on pvc in raw mode are activated by the init containers
+	pvcProps := osdProperties{crushHostname: "pvc1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"}}
+	pvcOSD := OSDInfo{ID: 1, UUID: "uuid-1", BlockPath: "/mnt/pvc1", CVMode: "raw"}
+	deployment, err = c.makeDeployment(pvcProps, pvcOSD, dataPathMap)
+	assert.NoError(t, err)
+	spec = deployment.Spec.Template.Spec
+	assert.True(t, *spec.Containers[0].SecurityContext.ReadOnlyRootFilesystem)
+	assert.Contains(t, mountPaths(spec.Containers[0]), "/tmp")
+
+	// the osds on pvc in lvm mode run ceph-volume lvm activate in the daemon container, which writes
+	// to /etc/lvm, so their root filesystem stays writable
+	pvcOSD.CVMode = "lvm"
+	pvcOSD.BlockPath = "/dev/ceph-vg/osd-block-uuid-1"
+	deployment, err = c.makeDeployment(pvcProps, pvcOSD, dataPathMap)
+	assert.NoError(t, err)
+	spec = deployment.Spec.Template.Spec
+	assert.Contains(t, spec.Containers[0].Args, "start")
+	assert.False(t, *spec.Containers[0].SecurityContext.ReadOnlyRootFilesystem)
+	assert.NotContains(t, mountPaths(spec.Containers[0]), "/tmp")
+	for _, volume := range spec.Volumes {
+		assert.NotEqual(t, tmpVolName, volume.Name)
+	}
+}
+
+func TestOSDPodHostname(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 3, UUID: "uuid-3", BlockPath: "/dev/sdb", CVMode: "raw"}
+
+	// not set by default
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Empty(t, deployment.Spec.Template.Spec.Hostname)
+	assert.Empty(t, deployment.Spec.Template.Spec.Subdomain)
+
+	c.spec.Storage.PodHostname = "osd"
+	c.spec.Storage.PodSubdomain = "rook-ceph-osds"
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.Equal(t, "osd-3", deployment.Spec.Template.Spec.Hostname)
+	assert.Equal(t, "rook-ceph-osds", deployment.Spec.Template.Spec.Subdomain)
+
+	// the hostname and the subdomain must be dns labels
+	c.spec.Storage.PodHostname = "OSD"
+	_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.Error(t, err)
+	c.spec.Storage.PodHostname = "osd"
+	c.spec.Storage.PodSubdomain = "rook.osds"
+	_, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.Error(t, err)
+}
+
+func TestGetDaemonArgs(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	osdProps := osdProperties{crushHostname: "node1"}
+	osd := OSDInfo{ID: 3, CVMode: "raw"}
+
+	// no args by default
+	args, err := c.getDaemonArgs(osdProps, osd, "")
+	assert.NoError(t, err)
+	assert.Empty(t, args)
+
+	disabled := false
+	c.spec.Storage.CrushUpdateOnStart = &disabled
+	osdProps.storeConfig.NUMANode = "1"
+	args, err = c.getDaemonArgs(osdProps, osd, "/var/run/custom")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"--admin-socket=/var/run/custom/ceph-osd.3.asok",
+		"--osd-crush-update-on-start=false",
+		"--osd-numa-node=1",
+	}, args)
+
+	// invalid store config values are reported
+	osdProps.storeConfig.NUMANode = "-1"
+	_, err = c.getDaemonArgs(osdProps, osd, "")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "numa node of osd 3")
+}
+
+func TestGetUnprivilegedSecurityContexts(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	privileged := true
+	securityContext := &v1.SecurityContext{Privileged: &privileged}
+	pvcProps := osdProperties{crushHostname: "node1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"}}
+	nodeProps := osdProperties{crushHostname: "node1"}
+	osd := OSDInfo{ID: 0, CVMode: "raw"}
+
+	// privileged by default
+	initContext, daemonContext, devices := c.getUnprivilegedSecurityContexts(securityContext, pvcProps, osd)
+	assert.Equal(t, securityContext, initContext)
+	assert.Equal(t, securityContext, daemonContext)
+	assert.Empty(t, devices)
+
+	// the raw mode osds on pvc are granted capabilities and run the daemon as the ceph user
+	c.spec.Storage.UseCapabilities = true
+	c.spec.Storage.RunAsCephUser = true
+	initContext, daemonContext, devices = c.getUnprivilegedSecurityContexts(securityContext, pvcProps, osd)
+	assert.False(t, *initContext.Privileged)
+	assert.NotNil(t, initContext.Capabilities)
+	assert.Nil(t, initContext.RunAsGroup)
+	assert.False(t, *daemonContext.Privileged)
+	assert.Equal(t, int64(cephUserID), *daemonContext.RunAsUser)
+	assert.Equal(t, []string{"mypvc"}, []string{devices[0].Name})
+	// the given security context is not modified
+	assert.True(t, *securityContext.Privileged)
+
+	// the other osds keep running privileged
+	for _, props := range []osdProperties{nodeProps, {crushHostname: "node1", pvc: pvcProps.pvc, encrypted: true}} {
+		initContext, daemonContext, devices = c.getUnprivilegedSecurityContexts(securityContext, props, osd)
+		assert.Equal(t, securityContext, initContext)
+		assert.Equal(t, securityContext, daemonContext)
+		assert.Empty(t, devices)
+	}
+}
+
+func TestAddOSDDeviceClassLabels(t *testing.T) {
+	storeConfig := config.StoreConfig{DeviceClass: "configured", DeviceClassHint: "configured-hint"}
+
+	// the classes found when the osd was prepared take precedence
+	labels := map[string]string{}
+	addOSDDeviceClassLabels(labels, OSDInfo{DeviceClass: "ssd", DeviceClassHint: "nvme"}, storeConfig)
+	assert.Equal(t, "ssd", labels[DeviceClassLabelKey])
+	assert.Equal(t, "nvme", labels[DeviceClassHintLabelKey])
+
+	labels = map[string]string{}
+	addOSDDeviceClassLabels(labels, OSDInfo{}, storeConfig)
+	assert.Equal(t, "configured", labels[DeviceClassLabelKey])
+	assert.Equal(t, "configured-hint", labels[DeviceClassHintLabelKey])
+
+	labels = map[string]string{}
+	addOSDDeviceClassLabels(labels, OSDInfo{}, config.StoreConfig{})
+	assert.Empty(t, labels)
+}
+
+func TestApplyReadOnlyRootFilesystem(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	nodeProps := osdProperties{crushHostname: "node1"}
+	pvcProps := osdProperties{crushHostname: "node1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"}}
+	readOnly := func(props osdProperties, osd OSDInfo) bool {
+		spec := v1.PodSpec{Containers: []v1.Container{{Name: "osd"}}}
+		c.applyReadOnlyRootFilesystem(&spec, props, osd)
+		securityContext := spec.Containers[0].SecurityContext
+		return securityContext != nil && securityContext.ReadOnlyRootFilesystem != nil && *securityContext.ReadOnlyRootFilesystem
+	}
+
+	assert.False(t, readOnly(nodeProps, OSDInfo{CVMode: "raw"}))
+
+	c.spec.Storage.ReadOnlyRootFilesystem = true
+	assert.True(t, readOnly(nodeProps, OSDInfo{CVMode: "raw"}))
+	assert.True(t, readOnly(nodeProps, OSDInfo{CVMode: "lvm"}))
+	assert.True(t, readOnly(pvcProps, OSDInfo{CVMode: "raw"}))
+	// the lvm osds on pvc are activated in the daemon container
+	assert.False(t, readOnly(pvcProps, OSDInfo{CVMode: "lvm"}))
+}
+
+func TestApplySysfs(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	nodeProps := osdProperties{crushHostname: "node1"}
+	pvcProps := osdProperties{crushHostname: "node1", pvc: v1.PersistentVolumeClaimVolumeSource{ClaimName: "mypvc"}}
+	mountsSysfs := func(props osdProperties) bool {
+		spec := v1.PodSpec{Containers: []v1.Container{{Name: "osd"}}}
+		c.applySysfs(&spec, props)
+		return len(spec.Volumes) == 1 && spec.Volumes[0].Name == sysfsVolName
+	}
+
+	assert.False(t, mountsSysfs(nodeProps))
+	c.spec.Storage.Sysfs = &cephv1.OSDSysfsSpec{}
+	assert.False(t, mountsSysfs(nodeProps))
+	c.spec.Storage.Sysfs.Daemon = true
+	assert.True(t, mountsSysfs(nodeProps))
+	// the osds on pvc do not mount it
+	assert.False(t, mountsSysfs(pvcProps))
+}
+
+func TestApplyAdminSocketDir(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	socketMounts := func(adminSocketDir string) (int, []string) {
+		spec := v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "init"}},
+			Containers:     []v1.Container{{Name: "osd"}},
+		}
+		c.applyAdminSocketDir(&spec, adminSocketDir)
+		paths := []string{}
+		for _, container := range append(spec.InitContainers, spec.Containers...) {
+			for _, mount := range container.VolumeMounts {
+				paths = append(paths, mount.MountPath)
+			}
+		}
+		return len(spec.Volumes), paths
+	}
+
+	// the default directory exists in the ceph image
+	volumes, paths := socketMounts("")
+	assert.Zero(t, volumes)
+	assert.Empty(t, paths)
+	volumes, _ = socketMounts(controller.DaemonSocketDir)
+	assert.Zero(t, volumes)
+
+	// a custom directory is only mounted in the daemon container
+	volumes, paths = socketMounts("/var/run/custom")
+	assert.Equal(t, 1, volumes)
+	assert.Equal(t, []string{"/var/run/custom"}, paths)
+
+	// the empty dir is shared by all the containers
+	c.spec.Storage.AdminSocketEmptyDir = true
+	volumes, paths = socketMounts("")
+	assert.Equal(t, 1, volumes)
+	assert.Equal(t, []string{controller.DaemonSocketDir, controller.DaemonSocketDir}, paths)
+	_, paths = socketMounts("/var/run/custom")
+	assert.Equal(t, []string{"/var/run/custom", "/var/run/custom"}, paths)
+}
+
+func TestApplyOSDImage(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	c.spec.Storage.OSDImages = map[string]string{"1": "ceph/ceph:v14"}
+	image := func(osd OSDInfo) string {
+		spec := v1.PodSpec{Containers: []v1.Container{{Name: "osd", Image: c.spec.CephVersion.Image}}}
+		c.applyOSDImage(&spec, osd)
+		return spec.Containers[0].Image
+	}
+
+	assert.Equal(t, "ceph/ceph:v14", image(OSDInfo{ID: 1}))
+	assert.Equal(t, c.spec.CephVersion.Image, image(OSDInfo{ID: 2}))
+}
@@ -60,6 +60,12 @@ var (
 
 type provisionConfig struct {
 	DataPathMap *config.DataPathMap // location to store data in OSD and OSD prepare containers
+
+	// prepare jobs running in this reconcile, only tracked when storage.maxConcurrentPrepareJobs is set
+	prepareJobSlots *prepareJobSlots
+
+	// number of OSD deployments on each node, counted once per reconcile with the existing OSDs
+	nodeOSDCounts map[string]int
 }
 
 func (c *Cluster) newProvisionConfig() *provisionConfig {
@@ -326,12 +332,14 @@ func (c *Cluster) createOSDsForStatusMap(
 	logger.Infof("OSD orchestration status for %s %s is %q", nodeOrPVC, nodeOrPVCName, status.Status)
 
 	if status.Status == OrchestrationStatusCompleted {
+		c.observePrepareJobDuration(nodeOrPVCName, OrchestrationStatusCompleted)
 		createConfig.createNewOSDsFromStatus(status, nodeOrPVCName, errs)
 		c.deleteStatusConfigMap(nodeOrPVCName) // remove the provisioning status configmap
 		return
 	}
 
 	if status.Status == OrchestrationStatusFailed {
+		c.observePrepareJobDuration(nodeOrPVCName, OrchestrationStatusFailed)
 		createConfig.doneWithStatus(nodeOrPVCName)
 		errs.addError("failed to provision OSD(s) on %s %s. %+v", nodeOrPVC, nodeOrPVCName, status)
 		c.deleteStatusConfigMap(nodeOrPVCName) // remove the provisioning status configmap
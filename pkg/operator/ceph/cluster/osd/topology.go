@@ -21,7 +21,9 @@ package osd
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/rook/rook/pkg/daemon/ceph/client"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -116,3 +118,36 @@ func extractTopologyFromLabels(labels map[string]string) (map[string]string, str
 func formatTopologyAffinity(label, value string) string {
 	return fmt.Sprintf("%s=%s", label, value)
 }
+
+// overrideCrushLocation applies the CRUSH buckets of the override to the location found when the OSD
+// was provisioned, e.g. "root=default host=node1". The buckets missing from the location are
+// appended in the order of the CRUSH hierarchy so that the resulting location is stable.
+func overrideCrushLocation(location string, override map[string]string) (string, error) {
+	if len(override) == 0 {
+		return location, nil
+	}
+
+	levels := append([]string{"root"}, CRUSHMapLevelsOrdered...)
+	validLevels := map[string]bool{}
+	for _, level := range levels {
+		validLevels[level] = true
+	}
+	for bucketType := range override {
+		if !validLevels[bucketType] {
+			return "", errors.Errorf("invalid crush bucket type %q, expected one of %v", bucketType, levels)
+		}
+	}
+
+	locArgs := strings.Fields(location)
+	for _, bucketType := range levels {
+		bucketName, ok := override[bucketType]
+		if !ok {
+			continue
+		}
+		if bucketName == "" {
+			return "", errors.Errorf("empty crush bucket name for bucket type %q", bucketType)
+		}
+		client.UpdateCrushMapValue(&locArgs, bucketType, client.NormalizeCrushName(bucketName))
+	}
+	return strings.Join(locArgs, " "), nil
+}
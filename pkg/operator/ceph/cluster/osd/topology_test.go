@@ -135,3 +135,35 @@ func TestTopologyLabels(t *testing.T) {
 	assert.Equal(t, 0, len(topology))
 	assert.Equal(t, "", affinity)
 }
+
+func TestOverrideCrushLocation(t *testing.T) {
+	location := "root=default host=node1 zone=z1"
+
+	// no override
+	loc, err := overrideCrushLocation(location, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, location, loc)
+
+	// existing buckets are replaced in place and new ones are appended in hierarchy order
+	loc, err = overrideCrushLocation(location, map[string]string{
+		"region": "r1",
+		"rack":   "rack1",
+		"root":   "ssd",
+		"zone":   "z2",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "root=ssd host=node1 zone=z2 rack=rack1 region=r1", loc)
+
+	// bucket names are normalized for crush
+	loc, err = overrideCrushLocation(location, map[string]string{"host": "node1.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "root=default host=node1-example-com zone=z1", loc)
+
+	// invalid bucket type
+	_, err = overrideCrushLocation(location, map[string]string{"shelf": "s1"})
+	assert.Error(t, err)
+
+	// empty bucket name
+	_, err = overrideCrushLocation(location, map[string]string{"rack": ""})
+	assert.Error(t, err)
+}
@@ -33,6 +33,12 @@ import (
 
 // THE LIBRARY PROVIDED BY THIS FILE IS NOT THREAD SAFE
 
+const (
+	// osdDeploymentDriftReason is the reason of the event reported when the managed fields of an
+	// existing OSD deployment differ from the desired deployment
+	osdDeploymentDriftReason = "OSDDeploymentDrift"
+)
+
 var (
 	// allow unit tests to override these values
 	maxUpdatesInParallel                 = 20
@@ -174,6 +180,12 @@ func (c *updateConfig) updateExistingOSDs(errs *provisionErrors) {
 			continue
 		}
 
+		if DiffOSDDeployment(dep, updatedDep) {
+			message := fmt.Sprintf("reverting the containers of OSD deployment %q that differ from the desired spec", depName)
+			logger.Info(message)
+			c.cluster.reportEvent(v1.EventTypeNormal, osdDeploymentDriftReason, message)
+		}
+
 		updatedDeployments = append(updatedDeployments, updatedDep)
 		listIDs = append(listIDs, strconv.Itoa(osdID))
 	}
@@ -193,7 +205,7 @@ func (c *updateConfig) updateExistingOSDs(errs *provisionErrors) {
 
 // getOSDUpdateInfo returns an update queue of OSDs which need updated and an existence list of OSD
 // Deployments which already exist.
-func (c *Cluster) getOSDUpdateInfo(errs *provisionErrors) (*updateQueue, *existenceList, error) {
+func (c *Cluster) getOSDUpdateInfo(config *provisionConfig, errs *provisionErrors) (*updateQueue, *existenceList, error) {
 	ctx := context.TODO()
 	namespace := c.clusterInfo.Namespace
 
@@ -206,6 +218,7 @@ func (c *Cluster) getOSDUpdateInfo(errs *provisionErrors) (*updateQueue, *existe
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "failed to query existing OSD deployments to see if they need updated")
 	}
+	config.nodeOSDCounts = countOSDDeploymentsPerNode(deps.Items)
 
 	updateQueue := newUpdateQueueWithCapacity(len(deps.Items))
 	existenceList := newExistenceListWithCapacity(len(deps.Items))
@@ -34,12 +34,14 @@ import (
 	"github.com/rook/rook/pkg/operator/test"
 	exectest "github.com/rook/rook/pkg/util/exec/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 )
 
 func Test_updateExistingOSDs(t *testing.T) {
@@ -478,6 +480,41 @@ func Test_updateExistingOSDs(t *testing.T) {
 
 		assert.Equal(t, 0, updateQueue.Len()) // should be done with updates
 	})
+
+	t.Run("report an event for the drifted OSD deployments", func(t *testing.T) {
+		clientset = fake.NewSimpleClientset()
+		updateQueue = newUpdateQueueWithIDs(0, 4)
+		existingDeployments = newExistenceListWithIDs(0, 4)
+		forceUpgradeIfUnhealthy = false
+		updateInjectFailures = k8sutil.Failures{}
+		doSetup()
+		recorder := record.NewFakeRecorder(10)
+		c.SetEventRecorder(recorder, &cephv1.CephCluster{ObjectMeta: metav1.ObjectMeta{Name: "mycluster", Namespace: namespace}})
+		// the device class is set to skip the backward compatibility for old deployments
+		for i, nodeName := range []string{"node0", "node1"} {
+			c.ValidStorage.Nodes = append(c.ValidStorage.Nodes, cephv1.Node{Name: nodeName})
+			osd := OSDInfo{ID: i * 4, UUID: "some-uuid", BlockPath: "/dev/vda", CVMode: "raw", DeviceClass: "hdd"}
+			d, err := deploymentOnNode(c, osd, nodeName, c.newProvisionConfig())
+			assert.NoError(t, err)
+			if i == 0 {
+				// change the image of OSD 0 out of band
+				d.Spec.Template.Spec.Containers[0].Image = "ceph/ceph:other"
+			}
+			createDeploymentOrPanic(clientset, d)
+		}
+
+		osdToBeQueried = 0
+		returnOkToStopIDs = []int{0, 4}
+		updateConfig.updateExistingOSDs(errs)
+		assert.Zero(t, errs.len())
+		assert.ElementsMatch(t, deploymentsUpdated, []string{deploymentName(0), deploymentName(4)})
+
+		// only the drifted deployment of OSD 0 is reported
+		require.Len(t, recorder.Events, 1)
+		event := <-recorder.Events
+		assert.Contains(t, event, osdDeploymentDriftReason)
+		assert.Contains(t, event, deploymentName(0))
+	})
 }
 
 func Test_getOSDUpdateInfo(t *testing.T) {
@@ -505,7 +542,7 @@ func Test_getOSDUpdateInfo(t *testing.T) {
 
 	t.Run("cluster with no existing deployments", func(t *testing.T) {
 		errs = newProvisionErrors()
-		updateQueue, existenceList, err := c.getOSDUpdateInfo(errs)
+		updateQueue, existenceList, err := c.getOSDUpdateInfo(c.newProvisionConfig(), errs)
 		assert.NoError(t, err)
 		assert.Zero(t, errs.len())
 		assert.Zero(t, updateQueue.Len())
@@ -538,7 +575,7 @@ func Test_getOSDUpdateInfo(t *testing.T) {
 		createDeploymentOrPanic(clientset, d)
 
 		errs = newProvisionErrors()
-		updateQueue, existenceList, err := c.getOSDUpdateInfo(errs)
+		updateQueue, existenceList, err := c.getOSDUpdateInfo(c.newProvisionConfig(), errs)
 		assert.NoError(t, err)
 		assert.Zero(t, errs.len())
 		assert.Zero(t, updateQueue.Len())
@@ -555,7 +592,7 @@ func Test_getOSDUpdateInfo(t *testing.T) {
 		createDeploymentOrPanic(clientset, d)
 
 		errs = newProvisionErrors()
-		updateQueue, existenceList, err := c.getOSDUpdateInfo(errs)
+		updateQueue, existenceList, err := c.getOSDUpdateInfo(c.newProvisionConfig(), errs)
 		assert.NoError(t, err)
 		assert.Zero(t, errs.len())
 		assert.Equal(t, 2, updateQueue.Len())
@@ -571,7 +608,7 @@ func Test_getOSDUpdateInfo(t *testing.T) {
 		addTestDeployment(clientset, "rook-ceph-osd-NOID", namespace, l)
 
 		errs = newProvisionErrors()
-		updateQueue, existenceList, err := c.getOSDUpdateInfo(errs)
+		updateQueue, existenceList, err := c.getOSDUpdateInfo(c.newProvisionConfig(), errs)
 		assert.NoError(t, err)
 		assert.Equal(t, 1, errs.len())
 		// should have same update queue and existence list as last test
@@ -588,7 +625,7 @@ func Test_getOSDUpdateInfo(t *testing.T) {
 		c = New(ctx, clusterInfo, spec, "rook/rook:master")
 
 		errs = newProvisionErrors()
-		_, _, err := c.getOSDUpdateInfo(errs)
+		_, _, err := c.getOSDUpdateInfo(c.newProvisionConfig(), errs)
 		fmt.Println(err)
 		assert.Error(t, err)
 	})
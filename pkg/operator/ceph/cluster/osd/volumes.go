@@ -20,11 +20,16 @@ import (
 	"fmt"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"github.com/libopenstorage/secrets"
+	"github.com/pkg/errors"
 	kms "github.com/rook/rook/pkg/daemon/ceph/osd/kms"
 	"github.com/rook/rook/pkg/operator/ceph/config"
+	"github.com/rook/rook/pkg/operator/ceph/controller"
+	"github.com/rook/rook/pkg/operator/k8sutil"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 const (
@@ -33,6 +38,18 @@ const (
 	osdEncryptionVolName = "osd-encryption-key"
 	dmPath               = "/dev/mapper"
 	dmVolName            = "dev-mapper"
+	osdKeyringVolName    = "rook-ceph-osd-keyring"
+	caBundleVolName      = "rook-ceph-ca-bundle"
+	sysfsPath            = "/sys"
+	sysfsVolName         = "sysfs"
+	adminSocketVolName   = "ceph-daemons-sock-dir"
+	cephConfigDirVolName = "rook-ceph-config-dir"
+	tmpVolName           = "osd-tmp"
+	varRunVolName        = "osd-var-run"
+	// defaultCABundlePath is the directory the CA bundle is mounted in if none is configured
+	defaultCABundlePath = "/etc/rook/ca-bundle"
+	// bridgeVolumeMediumDisk backs the PVC bridge emptyDir with the node disk instead of memory
+	bridgeVolumeMediumDisk = "Disk"
 )
 
 func getPvcOSDBridgeMount(claimName string) v1.VolumeMount {
@@ -87,12 +104,46 @@ func getDeviceMapperVolume() (v1.Volume, v1.VolumeMount) {
 	return volume, volumeMounts
 }
 
-func getDataBridgeVolumeSource(claimName, configDir, namespace string, inProvisioning bool) v1.VolumeSource {
+var (
+	// The bridge only holds the block device files copied by the init containers
+	defaultBridgeVolumeSizeLimit = resource.MustParse("100Mi")
+	// The binaries volume holds the "rook" and "tini" binaries
+	defaultBinariesVolumeSizeLimit = resource.MustParse("512Mi")
+)
+
+// bridgeVolumeMedium returns the storage medium of the emptyDir bridging the PVC block devices during provisioning
+func (c *Cluster) bridgeVolumeMedium() v1.StorageMedium {
+	if c.spec.Storage.BridgeVolumeMedium == bridgeVolumeMediumDisk {
+		return v1.StorageMediumDefault
+	}
+	return v1.StorageMediumMemory
+}
+
+// bridgeVolumeEmptyDir returns the emptyDir bridging the PVC block devices during provisioning
+func (c *Cluster) bridgeVolumeEmptyDir() v1.EmptyDirVolumeSource {
+	sizeLimit := defaultBridgeVolumeSizeLimit.DeepCopy()
+	if c.spec.Storage.BridgeVolumeSizeLimit != nil {
+		sizeLimit = c.spec.Storage.BridgeVolumeSizeLimit.DeepCopy()
+	}
+	return v1.EmptyDirVolumeSource{
+		Medium:    c.bridgeVolumeMedium(),
+		SizeLimit: &sizeLimit,
+	}
+}
+
+// binariesVolumeEmptyDir returns the emptyDir receiving the rook binaries
+func (c *Cluster) binariesVolumeEmptyDir() v1.EmptyDirVolumeSource {
+	sizeLimit := defaultBinariesVolumeSizeLimit.DeepCopy()
+	if c.spec.Storage.BinariesVolumeSizeLimit != nil {
+		sizeLimit = c.spec.Storage.BinariesVolumeSizeLimit.DeepCopy()
+	}
+	return v1.EmptyDirVolumeSource{SizeLimit: &sizeLimit}
+}
+
+func getDataBridgeVolumeSource(claimName, configDir, namespace string, inProvisioning bool, emptyDir v1.EmptyDirVolumeSource) v1.VolumeSource {
 	var source v1.VolumeSource
 	if inProvisioning {
-		source.EmptyDir = &v1.EmptyDirVolumeSource{
-			Medium: "Memory",
-		}
+		source.EmptyDir = &emptyDir
 	} else {
 		// We need to use hostPath to prevent multiple OSD pods from launching the same OSD and causing corruption.
 		// Ceph avoids this problem by locking fsid file and block device file under the data bridge volume directory.
@@ -111,7 +162,7 @@ func getDataBridgeVolumeSource(claimName, configDir, namespace string, inProvisi
 	return source
 }
 
-func getPVCOSDVolumes(osdProps *osdProperties, configDir string, namespace string, prepare bool) []v1.Volume {
+func getPVCOSDVolumes(osdProps *osdProperties, configDir string, namespace string, prepare bool, bridge v1.EmptyDirVolumeSource) []v1.Volume {
 	volumes := []v1.Volume{
 		{
 			Name: osdProps.pvc.ClaimName,
@@ -124,7 +175,7 @@ func getPVCOSDVolumes(osdProps *osdProperties, configDir string, namespace strin
 			// and the privileged provision container or osd daemon container
 			// The reason for this is mentioned in the comment for getPVCInitContainer() method
 			Name:         fmt.Sprintf("%s-bridge", osdProps.pvc.ClaimName),
-			VolumeSource: getDataBridgeVolumeSource(osdProps.pvc.ClaimName, configDir, namespace, prepare),
+			VolumeSource: getDataBridgeVolumeSource(osdProps.pvc.ClaimName, configDir, namespace, prepare, bridge),
 		},
 	}
 
@@ -142,7 +193,7 @@ func getPVCOSDVolumes(osdProps *osdProperties, configDir string, namespace strin
 				// and the privileged provision container or osd daemon container
 				// The reason for this is mentioned in the comment for getPVCInitContainer() method
 				Name:         fmt.Sprintf("%s-bridge", osdProps.metadataPVC.ClaimName),
-				VolumeSource: getDataBridgeVolumeSource(osdProps.metadataPVC.ClaimName, configDir, namespace, prepare),
+				VolumeSource: getDataBridgeVolumeSource(osdProps.metadataPVC.ClaimName, configDir, namespace, prepare, bridge),
 			},
 		}
 
@@ -163,7 +214,7 @@ func getPVCOSDVolumes(osdProps *osdProperties, configDir string, namespace strin
 				// and the privileged provision container or osd daemon container
 				// The reason for this is mentioned in the comment for getPVCInitContainer() method
 				Name:         fmt.Sprintf("%s-bridge", osdProps.walPVC.ClaimName),
-				VolumeSource: getDataBridgeVolumeSource(osdProps.walPVC.ClaimName, configDir, namespace, prepare),
+				VolumeSource: getDataBridgeVolumeSource(osdProps.walPVC.ClaimName, configDir, namespace, prepare, bridge),
 			},
 		}
 
@@ -175,6 +226,27 @@ func getPVCOSDVolumes(osdProps *osdProperties, configDir string, namespace strin
 	return volumes
 }
 
+// getPVCVolumeDevices returns the block devices of all the PVCs of the OSD. An unprivileged
+// container can only open the devices it has been given access to.
+func getPVCVolumeDevices(osdProps osdProperties) []v1.VolumeDevice {
+	claims := []string{osdProps.pvc.ClaimName}
+	if osdProps.onPVCWithMetadata() {
+		claims = append(claims, osdProps.metadataPVC.ClaimName)
+	}
+	if osdProps.onPVCWithWal() {
+		claims = append(claims, osdProps.walPVC.ClaimName)
+	}
+
+	devices := make([]v1.VolumeDevice, 0, len(claims))
+	for _, claim := range claims {
+		devices = append(devices, v1.VolumeDevice{
+			Name:       claim,
+			DevicePath: fmt.Sprintf("/%s", claim),
+		})
+	}
+	return devices
+}
+
 func getUdevVolume() (v1.Volume, v1.VolumeMount) {
 	volume := v1.Volume{
 		Name: udevVolName,
@@ -243,3 +315,318 @@ func (c *Cluster) getEncryptionVolume(osdProps osdProperties) (v1.Volume, v1.Vol
 
 	return volume, volumeMounts
 }
+
+// addOSDKeyring mounts the secret holding the OSD keyring in the OSD daemon container and passes
+// the keyring path to the OSD daemon, if the storage spec sets an OSD keyring
+func (c *Cluster) addOSDKeyring(spec *v1.PodSpec) error {
+	osdKeyring := c.spec.Storage.OSDKeyring
+	if osdKeyring == nil {
+		return nil
+	}
+	if osdKeyring.SecretName == "" {
+		return errors.New("the osd keyring secret name must not be empty")
+	}
+	keyringPath := filepath.Clean(osdKeyring.Path)
+	keyringDir, keyringFile := filepath.Split(keyringPath)
+	keyringDir = filepath.Clean(keyringDir)
+	if !filepath.IsAbs(keyringPath) || keyringDir == "/" || keyringFile == "" {
+		return errors.Errorf("invalid osd keyring path %q. the path must be an absolute path of a file that is not at the root", osdKeyring.Path)
+	}
+
+	container := &spec.Containers[0]
+	for _, mount := range container.VolumeMounts {
+		if mount.MountPath == keyringDir {
+			return errors.Errorf("osd keyring directory %q collides with the mount path managed by rook", keyringDir)
+		}
+	}
+
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name: osdKeyringVolName,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{
+				SecretName: osdKeyring.SecretName,
+				Items:      []v1.KeyToPath{{Key: keyringFile, Path: keyringFile}},
+			},
+		},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{Name: osdKeyringVolName, MountPath: keyringDir, ReadOnly: true})
+	container.Args = append(container.Args, fmt.Sprintf("--keyring=%s", keyringPath))
+	return nil
+}
+
+// addCABundle mounts the configmap or secret holding the CA bundle in all the containers of the pod,
+// if the storage spec sets a CA bundle
+func (c *Cluster) addCABundle(spec *v1.PodSpec) error {
+	caBundle := c.spec.Storage.CABundle
+	if caBundle == nil {
+		return nil
+	}
+	var source v1.VolumeSource
+	switch {
+	case caBundle.ConfigMapName != "" && caBundle.SecretName != "":
+		return errors.New("the ca bundle must be either in a configmap or in a secret, not both")
+	case caBundle.ConfigMapName != "":
+		source.ConfigMap = &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: caBundle.ConfigMapName}}
+	case caBundle.SecretName != "":
+		source.Secret = &v1.SecretVolumeSource{SecretName: caBundle.SecretName}
+	default:
+		return errors.New("the ca bundle configmap or secret name must be set")
+	}
+	mountPath := defaultCABundlePath
+	if caBundle.MountPath != "" {
+		mountPath = filepath.Clean(caBundle.MountPath)
+		if !filepath.IsAbs(mountPath) || mountPath == "/" {
+			return errors.Errorf("invalid ca bundle mount path %q. the path must be an absolute path that is not the root", caBundle.MountPath)
+		}
+	}
+
+	mount := v1.VolumeMount{Name: caBundleVolName, MountPath: mountPath, ReadOnly: true}
+	addMount := func(containers []v1.Container) error {
+		for i := range containers {
+			for _, m := range containers[i].VolumeMounts {
+				if m.MountPath == mountPath {
+					return errors.Errorf("ca bundle mount path %q collides with the mount path managed by rook in container %q", mountPath, containers[i].Name)
+				}
+			}
+			containers[i].VolumeMounts = append(containers[i].VolumeMounts, mount)
+		}
+		return nil
+	}
+	if err := addMount(spec.InitContainers); err != nil {
+		return err
+	}
+	if err := addMount(spec.Containers); err != nil {
+		return err
+	}
+	spec.Volumes = append(spec.Volumes, v1.Volume{Name: caBundleVolName, VolumeSource: source})
+	return nil
+}
+
+// applyLogHostPath sets the host path of the volume mounted at /var/log/ceph in the containers of the
+// pod to the log host path of the storage spec. The path is only used when the logs are written to
+// files, i.e. when the log collector is enabled.
+func (c *Cluster) applyLogHostPath(spec *v1.PodSpec) error {
+	logHostPath := c.spec.Storage.LogHostPath
+	if logHostPath == "" || !c.spec.LogCollector.Enabled {
+		return nil
+	}
+	if !filepath.IsAbs(logHostPath) || filepath.Clean(logHostPath) == "/" {
+		return errors.Errorf("invalid log host path %q. the path must be an absolute path that is not the root", logHostPath)
+	}
+
+	logVolumeNames := map[string]bool{}
+	for _, container := range append(spec.InitContainers, spec.Containers...) {
+		for _, m := range container.VolumeMounts {
+			if m.MountPath == config.VarLogCephDir {
+				logVolumeNames[m.Name] = true
+			}
+		}
+	}
+	for i := range spec.Volumes {
+		if logVolumeNames[spec.Volumes[i].Name] && spec.Volumes[i].HostPath != nil {
+			spec.Volumes[i].HostPath.Path = filepath.Clean(logHostPath)
+		}
+	}
+	return nil
+}
+
+// reservedCephConfigFiles are the files of the ceph config directory managed by Rook
+var reservedCephConfigFiles = map[string]bool{
+	"ceph.conf":           true,
+	"keyring":             true,
+	encryptionKeyFileName: true,
+}
+
+// addCephConfigDir adds the keys of the configmap of the storage spec as files of the ceph config
+// directory, if the storage spec sets one. The configmap is projected into the config override
+// volume when /etc/ceph is that read-only volume, otherwise each file is mounted into the emptyDir
+// the config is generated in.
+func (c *Cluster) addCephConfigDir(spec *v1.PodSpec) error {
+	configDir := c.spec.Storage.CephConfigDir
+	if configDir == nil {
+		return nil
+	}
+	if configDir.ConfigMapName == "" {
+		return errors.New("the ceph config directory configmap name must be set")
+	}
+	if len(configDir.Items) == 0 {
+		return errors.Errorf("no item of the ceph config directory configmap %q is set", configDir.ConfigMapName)
+	}
+	for _, item := range configDir.Items {
+		if item.Key == "" || item.Path == "" || strings.Contains(item.Path, "/") || strings.HasPrefix(item.Path, ".") {
+			return errors.Errorf("invalid item %q of the ceph config directory configmap, the path must be a file name", item.Key)
+		}
+		if reservedCephConfigFiles[item.Path] {
+			return errors.Errorf("ceph config directory file %q collides with a file managed by rook", item.Path)
+		}
+	}
+
+	for i := range spec.Volumes {
+		volume := &spec.Volumes[i]
+		if volume.Name == k8sutil.ConfigOverrideName && volume.Projected != nil {
+			projection := &v1.ConfigMapProjection{Items: configDir.Items}
+			projection.Name = configDir.ConfigMapName
+			volume.Projected.Sources = append(volume.Projected.Sources, v1.VolumeProjection{ConfigMap: projection})
+			return nil
+		}
+	}
+
+	// the config is generated in the pod, the files are mounted in the containers sharing it
+	generatedConfigVolume, _ := controller.ConfGeneratedInPodVolumeAndMount()
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name: cephConfigDirVolName,
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: configDir.ConfigMapName},
+				Items:                configDir.Items,
+			},
+		},
+	})
+	addMounts := func(containers []v1.Container) {
+		for i := range containers {
+			for _, mount := range containers[i].VolumeMounts {
+				if mount.Name != generatedConfigVolume.Name {
+					continue
+				}
+				for _, item := range configDir.Items {
+					containers[i].VolumeMounts = append(containers[i].VolumeMounts, v1.VolumeMount{
+						Name:      cephConfigDirVolName,
+						MountPath: filepath.Join(mount.MountPath, item.Path),
+						SubPath:   item.Path,
+						ReadOnly:  true,
+					})
+				}
+				break
+			}
+		}
+	}
+	addMounts(spec.InitContainers)
+	addMounts(spec.Containers)
+	return nil
+}
+
+// addSysfs mounts the /sys directory of the host read-only in the given container of the pod
+func addSysfs(spec *v1.PodSpec, container *v1.Container) {
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name:         sysfsVolName,
+		VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: sysfsPath}},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{Name: sysfsVolName, MountPath: sysfsPath, ReadOnly: true})
+}
+
+// writableDirs are the directories the ceph daemons write to, backed by emptyDirs when the root
+// filesystem of the daemon container is read-only
+var writableDirs = []struct{ volumeName, path string }{
+	{tmpVolName, "/tmp"},
+	{varRunVolName, "/var/run"},
+}
+
+// setReadOnlyRootFilesystem makes the root filesystem of the given container of the pod read-only and
+// mounts emptyDirs at the directories the daemon needs to write to
+func setReadOnlyRootFilesystem(spec *v1.PodSpec, container *v1.Container) {
+	// the security context may be shared with the init containers
+	securityContext := &v1.SecurityContext{}
+	if container.SecurityContext != nil {
+		securityContext = container.SecurityContext.DeepCopy()
+	}
+	readOnlyRootFilesystem := true
+	securityContext.ReadOnlyRootFilesystem = &readOnlyRootFilesystem
+	container.SecurityContext = securityContext
+
+	for _, dir := range writableDirs {
+		spec.Volumes = append(spec.Volumes, v1.Volume{
+			Name:         dir.volumeName,
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{Name: dir.volumeName, MountPath: dir.path})
+	}
+}
+
+// addAdminSocketEmptyDir mounts an emptyDir at the admin socket directory in all the containers and
+// init containers of the pod, so the admin sockets of the daemon are shared between the containers
+func addAdminSocketEmptyDir(spec *v1.PodSpec, socketDir string) {
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name:         adminSocketVolName,
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+	})
+	mount := v1.VolumeMount{Name: adminSocketVolName, MountPath: socketDir}
+	for i := range spec.InitContainers {
+		spec.InitContainers[i].VolumeMounts = append(spec.InitContainers[i].VolumeMounts, mount)
+	}
+	for i := range spec.Containers {
+		spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, mount)
+	}
+}
+
+// addAdminSocketDirVolume mounts an emptyDir at the admin socket directory in the daemon container only
+func addAdminSocketDirVolume(spec *v1.PodSpec, container *v1.Container, socketDir string) {
+	spec.Volumes = append(spec.Volumes, v1.Volume{
+		Name:         adminSocketVolName,
+		VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+	})
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{Name: adminSocketVolName, MountPath: socketDir})
+}
+
+// reservedPrepareHostPaths are the host paths mounted in the OSD prepare pod to access the devices
+// of the host
+var reservedPrepareHostPaths = []string{"/dev", udevPath}
+
+// deviceVolumeNames are the names of the host path volumes mounted by Rook in the OSD prepare pod to
+// access the devices of the host, they may be below the reserved host paths
+var deviceVolumeNames = map[string]bool{
+	"devices":   true,
+	"udev":      true,
+	udevVolName: true,
+	dmVolName:   true,
+}
+
+// validatePrepareHostPaths checks that no other host path volume of the OSD prepare pod is the same
+// as or below the host paths mounted to access the devices, since the mounts would conflict
+func validatePrepareHostPaths(spec *v1.PodSpec) error {
+	for _, volume := range spec.Volumes {
+		if volume.HostPath == nil || deviceVolumeNames[volume.Name] {
+			continue
+		}
+		hostPath := filepath.Clean(volume.HostPath.Path)
+		for _, reserved := range reservedPrepareHostPaths {
+			if hostPath == reserved || strings.HasPrefix(hostPath, reserved+"/") {
+				return errors.Errorf("host path %q of volume %q overlaps with the reserved mount point %q", volume.HostPath.Path, volume.Name, reserved)
+			}
+		}
+	}
+	return nil
+}
+
+// addExtraVolumes adds the extra volumes of the storage spec to the OSD daemon pod and the extra
+// volume mounts to its daemon container. The pod spec must already contain all the volumes managed
+// by Rook so that name collisions are detected.
+func (c *Cluster) addExtraVolumes(spec *v1.PodSpec) error {
+	volumeNames := map[string]bool{}
+	for _, volume := range spec.Volumes {
+		volumeNames[volume.Name] = true
+	}
+	for _, volume := range c.spec.Storage.ExtraVolumes {
+		if volumeNames[volume.Name] {
+			return errors.Errorf("extra volume %q collides with a volume managed by rook", volume.Name)
+		}
+		volumeNames[volume.Name] = true
+		spec.Volumes = append(spec.Volumes, volume)
+	}
+
+	container := &spec.Containers[0]
+	mountPaths := map[string]bool{}
+	for _, mount := range container.VolumeMounts {
+		mountPaths[mount.MountPath] = true
+	}
+	for _, mount := range c.spec.Storage.ExtraVolumeMounts {
+		if !volumeNames[mount.Name] {
+			return errors.Errorf("extra volume mount %q does not refer to a volume of the osd pod", mount.Name)
+		}
+		if mountPaths[mount.MountPath] {
+			return errors.Errorf("extra volume mount %q collides with the mount path %q managed by rook", mount.Name, mount.MountPath)
+		}
+		mountPaths[mount.MountPath] = true
+		container.VolumeMounts = append(container.VolumeMounts, mount)
+	}
+	return nil
+}
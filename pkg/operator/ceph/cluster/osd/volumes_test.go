@@ -23,6 +23,7 @@ import (
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 func TestGetEncryptionVolume(t *testing.T) {
@@ -51,9 +52,165 @@ func TestGetDataBridgeVolumeSource(t *testing.T) {
 	configDir := "/var/lib/rook"
 	namespace := "rook-ceph"
 
-	source := getDataBridgeVolumeSource(claimName, configDir, namespace, true)
+	source := getDataBridgeVolumeSource(claimName, configDir, namespace, true, v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory})
 	assert.Equal(t, v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{Medium: "Memory"}}, source)
+	source = getDataBridgeVolumeSource(claimName, configDir, namespace, true, v1.EmptyDirVolumeSource{Medium: v1.StorageMediumDefault})
+	assert.Equal(t, v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{Medium: ""}}, source)
 	hostPathType := v1.HostPathDirectoryOrCreate
-	source = getDataBridgeVolumeSource(claimName, configDir, namespace, false)
+	source = getDataBridgeVolumeSource(claimName, configDir, namespace, false, v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory})
 	assert.Equal(t, v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: filepath.Join(configDir, namespace, claimName), Type: &hostPathType}}, source)
 }
+
+func TestBridgeVolumeMedium(t *testing.T) {
+	c := &Cluster{}
+	assert.Equal(t, v1.StorageMediumMemory, c.bridgeVolumeMedium())
+
+	c.spec.Storage.BridgeVolumeMedium = "Memory"
+	assert.Equal(t, v1.StorageMediumMemory, c.bridgeVolumeMedium())
+
+	c.spec.Storage.BridgeVolumeMedium = "Disk"
+	assert.Equal(t, v1.StorageMediumDefault, c.bridgeVolumeMedium())
+
+	// the medium applies to all the bridge volumes of the prepare job
+	osdProps := &osdProperties{
+		pvc:         v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+		metadataPVC: v1.PersistentVolumeClaimVolumeSource{ClaimName: "metadata"},
+		walPVC:      v1.PersistentVolumeClaimVolumeSource{ClaimName: "wal"},
+	}
+	volumes := getPVCOSDVolumes(osdProps, "/var/lib/rook", "rook-ceph", true, c.bridgeVolumeEmptyDir())
+	assert.Equal(t, 6, len(volumes))
+	for _, volume := range volumes {
+		if volume.EmptyDir != nil {
+			assert.Equal(t, v1.StorageMediumDefault, volume.EmptyDir.Medium, volume.Name)
+		}
+	}
+	c.spec.Storage.BridgeVolumeMedium = ""
+	volumes = getPVCOSDVolumes(osdProps, "/var/lib/rook", "rook-ceph", true, c.bridgeVolumeEmptyDir())
+	for _, volume := range volumes {
+		if volume.EmptyDir != nil {
+			assert.Equal(t, v1.StorageMediumMemory, volume.EmptyDir.Medium, volume.Name)
+		}
+	}
+}
+
+func TestEmptyDirSizeLimits(t *testing.T) {
+	c := &Cluster{}
+	osdProps := &osdProperties{
+		pvc:         v1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+		metadataPVC: v1.PersistentVolumeClaimVolumeSource{ClaimName: "metadata"},
+	}
+
+	// defaults
+	volumes := getPVCOSDVolumes(osdProps, "/var/lib/rook", "rook-ceph", true, c.bridgeVolumeEmptyDir())
+	bridges := 0
+	for _, volume := range volumes {
+		if volume.EmptyDir != nil {
+			bridges++
+			assert.Equal(t, "100Mi", volume.EmptyDir.SizeLimit.String(), volume.Name)
+		}
+	}
+	assert.Equal(t, 2, bridges)
+	binariesVolume, _ := c.getCopyBinariesContainer(rookBinariesMountPath)
+	assert.Equal(t, rookBinariesVolumeName, binariesVolume.Name)
+	assert.Equal(t, "512Mi", binariesVolume.EmptyDir.SizeLimit.String())
+
+	// custom limits
+	bridgeLimit := resource.MustParse("1Gi")
+	binariesLimit := resource.MustParse("256Mi")
+	c.spec.Storage.BridgeVolumeSizeLimit = &bridgeLimit
+	c.spec.Storage.BinariesVolumeSizeLimit = &binariesLimit
+	volumes = getPVCOSDVolumes(osdProps, "/var/lib/rook", "rook-ceph", true, c.bridgeVolumeEmptyDir())
+	for _, volume := range volumes {
+		if volume.EmptyDir != nil {
+			assert.Equal(t, "1Gi", volume.EmptyDir.SizeLimit.String(), volume.Name)
+		}
+	}
+	binariesVolume, _ = c.getCopyBinariesContainer(rookBinariesMountPath)
+	assert.Equal(t, "256Mi", binariesVolume.EmptyDir.SizeLimit.String())
+
+	// the daemon bridge is a host path and has no size limit
+	volumes = getPVCOSDVolumes(osdProps, "/var/lib/rook", "rook-ceph", false, c.bridgeVolumeEmptyDir())
+	for _, volume := range volumes {
+		assert.Nil(t, volume.EmptyDir, volume.Name)
+	}
+}
+
+func TestValidatePrepareHostPaths(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{DataDirHostPath: "/var/lib/rook"})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+
+	_, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+
+	// the paths overlapping with the device mounts are rejected
+	for _, dataDirHostPath := range []string{"/dev", "/dev/rook", "/run/udev/rook", "/dev/../dev/rook"} {
+		c.spec.DataDirHostPath = dataDirHostPath
+		_, err = c.makeJob(osdProps, dataPathMap)
+		if assert.Error(t, err, dataDirHostPath) {
+			assert.Contains(t, err.Error(), "overlaps with the reserved mount point", dataDirHostPath)
+		}
+	}
+
+	// a common prefix is not an overlap
+	c.spec.DataDirHostPath = "/devices/rook"
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+
+	c.spec.DataDirHostPath = "/var/lib/rook"
+	c.spec.LogCollector.Enabled = true
+	c.spec.Storage.LogHostPath = "/run/udev/ceph-logs"
+	_, err = c.makeJob(osdProps, dataPathMap)
+	assert.Error(t, err)
+}
+
+func TestSysfsMount(t *testing.T) {
+	c := newTestCluster(t, cephv1.ClusterSpec{})
+	dataPathMap := testProvisionConfig(c)
+	osdProps := osdProperties{crushHostname: "node1", devices: []cephv1.Device{{Name: "sdb"}}}
+	osd := OSDInfo{ID: 0, UUID: "uuid-0", BlockPath: "/dev/sdb", CVMode: "raw"}
+	sysfsMount := v1.VolumeMount{Name: "sysfs", MountPath: "/sys", ReadOnly: true}
+	hasSysfsVolume := func(spec v1.PodSpec) bool {
+		for _, v := range spec.Volumes {
+			if v.Name == "sysfs" {
+				return v.HostPath != nil && v.HostPath.Path == "/sys"
+			}
+		}
+		return false
+	}
+
+	// not mounted by default
+	job, err := c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.False(t, hasSysfsVolume(job.Spec.Template.Spec))
+	assert.NotContains(t, job.Spec.Template.Spec.Containers[0].VolumeMounts, sysfsMount)
+	deployment, err := c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.False(t, hasSysfsVolume(deployment.Spec.Template.Spec))
+
+	// only in the prepare pod
+	c.spec.Storage.Sysfs = &cephv1.OSDSysfsSpec{Prepare: true}
+	job, err = c.makeJob(osdProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.True(t, hasSysfsVolume(job.Spec.Template.Spec))
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].VolumeMounts, sysfsMount)
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.False(t, hasSysfsVolume(deployment.Spec.Template.Spec))
+
+	// in the daemon container too
+	c.spec.Storage.Sysfs.Daemon = true
+	deployment, err = c.makeDeployment(osdProps, osd, dataPathMap)
+	assert.NoError(t, err)
+	assert.True(t, hasSysfsVolume(deployment.Spec.Template.Spec))
+	assert.Contains(t, deployment.Spec.Template.Spec.Containers[0].VolumeMounts, sysfsMount)
+
+	// not for the osds on pvc
+	pvcProps := osdProperties{
+		crushHostname: "pvc1",
+		pvc:           v1.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"},
+	}
+	job, err = c.makeJob(pvcProps, dataPathMap)
+	assert.NoError(t, err)
+	assert.False(t, hasSysfsVolume(job.Spec.Template.Spec))
+}
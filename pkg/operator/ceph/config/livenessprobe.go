@@ -52,6 +52,34 @@ func ConfigureLivenessProbe(daemon rook.KeyType, container v1.Container, healthC
 	return container
 }
 
+// ConfigureReadinessProbe returns the desired readiness probe for a given daemon, only the OSD
+// readiness probe can be configured. The probe is opt-in: the default probe of the container is only
+// kept when the daemon is set in the readiness probes of the health check and not disabled, so that
+// adding the probe does not restart the daemons of the existing clusters.
+func ConfigureReadinessProbe(daemon rook.KeyType, container v1.Container, healthCheck cephv1.CephClusterHealthCheckSpec) v1.Container {
+	// Map of functions
+	probeFnMap := map[rook.KeyType]fn{
+		cephv1.KeyOSD: cephv1.GetOSDReadinessProbe,
+	}
+
+	if _, ok := probeFnMap[daemon]; !ok {
+		return container
+	}
+	if spec, ok := healthCheck.ReadinessProbe[daemon]; !ok || spec == nil || spec.Disabled {
+		container.ReadinessProbe = nil
+		return container
+	}
+
+	probe := probeFnMap[daemon](healthCheck)
+	// If the spec value is not empty, let's apply it along with default when some fields are not specified
+	if probe != nil && container.ReadinessProbe != nil {
+		// Set the readiness probe on the container to overwrite the default probe created by Rook
+		container.ReadinessProbe = GetLivenessProbeWithDefaults(probe, container.ReadinessProbe)
+	}
+
+	return container
+}
+
 func GetLivenessProbeWithDefaults(desiredProbe, currentProbe *v1.Probe) *v1.Probe {
 	newProbe := *desiredProbe
 
@@ -143,3 +143,48 @@ func TestGetLivenessProbeWithDefaults(t *testing.T) {
 		assert.Equal(t, desiredProbe.TimeoutSeconds, int32(5))
 	})
 }
+
+func TestConfigureReadinessProbe(t *testing.T) {
+	p := &v1.Probe{
+		Handler: v1.Handler{
+			Exec: &v1.ExecAction{Command: []string{"status"}},
+		},
+		InitialDelaySeconds: 10,
+	}
+	container := v1.Container{ReadinessProbe: p}
+
+	t.Run("probe disabled by default", func(t *testing.T) {
+		got := ConfigureReadinessProbe(cephv1.KeyOSD, container, cephv1.CephClusterHealthCheckSpec{})
+		assert.Nil(t, got.ReadinessProbe)
+	})
+
+	t.Run("probe enabled", func(t *testing.T) {
+		healthCheck := cephv1.CephClusterHealthCheckSpec{ReadinessProbe: map[rook.KeyType]*cephv1.ProbeSpec{cephv1.KeyOSD: {}}}
+		got := ConfigureReadinessProbe(cephv1.KeyOSD, container, healthCheck)
+		assert.Equal(t, container, got)
+	})
+
+	t.Run("probe disabled", func(t *testing.T) {
+		healthCheck := cephv1.CephClusterHealthCheckSpec{ReadinessProbe: map[rook.KeyType]*cephv1.ProbeSpec{cephv1.KeyOSD: {Disabled: true}}}
+		got := ConfigureReadinessProbe(cephv1.KeyOSD, container, healthCheck)
+		assert.Nil(t, got.ReadinessProbe)
+	})
+
+	t.Run("thresholds overridden", func(t *testing.T) {
+		healthCheck := cephv1.CephClusterHealthCheckSpec{ReadinessProbe: map[rook.KeyType]*cephv1.ProbeSpec{
+			cephv1.KeyOSD: {Probe: &v1.Probe{PeriodSeconds: 15, FailureThreshold: 10}},
+		}}
+		got := ConfigureReadinessProbe(cephv1.KeyOSD, container, healthCheck)
+		// the handler cannot be changed
+		assert.Equal(t, p.Handler, got.ReadinessProbe.Handler)
+		assert.Equal(t, int32(10), got.ReadinessProbe.InitialDelaySeconds)
+		assert.Equal(t, int32(15), got.ReadinessProbe.PeriodSeconds)
+		assert.Equal(t, int32(10), got.ReadinessProbe.FailureThreshold)
+	})
+
+	t.Run("only the osd is supported", func(t *testing.T) {
+		healthCheck := cephv1.CephClusterHealthCheckSpec{ReadinessProbe: map[rook.KeyType]*cephv1.ProbeSpec{cephv1.KeyMon: {Disabled: true}}}
+		got := ConfigureReadinessProbe(cephv1.KeyMon, container, healthCheck)
+		assert.Equal(t, container, got)
+	})
+}
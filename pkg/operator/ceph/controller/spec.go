@@ -48,9 +48,10 @@ const (
 	logVolumeName                         = "rook-ceph-log"
 	volumeMountSubPath                    = "data"
 	crashVolumeName                       = "rook-ceph-crash"
-	daemonSocketDir                       = "/run/ceph"
+	DaemonSocketDir                       = "/run/ceph"
 	initialDelaySecondsNonOSDDaemon int32 = 10
 	initialDelaySecondsOSDDaemon    int32 = 45
+	readinessInitialDelaySecondsOSD int32 = 10
 	logCollector                          = "log-collector"
 	DaemonIDLabel                         = "ceph_daemon_id"
 	daemonTypeLabel                       = "ceph_daemon_type"
@@ -61,6 +62,8 @@ const (
 type daemonConfig struct {
 	daemonType string
 	daemonID   string
+	// socketDir is the directory of the admin socket of the daemon, DaemonSocketDir if empty
+	socketDir string
 }
 
 var logger = capnslog.NewPackageLogger("github.com/rook/rook", "ceph-spec")
@@ -556,7 +559,14 @@ func StoredLogAndCrashVolumeMount(varLogCephDir, varLibCephCrashDir string) []v1
 
 // GenerateLivenessProbeExecDaemon makes sure a daemon has a socket and that it can be called and returns 0
 func GenerateLivenessProbeExecDaemon(daemonType, daemonID string) *v1.Probe {
+	return GenerateLivenessProbeExecDaemonInDir(daemonType, daemonID, "")
+}
+
+// GenerateLivenessProbeExecDaemonInDir is GenerateLivenessProbeExecDaemon for a daemon whose admin
+// socket is in the given directory, the default directory if empty
+func GenerateLivenessProbeExecDaemonInDir(daemonType, daemonID, socketDir string) *v1.Probe {
 	confDaemon := getDaemonConfig(daemonType, daemonID)
+	confDaemon.socketDir = socketDir
 	initialDelaySeconds := initialDelaySecondsNonOSDDaemon
 	if daemonType == config.OsdType {
 		initialDelaySeconds = initialDelaySecondsOSDDaemon
@@ -583,6 +593,39 @@ func GenerateLivenessProbeExecDaemon(daemonType, daemonID string) *v1.Probe {
 	}
 }
 
+// GenerateReadinessProbeExecOSD makes sure the OSD reports the active state on its socket, the state
+// is only active once the OSD has booted and is marked up by the monitors
+func GenerateReadinessProbeExecOSD(osdID string) *v1.Probe {
+	return GenerateReadinessProbeExecOSDInDir(osdID, "")
+}
+
+// GenerateReadinessProbeExecOSDInDir is GenerateReadinessProbeExecOSD for an OSD whose admin socket is
+// in the given directory, the default directory if empty
+func GenerateReadinessProbeExecOSDInDir(osdID, socketDir string) *v1.Probe {
+	confDaemon := getDaemonConfig(config.OsdType, osdID)
+	confDaemon.socketDir = socketDir
+
+	return &v1.Probe{
+		Handler: v1.Handler{
+			Exec: &v1.ExecAction{
+				// Run with env -i to clean env variables in the exec context
+				// This avoids conflict with the CEPH_ARGS env
+				//
+				// Example:
+				// env -i sh -c "ceph --admin-daemon /run/ceph/ceph-osd.0.asok status | grep -Eq '\"state\": *\"active\"'"
+				Command: []string{
+					"env",
+					"-i",
+					"sh",
+					"-c",
+					fmt.Sprintf(`ceph --admin-daemon %s %s | grep -Eq '"state": *"active"'`, confDaemon.buildSocketPath(), confDaemon.buildAdminSocketCommand()),
+				},
+			},
+		},
+		InitialDelaySeconds: readinessInitialDelaySecondsOSD,
+	}
+}
+
 func getDaemonConfig(daemonType, daemonID string) *daemonConfig {
 	return &daemonConfig{
 		daemonType: string(daemonType),
@@ -595,7 +638,18 @@ func (c *daemonConfig) buildSocketName() string {
 }
 
 func (c *daemonConfig) buildSocketPath() string {
-	return path.Join(daemonSocketDir, c.buildSocketName())
+	if c.socketDir != "" {
+		return path.Join(c.socketDir, c.buildSocketName())
+	}
+	return path.Join(DaemonSocketDir, c.buildSocketName())
+}
+
+// DaemonSocketPath returns the path of the admin socket of the daemon in the given directory, the
+// default directory if empty. It is the path of the socket checked by the probes of the daemon.
+func DaemonSocketPath(daemonType, daemonID, socketDir string) string {
+	confDaemon := getDaemonConfig(daemonType, daemonID)
+	confDaemon.socketDir = socketDir
+	return confDaemon.buildSocketPath()
 }
 
 func (c *daemonConfig) buildAdminSocketCommand() string {
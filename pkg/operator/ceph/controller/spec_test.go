@@ -144,6 +144,11 @@ func TestBuildSocketPath(t *testing.T) {
 
 	socketPath := c.buildSocketPath()
 	assert.Equal(t, "/run/ceph/ceph-osd.0.asok", socketPath)
+
+	c.socketDir = "/var/run/custom"
+	socketPath = c.buildSocketPath()
+	assert.Equal(t, "/var/run/custom/ceph-osd.0.asok", socketPath)
+	assert.Equal(t, socketPath, DaemonSocketPath(opconfig.OsdType, daemonID, "/var/run/custom"))
 }
 
 func TestGenerateLivenessProbeExecDaemon(t *testing.T) {
@@ -163,6 +168,11 @@ func TestGenerateLivenessProbeExecDaemon(t *testing.T) {
 	// test with a mon so the delay should be 10
 	probe = GenerateLivenessProbeExecDaemon(opconfig.MonType, "a")
 	assert.Equal(t, initialDelaySecondsNonOSDDaemon, probe.InitialDelaySeconds)
+
+	// test with a custom socket directory
+	probe = GenerateLivenessProbeExecDaemonInDir(opconfig.OsdType, daemonID, "/var/run/custom")
+	assert.Equal(t, "ceph --admin-daemon /var/run/custom/ceph-osd.0.asok status", probe.Handler.Exec.Command[4])
+	assert.Equal(t, initialDelaySecondsOSDDaemon, probe.InitialDelaySeconds)
 }
 
 func TestDaemonFlags(t *testing.T) {
@@ -390,3 +400,20 @@ func TestConfigureExternalMetricsEndpoint(t *testing.T) {
 		assert.Equal(t, "192.168.0.1", currentEndpoints.Subsets[0].Addresses[0].IP, currentEndpoints)
 	})
 }
+
+func TestGenerateReadinessProbeExecOSD(t *testing.T) {
+	probe := GenerateReadinessProbeExecOSD("3")
+	expectedCommand := []string{"env",
+		"-i",
+		"sh",
+		"-c",
+		`ceph --admin-daemon /run/ceph/ceph-osd.3.asok status | grep -Eq '"state": *"active"'`,
+	}
+
+	assert.Equal(t, expectedCommand, probe.Handler.Exec.Command)
+	assert.Equal(t, readinessInitialDelaySecondsOSD, probe.InitialDelaySeconds)
+
+	// test with a custom socket directory
+	probe = GenerateReadinessProbeExecOSDInDir("3", "/var/run/custom")
+	assert.Equal(t, `ceph --admin-daemon /var/run/custom/ceph-osd.3.asok status | grep -Eq '"state": *"active"'`, probe.Handler.Exec.Command[4])
+}
@@ -69,25 +69,18 @@ func (r *ReconcileClusterDisruption) deletePDB(pdb *policyv1beta1.PodDisruptionB
 	return nil
 }
 
-// createDefaultPDBforOSD creates a single PDB for all OSDs with maxUnavailable=1
-// This allows all OSDs in a single failure domain to go down.
-func (r *ReconcileClusterDisruption) createDefaultPDBforOSD(namespace string) error {
+// createDefaultPDBforOSD creates a single PDB for all OSDs with maxUnavailable=1, or the configured
+// osdMaxUnavailable if all the OSDs are in a single failure domain. This allows all OSDs in a single
+// failure domain to go down.
+func (r *ReconcileClusterDisruption) createDefaultPDBforOSD(namespace string, failureDomains int) error {
 	cephCluster, ok := r.clusterMap.GetCluster(namespace)
 	if !ok {
 		return errors.Errorf("failed to find the namespace %q in the clustermap", namespace)
 	}
-	pdbRequest := types.NamespacedName{Name: osdPDBAppName, Namespace: namespace}
-	pdb := &policyv1beta1.PodDisruptionBudget{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      osdPDBAppName,
-			Namespace: namespace,
-		},
-		Spec: policyv1beta1.PodDisruptionBudgetSpec{
-			MaxUnavailable: &intstr.IntOrString{IntVal: 1},
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{k8sutil.AppAttr: osdPDBAppName},
-			},
-		},
+	pdbRequest := types.NamespacedName{Name: osd.DefaultPDBName, Namespace: namespace}
+	pdb := osd.MakeDefaultPDB(namespace, cephCluster.Spec.DisruptionManagement, failureDomains)
+	if configured := cephCluster.Spec.DisruptionManagement.OSDMaxUnavailable; configured > pdb.Spec.MaxUnavailable.IntValue() {
+		logger.Warningf("osdMaxUnavailable=%d is clamped to %s in the default pdb %q since the osds are in %d failure domains", configured, pdb.Spec.MaxUnavailable.String(), osd.DefaultPDBName, failureDomains)
 	}
 	ownerInfo := k8sutil.NewOwnerInfo(cephCluster, r.scheme)
 	err := ownerInfo.SetControllerReference(pdb)
@@ -95,15 +88,24 @@ func (r *ReconcileClusterDisruption) createDefaultPDBforOSD(namespace string) er
 		return errors.Wrapf(err, "failed to set owner reference to pdb %q", pdb)
 	}
 
-	err = r.client.Get(context.TODO(), pdbRequest, &policyv1beta1.PodDisruptionBudget{})
+	existingPDB := &policyv1beta1.PodDisruptionBudget{}
+	err = r.client.Get(context.TODO(), pdbRequest, existingPDB)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			logger.Info("all PGs are active+clean. Restoring default OSD pdb settings")
-			logger.Infof("creating the default pdb %q with maxUnavailable=1 for all osd", osdPDBAppName)
+			logger.Infof("creating the default pdb %q with maxUnavailable=%s for all osd", osd.DefaultPDBName, pdb.Spec.MaxUnavailable.String())
 			return r.createPDB(pdb)
 		}
 		return errors.Wrapf(err, "failed to get pdb %q", pdb.Name)
 	}
+
+	if existingPDB.Spec.MaxUnavailable == nil || *existingPDB.Spec.MaxUnavailable != *pdb.Spec.MaxUnavailable {
+		logger.Infof("updating the default pdb %q with maxUnavailable=%s for all osd", osd.DefaultPDBName, pdb.Spec.MaxUnavailable.String())
+		existingPDB.Spec.MaxUnavailable = pdb.Spec.MaxUnavailable
+		if err := r.client.Update(context.TODO(), existingPDB); err != nil {
+			return errors.Wrapf(err, "failed to update pdb %q", pdb.Name)
+		}
+	}
 	return nil
 }
 
@@ -122,7 +124,7 @@ func (r *ReconcileClusterDisruption) deleteDefaultPDBforOSD(namespace string) er
 		}
 		return errors.Wrapf(err, "failed to get pdb %q", pdb.Name)
 	}
-	logger.Infof("deleting the default pdb %q with maxUnavailable=1 for all osd", osdPDBAppName)
+	logger.Infof("deleting the default pdb %q for all osd", osdPDBAppName)
 	return r.deletePDB(pdb)
 }
 
@@ -343,7 +345,7 @@ func (r *ReconcileClusterDisruption) handleActiveDrains(allFailureDomains []stri
 }
 
 func (r *ReconcileClusterDisruption) handleInactiveDrains(allFailureDomains []string, failureDomainType, namespace string) error {
-	err := r.createDefaultPDBforOSD(namespace)
+	err := r.createDefaultPDBforOSD(namespace, len(allFailureDomains))
 	if err != nil {
 		return errors.Wrap(err, "failed to create default pdb")
 	}
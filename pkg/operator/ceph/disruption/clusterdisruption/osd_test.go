@@ -448,3 +448,31 @@ func TestHasNodeDrained(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, expected)
 }
+
+func TestCreateDefaultPDBforOSD(t *testing.T) {
+	r := getFakeReconciler(t)
+	getPDB := func() *policyv1beta1.PodDisruptionBudget {
+		pdb := &policyv1beta1.PodDisruptionBudget{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: osdPDBAppName, Namespace: namespace}, pdb)
+		assert.NoError(t, err)
+		return pdb
+	}
+
+	// one osd is allowed to be unavailable by default
+	err := r.createDefaultPDBforOSD(namespace, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, getPDB().Spec.MaxUnavailable.IntValue())
+
+	// the configured limit is clamped to one osd with several failure domains
+	cluster := cephCluster.DeepCopy()
+	cluster.Spec.DisruptionManagement.OSDMaxUnavailable = 3
+	r.clusterMap.clusterMap[namespace] = cluster
+	err = r.createDefaultPDBforOSD(namespace, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, getPDB().Spec.MaxUnavailable.IntValue())
+
+	// the existing pdb is updated with the configured limit in a single failure domain
+	err = r.createDefaultPDBforOSD(namespace, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, getPDB().Spec.MaxUnavailable.IntValue())
+}